@@ -1,21 +1,61 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"net/http"
+	"io"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/nryberg/lnk/client"
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/net/html"
 )
 
 const defaultServerURL = "http://localhost:8080"
 
-type CLIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+// Exit codes: 1 when a command fails, 2 when it's used wrongly.
+const (
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// Output formats for -output. table is for people; json and csv are for
+// scripts.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+// usageError is a mistake in the command line rather than a failed
+// request.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string { return e.message }
+
+func usage(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
 }
 
 func main() {
@@ -23,24 +63,88 @@ func main() {
 		serverURL = flag.String("server", defaultServerURL, "Server URL")
 		add       = flag.String("add", "", "Add a new link (format: shortcode,url)")
 		list      = flag.Bool("list", false, "List all links")
+		get       = flag.String("get", "", "Show a link and its clicks by shortcode")
+		open      = flag.String("open", "", "Open a link's destination in the browser")
+		copyArg   = flag.String("copy", "", "Copy a link's short URL to the clipboard")
+		qr        = flag.String("qr", "", "Show a link's QR code in the terminal, or save it with -out")
+		qrOut     = flag.String("out", "", "File for -qr to save the QR code to, as .png or .svg")
+		stats     = flag.String("stats", "", "Show a link's clicks by variant and referrer")
+		top       = flag.Bool("top", false, "Show the most clicked and trending links")
+		window    = flag.String("window", "24h", "Window for -top: 24h, 7d, or 30d")
+		update    = flag.String("update", "", "Change a link's URL (format: shortcode,url)")
 		del       = flag.String("delete", "", "Delete a link by shortcode")
+		importArg = flag.String("import", "", "Add links from a CSV file of shortcode,url rows, or - for stdin")
+		format    = flag.String("format", "lnk", "What -import reads: lnk, an export from yourls, shlink, bitly, or kutt, or browser bookmarks")
+		restore   = flag.String("restore", "", "Replace the server's database with a backup file")
+		apply     = flag.String("apply", "", "Make the server's links match a YAML or JSON links file, or - for stdin")
+		prune     = flag.Bool("prune", false, "With -apply, delete the links the file doesn't list")
+		dryRun    = flag.Bool("dry-run", false, "With -apply, show what would change without changing it")
+		output    = flag.String("output", outputTable, "Output format: table, json, or csv")
+		user      = flag.String("user", os.Getenv("LNK_USER"), "Username for servers with accounts enabled")
+		tuiFlag   = flag.Bool("tui", false, "Browse, search, open, edit, and delete links in a terminal UI")
+		complete  = flag.String("completion", "", "Print a shell completion script: bash, zsh, or fish")
 		help      = flag.Bool("help", false, "Show help")
 	)
 	flag.Parse()
 
+	var opts []client.Option
+	if token := os.Getenv("LNK_TOKEN"); token != "" {
+		opts = append(opts, client.WithBearerToken(token))
+	} else if *user != "" {
+		opts = append(opts, client.WithBasicAuth(*user, os.Getenv("LNK_PASSWORD")))
+	}
+	c := client.New(*serverURL, opts...)
+
 	if *help {
 		showHelp()
 		return
 	}
 
-	if *add != "" {
-		handleAdd(*serverURL, *add)
-	} else if *list {
-		handleList(*serverURL)
-	} else if *del != "" {
-		handleDelete(*serverURL, *del)
-	} else {
+	out := *output
+	var err error
+	switch {
+	case out != outputTable && out != outputJSON && out != outputCSV:
+		err = usage("Invalid output format %q. Use: table, json, or csv", out)
+	case *complete != "":
+		err = handleCompletion(*complete)
+	case *add != "":
+		err = handleAdd(c, out, *add)
+	case *list:
+		err = handleList(c, out)
+	case *get != "":
+		err = handleGet(c, out, *get)
+	case *open != "":
+		err = handleOpen(c, out, *open)
+	case *copyArg != "":
+		err = handleCopy(c, out, *copyArg)
+	case *qr != "":
+		err = handleQR(c, out, *qr, *qrOut)
+	case *stats != "":
+		err = handleStats(c, out, *stats)
+	case *tuiFlag:
+		err = handleTUI(c)
+	case *top:
+		err = handleTop(c, out, *window)
+	case *update != "":
+		err = handleUpdate(c, out, *update)
+	case *del != "":
+		err = handleDelete(c, out, *del)
+	case *importArg != "":
+		err = handleImport(c, out, *importArg, strings.ToLower(*format))
+	case *restore != "":
+		err = handleRestore(c, out, *restore)
+	case *apply != "":
+		err = handleApply(c, out, *apply, client.SyncOptions{Prune: *prune, DryRun: *dryRun})
+	default:
 		showHelp()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		printError(err)
+		if errors.As(err, new(*usageError)) {
+			os.Exit(exitUsage)
+		}
+		os.Exit(exitFailure)
 	}
 }
 
@@ -50,147 +154,1782 @@ func showHelp() {
 	fmt.Println("Usage:")
 	fmt.Println("  go run cli.go -add shortcode,url    Add a new link")
 	fmt.Println("  go run cli.go -list                 List all links")
+	fmt.Println("  go run cli.go -get shortcode        Show a link and its clicks")
+	fmt.Println("  go run cli.go -update shortcode,url Change a link's URL")
+	fmt.Println("  go run cli.go -open shortcode       Open a link's destination in the browser")
+	fmt.Println("  go run cli.go -copy shortcode       Copy a link's short URL to the clipboard")
+	fmt.Println("  go run cli.go -qr shortcode         Show a link's QR code, or save it with -out qr.png")
+	fmt.Println("  go run cli.go -stats shortcode      Show a link's clicks by variant and referrer")
+	fmt.Println("  go run cli.go -top                  Show the most clicked and trending links")
 	fmt.Println("  go run cli.go -delete shortcode     Delete a link")
+	fmt.Println("  go run cli.go -import links.csv     Add links from a CSV file, or - for stdin")
+	fmt.Println("  go run cli.go -restore backup.db    Restore the server's database (admin)")
+	fmt.Println("  go run cli.go -apply links.yaml     Make the links match a links file; -prune deletes the rest")
+	fmt.Println("  go run cli.go -tui                  Browse and manage links in a terminal UI")
+	fmt.Println("  go run cli.go -completion bash      Print a shell completion script (bash, zsh, or fish)")
 	fmt.Println("  go run cli.go -help                 Show this help")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  go run cli.go -add google,www.google.com")
 	fmt.Println("  go run cli.go -add gh,github.com")
 	fmt.Println("  go run cli.go -list")
+	fmt.Println("  go run cli.go -output csv -list > links.csv")
+	fmt.Println("  go run cli.go -output json -get gh | jq -r .url")
+	fmt.Println("  go run cli.go -update gh,github.com/nryberg")
+	fmt.Println("  go run cli.go -open gh")
+	fmt.Println("  go run cli.go -copy gh")
+	fmt.Println("  go run cli.go -qr gh -out gh.svg")
+	fmt.Println("  go run cli.go -stats gh")
+	fmt.Println("  go run cli.go -top -window 7d")
 	fmt.Println("  go run cli.go -delete google")
+	fmt.Println("  go run cli.go -import links.csv")
+	fmt.Println("  printf 'gh,github.com\\ngo,go.dev\\n' | go run cli.go -import -")
+	fmt.Println("  go run cli.go -import bitly-links.csv -format bitly")
+	fmt.Println("  go run cli.go -import bookmarks.html -format bookmarks")
+	fmt.Println("  go run cli.go -user admin -restore links-20240501T030000.000Z.db")
+	fmt.Println("  go run cli.go -apply links.yaml -prune -dry-run")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  -server string    Server URL (default: http://localhost:8080)")
+	fmt.Println("  -user string      Username when the server has accounts (default: $LNK_USER)")
+	fmt.Println("  -output string    Output format: table, json, or csv (default: table)")
+	fmt.Println("  -window string    Window for -top: 24h, 7d, or 30d (default: 24h)")
+	fmt.Println("  -out string       File for -qr to save the QR code to, as .png or .svg")
+	fmt.Println("  -format string    What -import reads: lnk, yourls, shlink, bitly, kutt, or bookmarks (default: lnk)")
+	fmt.Println("  -prune            With -apply, delete the links the file doesn't list")
+	fmt.Println("  -dry-run          With -apply, show what would change without changing it")
+	fmt.Println()
+	fmt.Println("Environment:")
+	fmt.Println("  LNK_USER          Username when the server has accounts")
+	fmt.Println("  LNK_PASSWORD      Password for -user")
+	fmt.Println("  LNK_TOKEN         API token to use instead of -user (create one at /tokens)")
+	fmt.Println()
+	fmt.Println("Exit status is 0 on success, 1 when a command fails, and 2 on a usage error.")
 }
 
-func handleAdd(serverURL, addArg string) {
-	parts := strings.Split(addArg, ",")
+// splitLinkArg reads the shortcode,url argument of -add and -update.
+func splitLinkArg(arg string) (string, string, error) {
+	parts := strings.Split(arg, ",")
 	if len(parts) != 2 {
-		fmt.Println("Error: Invalid format. Use: shortcode,url")
-		return
+		return "", "", usage("Invalid format. Use: shortcode,url")
 	}
 
 	shortcode := strings.TrimSpace(parts[0])
 	url := strings.TrimSpace(parts[1])
 
 	if shortcode == "" || url == "" {
-		fmt.Println("Error: Both shortcode and URL are required")
-		return
+		return "", "", usage("Both shortcode and URL are required")
 	}
+	return shortcode, url, nil
+}
 
-	payload := map[string]string{
-		"shortcode": shortcode,
-		"url":       url,
+func handleAdd(c *client.Client, out, addArg string) error {
+	shortcode, url, err := splitLinkArg(addArg)
+	if err != nil {
+		return err
 	}
 
-	jsonData, err := json.Marshal(payload)
+	link, err := c.Create(context.Background(), client.Link{Shortcode: shortcode, URL: url})
 	if err != nil {
-		fmt.Printf("Error: Failed to encode JSON: %v\n", err)
-		return
+		return err
+	}
+	if out == outputTable {
+		fmt.Printf("✓ Link added: %s -> %s\n", shortcode, url)
+		return nil
 	}
+	return printLink(out, *link)
+}
 
-	resp, err := http.Post(serverURL+"/api/links", "application/json", strings.NewReader(string(jsonData)))
+func handleList(c *client.Client, out string) error {
+	links, _, err := c.List(context.Background(), client.ListOptions{})
 	if err != nil {
-		fmt.Printf("Error: Failed to connect to server: %v\n", err)
-		return
+		return err
+	}
+	if out != outputTable {
+		return printLinks(out, links)
 	}
-	defer resp.Body.Close()
 
-	var response CLIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		fmt.Printf("Error: Failed to decode response: %v\n", err)
-		return
+	if len(links) == 0 {
+		fmt.Println("No links found")
+		return nil
 	}
 
-	if response.Success {
-		fmt.Printf("✓ Link added: %s -> %s\n", shortcode, url)
-	} else {
-		fmt.Printf("Error: %s\n", response.Message)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SHORTCODE\tURL")
+	fmt.Fprintln(w, "---------\t---")
+
+	for _, link := range links {
+		fmt.Fprintf(w, "%s\t%s\n", link.Shortcode, link.URL)
 	}
+
+	return w.Flush()
 }
 
-func handleList(serverURL string) {
-	resp, err := http.Get(serverURL + "/api/links")
-	if err != nil {
-		fmt.Printf("Error: Failed to connect to server: %v\n", err)
-		return
+// linkDetails is a link as -get -output json prints it, with its clicks
+// per A/B variant.
+type linkDetails struct {
+	client.Link
+	VariantClicks map[string]int `json:"variant_clicks,omitempty"`
+}
+
+func handleGet(c *client.Client, out, shortcode string) error {
+	shortcode = strings.TrimSpace(shortcode)
+	if shortcode == "" {
+		return usage("Shortcode is required")
 	}
-	defer resp.Body.Close()
 
-	var response CLIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		fmt.Printf("Error: Failed to decode response: %v\n", err)
-		return
+	ctx := context.Background()
+	link, err := c.Get(ctx, shortcode)
+	if err != nil {
+		return err
 	}
 
-	if !response.Success {
-		fmt.Printf("Error: %s\n", response.Message)
-		return
+	// Clicks per A/B variant come from the stats endpoint
+	details := linkDetails{Link: *link}
+	if len(link.Variants) > 0 {
+		stats, err := c.Stats(ctx, shortcode)
+		if err != nil {
+			return err
+		}
+		details.VariantClicks = stats.Variants
 	}
 
-	data, ok := response.Data.([]interface{})
-	if !ok || len(data) == 0 {
-		fmt.Println("No links found")
-		return
+	switch out {
+	case outputJSON:
+		return printJSON(details)
+	case outputCSV:
+		return printLink(out, *link)
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "SHORTCODE\tURL")
-	fmt.Fprintln(w, "---------\t---")
+	fmt.Fprintf(w, "Shortcode:\t%s\n", link.Shortcode)
+	fmt.Fprintf(w, "Short URL:\t%s\n", link.ShortURL)
+	fmt.Fprintf(w, "URL:\t%s\n", link.URL)
+	if link.Title != "" {
+		fmt.Fprintf(w, "Title:\t%s\n", link.Title)
+	}
+	if link.Owner != "" {
+		fmt.Fprintf(w, "Owner:\t%s\n", link.Owner)
+	}
+	if link.CreatedAt != nil {
+		fmt.Fprintf(w, "Created:\t%s\n", link.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	lastUsed := "never"
+	if link.LastAccessedAt != nil {
+		lastUsed = link.LastAccessedAt.Local().Format("2006-01-02 15:04")
+	}
+	fmt.Fprintf(w, "Last used:\t%s\n", lastUsed)
+	fmt.Fprintf(w, "Clicks:\t%d\n", link.Clicks)
+	for _, v := range link.Variants {
+		fmt.Fprintf(w, "  %s:\t%d\n", v.Name, details.VariantClicks[v.Name])
+	}
+	return w.Flush()
+}
+
+// openCommand returns the command that opens url in the default
+// browser.
+func openCommand(url string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url)
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		return exec.Command("xdg-open", url)
+	}
+}
 
-	for _, item := range data {
-		link, ok := item.(map[string]interface{})
-		if !ok {
+// clipboardCommands are the commands that copy their input to the
+// clipboard, tried in order until one is installed.
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	default:
+		return [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	}
+}
+
+// linkAction is what -open and -copy print with -output json or csv.
+type linkAction struct {
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+}
+
+func printLinkAction(out, action string, a linkAction) error {
+	switch out {
+	case outputJSON:
+		return printJSON(a)
+	case outputCSV:
+		return printCSV([]string{"shortcode", "url"}, [][]string{{a.Shortcode, a.URL}})
+	}
+	fmt.Printf("✓ %s: %s\n", action, a.URL)
+	return nil
+}
+
+func handleOpen(c *client.Client, out, shortcode string) error {
+	shortcode = strings.TrimSpace(shortcode)
+	if shortcode == "" {
+		return usage("Shortcode is required")
+	}
+
+	link, err := c.Get(context.Background(), shortcode)
+	if err != nil {
+		return err
+	}
+	if err := openCommand(link.URL).Run(); err != nil {
+		return fmt.Errorf("Failed to open the browser: %v", err)
+	}
+	return printLinkAction(out, "Opened", linkAction{link.Shortcode, link.URL})
+}
+
+func handleCopy(c *client.Client, out, shortcode string) error {
+	shortcode = strings.TrimSpace(shortcode)
+	if shortcode == "" {
+		return usage("Shortcode is required")
+	}
+
+	link, err := c.Get(context.Background(), shortcode)
+	if err != nil {
+		return err
+	}
+	copied := false
+	for _, args := range clipboardCommands() {
+		if _, err := exec.LookPath(args[0]); err != nil {
 			continue
 		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(link.ShortURL)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Failed to copy to the clipboard: %v", err)
+		}
+		copied = true
+		break
+	}
+	if !copied {
+		return errors.New("No clipboard command found; install wl-copy, xclip, or xsel")
+	}
+	return printLinkAction(out, "Copied", linkAction{link.Shortcode, link.ShortURL})
+}
 
-		shortcode := getString(link, "shortcode")
-		url := getString(link, "url")
+// completionName is the command completion scripts complete, the CLI
+// built with go build -o lnk cli.go.
+const completionName = "lnk"
 
-		fmt.Fprintf(w, "%s\t%s\n", shortcode, url)
+// Flags whose values completion fills in: shortcodes fetched from the
+// server, files, or a fixed list.
+var (
+	shortcodeFlags = map[string]bool{"get": true, "update": true, "delete": true, "open": true, "copy": true, "stats": true}
+	fileFlags      = map[string]bool{"import": true, "restore": true, "apply": true}
+	choiceFlags    = map[string]string{
+		"output":     "table json csv",
+		"window":     "24h 7d 30d",
+		"completion": "bash zsh fish",
+		"format":     "lnk yourls shlink bitly kutt bookmarks",
 	}
+)
 
-	w.Flush()
+// bashCompletion completes flags and their values. Shortcodes come from
+// running the CLI with -list, passing on the -server and -user already on
+// the command line; COMP_LINE is split by hand because bash breaks
+// COMP_WORDS at the colons in a server URL.
+const bashCompletion = `_lnk_shortcodes() {
+    local words args=() i
+    read -ra words <<< "$COMP_LINE"
+    for ((i = 1; i < ${#words[@]} - 1; i++)); do
+        case ${words[i]} in
+            -server|--server|-user|--user) args+=("${words[i]}" "${words[i+1]}") ;;
+        esac
+    done
+    "${words[0]}" "${args[@]}" -output csv -list 2>/dev/null | tail -n +2 | cut -d, -f1
 }
 
-func handleDelete(serverURL, shortcode string) {
+_lnk() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    case ${prev#-} in
+        %[1]s)
+            COMPREPLY=($(compgen -W "$(_lnk_shortcodes)" -- "$cur"))
+            return ;;
+        -update|update)
+            # -update takes shortcode,url
+            compopt -o nospace 2>/dev/null
+            COMPREPLY=($(compgen -S , -W "$(_lnk_shortcodes)" -- "$cur"))
+            return ;;
+        %[2]s)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return ;;
+%[3]s        %[4]s)
+            return ;;
+    esac
+    COMPREPLY=($(compgen -W "%[5]s" -- "$cur"))
+}
+
+complete -o default -F _lnk %[6]s
+`
+
+// fishCompletion is the start of the fish script; a complete command per
+// flag follows.
+const fishCompletion = `function __lnk_shortcodes
+    set -l words (commandline -opc)
+    set -l args
+    for i in (seq 2 (math (count $words) - 1))
+        switch $words[$i]
+            case -server --server -user --user
+                set -a args $words[$i] $words[(math $i + 1)]
+        end
+    end
+    $words[1] $args -output csv -list 2>/dev/null | tail -n +2 | string split -f1 ,
+end
+
+complete -c %s -f
+`
+
+// handleCompletion prints the completion script for shell.
+func handleCompletion(shell string) error {
+	type flagInfo struct {
+		name, usage string
+		isBool      bool
+	}
+	var flags []flagInfo
+	flag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, flagInfo{f.Name, f.Usage, ok && b.IsBoolFlag()})
+	})
+
+	switch shell {
+	case "bash", "zsh":
+		var shortcodes, files, others, names []string
+		var choices strings.Builder
+		for _, f := range flags {
+			names = append(names, "-"+f.name)
+			switch {
+			case f.name == "update":
+			case shortcodeFlags[f.name]:
+				shortcodes = append(shortcodes, "-"+f.name)
+			case fileFlags[f.name]:
+				files = append(files, "-"+f.name)
+			case choiceFlags[f.name] != "":
+				fmt.Fprintf(&choices, "        -%s|%s)\n            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n            return ;;\n",
+					f.name, f.name, choiceFlags[f.name])
+			case !f.isBool:
+				others = append(others, "-"+f.name)
+			}
+		}
+		// ${prev#-} drops one dash, so -flag and --flag both match -name
+		// and name; list each flag both ways
+		alternatives := func(flags []string) string {
+			var both []string
+			for _, f := range flags {
+				both = append(both, f, f[1:])
+			}
+			return strings.Join(both, "|")
+		}
+		script := fmt.Sprintf(bashCompletion, alternatives(shortcodes), alternatives(files),
+			choices.String(), alternatives(others), strings.Join(names, " "), completionName)
+		if shell == "zsh" {
+			// zsh runs bash completion functions through bashcompinit
+			script = "autoload -U +X bashcompinit && bashcompinit\n\n" + script
+		}
+		fmt.Print(script)
+
+	case "fish":
+		fmt.Printf(fishCompletion, completionName)
+		for _, f := range flags {
+			line := fmt.Sprintf("complete -c %s -o %s -d %s", completionName, f.name, fishQuote(f.usage))
+			switch {
+			case shortcodeFlags[f.name]:
+				line += " -x -a '(__lnk_shortcodes)'"
+			case fileFlags[f.name]:
+				line += " -r -F"
+			case choiceFlags[f.name] != "":
+				line += " -x -a " + fishQuote(choiceFlags[f.name])
+			case !f.isBool:
+				line += " -x"
+			}
+			fmt.Println(line)
+		}
+
+	default:
+		return usage("Invalid shell %q. Use: bash, zsh, or fish", shell)
+	}
+	return nil
+}
+
+// fishQuote quotes s as a single-quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// qrPNGSize is the width and height of QR codes saved as PNG, in pixels,
+// as the server makes them.
+const qrPNGSize = 512
+
+// handleQR shows the QR code of a link's short URL in the terminal, or
+// saves it as a PNG or SVG file. It's made locally, with the same error
+// correction as the server's.
+func handleQR(c *client.Client, out, shortcode, path string) error {
+	shortcode = strings.TrimSpace(shortcode)
 	if shortcode == "" {
-		fmt.Println("Error: Shortcode is required")
-		return
+		return usage("Shortcode is required")
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if path != "" && ext != ".png" && ext != ".svg" {
+		return usage("Invalid file %q for -out. Use a .png or .svg file", path)
+	}
+	if path == "" && out != outputTable {
+		return usage("-qr -output %s needs a file to save the QR code to with -out", out)
 	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("DELETE", serverURL+"/api/links/"+shortcode, nil)
+	link, err := c.Get(context.Background(), shortcode)
 	if err != nil {
-		fmt.Printf("Error: Failed to create request: %v\n", err)
-		return
+		return err
+	}
+	code, err := qrcode.New(link.ShortURL, qrcode.Medium)
+	if err != nil {
+		return err
 	}
 
-	resp, err := client.Do(req)
+	switch ext {
+	case "":
+		fmt.Print(qrTerminal(code.Bitmap()))
+		fmt.Println(link.ShortURL)
+		return nil
+	case ".png":
+		err = code.WriteFile(qrPNGSize, path)
+	case ".svg":
+		err = os.WriteFile(path, []byte(qrSVG(code.Bitmap())), 0o644)
+	}
 	if err != nil {
-		fmt.Printf("Error: Failed to connect to server: %v\n", err)
-		return
+		return err
 	}
-	defer resp.Body.Close()
 
-	var response CLIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		fmt.Printf("Error: Failed to decode response: %v\n", err)
-		return
+	switch out {
+	case outputJSON:
+		return printJSON(map[string]string{"shortcode": link.Shortcode, "url": link.ShortURL, "file": path})
+	case outputCSV:
+		return printCSV([]string{"shortcode", "url", "file"}, [][]string{{link.Shortcode, link.ShortURL, path}})
+	}
+	fmt.Printf("✓ QR code for %s saved to %s\n", link.ShortURL, path)
+	return nil
+}
+
+// qrTerminal draws a QR code with ANSI colors, two rows of modules to a
+// line of half blocks. Its colors are set explicitly so it scans on dark
+// and light terminals alike.
+func qrTerminal(bitmap [][]bool) string {
+	color := func(dark bool, base int) int {
+		if dark {
+			return base // black
+		}
+		return base + 67 // bright white
+	}
+	var b strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			bottom := false // past the last row is light
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+			fmt.Fprintf(&b, "\x1b[%d;%dm▀", color(bitmap[y][x], 30), color(bottom, 40))
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+// qrSVG draws a QR code as an SVG image, a unit square per module, which
+// scales to any size.
+func qrSVG(bitmap [][]bool) string {
+	n := len(bitmap)
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="%d" height="%d" fill="#fff"/>
+<path d="%s" fill="#000"/>
+</svg>
+`, n, n, n, n, path.String())
+}
+
+// barWidth is the length of the longest bar in -stats and -top charts.
+const barWidth = 30
+
+// bar draws n out of most as a run of blocks.
+func bar(n, most int) string {
+	if most <= 0 {
+		return ""
+	}
+	return strings.Repeat("█", max(barWidth*n/most, min(n, 1)))
+}
+
+func handleStats(c *client.Client, out, shortcode string) error {
+	shortcode = strings.TrimSpace(shortcode)
+	if shortcode == "" {
+		return usage("Shortcode is required")
+	}
+
+	stats, err := c.Stats(context.Background(), shortcode)
+	if err != nil {
+		return err
+	}
+
+	switch out {
+	case outputJSON:
+		return printJSON(stats)
+	case outputCSV:
+		// One row per count: the total, then each variant and referrer
+		rows := [][]string{{"total", "", strconv.Itoa(stats.Clicks)}}
+		for _, name := range sortedKeys(stats.Variants) {
+			rows = append(rows, []string{"variant", name, strconv.Itoa(stats.Variants[name])})
+		}
+		for _, ref := range stats.Referrers {
+			rows = append(rows, []string{"referrer", ref.Referrer, strconv.Itoa(ref.Clicks)})
+		}
+		return printCSV([]string{"kind", "name", "clicks"}, rows)
+	}
+
+	fmt.Printf("%s: %d clicks\n", stats.Shortcode, stats.Clicks)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(stats.Variants) > 0 {
+		fmt.Fprintln(w, "\nVARIANT\tCLICKS\t")
+		for _, name := range sortedKeys(stats.Variants) {
+			n := stats.Variants[name]
+			fmt.Fprintf(w, "%s\t%d\t%s\n", name, n, bar(n, stats.Clicks))
+		}
+	}
+	if len(stats.Referrers) > 0 {
+		fmt.Fprintln(w, "\nREFERRER\tCLICKS\t")
+		for _, ref := range stats.Referrers {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", ref.Referrer, ref.Clicks, bar(ref.Clicks, stats.Referrers[0].Clicks))
+		}
+	}
+	return w.Flush()
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func handleTop(c *client.Client, out, window string) error {
+	if window != "24h" && window != "7d" && window != "30d" {
+		return usage("Invalid window %q. Use: 24h, 7d, or 30d", window)
+	}
+
+	top, err := c.Top(context.Background(), window)
+	if err != nil {
+		return err
+	}
+
+	switch out {
+	case outputJSON:
+		return printJSON(top)
+	case outputCSV:
+		var rows [][]string
+		for _, list := range []struct {
+			name  string
+			links []client.TrendingLink
+		}{{"top", top.Top}, {"trending", top.Trending}} {
+			for i, link := range list.links {
+				rows = append(rows, []string{
+					list.name, strconv.Itoa(i + 1), link.Shortcode, link.URL,
+					strconv.Itoa(link.Clicks), strconv.Itoa(link.PreviousClicks), strconv.Itoa(link.Change),
+				})
+			}
+		}
+		return printCSV([]string{"list", "rank", "shortcode", "url", "clicks", "previous_clicks", "change"}, rows)
+	}
+
+	fmt.Printf("Most clicked over the last %s\n", top.Window)
+	if len(top.Top) == 0 {
+		fmt.Println("No clicks yet")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHORTCODE\tCLICKS\tCHANGE\t")
+	for _, link := range top.Top {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", link.Shortcode, link.Clicks, formatChange(link.Change), bar(link.Clicks, top.Top[0].Clicks))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(top.Trending) > 0 {
+		fmt.Printf("\nTrending, against the %s before\n", top.Window)
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SHORTCODE\tCLICKS\tBEFORE\tCHANGE")
+		for _, link := range top.Trending {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", link.Shortcode, link.Clicks, link.PreviousClicks, formatChange(link.Change))
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+// formatChange shows a change in clicks with an arrow for its direction.
+func formatChange(change int) string {
+	switch {
+	case change > 0:
+		return fmt.Sprintf("▲ %d", change)
+	case change < 0:
+		return fmt.Sprintf("▼ %d", -change)
+	}
+	return "–"
+}
+
+func handleUpdate(c *client.Client, out, updateArg string) error {
+	shortcode, url, err := splitLinkArg(updateArg)
+	if err != nil {
+		return err
+	}
+
+	saved, err := updateURL(context.Background(), c, shortcode, url)
+	if err != nil {
+		return err
+	}
+	if out == outputTable {
+		fmt.Printf("✓ Link updated: %s -> %s\n", shortcode, url)
+		return nil
 	}
+	return printLink(out, *saved)
+}
+
+// updateURL changes a link's URL. An update replaces the whole link, so it
+// starts from the link's current settings to keep the rest.
+func updateURL(ctx context.Context, c *client.Client, shortcode, url string) (*client.Link, error) {
+	link, err := c.Get(ctx, shortcode)
+	if err != nil {
+		return nil, err
+	}
+	link.URL = url
+	return c.Update(ctx, *link)
+}
+
+func handleDelete(c *client.Client, out, shortcode string) error {
+	if shortcode == "" {
+		return usage("Shortcode is required")
+	}
+
+	if err := c.Delete(context.Background(), shortcode); err != nil {
+		return err
+	}
+	switch out {
+	case outputJSON:
+		return printJSON(map[string]any{"shortcode": shortcode, "deleted": true})
+	case outputCSV:
+		return printCSV([]string{"shortcode", "deleted"}, [][]string{{shortcode, "true"}})
+	}
+	fmt.Printf("✓ Link deleted: %s\n", shortcode)
+	return nil
+}
+
+// tuiLink is a link in the -tui list, with how well it matches the search.
+type tuiLink struct {
+	link  client.Link
+	score int
+}
+
+// tuiMode is what the keys of the terminal UI do at the moment.
+type tuiMode int
 
-	if response.Success {
-		fmt.Printf("✓ Link deleted: %s\n", shortcode)
+const (
+	tuiBrowsing tuiMode = iota
+	tuiSearching
+	tuiEditing  // changing the selected link's URL
+	tuiDeleting // asking whether to delete the selected link
+)
+
+// tui is the bubbletea model of the -tui terminal UI.
+type tui struct {
+	c      *client.Client
+	links  []client.Link
+	shown  []tuiLink // links matching the search, best first
+	mode   tuiMode
+	search textinput.Model
+	edit   textinput.Model
+	cursor int // index into shown
+	offset int // first row of shown on screen
+	status string
+	width  int
+	height int
+}
+
+// Messages the terminal UI's commands send back when they're done.
+type (
+	tuiLoaded  struct{ links []client.Link }
+	tuiSaved   struct{ link client.Link }
+	tuiDeleted struct{ shortcode string }
+	tuiStatus  string
+)
+
+var (
+	tuiBold     = lipgloss.NewStyle().Bold(true)
+	tuiFaint    = lipgloss.NewStyle().Faint(true)
+	tuiSelected = lipgloss.NewStyle().Reverse(true)
+	tuiNotice   = lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+)
+
+// handleTUI runs the terminal UI: the list of links, searched as you type
+// after /, with enter to open a link, e to edit its URL, and d to delete
+// it.
+func handleTUI(c *client.Client) error {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return usage("-tui needs a terminal")
+	}
+
+	links, _, err := c.List(context.Background(), client.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	t := &tui{c: c, links: links, search: textinput.New(), edit: textinput.New(), width: 80, height: 24}
+	t.search.Prompt = "/ "
+	t.filter()
+	_, err = tea.NewProgram(t, tea.WithAltScreen()).Run()
+	return err
+}
+
+func (t *tui) Init() tea.Cmd {
+	return nil
+}
+
+func (t *tui) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		t.width, t.height = msg.Width, msg.Height
+	case tuiLoaded:
+		t.links = msg.links
+		t.filter()
+		t.status = fmt.Sprintf("Loaded %d links", len(msg.links))
+	case tuiSaved:
+		for i := range t.links {
+			if t.links[i].Shortcode == msg.link.Shortcode {
+				t.links[i] = msg.link
+			}
+		}
+		t.filter()
+		t.status = fmt.Sprintf("✓ Link updated: %s -> %s", msg.link.Shortcode, msg.link.URL)
+	case tuiDeleted:
+		for i := range t.links {
+			if t.links[i].Shortcode == msg.shortcode {
+				t.links = append(t.links[:i], t.links[i+1:]...)
+				break
+			}
+		}
+		t.filter()
+		t.status = "✓ Link deleted: " + msg.shortcode
+	case tuiStatus:
+		t.status = string(msg)
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return t, tea.Quit
+		}
+		t.status = ""
+		switch t.mode {
+		case tuiSearching:
+			cmd = t.searchKey(msg)
+		case tuiEditing:
+			cmd = t.editKey(msg)
+		case tuiDeleting:
+			cmd = t.deleteKey(msg)
+		default:
+			cmd = t.listKey(msg)
+		}
+	default:
+		// Such as the cursor blinking in the line being typed
+		switch t.mode {
+		case tuiSearching:
+			t.search, cmd = t.search.Update(msg)
+		case tuiEditing:
+			t.edit, cmd = t.edit.Update(msg)
+		}
+	}
+	t.scroll()
+	return t, cmd
+}
+
+// listKey handles a key pressed while browsing the list.
+func (t *tui) listKey(msg tea.KeyMsg) tea.Cmd {
+	switch key := msg.String(); key {
+	case "q", "ctrl+d":
+		return tea.Quit
+	case "/":
+		t.mode = tuiSearching
+		return t.search.Focus()
+	case "esc":
+		t.search.SetValue("")
+		t.filter()
+	case "up", "k", "down", "j", "pgup", "pgdown":
+		t.move(key)
+	case "r":
+		return t.reload()
+	}
+	if len(t.shown) == 0 {
+		return nil
+	}
+
+	link := t.selected()
+	switch msg.String() {
+	case "enter":
+		return openLink(link.URL)
+	case "e":
+		t.mode = tuiEditing
+		t.edit.Prompt = fmt.Sprintf("New URL for %s: ", link.Shortcode)
+		t.edit.SetValue(link.URL)
+		t.edit.CursorEnd()
+		return t.edit.Focus()
+	case "d":
+		t.mode = tuiDeleting
+	}
+	return nil
+}
+
+// searchKey handles a key pressed while typing the search.
+func (t *tui) searchKey(msg tea.KeyMsg) tea.Cmd {
+	switch key := msg.String(); key {
+	case "enter", "esc":
+		t.mode = tuiBrowsing
+		t.search.Blur()
+		return nil
+	case "up", "down", "pgup", "pgdown":
+		t.move(key)
+		return nil
+	}
+	var cmd tea.Cmd
+	t.search, cmd = t.search.Update(msg)
+	t.filter()
+	return cmd
+}
+
+// editKey handles a key pressed while typing a link's new URL, saving it
+// on enter.
+func (t *tui) editKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		t.mode = tuiBrowsing
+		t.edit.Blur()
+		return nil
+	case "enter":
+		t.mode = tuiBrowsing
+		t.edit.Blur()
+		link, url := t.selected(), strings.TrimSpace(t.edit.Value())
+		if url == "" || url == link.URL {
+			return nil
+		}
+		c := t.c
+		return func() tea.Msg {
+			saved, err := updateURL(context.Background(), c, link.Shortcode, url)
+			if err != nil {
+				return tuiStatus("Error: " + errorMessage(err))
+			}
+			return tuiSaved{*saved}
+		}
+	}
+	var cmd tea.Cmd
+	t.edit, cmd = t.edit.Update(msg)
+	return cmd
+}
+
+// deleteKey deletes the selected link if the key answers yes.
+func (t *tui) deleteKey(msg tea.KeyMsg) tea.Cmd {
+	t.mode = tuiBrowsing
+	if key := msg.String(); key != "y" && key != "Y" {
+		return nil
+	}
+	c, shortcode := t.c, t.selected().Shortcode
+	return func() tea.Msg {
+		if err := c.Delete(context.Background(), shortcode); err != nil {
+			return tuiStatus("Error: " + errorMessage(err))
+		}
+		return tuiDeleted{shortcode}
+	}
+}
+
+func (t *tui) reload() tea.Cmd {
+	c := t.c
+	return func() tea.Msg {
+		links, _, err := c.List(context.Background(), client.ListOptions{})
+		if err != nil {
+			return tuiStatus("Error: " + errorMessage(err))
+		}
+		return tuiLoaded{links}
+	}
+}
+
+// openLink opens a destination in the browser.
+func openLink(url string) tea.Cmd {
+	return func() tea.Msg {
+		if err := openCommand(url).Run(); err != nil {
+			return tuiStatus(fmt.Sprintf("Failed to open the browser: %v", err))
+		}
+		return tuiStatus("Opened " + url)
+	}
+}
+
+// filter lists the links matching the search, best match first.
+func (t *tui) filter() {
+	query := t.search.Value()
+	t.shown = t.shown[:0]
+	for _, link := range t.links {
+		if query == "" {
+			t.shown = append(t.shown, tuiLink{link: link})
+			continue
+		}
+		// A match in the shortcode counts for more than one in the rest
+		best, matched := fuzzyScore(query, link.Shortcode)
+		best *= 2
+		if score, ok := fuzzyScore(query, link.Title+" "+link.URL); ok && (!matched || score > best) {
+			best, matched = score, true
+		}
+		if matched {
+			t.shown = append(t.shown, tuiLink{link: link, score: best})
+		}
+	}
+	sort.SliceStable(t.shown, func(i, j int) bool { return t.shown[i].score > t.shown[j].score })
+	t.cursor = min(t.cursor, max(len(t.shown)-1, 0))
+}
+
+// fuzzyScore reports whether the characters of query appear in text in
+// order, ignoring case, and scores the match: higher for characters that
+// run together or start a word.
+func fuzzyScore(query, text string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(text))
+	score, qi, prev := 0, 0, -2
+	for i, r := range t {
+		if qi == len(q) {
+			break
+		}
+		if r != q[qi] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 5
+		}
+		if i == 0 || strings.ContainsRune(" /.-_:", t[i-1]) {
+			score += 10
+		}
+		prev = i
+		qi++
+	}
+	return score, qi == len(q)
+}
+
+// listHeight is how many links fit on screen.
+func (t *tui) listHeight() int {
+	return max(t.height-5, 1)
+}
+
+func (t *tui) move(key string) {
+	switch key {
+	case "up", "k":
+		t.cursor--
+	case "down", "j":
+		t.cursor++
+	case "pgup":
+		t.cursor -= t.listHeight()
+	case "pgdown":
+		t.cursor += t.listHeight()
+	}
+	t.cursor = max(min(t.cursor, len(t.shown)-1), 0)
+}
+
+// scroll keeps the selected link on screen.
+func (t *tui) scroll() {
+	height := t.listHeight()
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+	if t.cursor >= t.offset+height {
+		t.offset = t.cursor - height + 1
+	}
+}
+
+func (t *tui) selected() client.Link {
+	return t.shown[t.cursor].link
+}
+
+func (t *tui) View() string {
+	var lines []string
+	line := func(style lipgloss.Style, text string) {
+		if r := []rune(text); len(r) > t.width {
+			text = string(r[:max(t.width-1, 0)]) + "…"
+		}
+		lines = append(lines, style.Render(text))
+	}
+
+	count := fmt.Sprintf("%d links", len(t.links))
+	if t.search.Value() != "" {
+		count = fmt.Sprintf("%d of %d links", len(t.shown), len(t.links))
+	}
+	line(tuiBold, "lnk · "+count)
+	if t.mode == tuiSearching || t.search.Value() != "" {
+		lines = append(lines, t.search.View())
 	} else {
-		fmt.Printf("Error: %s\n", response.Message)
+		line(tuiFaint, "Press / to search")
+	}
+	lines = append(lines, "")
+
+	width := 0
+	for _, l := range t.shown {
+		width = max(width, utf8.RuneCountInString(l.link.Shortcode))
+	}
+	width = min(width, 24)
+	for i := t.offset; i < t.offset+t.listHeight(); i++ {
+		if i >= len(t.shown) {
+			lines = append(lines, "")
+			continue
+		}
+		link := t.shown[i].link
+		text := fmt.Sprintf(" %-*s  %s", width, link.Shortcode, link.URL)
+		if link.Title != "" {
+			text += "  · " + link.Title
+		}
+		style := lipgloss.NewStyle()
+		if i == t.cursor {
+			style = tuiSelected
+			text += strings.Repeat(" ", max(t.width-utf8.RuneCountInString(text), 0))
+		}
+		line(style, text)
+	}
+
+	switch {
+	case t.mode == tuiEditing:
+		lines = append(lines, t.edit.View())
+	case t.mode == tuiDeleting:
+		line(tuiBold, fmt.Sprintf("Delete %s? (y/n)", t.selected().Shortcode))
+	case t.status != "":
+		line(tuiNotice, t.status)
+	case len(t.shown) == 0:
+		line(tuiFaint, "No links found")
+	default:
+		lines = append(lines, "")
+	}
+	keys := "↑/↓ move  enter open  e edit  d delete  / search  r reload  q quit"
+	switch t.mode {
+	case tuiSearching:
+		keys = "type to search  ↑/↓ move  enter done  esc done  ctrl+u clear"
+	case tuiEditing:
+		keys = "enter save  esc cancel"
+	}
+	line(tuiFaint, keys)
+	return strings.Join(lines, "\n")
+}
+
+// importBatchSize is how many links -import sends per batch request.
+const importBatchSize = 100
+
+// importRow is a link read by -import, and what became of it.
+type importRow struct {
+	Line      int    `json:"line"`
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+	Error     string `json:"error,omitempty"`
+	link      client.Link
+}
+
+// importSummary is what -import -output json prints.
+type importSummary struct {
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Failures []importRow `json:"failures"`
+}
+
+// importFormat describes the CSV export of a link shortener: the header
+// names each of lnk's fields may have, lowercase, and for exports that can
+// come without a header row, the fields in column order.
+type importFormat struct {
+	columns map[string][]string
+	order   []string
+	tagSeps string // characters separating tags; whitespace if empty
+	short   bool   // the shortcode column holds short URLs, as in bit.ly/abc
+}
+
+// importFormats are the exports -import reads with -format. Kutt's, JSON
+// from its API, and browser bookmarks are read by readKutt and
+// readBookmarks instead.
+var importFormats = map[string]importFormat{
+	// -output csv -list, or rows of shortcode,url
+	"lnk": {
+		columns: map[string][]string{
+			"shortcode": {"shortcode"}, "url": {"url"}, "title": {"title"}, "tags": {"tags"},
+			"group": {"group"}, "clicks": {"clicks"},
+		},
+		order: []string{"shortcode", "url"},
+	},
+	// The yourls_url table, as export plugins and database dumps write it
+	"yourls": {
+		columns: map[string][]string{
+			"shortcode": {"keyword"}, "url": {"url"}, "title": {"title"}, "clicks": {"clicks"},
+		},
+		order: []string{"shortcode", "url", "title", "timestamp", "ip", "clicks"},
+	},
+	// The web client's export of the short URLs list
+	"shlink": {
+		columns: map[string][]string{
+			"shortcode": {"shortcode", "short code"}, "url": {"longurl", "long url"}, "title": {"title"},
+			"tags": {"tags"}, "clicks": {"visits", "visitscount"},
+		},
+		tagSeps: "|",
+	},
+	// The links page's CSV export
+	"bitly": {
+		columns: map[string][]string{
+			"shortcode": {"bitlink", "link", "short link", "short url"},
+			"url":       {"long_url", "long url", "destination", "destination url", "original url"},
+			"title":     {"title", "link title"}, "tags": {"tags"},
+			"clicks": {"clicks", "total clicks", "engagements", "total engagements"},
+		},
+		tagSeps: ",;|",
+		short:   true,
+	},
+}
+
+// formatNames lists the values -format takes.
+const formatNames = "lnk, yourls, shlink, bitly, kutt, or bookmarks"
+
+// readImport reads the links to import from a file, or stdin for -, in
+// the given format. Lines of a CSV starting with # are skipped.
+func readImport(path, format string) ([]importRow, error) {
+	spec, ok := importFormats[format]
+	if !ok && format != "kutt" && format != "bookmarks" {
+		return nil, usage("Invalid import format %q. Use: %s", format, formatNames)
 	}
+
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	switch format {
+	case "kutt":
+		return readKutt(f)
+	case "bookmarks":
+		return readBookmarks(f)
+	}
+	return readImportCSV(f, path, format, spec)
 }
 
-func getString(m map[string]interface{}, key string) string {
-	if val, ok := m[key]; ok {
-		if str, ok := val.(string); ok {
-			return str
+// readImportCSV reads a CSV export. A header row, recognized by naming the
+// shortcode or URL column, may have its columns in any order; without one
+// the columns are taken in spec.order.
+func readImportCSV(f io.Reader, path, format string, spec importFormat) ([]importRow, error) {
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	var records [][]string
+	var lines []int
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	headers := map[string]string{} // header name to field
+	for field, names := range spec.columns {
+		for _, name := range names {
+			headers[name] = field
+		}
+	}
+	isHeader := false
+	if len(records) > 0 {
+		for _, name := range records[0] {
+			field := headers[strings.ToLower(strings.TrimSpace(name))]
+			isHeader = isHeader || field == "shortcode" || field == "url"
+		}
+	}
+
+	cols := map[string]int{}
+	if isHeader {
+		for i, name := range records[0] {
+			if field, ok := headers[strings.ToLower(strings.TrimSpace(name))]; ok {
+				if _, seen := cols[field]; !seen {
+					cols[field] = i
+				}
+			}
+		}
+		if _, ok := cols["url"]; !ok {
+			return nil, usage("The header row of %s has no %s column", path, spec.columns["url"][0])
+		}
+		records, lines = records[1:], lines[1:]
+	} else if len(records) > 0 {
+		if spec.order == nil {
+			return nil, usage("%s has no header row naming the columns of a %s export", path, format)
+		}
+		for i, field := range spec.order {
+			cols[field] = i
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]importRow, 0, len(records))
+	for i, record := range records {
+		row := importRow{Line: lines[i], Shortcode: field(record, "shortcode"), URL: field(record, "url")}
+		if spec.short {
+			row.Shortcode = shortcodeOf(row.Shortcode)
+		}
+		tags := strings.Fields(field(record, "tags"))
+		if spec.tagSeps != "" {
+			tags = splitTags(field(record, "tags"), spec.tagSeps)
+		}
+		row.link = client.Link{
+			Shortcode: row.Shortcode,
+			URL:       row.URL,
+			Title:     field(record, "title"),
+			Tags:      tags,
+			Group:     field(record, "group"),
+		}
+		if clicks := field(record, "clicks"); clicks != "" {
+			n, err := strconv.Atoi(strings.ReplaceAll(clicks, ",", ""))
+			if err != nil || n < 0 {
+				row.Error = fmt.Sprintf("Invalid click count %q", clicks)
+			}
+			row.link.Clicks = n
 		}
+		if row.Shortcode == "" || row.URL == "" {
+			row.Error = "Both shortcode and URL are required"
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// shortcodeOf returns the shortcode of a short URL, such as abc for
+// https://bit.ly/abc.
+func shortcodeOf(shortURL string) string {
+	shortURL = strings.TrimRight(shortURL, "/")
+	return shortURL[strings.LastIndex(shortURL, "/")+1:]
+}
+
+// splitTags splits a tags column on any of seps.
+func splitTags(s, seps string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool { return strings.ContainsRune(seps, r) }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// kuttLink is a link as Kutt's API lists them, from GET /api/v2/links.
+type kuttLink struct {
+	Address     string `json:"address"`
+	Target      string `json:"target"`
+	Description string `json:"description"`
+	VisitCount  int    `json:"visit_count"`
+}
+
+// readKutt reads a list of links from Kutt's API: the response, with the
+// links under data, or the links on their own. Each link's line is its
+// place in the list.
+func readKutt(f io.Reader) ([]importRow, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var links []kuttLink
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &links)
+	} else {
+		var page struct {
+			Data []kuttLink `json:"data"`
+		}
+		err = json.Unmarshal(data, &page)
+		links = page.Data
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Kutt links: %v", err)
+	}
+
+	rows := make([]importRow, 0, len(links))
+	for i, link := range links {
+		row := importRow{Line: i + 1, Shortcode: strings.TrimSpace(link.Address), URL: strings.TrimSpace(link.Target)}
+		row.link = client.Link{
+			Shortcode:   row.Shortcode,
+			URL:         row.URL,
+			Description: strings.TrimSpace(link.Description),
+			Clicks:      link.VisitCount,
+		}
+		if row.Shortcode == "" || row.URL == "" {
+			row.Error = "Both address and target are required"
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// bookmarkRootAttrs mark the browser's own folders, such as the bookmarks
+// bar, which aren't part of shortcodes.
+var bookmarkRootAttrs = map[string]bool{"personal_toolbar_folder": true, "unfiled_bookmarks_folder": true}
+
+// bookmarkFolder is a folder of a bookmark export.
+type bookmarkFolder struct {
+	name string
+	root bool
+}
+
+// readBookmarks reads a browser's bookmark export, in the Netscape
+// bookmark file format that every browser writes: each folder is an <H3>
+// followed by a <DL> list of its bookmarks and subfolders, and each
+// bookmark an <A>, with an optional <DD> description after it. Bookmarks
+// of anything but web pages, such as bookmarklets, are skipped.
+func readBookmarks(f io.Reader) ([]importRow, error) {
+	z := html.NewTokenizer(f)
+	var rows []importRow
+	var paths [][]string // each row's folders
+	var folders []bookmarkFolder
+	var heading *bookmarkFolder // the folder whose list comes next
+	var text *string            // where the element's text goes
+	var described *importRow    // the bookmark a <DD> would describe
+	line := 1
+	for {
+		tt := z.Next()
+		tokenLine := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+		if tt == html.ErrorToken {
+			if z.Err() == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("Failed to read bookmarks: %v", z.Err())
+		}
+		if tt == html.TextToken {
+			if text != nil {
+				*text += string(z.Text())
+			}
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		attrs := map[string]string{}
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			attrs[string(key)] = string(val)
+		}
+		if tt == html.EndTagToken {
+			switch string(name) {
+			case "h3", "a":
+				text = nil
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			}
+			continue
+		}
+		prev := described
+		text, described = nil, nil
+		switch string(name) {
+		case "h3":
+			heading = &bookmarkFolder{}
+			for attr := range attrs {
+				heading.root = heading.root || bookmarkRootAttrs[attr]
+			}
+			text = &heading.name
+		case "dl":
+			// The outermost list, of the bookmarks in no folder, has no
+			// heading
+			if heading == nil {
+				heading = &bookmarkFolder{root: true}
+			}
+			folders = append(folders, *heading)
+			heading = nil
+		case "a":
+			href := strings.TrimSpace(attrs["href"])
+			if u, err := url.Parse(href); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			var path []string
+			for _, folder := range folders {
+				if !folder.root {
+					path = append(path, folder.name)
+				}
+			}
+			rows = append(rows, importRow{Line: tokenLine, URL: href})
+			paths = append(paths, path)
+			row := &rows[len(rows)-1]
+			row.link = client.Link{URL: href, Tags: splitTags(attrs["tags"], ",")}
+			text, described = &row.link.Title, row
+		case "dd":
+			if prev != nil {
+				text = &prev.link.Description
+			}
+		}
+	}
+
+	taken := map[string]bool{}
+	for i := range rows {
+		row := &rows[i]
+		row.link.Title = strings.TrimSpace(row.link.Title)
+		row.link.Description = strings.TrimSpace(row.link.Description)
+		row.Shortcode = bookmarkShortcode(paths[i], row.link.Title, row.URL, taken)
+		row.link.Shortcode = row.Shortcode
+		if row.Shortcode == "" {
+			row.Error = "No shortcode can be made from the bookmark's folders and name"
+		}
+	}
+	return rows, nil
+}
+
+// bookmarkShortcode makes a bookmark's shortcode from its folders and
+// name, as work-jira for Jira in the Work folder, or from the page's host
+// if the bookmark has no name. Shortcodes already taken get a number.
+func bookmarkShortcode(folders []string, name, rawURL string, taken map[string]bool) string {
+	if slug(name) == "" {
+		if u, err := url.Parse(rawURL); err == nil {
+			name = strings.TrimPrefix(u.Hostname(), "www.")
+		}
+	}
+	var parts []string
+	for _, part := range append(folders, name) {
+		if part = slug(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	shortcode := strings.Join(parts, "-")
+	if len(shortcode) > maxImportShortcode {
+		shortcode = strings.TrimRight(shortcode[:maxImportShortcode], "-")
+	}
+	if shortcode == "" {
+		return ""
+	}
+	unique := shortcode
+	for n := 2; taken[unique]; n++ {
+		suffix := "-" + strconv.Itoa(n)
+		unique = strings.TrimRight(shortcode[:min(len(shortcode), maxImportShortcode-len(suffix))], "-") + suffix
+	}
+	taken[unique] = true
+	return unique
+}
+
+// maxImportShortcode is the longest shortcode readBookmarks makes, the
+// server's default limit.
+const maxImportShortcode = 64
+
+// slug turns a name into part of a shortcode: its letters and digits,
+// lowercase, with a dash for each run of anything else. Letters outside
+// ASCII are dropped, as the default SHORTCODE_PATTERN doesn't allow them.
+func slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= utf8.RuneSelf || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			dash = true
+			continue
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+		dash = false
+	}
+	return b.String()
+}
+
+func handleImport(c *client.Client, out, path, format string) error {
+	rows, err := readImport(path, format)
+	if err != nil {
+		return err
+	}
+
+	progress := newProgressBar(len(rows))
+	for start := 0; start < len(rows); start += importBatchSize {
+		chunk := rows[start:min(start+importBatchSize, len(rows))]
+		if err := importChunk(c, chunk); err != nil {
+			progress.done()
+			return err
+		}
+		progress.set(start + len(chunk))
+	}
+	progress.done()
+
+	summary := importSummary{Failures: []importRow{}}
+	for _, row := range rows {
+		if row.Error != "" {
+			summary.Failed++
+			summary.Failures = append(summary.Failures, row)
+		} else {
+			summary.Imported++
+		}
+	}
+
+	switch out {
+	case outputJSON:
+		err = printJSON(summary)
+	case outputCSV:
+		records := make([][]string, 0, len(rows))
+		for _, row := range rows {
+			result := "imported"
+			if row.Error != "" {
+				result = "failed"
+			}
+			records = append(records, []string{strconv.Itoa(row.Line), row.Shortcode, row.URL, result, row.Error})
+		}
+		err = printCSV([]string{"line", "shortcode", "url", "result", "error"}, records)
+	default:
+		fmt.Printf("✓ Imported %d links", summary.Imported)
+		if summary.Failed > 0 {
+			fmt.Printf(", %d failed:", summary.Failed)
+		}
+		fmt.Println()
+		// Kutt's links are numbered by their place in its JSON
+		place := "line"
+		if format == "kutt" {
+			place = "link"
+		}
+		for _, row := range summary.Failures {
+			fmt.Printf("  %s %d (%s): %s\n", place, row.Line, row.Shortcode, row.Error)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d links failed to import", summary.Failed, len(rows))
+	}
+	return nil
+}
+
+// importChunk creates the links in rows with one batch request. A batch is
+// all or nothing, so when the server rejects it, the links it found fault
+// with are marked failed and the rest sent again.
+func importChunk(c *client.Client, rows []importRow) error {
+	for {
+		var ops []client.BatchOperation
+		var pending []*importRow
+		for i := range rows {
+			if rows[i].Error == "" {
+				ops = append(ops, client.CreateOp(rows[i].link))
+				pending = append(pending, &rows[i])
+			}
+		}
+		if len(ops) == 0 {
+			return nil
+		}
+
+		results, err := c.Batch(context.Background(), ops)
+		if err == nil {
+			return nil
+		}
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || len(results) != len(ops) {
+			return err
+		}
+		rejected := false
+		for i, result := range results {
+			if result.Status >= 400 {
+				pending[i].Error = result.Message
+				rejected = true
+			}
+		}
+		if !rejected {
+			return err
+		}
+	}
+}
+
+// progressBar shows how far -import has got on stderr, when that's a
+// terminal.
+type progressBar struct {
+	total int
+	shown bool
+}
+
+func newProgressBar(total int) *progressBar {
+	info, err := os.Stderr.Stat()
+	return &progressBar{total: total, shown: err == nil && info.Mode()&os.ModeCharDevice != 0 && total > 0}
+}
+
+func (p *progressBar) set(n int) {
+	if !p.shown {
+		return
+	}
+	const width = 30
+	filled := width * n / p.total
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %d/%d", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), n, p.total)
+}
+
+// done ends the progress bar's line.
+func (p *progressBar) done() {
+	if p.shown {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func handleRestore(c *client.Client, out, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	restore, err := c.Restore(context.Background(), f)
+	if err != nil {
+		return err
+	}
+	switch out {
+	case outputJSON:
+		return printJSON(restore)
+	case outputCSV:
+		return printCSV([]string{"source", "links", "previous_backup", "restored_at"}, [][]string{{
+			restore.Source, strconv.Itoa(restore.Links), restore.PreviousBackup, restore.RestoredAt.Format(time.RFC3339),
+		}})
+	}
+	fmt.Printf("✓ Database restored from %s: %d links\n", path, restore.Links)
+	fmt.Printf("  The previous database was saved on the server as %s\n", restore.PreviousBackup)
+	return nil
+}
+
+func handleApply(c *client.Client, out, path string, opts client.SyncOptions) error {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	result, err := c.Sync(context.Background(), f, opts)
+	if err != nil {
+		return err
+	}
+	changes := []struct {
+		sign, action string
+		links        []string
+	}{
+		{"+", "create", result.Created},
+		{"~", "update", result.Updated},
+		{"-", "delete", result.Deleted},
+	}
+	switch out {
+	case outputJSON:
+		return printJSON(result)
+	case outputCSV:
+		var rows [][]string
+		for _, change := range changes {
+			for _, link := range change.links {
+				rows = append(rows, []string{change.action, link})
+			}
+		}
+		return printCSV([]string{"action", "link"}, rows)
+	}
+
+	for _, change := range changes {
+		for _, link := range change.links {
+			fmt.Printf("%s %s\n", change.sign, link)
+		}
+	}
+	if result.DryRun {
+		fmt.Printf("Dry run, nothing changed: %d to create, %d to update, %d to delete, %d unchanged\n",
+			len(result.Created), len(result.Updated), len(result.Deleted), result.Unchanged)
+		return nil
+	}
+	fmt.Printf("✓ Applied %s: %d created, %d updated, %d deleted, %d unchanged\n",
+		path, len(result.Created), len(result.Updated), len(result.Deleted), result.Unchanged)
+	return nil
+}
+
+// linkCSVHeader is the header row of links printed as CSV.
+var linkCSVHeader = []string{
+	"shortcode", "url", "short_url", "title", "tags", "group", "owner", "clicks", "created_at", "last_accessed_at",
+}
+
+// printLink prints the link a command made or changed, as a JSON object
+// or a CSV row under a header.
+func printLink(out string, link client.Link) error {
+	if out == outputJSON {
+		return printJSON(link)
+	}
+	return printLinks(out, []client.Link{link})
+}
+
+// printLinks prints links as a JSON array, or as CSV with a header row.
+func printLinks(out string, links []client.Link) error {
+	if out == outputJSON {
+		if links == nil {
+			links = []client.Link{}
+		}
+		return printJSON(links)
+	}
+
+	rows := make([][]string, 0, len(links))
+	for _, link := range links {
+		rows = append(rows, []string{
+			link.Shortcode, link.URL, link.ShortURL, link.Title, strings.Join(link.Tags, " "), link.Group,
+			link.Owner, strconv.Itoa(link.Clicks), formatTime(link.CreatedAt), formatTime(link.LastAccessedAt),
+		})
+	}
+	return printCSV(linkCSVHeader, rows)
+}
+
+// formatTime formats an optional time for CSV: RFC 3339, or empty.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printCSV(header []string, rows [][]string) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+// printError reports a failure on stderr.
+func printError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", errorMessage(err))
+}
+
+// errorMessage describes a failure, telling the server's own message apart
+// from not reaching it at all.
+func errorMessage(err error) string {
+	var apiErr *client.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Message
+	case errors.As(err, &urlErr):
+		return fmt.Sprintf("Failed to connect to server: %v", err)
+	default:
+		return err.Error()
 	}
-	return ""
 }
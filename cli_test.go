@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nryberg/lnk/client"
+)
+
+// writeImport writes an export to import to a temporary file.
+func writeImport(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestReadImport checks that each shortener's export is read into the
+// links it describes, whichever order its columns come in.
+func TestReadImport(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+		want   []client.Link
+	}{
+		{
+			"lnk",
+			"docs,https://dest.example/docs\n# skipped\nblog,https://dest.example/blog\n",
+			[]client.Link{
+				{Shortcode: "docs", URL: "https://dest.example/docs"},
+				{Shortcode: "blog", URL: "https://dest.example/blog"},
+			},
+		},
+		{
+			"lnk",
+			"url,shortcode,tags,group,clicks\nhttps://dest.example/docs,docs,a b,team,7\n",
+			[]client.Link{{Shortcode: "docs", URL: "https://dest.example/docs", Tags: []string{"a", "b"}, Group: "team", Clicks: 7}},
+		},
+		{
+			"yourls",
+			"docs,https://dest.example/docs,Docs,2024-01-02 03:04:05,127.0.0.1,12\n",
+			[]client.Link{{Shortcode: "docs", URL: "https://dest.example/docs", Title: "Docs", Clicks: 12}},
+		},
+		{
+			"shlink",
+			"Long URL,Short URL,Short code,Title,Tags,Visits\nhttps://dest.example/docs,https://s.example/docs,docs,Docs,guides|api,3\n",
+			[]client.Link{{Shortcode: "docs", URL: "https://dest.example/docs", Title: "Docs", Tags: []string{"guides", "api"}, Clicks: 3}},
+		},
+		{
+			"bitly",
+			"Title,Bitlink,Long URL,Tags,Total Engagements\nDocs,https://bit.ly/docs,https://dest.example/docs,\"guides, api\",\"1,204\"\n",
+			[]client.Link{{Shortcode: "docs", URL: "https://dest.example/docs", Title: "Docs", Tags: []string{"guides", "api"}, Clicks: 1204}},
+		},
+		{
+			"kutt",
+			`{"limit":10,"data":[{"address":"docs","target":"https://dest.example/docs","description":"Docs","visit_count":5}]}`,
+			[]client.Link{{Shortcode: "docs", URL: "https://dest.example/docs", Description: "Docs", Clicks: 5}},
+		},
+		{
+			"kutt",
+			`[{"address":"docs","target":"https://dest.example/docs"}]`,
+			[]client.Link{{Shortcode: "docs", URL: "https://dest.example/docs"}},
+		},
+	}
+	for _, tt := range tests {
+		rows, err := readImport(writeImport(t, "export", tt.data), tt.format)
+		if err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+		var got []client.Link
+		for _, row := range rows {
+			if row.Error != "" {
+				t.Errorf("%s: line %d: %s", tt.format, row.Line, row.Error)
+			}
+			if len(row.link.Tags) == 0 {
+				row.link.Tags = nil // sent the same either way
+			}
+			got = append(got, row.link)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: read %+v, want %+v", tt.format, got, tt.want)
+		}
+	}
+}
+
+// TestReadImportErrors checks that exports that can't be read are refused
+// as a whole, and that bad rows are reported with their line.
+func TestReadImportErrors(t *testing.T) {
+	var usageErr *usageError
+	if _, err := readImport(writeImport(t, "export", "docs,https://dest.example/docs\n"), "tinyurl"); !errors.As(err, &usageErr) {
+		t.Errorf("unknown format: %v, want a usage error", err)
+	}
+	if _, err := readImport(writeImport(t, "export", "docs,https://dest.example/docs\n"), "shlink"); err == nil || !strings.Contains(err.Error(), "no header row") {
+		t.Errorf("shlink export without a header: %v, want an error", err)
+	}
+	if _, err := readImport(writeImport(t, "export", "Short code,Title\ndocs,Docs\n"), "shlink"); err == nil || !strings.Contains(err.Error(), "no longurl column") {
+		t.Errorf("shlink export without a URL column: %v, want an error", err)
+	}
+	if _, err := readImport(writeImport(t, "export", `{"data":`), "kutt"); err == nil {
+		t.Error("truncated Kutt JSON: want an error")
+	}
+
+	rows, err := readImport(writeImport(t, "export", "shortcode,url,clicks\ndocs,,1\nblog,https://dest.example/blog,many\n"), "lnk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Line != 2 || rows[0].Error == "" || rows[1].Line != 3 || !strings.Contains(rows[1].Error, "click count") {
+		t.Errorf("rows %+v, want lines 2 and 3 to fail", rows)
+	}
+}
+
+// TestReadBookmarks checks that a browser's bookmark export becomes links
+// named after each bookmark's folders and title.
+func TestReadBookmarks(t *testing.T) {
+	data := `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1700000000" PERSONAL_TOOLBAR_FOLDER="true">Bookmarks bar</H3>
+    <DL><p>
+        <DT><H3>Work</H3>
+        <DL><p>
+            <DT><A HREF="https://jira.example/" TAGS="tickets,work">Jira</A>
+            <DD>Issue tracker
+            <DT><A HREF="https://jira.example/board">Jira</A>
+        </DL><p>
+        <DT><A HREF="javascript:alert(1)">Bookmarklet</A>
+        <DT><A HREF="https://www.news.example/"></A>
+    </DL><p>
+    <DT><A HREF="https://dest.example/menu">Café Menu!</A>
+</DL><p>
+`
+	rows, err := readImport(writeImport(t, "bookmarks.html", data), "bookmarks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []importRow{
+		{Line: 10, Shortcode: "work-jira", URL: "https://jira.example/", link: client.Link{
+			Shortcode: "work-jira", URL: "https://jira.example/", Title: "Jira", Description: "Issue tracker", Tags: []string{"tickets", "work"},
+		}},
+		{Line: 12, Shortcode: "work-jira-2", URL: "https://jira.example/board", link: client.Link{
+			Shortcode: "work-jira-2", URL: "https://jira.example/board", Title: "Jira",
+		}},
+		{Line: 15, Shortcode: "news-example", URL: "https://www.news.example/", link: client.Link{
+			Shortcode: "news-example", URL: "https://www.news.example/",
+		}},
+		{Line: 17, Shortcode: "caf-menu", URL: "https://dest.example/menu", link: client.Link{
+			Shortcode: "caf-menu", URL: "https://dest.example/menu", Title: "Café Menu!",
+		}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("read %+v, want %+v", rows, want)
+	}
+}
+
+// TestBookmarkShortcode checks how shortcodes are made from folder and
+// bookmark names.
+func TestBookmarkShortcode(t *testing.T) {
+	taken := map[string]bool{}
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		folders []string
+		name    string
+		url     string
+		want    string
+	}{
+		{[]string{"Team Docs"}, "API / Reference", "https://dest.example/", "team-docs-api-reference"},
+		{nil, "  ", "https://www.dest.example/", "dest-example"},
+		{nil, "", "https://www.dest.example/other", "dest-example-2"},
+		{nil, long, "https://dest.example/", strings.Repeat("a", 64)},
+		{nil, long, "https://dest.example/", strings.Repeat("a", 62) + "-2"},
+		{nil, "日本", "file:///", ""},
+	}
+	for _, tt := range tests {
+		if got := bookmarkShortcode(tt.folders, tt.name, tt.url, taken); got != tt.want {
+			t.Errorf("bookmarkShortcode(%q, %q) = %q, want %q", tt.folders, tt.name, got, tt.want)
+		}
+	}
+}
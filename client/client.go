@@ -0,0 +1,376 @@
+// Package client talks to a Link Forwarder server over its REST API.
+//
+//	c := client.New("http://localhost:8080", client.WithBasicAuth("admin", password))
+//	link, err := c.Create(ctx, client.Link{Shortcode: "docs", URL: "https://docs.example.com"})
+//	if errors.Is(err, client.ErrForbidden) {
+//		// someone else owns "docs"
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the API of one server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	username   string
+	password   string
+	token      string
+	domain     string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithBasicAuth authenticates as an account on servers with accounts
+// enabled.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username, c.password = username, password
+	}
+}
+
+// WithBearerToken authenticates with an API token (lnk_...) or an OpenID
+// Connect ID token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithDomain works with the links of one of the server's custom domains
+// instead of the default namespace.
+func WithDomain(domain string) Option {
+	return func(c *Client) {
+		c.domain = domain
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://go.example.com" or "http://localhost:8080/lnk".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// response is the envelope every API reply comes in.
+type response struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Code    string          `json:"code,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Meta    json.RawMessage `json:"meta,omitempty"`
+}
+
+// do sends a request to path, relative to /api/v1, and decodes the envelope's
+// data and meta into data and meta when they're non-nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, data, meta any) error {
+	if c.domain != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("domain", c.domain)
+	}
+	u := c.baseURL + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	// Readers are sent as they are, anything else as JSON
+	var reqBody io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reqBody, contentType = b, "application/octet-stream"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var env response
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		if resp.StatusCode >= 400 {
+			return &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode), RequestID: resp.Header.Get("X-Request-ID")}
+		}
+		return fmt.Errorf("invalid response from %s: %v", u, err)
+	}
+	// Some failures, like a rejected batch, still come with data
+	if data != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, data); err != nil {
+			return fmt.Errorf("invalid response from %s: %v", u, err)
+		}
+	}
+	if resp.StatusCode >= 400 || !env.Success {
+		return &Error{StatusCode: resp.StatusCode, Code: env.Code, Message: env.Message, RequestID: resp.Header.Get("X-Request-ID")}
+	}
+
+	if meta != nil && len(env.Meta) > 0 {
+		if err := json.Unmarshal(env.Meta, meta); err != nil {
+			return fmt.Errorf("invalid response from %s: %v", u, err)
+		}
+	}
+	return nil
+}
+
+// linkPath returns the API path of a link's resource.
+func linkPath(shortcode string, rest ...string) string {
+	parts := append([]string{"/links", url.PathEscape(shortcode)}, rest...)
+	return strings.Join(parts, "/")
+}
+
+// Create saves a new link and returns it as stored. The API replaces an
+// existing link with the same shortcode, so use Get first when that
+// matters.
+func (c *Client) Create(ctx context.Context, link Link) (*Link, error) {
+	var saved Link
+	if err := c.do(ctx, http.MethodPost, "/links", nil, link, &saved, nil); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Shorten returns a short link for rawURL, creating it unless one exists:
+// with a shortcode, the link under it if it already points to rawURL (or
+// ErrConflict if it points elsewhere); without one, any plain link to
+// rawURL, or a new one with a generated shortcode.
+func (c *Client) Shorten(ctx context.Context, rawURL, shortcode string) (*Link, error) {
+	query := url.Values{"url": {rawURL}, "format": {"json"}}
+	if shortcode != "" {
+		query.Set("code", shortcode)
+	}
+	var link Link
+	if err := c.do(ctx, http.MethodGet, "/shorten", query, nil, &link, nil); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Update replaces the settings of an existing link. It fails with
+// ErrNotFound rather than creating the link.
+func (c *Client) Update(ctx context.Context, link Link) (*Link, error) {
+	var saved Link
+	if err := c.do(ctx, http.MethodPut, linkPath(link.Shortcode), nil, link, &saved, nil); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Get returns the link with the given shortcode. Use Stats for its clicks
+// per A/B variant.
+func (c *Client) Get(ctx context.Context, shortcode string) (*Link, error) {
+	var link Link
+	if err := c.do(ctx, http.MethodGet, linkPath(shortcode), nil, nil, &link, nil); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ListOptions filters, sorts, and paginates List. The zero value lists
+// every link, newest first.
+type ListOptions struct {
+	Query    string   // substring of the shortcode or URL
+	URL      string   // exact destination URL
+	Status   string   // broken, ok, or unchecked
+	Tags     []string // links must carry every one of these tags
+	Group    string   // only links in this group
+	Pinned   bool     // only pinned links
+	Archived bool     // the archived links instead of the rest
+	Sort     string   // created_at, shortcode, url, clicks, or last_used
+	Order    string   // asc or desc
+	Page     int      // 1-based; 0 returns every match
+	PerPage  int
+}
+
+func (o ListOptions) values() url.Values {
+	q := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	set("q", o.Query)
+	set("url", o.URL)
+	set("status", o.Status)
+	for _, tag := range o.Tags {
+		q.Add("tag", tag)
+	}
+	set("group", o.Group)
+	if o.Pinned {
+		q.Set("pinned", "true")
+	}
+	if o.Archived {
+		q.Set("archived", "true")
+	}
+	set("sort", o.Sort)
+	set("order", o.Order)
+	if o.Page > 0 {
+		q.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		q.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+	return q
+}
+
+// List returns the links matching opts and how many matched in total.
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]Link, *ListMeta, error) {
+	var links []Link
+	var meta ListMeta
+	if err := c.do(ctx, http.MethodGet, "/links", opts.values(), nil, &links, &meta); err != nil {
+		return nil, nil, err
+	}
+	return links, &meta, nil
+}
+
+// Groups returns the link groups, with how many links each holds. A link
+// is put in a group by setting its Group; the group is created with it.
+func (c *Client) Groups(ctx context.Context) ([]Group, error) {
+	var groups []Group
+	if err := c.do(ctx, http.MethodGet, "/groups", nil, nil, &groups, nil); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Delete removes a link.
+func (c *Client) Delete(ctx context.Context, shortcode string) error {
+	return c.do(ctx, http.MethodDelete, linkPath(shortcode), nil, nil, nil, nil)
+}
+
+// DeleteMany removes several links in one transaction: all of them, or
+// none if any can't be deleted. The results are returned along with the
+// error when they weren't, as with Batch.
+func (c *Client) DeleteMany(ctx context.Context, shortcodes ...string) ([]BatchResult, error) {
+	var results []BatchResult
+	query := url.Values{"shortcodes": {strings.Join(shortcodes, ",")}}
+	err := c.do(ctx, http.MethodDelete, "/links", query, nil, &results, nil)
+	return results, err
+}
+
+// Batch applies ops in one transaction: either all of them succeed or
+// nothing changes. The results line up with ops, and are returned along
+// with the error when the batch is rejected, to show which operation
+// failed.
+func (c *Client) Batch(ctx context.Context, ops []BatchOperation) ([]BatchResult, error) {
+	var results []BatchResult
+	err := c.do(ctx, http.MethodPost, "/links/batch", nil, ops, &results, nil)
+	return results, err
+}
+
+// SyncOptions change what Sync does.
+type SyncOptions struct {
+	Prune  bool // delete the links the file doesn't list
+	DryRun bool // only report what would change
+}
+
+// Sync makes the server's links match the links file, in YAML or JSON,
+// read from r: the links it lists are created or updated, all in one
+// transaction.
+func (c *Client) Sync(ctx context.Context, r io.Reader, opts SyncOptions) (*SyncResult, error) {
+	query := url.Values{}
+	if opts.Prune {
+		query.Set("prune", "true")
+	}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	var result SyncResult
+	if err := c.do(ctx, http.MethodPost, "/links/sync", query, r, &result, nil); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Stats returns a link's click totals.
+func (c *Client) Stats(ctx context.Context, shortcode string) (*Stats, error) {
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, linkPath(shortcode, "stats"), nil, nil, &stats, nil); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Top returns the most clicked links over window, 24h, 7d, or 30d, and
+// those gaining the most clicks on the window before. An empty window is
+// 24h.
+func (c *Client) Top(ctx context.Context, window string) (*TopLinks, error) {
+	query := url.Values{}
+	if window != "" {
+		query.Set("window", window)
+	}
+	var top TopLinks
+	if err := c.do(ctx, http.MethodGet, "/stats/top", query, nil, &top, nil); err != nil {
+		return nil, err
+	}
+	return &top, nil
+}
+
+// Restore replaces the server's database with the backup file read from r.
+// The server checks the backup and saves its current database first.
+// Needs an admin account.
+func (c *Client) Restore(ctx context.Context, r io.Reader) (*Restore, error) {
+	var restore Restore
+	if err := c.do(ctx, http.MethodPost, "/admin/restore", nil, r, &restore, nil); err != nil {
+		return nil, err
+	}
+	return &restore, nil
+}
+
+// RestoreBackup replaces the server's database with one of the backups in
+// its own backup directory, such as "links-20240501T030000.000Z.db".
+func (c *Client) RestoreBackup(ctx context.Context, name string) (*Restore, error) {
+	var restore Restore
+	body := map[string]string{"name": name}
+	if err := c.do(ctx, http.MethodPost, "/admin/restore", nil, body, &restore, nil); err != nil {
+		return nil, err
+	}
+	return &restore, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors that API failures match with errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("authentication required")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+)
+
+// codeErrors maps the API's error codes to the errors above.
+var codeErrors = map[string]error{
+	"bad_request":  ErrBadRequest,
+	"unauthorized": ErrUnauthorized,
+	"forbidden":    ErrForbidden,
+	"not_found":    ErrNotFound,
+	"conflict":     ErrConflict,
+}
+
+// statusErrors is used instead when a response has no code, such as an
+// error page from a proxy in front of the server.
+var statusErrors = map[int]error{
+	http.StatusBadRequest:   ErrBadRequest,
+	http.StatusUnauthorized: ErrUnauthorized,
+	http.StatusForbidden:    ErrForbidden,
+	http.StatusNotFound:     ErrNotFound,
+	http.StatusConflict:     ErrConflict,
+}
+
+// Error is a request the server rejected.
+type Error struct {
+	StatusCode int
+	Code       string // the API's error code, such as "not_found"
+	Message    string // the server's explanation
+	RequestID  string // the server's X-Request-ID, to find the request in its logs
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.StatusCode)
+}
+
+// Is lets errors.Is match an Error against ErrNotFound and the like.
+func (e *Error) Is(target error) bool {
+	if e.Code != "" {
+		return codeErrors[e.Code] == target
+	}
+	return statusErrors[e.StatusCode] == target
+}
@@ -0,0 +1,184 @@
+package client
+
+import "time"
+
+// Link is a short link as the API reports it. On writes, leave out the
+// fields the server fills in (Owner, ShortURL, CreatedAt, Protected).
+// Clicks is kept when an admin creates a link, to carry counts over from
+// another shortener, and ignored otherwise.
+type Link struct {
+	Domain       string   `json:"domain,omitempty"`
+	Shortcode    string   `json:"shortcode"`
+	URL          string   `json:"url"`
+	RedirectType int      `json:"redirect_type,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	Pinned       bool     `json:"pinned,omitempty"` // listed before the rest
+	Owner        string   `json:"owner,omitempty"`
+	MaxClicks    int      `json:"max_clicks,omitempty"`
+	OneTime      bool     `json:"one_time,omitempty"`
+	Clicks       int      `json:"clicks,omitempty"`
+	ShortURL     string   `json:"short_url,omitempty"`
+
+	IOSURL         string            `json:"ios_url,omitempty"`
+	AndroidURL     string            `json:"android_url,omitempty"`
+	DesktopURL     string            `json:"desktop_url,omitempty"`
+	ForwardQuery   bool              `json:"forward_query,omitempty"`
+	ForwardPath    bool              `json:"forward_path,omitempty"`
+	UTM            *UTM              `json:"utm,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"` // sent with the redirect
+	Aliases        []string          `json:"aliases,omitempty"`
+	GeoRules       []GeoRule         `json:"geo_rules,omitempty"`
+	Variants       []Variant         `json:"variants,omitempty"`
+	StickyVariants bool              `json:"sticky_variants,omitempty"`
+	Untracked      bool              `json:"untracked,omitempty"` // clicks are counted but not logged
+
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	// When the link last redirected someone, if it has
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	// When the link was archived for going unused, if it is
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+
+	// Password protects the link on writes; reads report Protected instead.
+	// Updates keep the existing password unless RemovePassword is set.
+	Password       string `json:"password,omitempty"`
+	RemovePassword bool   `json:"remove_password,omitempty"`
+	Protected      bool   `json:"protected,omitempty"`
+}
+
+// UTM parameters are added to the destination of every redirect.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// GeoRule sends visitors from a country or continent to URL.
+type GeoRule struct {
+	Country   string `json:"country,omitempty"`
+	Continent string `json:"continent,omitempty"`
+	URL       string `json:"url"`
+}
+
+// Variant is one destination of an A/B test, picked in proportion to its
+// weight.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// ListMeta describes a page of List results.
+type ListMeta struct {
+	Total   int    `json:"total"`
+	Page    int    `json:"page,omitempty"`
+	PerPage int    `json:"per_page,omitempty"`
+	Pages   int    `json:"pages,omitempty"`
+	Sort    string `json:"sort"`
+	Order   string `json:"order"`
+}
+
+// Group is a folder of links, such as marketing or engineering.
+type Group struct {
+	Domain      string     `json:"domain,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Links       int        `json:"links"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// Stats are a link's click totals, with A/B variants counted separately.
+type Stats struct {
+	Domain    string           `json:"domain,omitempty"`
+	Shortcode string           `json:"shortcode"`
+	Clicks    int              `json:"clicks"`
+	Variants  map[string]int   `json:"variants,omitempty"`
+	Referrers []ReferrerClicks `json:"referrers,omitempty"` // the top sites, most clicks first
+}
+
+// ReferrerClicks counts the clicks that came from one site.
+type ReferrerClicks struct {
+	Referrer string `json:"referrer"` // hostname
+	Clicks   int    `json:"clicks"`
+}
+
+// TopLinks lists the most clicked links over a window, and the links whose
+// clicks grew the most since the window before it.
+type TopLinks struct {
+	Window   string         `json:"window"`
+	Since    time.Time      `json:"since"`
+	Top      []TrendingLink `json:"top"`      // most clicks first
+	Trending []TrendingLink `json:"trending"` // biggest increase first
+}
+
+// TrendingLink counts a link's clicks over a window and the window before.
+type TrendingLink struct {
+	Domain         string `json:"domain,omitempty"`
+	Shortcode      string `json:"shortcode"`
+	URL            string `json:"url"`
+	Title          string `json:"title,omitempty"`
+	Clicks         int    `json:"clicks"`
+	PreviousClicks int    `json:"previous_clicks"`
+	Change         int    `json:"change"`
+}
+
+// BatchOperation is one change in a Batch. Use the constructors below.
+type BatchOperation struct {
+	Op        string `json:"op"`
+	Link      *Link  `json:"link,omitempty"`
+	Shortcode string `json:"shortcode,omitempty"`
+	Domain    string `json:"domain,omitempty"`
+}
+
+// CreateOp creates link, replacing any link with the same shortcode.
+func CreateOp(link Link) BatchOperation {
+	return BatchOperation{Op: "create", Link: &link}
+}
+
+// UpdateOp replaces an existing link.
+func UpdateOp(link Link) BatchOperation {
+	return BatchOperation{Op: "update", Link: &link}
+}
+
+// DeleteOp deletes the link with the given shortcode.
+func DeleteOp(shortcode string) BatchOperation {
+	return BatchOperation{Op: "delete", Shortcode: shortcode}
+}
+
+// BatchResult is the outcome of the operation at the same position in a
+// Batch. Status is 0 for operations that weren't attempted because another
+// one failed.
+type BatchResult struct {
+	Op        string `json:"op"`
+	Shortcode string `json:"shortcode,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+	Link      *Link  `json:"link,omitempty"`
+}
+
+// SyncResult lists the links a Sync created, updated, and deleted, or
+// on a dry run, would have. Links on a custom domain are listed as
+// domain/shortcode.
+type SyncResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+}
+
+// Restore describes a database restore. PreviousBackup names the backup
+// the server took of its database just before replacing it.
+type Restore struct {
+	Source         string    `json:"source"`
+	Links          int       `json:"links"`
+	PreviousBackup string    `json:"previous_backup"`
+	RestoredAt     time.Time `json:"restored_at"`
+}
@@ -0,0 +1,411 @@
+//go:build server
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var configFile string
+
+func init() {
+	flag.StringVar(&configFile, "config", "", "Path to a YAML config file")
+}
+
+// Config is the layout of the --config file. Every setting mirrors one of
+// the environment variables documented in the README; variables that are
+// already set win over the file, so a deployment can override single values.
+type Config struct {
+	Port           string   `yaml:"port"`
+	GRPCPort       string   `yaml:"grpc_port"`
+	BasePath       string   `yaml:"base_path"`
+	DataDir        string   `yaml:"data_dir"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	SelfHosts      []string `yaml:"self_hosts"`
+	CustomDomains  []string `yaml:"custom_domains"`
+	GeoIPDB        string   `yaml:"geoip_db"`
+	RequestTimeout string   `yaml:"request_timeout"`
+	SwaggerUI      bool     `yaml:"swagger_ui"`
+	PublicFeed     bool     `yaml:"public_feed"`
+	BitlyAPI       bool     `yaml:"bitly_api"`
+	PublicURL      string   `yaml:"public_url"`
+	ReadOnly       bool     `yaml:"read_only"`
+	ThemeDir       string   `yaml:"theme_dir"`
+
+	Database struct {
+		Driver      string `yaml:"driver"`
+		Path        string `yaml:"path"`
+		CacheSize   *int   `yaml:"cache_size"` // 0 turns the cache off
+		Replication string `yaml:"replication"`
+	} `yaml:"database"`
+
+	Redis struct {
+		URL      string `yaml:"url"`
+		CacheTTL string `yaml:"cache_ttl"`
+	} `yaml:"redis"`
+
+	Tracing struct {
+		Endpoint    string            `yaml:"endpoint"`
+		Protocol    string            `yaml:"protocol"`
+		ServiceName string            `yaml:"service_name"`
+		Headers     map[string]string `yaml:"headers"`
+		Sampler     string            `yaml:"sampler"`
+		SamplerArg  string            `yaml:"sampler_arg"`
+	} `yaml:"tracing"`
+
+	AccessLog struct {
+		Path       string `yaml:"path"`
+		Format     string `yaml:"format"`
+		MaxSize    *int   `yaml:"max_size"`    // MB; 0 turns rotation off
+		MaxBackups *int   `yaml:"max_backups"` // 0 keeps none
+	} `yaml:"access_log"`
+
+	Debug struct {
+		Addr      string `yaml:"addr"`
+		Endpoints bool   `yaml:"endpoints"`
+	} `yaml:"debug"`
+
+	TLS struct {
+		Cert             string   `yaml:"cert"`
+		Key              string   `yaml:"key"`
+		HTTPPort         string   `yaml:"http_port"`
+		AutocertDomains  []string `yaml:"autocert_domains"`
+		AutocertEmail    string   `yaml:"autocert_email"`
+		AutocertCacheDir string   `yaml:"autocert_cache"`
+	} `yaml:"tls"`
+
+	Auth struct {
+		AdminUsername string `yaml:"admin_username"`
+		AdminPassword string `yaml:"admin_password"`
+		SessionTTL    string `yaml:"session_ttl"`
+		OIDC          struct {
+			Issuer       string   `yaml:"issuer"`
+			ClientID     string   `yaml:"client_id"`
+			ClientSecret string   `yaml:"client_secret"`
+			RedirectURL  string   `yaml:"redirect_url"`
+			Scopes       []string `yaml:"scopes"`
+			AdminEmails  []string `yaml:"admin_emails"`
+		} `yaml:"oidc"`
+	} `yaml:"auth"`
+
+	CORS struct {
+		AllowedOrigins   []string `yaml:"allowed_origins"`
+		AllowedMethods   []string `yaml:"allowed_methods"`
+		AllowedHeaders   []string `yaml:"allowed_headers"`
+		AllowCredentials bool     `yaml:"allow_credentials"`
+		MaxAge           int      `yaml:"max_age"`
+	} `yaml:"cors"`
+
+	Links struct {
+		DefaultRedirectType      int               `yaml:"default_redirect_type"`
+		PermanentRedirectMaxAge  string            `yaml:"permanent_redirect_max_age"`
+		TemporaryRedirectMaxAge  string            `yaml:"temporary_redirect_max_age"`
+		RedirectHeaders          map[string]string `yaml:"redirect_headers"`
+		ReservedShortcodes       []string          `yaml:"reserved_shortcodes"`
+		AllowedSchemes           []string          `yaml:"allowed_schemes"`
+		BlockPrivateDestinations bool              `yaml:"block_private_destinations"`
+		ClickLimitURL            string            `yaml:"click_limit_url"`
+		DeduplicateURLs          bool              `yaml:"deduplicate_urls"`
+		FetchPageInfo            bool              `yaml:"fetch_page_info"`
+		CheckInterval            string            `yaml:"check_interval"`
+		Shortcodes               struct {
+			Pattern   string `yaml:"pattern"`
+			MinLength int    `yaml:"min_length"`
+			MaxLength int    `yaml:"max_length"`
+			Case      string `yaml:"case"`
+		} `yaml:"shortcodes"`
+		Fallback struct {
+			Mode        string `yaml:"mode"`
+			URL         string `yaml:"url"`
+			Suggestions *bool  `yaml:"suggestions"` // false keeps other shortcodes off the 404 page
+		} `yaml:"fallback"`
+		Archive struct {
+			AfterDays int    `yaml:"after_days"`
+			Mode      string `yaml:"mode"` // page or redirect
+		} `yaml:"archive"`
+		Sync struct {
+			File     string `yaml:"file"`
+			Prune    bool   `yaml:"prune"`
+			Interval string `yaml:"interval"`
+		} `yaml:"sync"`
+	} `yaml:"links"`
+
+	Jobs struct {
+		Disabled      []string `yaml:"disabled"`
+		PurgeInterval string   `yaml:"purge_interval"`
+	} `yaml:"jobs"`
+
+	Clicks struct {
+		RollupInterval    string   `yaml:"rollup_interval"`
+		RetentionDays     *int     `yaml:"retention_days"` // 0 keeps raw clicks forever
+		ReferrerBlocklist []string `yaml:"referrer_blocklist"`
+		Stream            struct {
+			Broker string `yaml:"broker"`
+			URL    string `yaml:"url"`
+			Topic  string `yaml:"topic"`
+		} `yaml:"stream"`
+	} `yaml:"clicks"`
+
+	Privacy struct {
+		ClickLogging    *bool  `yaml:"click_logging"` // false counts clicks without logging them
+		RespectDNT      bool   `yaml:"respect_dnt"`
+		IPAnonymization string `yaml:"ip_anonymization"`
+		IPHashKey       string `yaml:"ip_hash_key"`
+	} `yaml:"privacy"`
+
+	Backup struct {
+		Dir      string `yaml:"dir"`
+		Keep     *int   `yaml:"keep"`
+		Interval string `yaml:"interval"`
+	} `yaml:"backup"`
+
+	S3 struct {
+		Bucket          string `yaml:"bucket"`
+		Endpoint        string `yaml:"endpoint"`
+		Region          string `yaml:"region"`
+		Prefix          string `yaml:"prefix"`
+		AccessKeyID     string `yaml:"access_key_id"`
+		SecretAccessKey string `yaml:"secret_access_key"`
+	} `yaml:"s3"`
+
+	CDN struct {
+		Purge      string `yaml:"purge"` // cloudflare or fastly
+		APIURL     string `yaml:"api_url"`
+		Cloudflare struct {
+			ZoneID   string `yaml:"zone_id"`
+			APIToken string `yaml:"api_token"`
+		} `yaml:"cloudflare"`
+		Fastly struct {
+			APIToken  string `yaml:"api_token"`
+			SoftPurge bool   `yaml:"soft_purge"`
+		} `yaml:"fastly"`
+	} `yaml:"cdn"`
+
+	Bots struct {
+		Telegram struct {
+			Token  string   `yaml:"token"`
+			Users  []string `yaml:"users"`
+			APIURL string   `yaml:"api_url"`
+		} `yaml:"telegram"`
+		Discord struct {
+			PublicKey string   `yaml:"public_key"`
+			Users     []string `yaml:"users"`
+		} `yaml:"discord"`
+	} `yaml:"bots"`
+}
+
+// env lists the environment variable each configured setting stands for.
+// Settings left out of the file are skipped.
+func (c *Config) env() map[string]string {
+	vars := map[string]string{}
+	set := func(name, value string) {
+		if value != "" {
+			vars[name] = value
+		}
+	}
+	list := func(name string, values []string) {
+		set(name, strings.Join(values, ","))
+	}
+	number := func(name string, n int) {
+		if n != 0 {
+			set(name, strconv.Itoa(n))
+		}
+	}
+	boolean := func(name string, b bool) {
+		if b {
+			set(name, "true")
+		}
+	}
+
+	set("PORT", c.Port)
+	set("GRPC_PORT", c.GRPCPort)
+	set("BASE_PATH", c.BasePath)
+	set("DATA_DIR", c.DataDir)
+	list("TRUSTED_PROXIES", c.TrustedProxies)
+	list("SELF_HOSTS", c.SelfHosts)
+	list("CUSTOM_DOMAINS", c.CustomDomains)
+	set("GEOIP_DB", c.GeoIPDB)
+	set("REQUEST_TIMEOUT", c.RequestTimeout)
+	boolean("SWAGGER_UI", c.SwaggerUI)
+	boolean("PUBLIC_FEED", c.PublicFeed)
+	boolean("BITLY_API", c.BitlyAPI)
+	set("PUBLIC_URL", c.PublicURL)
+	boolean("READ_ONLY", c.ReadOnly)
+	set("THEME_DIR", c.ThemeDir)
+
+	set("DB_DRIVER", c.Database.Driver)
+	set("DB_PATH", c.Database.Path)
+	set("REPLICATION", c.Database.Replication)
+	if c.Database.CacheSize != nil {
+		set("LINK_CACHE_SIZE", strconv.Itoa(*c.Database.CacheSize))
+	}
+	set("REDIS_URL", c.Redis.URL)
+	set("REDIS_CACHE_TTL", c.Redis.CacheTTL)
+
+	set("OTEL_EXPORTER_OTLP_ENDPOINT", c.Tracing.Endpoint)
+	set("OTEL_EXPORTER_OTLP_PROTOCOL", c.Tracing.Protocol)
+	set("OTEL_SERVICE_NAME", c.Tracing.ServiceName)
+	headers := make([]string, 0, len(c.Tracing.Headers))
+	for name, value := range c.Tracing.Headers {
+		headers = append(headers, name+"="+url.QueryEscape(value))
+	}
+	sort.Strings(headers)
+	list("OTEL_EXPORTER_OTLP_HEADERS", headers)
+	set("OTEL_TRACES_SAMPLER", c.Tracing.Sampler)
+	set("OTEL_TRACES_SAMPLER_ARG", c.Tracing.SamplerArg)
+
+	set("ACCESS_LOG", c.AccessLog.Path)
+	set("ACCESS_LOG_FORMAT", c.AccessLog.Format)
+	if c.AccessLog.MaxSize != nil {
+		set("ACCESS_LOG_MAX_SIZE", strconv.Itoa(*c.AccessLog.MaxSize))
+	}
+	if c.AccessLog.MaxBackups != nil {
+		set("ACCESS_LOG_MAX_BACKUPS", strconv.Itoa(*c.AccessLog.MaxBackups))
+	}
+
+	set("DEBUG_ADDR", c.Debug.Addr)
+	boolean("DEBUG_ENDPOINTS", c.Debug.Endpoints)
+
+	set("TLS_CERT", c.TLS.Cert)
+	set("TLS_KEY", c.TLS.Key)
+	set("HTTP_PORT", c.TLS.HTTPPort)
+	list("AUTOCERT_DOMAINS", c.TLS.AutocertDomains)
+	set("AUTOCERT_EMAIL", c.TLS.AutocertEmail)
+	set("AUTOCERT_CACHE", c.TLS.AutocertCacheDir)
+
+	set("ADMIN_USERNAME", c.Auth.AdminUsername)
+	set("ADMIN_PASSWORD", c.Auth.AdminPassword)
+	set("SESSION_TTL", c.Auth.SessionTTL)
+	set("OIDC_ISSUER", c.Auth.OIDC.Issuer)
+	set("OIDC_CLIENT_ID", c.Auth.OIDC.ClientID)
+	set("OIDC_CLIENT_SECRET", c.Auth.OIDC.ClientSecret)
+	set("OIDC_REDIRECT_URL", c.Auth.OIDC.RedirectURL)
+	list("OIDC_SCOPES", c.Auth.OIDC.Scopes)
+	list("OIDC_ADMIN_EMAILS", c.Auth.OIDC.AdminEmails)
+
+	list("CORS_ALLOWED_ORIGINS", c.CORS.AllowedOrigins)
+	list("CORS_ALLOWED_METHODS", c.CORS.AllowedMethods)
+	list("CORS_ALLOWED_HEADERS", c.CORS.AllowedHeaders)
+	boolean("CORS_ALLOW_CREDENTIALS", c.CORS.AllowCredentials)
+	number("CORS_MAX_AGE", c.CORS.MaxAge)
+
+	number("DEFAULT_REDIRECT_TYPE", c.Links.DefaultRedirectType)
+	set("PERMANENT_REDIRECT_MAX_AGE", c.Links.PermanentRedirectMaxAge)
+	set("TEMPORARY_REDIRECT_MAX_AGE", c.Links.TemporaryRedirectMaxAge)
+	redirectHeaders := make([]string, 0, len(c.Links.RedirectHeaders))
+	for name, value := range c.Links.RedirectHeaders {
+		redirectHeaders = append(redirectHeaders, name+"="+url.QueryEscape(value))
+	}
+	sort.Strings(redirectHeaders)
+	list("REDIRECT_HEADERS", redirectHeaders)
+	list("RESERVED_SHORTCODES", c.Links.ReservedShortcodes)
+	list("ALLOWED_SCHEMES", c.Links.AllowedSchemes)
+	boolean("BLOCK_PRIVATE_DESTINATIONS", c.Links.BlockPrivateDestinations)
+	set("CLICK_LIMIT_URL", c.Links.ClickLimitURL)
+	boolean("DEDUPLICATE_URLS", c.Links.DeduplicateURLs)
+	boolean("FETCH_PAGE_INFO", c.Links.FetchPageInfo)
+	set("LINK_CHECK_INTERVAL", c.Links.CheckInterval)
+	set("SHORTCODE_PATTERN", c.Links.Shortcodes.Pattern)
+	number("SHORTCODE_MIN_LENGTH", c.Links.Shortcodes.MinLength)
+	number("SHORTCODE_MAX_LENGTH", c.Links.Shortcodes.MaxLength)
+	set("SHORTCODE_CASE", c.Links.Shortcodes.Case)
+	set("FALLBACK_MODE", c.Links.Fallback.Mode)
+	set("FALLBACK_URL", c.Links.Fallback.URL)
+	if c.Links.Fallback.Suggestions != nil {
+		set("FALLBACK_SUGGESTIONS", strconv.FormatBool(*c.Links.Fallback.Suggestions))
+	}
+	number("ARCHIVE_AFTER_DAYS", c.Links.Archive.AfterDays)
+	set("ARCHIVED_LINKS", c.Links.Archive.Mode)
+	set("SYNC_FILE", c.Links.Sync.File)
+	boolean("SYNC_PRUNE", c.Links.Sync.Prune)
+	set("SYNC_INTERVAL", c.Links.Sync.Interval)
+
+	list("JOBS_DISABLED", c.Jobs.Disabled)
+	set("PURGE_INTERVAL", c.Jobs.PurgeInterval)
+
+	set("CLICK_ROLLUP_INTERVAL", c.Clicks.RollupInterval)
+	if c.Clicks.RetentionDays != nil {
+		set("CLICK_RETENTION_DAYS", strconv.Itoa(*c.Clicks.RetentionDays))
+	}
+	list("REFERRER_BLOCKLIST", c.Clicks.ReferrerBlocklist)
+	set("CLICK_STREAM", c.Clicks.Stream.Broker)
+	set("CLICK_STREAM_URL", c.Clicks.Stream.URL)
+	set("CLICK_STREAM_TOPIC", c.Clicks.Stream.Topic)
+	if c.Privacy.ClickLogging != nil {
+		set("CLICK_LOGGING", strconv.FormatBool(*c.Privacy.ClickLogging))
+	}
+	boolean("RESPECT_DNT", c.Privacy.RespectDNT)
+	set("IP_ANONYMIZATION", c.Privacy.IPAnonymization)
+	set("IP_HASH_KEY", c.Privacy.IPHashKey)
+
+	set("BACKUP_DIR", c.Backup.Dir)
+	// keep: 0 keeps every backup, so unlike other numbers it isn't skipped
+	if c.Backup.Keep != nil {
+		set("BACKUP_KEEP", strconv.Itoa(*c.Backup.Keep))
+	}
+	set("BACKUP_INTERVAL", c.Backup.Interval)
+
+	set("S3_BUCKET", c.S3.Bucket)
+	set("S3_ENDPOINT", c.S3.Endpoint)
+	set("S3_REGION", c.S3.Region)
+	set("S3_PREFIX", c.S3.Prefix)
+	set("S3_ACCESS_KEY_ID", c.S3.AccessKeyID)
+	set("S3_SECRET_ACCESS_KEY", c.S3.SecretAccessKey)
+
+	set("CDN_PURGE", c.CDN.Purge)
+	set("CDN_PURGE_API_URL", c.CDN.APIURL)
+	set("CLOUDFLARE_ZONE_ID", c.CDN.Cloudflare.ZoneID)
+	set("CLOUDFLARE_API_TOKEN", c.CDN.Cloudflare.APIToken)
+	set("FASTLY_API_TOKEN", c.CDN.Fastly.APIToken)
+	boolean("FASTLY_SOFT_PURGE", c.CDN.Fastly.SoftPurge)
+
+	set("TELEGRAM_BOT_TOKEN", c.Bots.Telegram.Token)
+	list("TELEGRAM_USERS", c.Bots.Telegram.Users)
+	set("TELEGRAM_API_URL", c.Bots.Telegram.APIURL)
+	set("DISCORD_PUBLIC_KEY", c.Bots.Discord.PublicKey)
+	list("DISCORD_USERS", c.Bots.Discord.Users)
+
+	return vars
+}
+
+// loadConfigFile reads the --config file, if any, and exports its settings
+// as environment variables that aren't already set.
+func loadConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	// Typos in setting names should fail loudly rather than be ignored
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	for name, value := range cfg.env() {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
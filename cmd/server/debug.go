@@ -0,0 +1,69 @@
+//go:build server
+
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+)
+
+// Profiling settings. Like the TLS flags, unset flags fall back to the
+// environment (or config file).
+var (
+	debugAddr      string
+	debugEndpoints bool
+)
+
+func init() {
+	flag.StringVar(&debugAddr, "debug-addr", "", "Address such as localhost:6060 to serve pprof and expvar on, without authentication (or set DEBUG_ADDR)")
+	flag.BoolVar(&debugEndpoints, "debug-endpoints", false, "Serve pprof and expvar under /debug/ on the main port to admins, and to nobody until an admin account exists (or set DEBUG_ENDPOINTS)")
+}
+
+// checkDebugFlags fills in the profiling settings from the environment.
+func checkDebugFlags() error {
+	if debugAddr == "" {
+		debugAddr = os.Getenv("DEBUG_ADDR")
+	}
+	if v := os.Getenv("DEBUG_ENDPOINTS"); v != "" && !debugEndpoints {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid DEBUG_ENDPOINTS %q: must be true or false", v)
+		}
+		debugEndpoints = on
+	}
+	return nil
+}
+
+// debugHandler serves the runtime's profiles under /debug/pprof/ and its
+// counters, memstats among them, at /debug/vars.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// serveDebug listens on DEBUG_ADDR for profiling, if it's set. The
+// listener has no authentication, so bind it to localhost or a private
+// network.
+func serveDebug() {
+	if debugAddr == "" {
+		return
+	}
+	go func() {
+		log.Printf("Serving pprof and expvar on %s", debugAddr)
+		if err := http.ListenAndServe(debugAddr, debugHandler()); err != nil {
+			log.Fatalf("Debug listener failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+//go:build server
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/nryberg/lnk/lnk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcPort is where the gRPC API is served, if anywhere. Like the TLS
+// flags, an unset flag falls back to the environment (or config file).
+var grpcPort string
+
+func init() {
+	flag.StringVar(&grpcPort, "grpc-port", "", "Port to serve the gRPC API on, such as 9090 (or set GRPC_PORT)")
+}
+
+// checkGRPCFlags fills in the gRPC port from the environment. PUBLIC_URL
+// must be set along with it, or the short URLs in responses would name the
+// gRPC port clients dialed.
+func checkGRPCFlags() error {
+	if grpcPort == "" {
+		grpcPort = os.Getenv("GRPC_PORT")
+	}
+	if grpcPort == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(grpcPort); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid GRPC_PORT %q: must be a port number", grpcPort)
+	}
+	if os.Getenv("PUBLIC_URL") == "" {
+		return fmt.Errorf("PUBLIC_URL is required with GRPC_PORT")
+	}
+	return nil
+}
+
+// serveGRPC serves the gRPC API on GRPC_PORT, if it's set, over TLS with
+// tlsConfig when the server has a certificate and in plain text otherwise.
+// It returns the server to stop on shutdown, or nil without GRPC_PORT.
+func serveGRPC(lf *lnk.LinkForwarder, tlsConfig *tls.Config) (*grpc.Server, error) {
+	if grpcPort == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for gRPC: %v", err)
+	}
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := lf.GRPCServer(opts...)
+	go func() {
+		log.Printf("Serving the gRPC API on port %s", grpcPort)
+		if err := srv.Serve(lis); err != nil {
+			log.Fatalf("gRPC listener failed: %v", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,225 @@
+//go:build server
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nryberg/lnk/lnk"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+)
+
+// defaultPort matches run.sh and the CLI's default server URL.
+const defaultPort = "8080"
+
+// shutdownTimeout is how long requests in flight get to finish once the
+// server is asked to stop.
+const shutdownTimeout = 15 * time.Second
+
+// TLS settings. Flags left unset fall back to the matching environment
+// variable (or config file setting) so containers can be configured either way.
+var (
+	tlsCert         string
+	tlsKey          string
+	autocertDomains string
+	autocertEmail   string
+	autocertCache   string
+	httpPort        string
+)
+
+func init() {
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file (PEM)")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS private key file (PEM)")
+	flag.StringVar(&autocertDomains, "autocert", "", "Comma-separated domains to get Let's Encrypt certificates for")
+	flag.StringVar(&autocertEmail, "autocert-email", "", "Contact email for the Let's Encrypt account")
+	flag.StringVar(&autocertCache, "autocert-cache", "", "Directory to store certificates in (default: DATA_DIR/autocert)")
+	flag.StringVar(&httpPort, "http-port", "", "Port to redirect plain HTTP to HTTPS from (default: 80 with -autocert)")
+}
+
+// checkTLSFlags fills in TLS settings from the environment and rejects
+// contradictory ones before anything starts.
+func checkTLSFlags() error {
+	for v, name := range map[*string]string{
+		&tlsCert:         "TLS_CERT",
+		&tlsKey:          "TLS_KEY",
+		&autocertDomains: "AUTOCERT_DOMAINS",
+		&autocertEmail:   "AUTOCERT_EMAIL",
+		&autocertCache:   "AUTOCERT_CACHE",
+		&httpPort:        "HTTP_PORT",
+	} {
+		if *v == "" {
+			*v = os.Getenv(name)
+		}
+	}
+
+	useCert := tlsCert != "" || tlsKey != ""
+	switch {
+	case useCert && autocertDomains != "":
+		return fmt.Errorf("use either -tls-cert/-tls-key or -autocert, not both")
+	case useCert && (tlsCert == "" || tlsKey == ""):
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	return nil
+}
+
+// serve runs the server over plain HTTP, or over HTTPS when a certificate or
+// autocert domains are configured, until ctx is cancelled.
+func serve(ctx context.Context, lf *lnk.LinkForwarder) error {
+	useCert := tlsCert != ""
+	useAutocert := autocertDomains != ""
+
+	port := os.Getenv("PORT")
+	if !useCert && !useAutocert {
+		if port == "" {
+			port = defaultPort
+		}
+		grpcSrv, err := serveGRPC(lf, nil)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Addr: ":" + port, Handler: lf}
+		logStartup("http", port)
+		return runServer(ctx, srv, srv.ListenAndServe, grpcSrv)
+	}
+
+	if port == "" {
+		port = "443"
+	}
+	srv := &http.Server{
+		Addr:      ":" + port,
+		Handler:   lf,
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	redirect := redirectToHTTPS(port)
+
+	if useAutocert {
+		m := autocertManager(lf)
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		// Let's Encrypt's HTTP-01 challenge always arrives on port 80
+		redirect = m.HTTPHandler(redirect)
+		if httpPort == "" {
+			httpPort = "80"
+		}
+	}
+
+	// gRPC clients get the same certificate, read here since
+	// ListenAndServeTLS only loads the files for srv
+	grpcTLS := srv.TLSConfig
+	if useCert {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return err
+		}
+		grpcTLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	grpcSrv, err := serveGRPC(lf, grpcTLS)
+	if err != nil {
+		return err
+	}
+
+	if httpPort != "" {
+		redirectSrv := &http.Server{Addr: ":" + httpPort, Handler: redirect}
+		srv.RegisterOnShutdown(func() { redirectSrv.Close() })
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", httpPort)
+			if err := redirectSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("HTTP redirect listener failed: %v", err)
+			}
+		}()
+	}
+
+	logStartup("https", port)
+	// With autocert the certificates come from TLSConfig, so no files are passed
+	return runServer(ctx, srv, func() error { return srv.ListenAndServeTLS(tlsCert, tlsKey) }, grpcSrv)
+}
+
+// runServer serves srv with listen until ctx is cancelled, then shuts it
+// and the gRPC server, if any, down, giving requests in flight up to
+// shutdownTimeout to finish.
+func runServer(ctx context.Context, srv *http.Server, listen func() error, grpcSrv *grpc.Server) error {
+	failed := make(chan error, 1)
+	go func() {
+		failed <- listen()
+	}()
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if grpcSrv != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		go func() {
+			select {
+			case <-stopped:
+			case <-shutdown.Done():
+				grpcSrv.Stop()
+			}
+		}()
+	}
+	return srv.Shutdown(shutdown)
+}
+
+// autocertManager fetches and renews Let's Encrypt certificates for the
+// configured domains and any CUSTOM_DOMAINS.
+func autocertManager(lf *lnk.LinkForwarder) *autocert.Manager {
+	var domains []string
+	for _, d := range strings.Split(autocertDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	domains = append(domains, lf.CustomDomains()...)
+
+	cache := autocertCache
+	if cache == "" {
+		cache = filepath.Join(lf.DataDir(), "autocert")
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cache),
+		Email:      autocertEmail,
+	}
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL on the HTTPS port.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+func logStartup(scheme, port string) {
+	log.Printf("Server starting on port %s", port)
+	log.Printf("Visit %s://localhost:%s to manage links", scheme, port)
+	log.Printf("Example: %s://localhost:%s/google will redirect to https://www.google.com", scheme, port)
+}
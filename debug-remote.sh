@@ -76,16 +76,16 @@ log "💾 Testing database operations..."
 
 # Try to read existing links
 log "📖 Attempting to read existing links..."
-if remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s http://localhost/api/links | jq ."; then
+if remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s http://localhost/api/v1/links | jq ."; then
     success "Database read successful"
 else
     warning "Database read failed or jq not available"
-    remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s http://localhost/api/links"
+    remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s http://localhost/api/v1/links"
 fi
 
 # Try to add a test link
 log "➕ Attempting to add test link..."
-TEST_RESULT=$(remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s -X POST -H 'Content-Type: application/json' -d '{\"shortcode\":\"debug-$(date +%s)\",\"url\":\"https://debug.example.com\"}' http://localhost/api/links")
+TEST_RESULT=$(remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s -X POST -H 'Content-Type: application/json' -d '{\"shortcode\":\"debug-$(date +%s)\",\"url\":\"https://debug.example.com\"}' http://localhost/api/v1/links")
 
 echo "Response: $TEST_RESULT"
 
@@ -122,7 +122,7 @@ else
 
     # Test again
     log "🔁 Testing again after restart..."
-    TEST_RESULT2=$(remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s -X POST -H 'Content-Type: application/json' -d '{\"shortcode\":\"debug-after-restart-$(date +%s)\",\"url\":\"https://debug2.example.com\"}' http://localhost/api/links")
+    TEST_RESULT2=$(remote_exec "cd $REMOTE_DIR && docker compose exec -T lnk curl -s -X POST -H 'Content-Type: application/json' -d '{\"shortcode\":\"debug-after-restart-$(date +%s)\",\"url\":\"https://debug2.example.com\"}' http://localhost/api/v1/links")
 
     echo "Response after restart: $TEST_RESULT2"
 
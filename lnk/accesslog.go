@@ -0,0 +1,236 @@
+package lnk
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultAccessLogMaxSize    = 100 // MB
+	defaultAccessLogMaxBackups = 7
+	// clfTimeFormat is how Apache writes the time of a request
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// accessLog writes a line per request in the Common or Combined Log
+// Format, as Apache does, for tools such as GoAccess that only read those.
+// A log file is rotated once it reaches maxSize: access.log becomes
+// access.log.1, access.log.1 becomes access.log.2, and so on.
+type accessLog struct {
+	combined   bool
+	path       string // empty for standard output
+	maxSize    int64  // bytes; 0 leaves rotation to something else
+	maxBackups int
+
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File
+	size int64
+}
+
+// loadAccessLog reads ACCESS_LOG, a file to append to or "-" for standard
+// output, returning nil when it isn't set. ACCESS_LOG_FORMAT is combined
+// (the default) or common; ACCESS_LOG_MAX_SIZE and ACCESS_LOG_MAX_BACKUPS
+// control rotation.
+func loadAccessLog(getenv func(string) string) (*accessLog, error) {
+	path := getenv("ACCESS_LOG")
+	if path == "" {
+		return nil, nil
+	}
+	a := &accessLog{combined: true, maxSize: defaultAccessLogMaxSize << 20, maxBackups: defaultAccessLogMaxBackups}
+	switch format := strings.ToLower(getenv("ACCESS_LOG_FORMAT")); format {
+	case "", "combined":
+	case "common":
+		a.combined = false
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT %q: must be combined or common", format)
+	}
+	if v := getenv("ACCESS_LOG_MAX_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_SIZE %q: must be a size in megabytes, or 0 to turn rotation off", v)
+		}
+		a.maxSize = int64(n) << 20
+	}
+	if v := getenv("ACCESS_LOG_MAX_BACKUPS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_BACKUPS %q: must be a non-negative integer", v)
+		}
+		a.maxBackups = n
+	}
+
+	if path == "-" {
+		a.out = os.Stdout
+		return a, nil
+	}
+	a.path = path
+	if err := a.open(); err != nil {
+		return nil, fmt.Errorf("failed to open ACCESS_LOG: %v", err)
+	}
+	return a, nil
+}
+
+// open appends to the log file, creating it if need be.
+func (a *accessLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.out, a.size = f, f, info.Size()
+	return nil
+}
+
+// rotate shifts the older files up by one, dropping the oldest beyond
+// maxBackups, and starts a new log file.
+func (a *accessLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	if a.maxBackups == 0 {
+		if err := os.Remove(a.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		os.Remove(a.path + "." + strconv.Itoa(a.maxBackups))
+		for i := a.maxBackups - 1; i >= 1; i-- {
+			os.Rename(a.path+"."+strconv.Itoa(i), a.path+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	}
+	return a.open()
+}
+
+// write appends a line to the log, rotating the file first when the line
+// would take it past maxSize.
+func (a *accessLog) write(line string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var rotateErr error
+	if a.file != nil && a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if rotateErr = a.rotate(); rotateErr != nil {
+			// Keep appending to the same file rather than losing requests
+			if err := a.open(); err != nil {
+				return fmt.Errorf("failed to rotate %s: %v", a.path, rotateErr)
+			}
+		}
+	}
+	n, err := io.WriteString(a.out, line)
+	a.size += int64(n)
+	if err == nil && rotateErr != nil {
+		err = fmt.Errorf("failed to rotate %s: %v", a.path, rotateErr)
+	}
+	return err
+}
+
+// close closes the log file.
+func (a *accessLog) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// clfField quotes a request header for the log, or gives "-" when it's
+// missing. Quotes and control characters are escaped as Apache does, so a
+// client can't forge a line.
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// withAccessLog writes each request to ACCESS_LOG once it's been served.
+// The client's address is anonymized like everywhere else it's recorded.
+func (lf *LinkForwarder) withAccessLog(next http.Handler) http.Handler {
+	if lf.accessLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := lf.now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		size := "-"
+		if rec.written > 0 {
+			size = strconv.FormatInt(rec.written, 10)
+		}
+		// The identity and user fields are always "-": the user isn't known
+		// out here, and a name from the request itself is unchecked
+		line := fmt.Sprintf(`%s - - [%s] "%s" %d %s`,
+			lf.privacy.anonymizeIP(clientIP(r)), start.Format(clfTimeFormat),
+			clfField(r.Method+" "+r.RequestURI+" "+r.Proto), status, size)
+		if lf.accessLog.combined {
+			line += fmt.Sprintf(` "%s" "%s"`, clfField(r.Referer()), clfField(r.UserAgent()))
+		}
+		if err := lf.accessLog.write(line + "\n"); err != nil {
+			lf.logger.Printf("Failed to write access log: %v", err)
+		}
+	})
+}
+
+// statusRecorder notes the status a handler responds with and how many
+// bytes of body it wrote. It passes Flush through, so streamed responses
+// still stream.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
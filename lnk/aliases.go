@@ -0,0 +1,208 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// aliasesColumn selects a link's aliases, separated by spaces since
+// shortcodes can't contain whitespace.
+const aliasesColumn = `(SELECT COALESCE(group_concat(alias, ' '), '') FROM aliases
+	WHERE aliases.domain = links.domain AND aliases.shortcode = links.shortcode)`
+
+var (
+	errAliasNotFound  = errors.New("alias not found")
+	errShortcodeTaken = errors.New("shortcode is already in use")
+)
+
+// splitAliases decodes the aliases column.
+func splitAliases(s string) []string {
+	aliases := strings.Fields(s)
+	sort.Strings(aliases)
+	return aliases
+}
+
+// shortcodeInUse reports whether code is taken by a link or an alias on
+// domain.
+func shortcodeInUse(ctx context.Context, q querier, domain, code string) (bool, error) {
+	var n int
+	err := q.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM links WHERE domain = ? AND shortcode = ?) +
+		(SELECT COUNT(*) FROM aliases WHERE domain = ? AND alias = ?)`, domain, code, domain, code).Scan(&n)
+	return n > 0, err
+}
+
+// resolveAlias returns the shortcode an alias on domain points to.
+func (lf *LinkForwarder) resolveAlias(ctx context.Context, domain, alias string) (string, error) {
+	var shortcode string
+	err := lf.db.QueryRowContext(ctx, `SELECT shortcode FROM aliases WHERE domain = ? AND alias = ?`,
+		domain, alias).Scan(&shortcode)
+	if err == sql.ErrNoRows {
+		return "", errAliasNotFound
+	}
+	return shortcode, err
+}
+
+// getLinkOrAlias looks up a link by its shortcode or one of its aliases.
+func (lf *LinkForwarder) getLinkOrAlias(ctx context.Context, domain, code string) (Link, error) {
+	link, err := lf.getLink(ctx, domain, code)
+	if !errors.Is(err, errLinkNotFound) {
+		return link, err
+	}
+	shortcode, err := lf.resolveAlias(ctx, domain, code)
+	if errors.Is(err, errAliasNotFound) {
+		return Link{}, errLinkNotFound
+	} else if err != nil {
+		return Link{}, err
+	}
+	return lf.getLink(ctx, domain, shortcode)
+}
+
+// addAlias makes alias another name for a link.
+func (lf *LinkForwarder) addAlias(ctx context.Context, link Link, alias string) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	inUse, err := shortcodeInUse(ctx, tx, link.Domain, alias)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return errShortcodeTaken
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO aliases (domain, alias, shortcode) VALUES (?, ?, ?)`,
+		link.Domain, alias, link.Shortcode); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	lf.invalidateLinks()
+	// A CDN may have kept the 404 from before the alias existed
+	lf.purgeCDN(link.Domain, alias)
+	return nil
+}
+
+// removeAlias deletes one of a link's aliases.
+func (lf *LinkForwarder) removeAlias(ctx context.Context, link Link, alias string) error {
+	result, err := lf.db.ExecContext(ctx, `DELETE FROM aliases WHERE domain = ? AND alias = ? AND shortcode = ?`,
+		link.Domain, alias, link.Shortcode)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errAliasNotFound
+	}
+	lf.invalidateLinks()
+	lf.purgeCDN(link.Domain, alias)
+	return nil
+}
+
+// aliasRequest is the body of a request adding an alias.
+type aliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+func (lf *LinkForwarder) handleAliases(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortcode := lf.rules.normalize(vars["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, err := lf.getLink(r.Context(), domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+
+	if r.Method != "GET" {
+		if user := currentUser(r); user != nil && !user.canEdit(link) {
+			writeError(w, http.StatusForbidden, "You can only change links you own")
+			return
+		}
+	}
+
+	switch r.Method {
+	case "GET":
+		aliases := link.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Aliases retrieved successfully",
+			Data:    aliases,
+		})
+
+	case "POST":
+		var req aliasRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		alias := lf.rules.normalize(strings.TrimSpace(req.Alias))
+		if alias == "" {
+			writeError(w, http.StatusBadRequest, "Alias is required")
+			return
+		}
+		if err := lf.validateShortcode(alias); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := lf.addAlias(r.Context(), link, alias); err != nil {
+			if errors.Is(err, errShortcodeTaken) {
+				writeError(w, http.StatusConflict, fmt.Sprintf("'%s' is already in use", alias))
+			} else {
+				lf.logf(r, "Failed to add alias %s for %s: %v", alias, link.Shortcode, err)
+				writeError(w, http.StatusInternalServerError, "Failed to add alias")
+			}
+			return
+		}
+		lf.logf(r, "%s added alias %s for %s", lf.requestActor(r), alias, link.Shortcode)
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "Alias added successfully",
+			Data:    map[string]string{"alias": alias, "shortcode": link.Shortcode},
+		})
+
+	case "DELETE":
+		alias := lf.rules.normalize(vars["alias"])
+		if err := lf.removeAlias(r.Context(), link, alias); err != nil {
+			if errors.Is(err, errAliasNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "Failed to remove alias")
+			}
+			return
+		}
+		lf.logf(r, "%s removed alias %s from %s", lf.requestActor(r), alias, link.Shortcode)
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Alias removed successfully",
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,285 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// History actions for archiving, recorded in link_history.
+const (
+	historyArchive = "archive"
+	historyRestore = "restore"
+)
+
+// archiveInterval is how often the archive-stale job looks for unused
+// links, when archiving is on.
+const archiveInterval = time.Hour
+
+// archiveConfig says when unused links are archived and what visitors to
+// an archived link get.
+type archiveConfig struct {
+	after    int  // days without a click; 0 turns archiving off
+	redirect bool // archived links keep redirecting instead of showing the archived page
+}
+
+// loadArchiveConfig reads ARCHIVE_AFTER_DAYS and ARCHIVED_LINKS.
+func loadArchiveConfig(getenv func(string) string) (archiveConfig, error) {
+	var cfg archiveConfig
+	if v := getenv("ARCHIVE_AFTER_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid ARCHIVE_AFTER_DAYS %q: must be a non-negative integer", v)
+		}
+		cfg.after = n
+	}
+	switch v := strings.ToLower(getenv("ARCHIVED_LINKS")); v {
+	case "", "page":
+	case "redirect":
+		cfg.redirect = true
+	default:
+		return cfg, fmt.Errorf("invalid ARCHIVED_LINKS %q: must be page or redirect", v)
+	}
+	return cfg, nil
+}
+
+// interval is how often the archive-stale job runs; 0 when archiving is
+// off.
+func (c archiveConfig) interval() time.Duration {
+	if c.after == 0 {
+		return 0
+	}
+	return archiveInterval
+}
+
+// archiveStale archives the links that haven't been clicked in
+// ARCHIVE_AFTER_DAYS, or since they were created or restored if that's
+// more recent, returning how many it archived. Pinned links are kept.
+func (lf *LinkForwarder) archiveStale(ctx context.Context) (int, error) {
+	now := lf.now().UTC()
+	cutoff := now.AddDate(0, 0, -lf.archive.after)
+
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+linkColumns+` FROM links
+		WHERE archived_at IS NULL AND pinned = 0 AND COALESCE(last_accessed_at, created_at) < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var stale []Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, link := range stale {
+		if _, err := tx.ExecContext(ctx, `UPDATE links SET archived_at = ? WHERE domain = ? AND shortcode = ?`,
+			now, link.Domain, link.Shortcode); err != nil {
+			return 0, err
+		}
+		archived := link
+		archived.ArchivedAt = &now
+		if err := recordHistory(ctx, tx, link.Domain, link.Shortcode, historyArchive, "archive-stale", &link, &archived); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if len(stale) > 0 {
+		lf.invalidateLinks()
+	}
+	for _, link := range stale {
+		lf.purgeCDN(link.Domain, link.Shortcode)
+	}
+	return len(stale), nil
+}
+
+// runArchive is the archive-stale job.
+func (lf *LinkForwarder) runArchive(ctx context.Context) (string, error) {
+	n, err := lf.archiveStale(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Archived %d links unused for %d days", n, lf.archive.after), nil
+}
+
+// setArchived archives or restores a link and records it in the link's
+// history. Restoring counts as using the link, so it isn't archived again
+// before another ARCHIVE_AFTER_DAYS go by.
+func (lf *LinkForwarder) setArchived(ctx context.Context, domain, shortcode string, archived bool, actor string) (Link, error) {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Link{}, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + linkColumns + ` FROM links WHERE domain = ? AND shortcode = ?`
+	previous, err := scanLink(tx.QueryRowContext(ctx, query, domain, shortcode))
+	if err == sql.ErrNoRows {
+		return Link{}, errLinkNotFound
+	} else if err != nil {
+		return Link{}, err
+	}
+	if (previous.ArchivedAt != nil) == archived {
+		return previous, nil
+	}
+
+	now := lf.now().UTC()
+	action := historyArchive
+	if archived {
+		_, err = tx.ExecContext(ctx, `UPDATE links SET archived_at = ? WHERE domain = ? AND shortcode = ?`,
+			now, domain, shortcode)
+	} else {
+		action = historyRestore
+		_, err = tx.ExecContext(ctx, `UPDATE links SET archived_at = NULL, last_accessed_at = ? WHERE domain = ? AND shortcode = ?`,
+			now, domain, shortcode)
+	}
+	if err != nil {
+		return Link{}, err
+	}
+	current, err := scanLink(tx.QueryRowContext(ctx, query, domain, shortcode))
+	if err != nil {
+		return Link{}, err
+	}
+	if err := recordHistory(ctx, tx, domain, shortcode, action, actor, &previous, &current); err != nil {
+		return Link{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Link{}, err
+	}
+	lf.invalidateLinks()
+	lf.purgeCDN(domain, shortcode)
+	return current, nil
+}
+
+// handleArchive archives a link, or restores it, on
+// /links/{shortcode}/archive and /links/{shortcode}/restore.
+func (lf *LinkForwarder) handleArchive(w http.ResponseWriter, r *http.Request) {
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	archive := strings.HasSuffix(r.URL.Path, "/archive")
+
+	link, err := lf.getLink(r.Context(), domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+	if user := currentUser(r); user != nil && !user.canEdit(link) {
+		writeError(w, http.StatusForbidden, "You can only change links you own")
+		return
+	}
+
+	link, err = lf.setArchived(r.Context(), domain, shortcode, archive, lf.requestActor(r))
+	if err != nil {
+		lf.logf(r, "Failed to archive or restore %s: %v", shortcode, err)
+		writeError(w, http.StatusInternalServerError, "Failed to update link")
+		return
+	}
+	link.ShortURL = lf.shortURL(r, link)
+
+	message := "Link restored successfully"
+	if archive {
+		message = "Link archived successfully"
+		lf.logf(r, "%s archived %s", lf.requestActor(r), shortcode)
+	} else {
+		lf.logf(r, "%s restored %s", lf.requestActor(r), shortcode)
+	}
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    link,
+	})
+}
+
+// ArchivedData is passed to the archived.html template.
+type ArchivedData struct {
+	Shortcode  string
+	ArchivedAt time.Time
+	CanRestore bool   // the visitor may restore the link
+	Action     string // where the restore form posts
+	CSRFToken  string
+}
+
+// handleArchivedLink shows the page for an archived link. Visitors who
+// may edit the link can restore it from there, which sends them on to
+// the link as usual.
+func (lf *LinkForwarder) handleArchivedLink(w http.ResponseWriter, r *http.Request, link Link) {
+	data := ArchivedData{
+		Shortcode:  link.Shortcode,
+		ArchivedAt: *link.ArchivedAt,
+		CanRestore: lf.canRestore(r, link),
+		Action:     lf.appPath(r.URL.RequestURI()),
+		CSRFToken:  requestCSRFToken(r),
+	}
+
+	if r.Method == http.MethodPost && r.FormValue("action") == "restore" {
+		if !data.CanRestore || !lf.csrfSafe(r) {
+			http.Error(w, "You can't restore this link", http.StatusForbidden)
+			return
+		}
+		if _, err := lf.setArchived(r.Context(), link.Domain, link.Shortcode, false, lf.requestActor(r)); err != nil {
+			lf.logf(r, "Failed to restore %s: %v", link.Shortcode, err)
+			http.Error(w, "Failed to restore link", http.StatusInternalServerError)
+			return
+		}
+		lf.logf(r, "%s restored %s", lf.requestActor(r), link.Shortcode)
+		http.Redirect(w, r, data.Action, http.StatusSeeOther)
+		return
+	}
+
+	tmpl, err := lf.loadTemplate("archived.html")
+	if err != nil {
+		http.Error(w, "This link was archived", http.StatusGone)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(http.StatusGone)
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+}
+
+// canRestore reports whether the visitor may restore an archived link
+// from its page: as with canCreate, and only a link they may edit. Only
+// admins may while maintenance mode is on.
+func (lf *LinkForwarder) canRestore(r *http.Request, link Link) bool {
+	if !lf.canCreate(r) {
+		return false
+	}
+	user, _ := lf.requestSessionUser(r) // nil without accounts
+	if user != nil && !user.canEdit(link) {
+		return false
+	}
+	m, err := lf.maintenance(r.Context())
+	return err == nil && (!m.Enabled || user.IsAdmin())
+}
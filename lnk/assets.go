@@ -0,0 +1,103 @@
+package lnk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// asset is a file served under /static/.
+type asset struct {
+	data        []byte
+	fingerprint string // of its contents
+}
+
+// assetSet holds the static files, keyed by their path under /static/.
+// Pages link to them by a name with the fingerprint of their contents in
+// it, such as css/home.3f2a1b9c0d.css, so browsers can cache them for good
+// and still pick up a new release's files at once.
+type assetSet map[string]asset
+
+// readAssets reads every file in files and fingerprints it.
+func readAssets(files fs.FS) (assetSet, error) {
+	set := assetSet{}
+	err := fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		set[name] = asset{data: data, fingerprint: hex.EncodeToString(sum[:5])}
+		return nil
+	})
+	return set, err
+}
+
+// fingerprinted returns the name pages link to the static file name by.
+func (s assetSet) fingerprinted(name string) (string, error) {
+	a, ok := s[name]
+	if !ok {
+		return "", fmt.Errorf("no static file %s", name)
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + a.fingerprint + ext, nil
+}
+
+// lookup finds the static file requested as name, with or without a
+// fingerprint, and reports whether the fingerprint is the current one.
+func (s assetSet) lookup(name string) (asset, bool, bool) {
+	if a, ok := s[name]; ok {
+		return a, false, true
+	}
+	// css/home.3f2a1b9c0d.css is css/home.css
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	dot := strings.LastIndexByte(base, '.')
+	if dot < 0 {
+		return asset{}, false, false
+	}
+	a, ok := s[base[:dot]+ext]
+	return a, ok && base[dot+1:] == a.fingerprint, ok
+}
+
+// handleStatic serves the static files. Fingerprinted names are cached for
+// a year; the rest, and names from an older release, are revalidated on
+// every use.
+func (lf *LinkForwarder) handleStatic(w http.ResponseWriter, r *http.Request) {
+	if lf.reloadTheme {
+		if err := lf.loadAssets(); err != nil {
+			lf.logf(r, "Failed to reload static files: %v", err)
+		}
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	a, current, ok := lf.currentAssets().lookup(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if current {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", `"`+a.fingerprint+`"`)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(a.data))
+}
+
+// assetPath is the template function that links to a static file.
+func (lf *LinkForwarder) assetPath(name string) (string, error) {
+	fingerprinted, err := lf.currentAssets().fingerprinted(name)
+	if err != nil {
+		return "", err
+	}
+	return lf.appPath("/static/" + fingerprinted), nil
+}
@@ -0,0 +1,354 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User roles. Admins can manage every link and account; users can only
+// change the links they own.
+const (
+	roleAdmin = "admin"
+	roleUser  = "user"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// dummyHash is compared against when a username doesn't exist, so unknown
+// usernames take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("lnk"), bcrypt.DefaultCost)
+
+type User struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == roleAdmin
+}
+
+// canEdit reports whether u may change or delete link. Links without an
+// owner predate accounts and can only be managed by admins.
+func (u *User) canEdit(link Link) bool {
+	return u.IsAdmin() || (u != nil && link.Owner != "" && link.Owner == u.Username)
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+func withUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// currentUser returns the authenticated user for a request, or nil when
+// authentication is disabled.
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
+// bootstrapAdmin creates the initial admin account from ADMIN_USERNAME
+// (default "admin") and ADMIN_PASSWORD if that account doesn't exist yet.
+func (lf *LinkForwarder) bootstrapAdmin(ctx context.Context) error {
+	password := lf.getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return nil
+	}
+	username := lf.getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+
+	if _, err := lf.getUser(ctx, username); !errors.Is(err, errUserNotFound) {
+		return err
+	}
+	if _, err := lf.createUser(ctx, username, password, roleAdmin); err != nil {
+		return err
+	}
+	lf.logger.Printf("Created admin account '%s'", username)
+	return nil
+}
+
+// authEnabled reports whether SSO is configured or any accounts exist.
+// Until then the API stays open, as it was before accounts existed.
+func (lf *LinkForwarder) authEnabled(ctx context.Context) (bool, error) {
+	if lf.oidc != nil {
+		return true, nil
+	}
+	var n int
+	err := lf.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&n)
+	return n > 0, err
+}
+
+func (lf *LinkForwarder) createUser(ctx context.Context, username, password, role string) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := lf.db.ExecContext(ctx, `INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`,
+		username, string(hash), role)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: id, Username: username, Role: role, CreatedAt: lf.now().UTC()}, nil
+}
+
+func (lf *LinkForwarder) getUser(ctx context.Context, username string) (*User, error) {
+	var u User
+	err := lf.db.QueryRowContext(ctx, `SELECT id, username, role, created_at FROM users WHERE username = ?`, username).
+		Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errUserNotFound
+	}
+	return &u, err
+}
+
+func (lf *LinkForwarder) listUsers(ctx context.Context) ([]User, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT id, username, role, created_at FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (lf *LinkForwarder) deleteUser(ctx context.Context, username string) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Log the user out everywhere and revoke their tokens along with
+	// deleting the account
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id IN (SELECT id FROM users WHERE username = ?)`, username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id IN (SELECT id FROM users WHERE username = ?)`, username); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errUserNotFound
+	}
+	return tx.Commit()
+}
+
+// checkPassword returns the user if the username and password match.
+func (lf *LinkForwarder) checkPassword(ctx context.Context, username, password string) (*User, error) {
+	var hash string
+	err := lf.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE username = ?`, username).Scan(&hash)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, errUserNotFound
+	}
+	return lf.getUser(ctx, username)
+}
+
+// authenticate identifies the user making a request from its Basic auth
+// credentials, an API token, an OIDC ID token, or, for the web UI, its
+// session cookie. The API token is returned too when one was used.
+func (lf *LinkForwarder) authenticate(r *http.Request) (*User, *APIToken, error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		user, err := lf.checkPassword(r.Context(), username, password)
+		return user, nil, err
+	}
+	if token, ok := bearerToken(r); ok && strings.HasPrefix(token, tokenPrefix) {
+		return lf.tokenUser(r.Context(), token)
+	}
+	if token, ok := bearerToken(r); ok && lf.oidc != nil {
+		user, err := lf.userForIDToken(r.Context(), token, "")
+		if err != nil {
+			lf.logf(r, "Rejected bearer token: %v", err)
+			return nil, nil, errUserNotFound
+		}
+		return user, nil, nil
+	}
+	user, err := lf.requestSessionUser(r)
+	return user, nil, err
+}
+
+// requireAuth rejects API requests without valid credentials once accounts
+// exist, and makes the authenticated user available via currentUser.
+func (lf *LinkForwarder) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled, err := lf.authEnabled(r.Context())
+		if err != nil {
+			lf.logger.Printf("Failed to check accounts: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to check credentials")
+			return
+		}
+		if !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, token, err := lf.authenticate(r)
+		if err != nil {
+			if !errors.Is(err, errUserNotFound) {
+				lf.logger.Printf("Failed to authenticate request: %v", err)
+			}
+			// Browsers with a session cookie are sent to the login page by
+			// the UI instead of getting a Basic auth prompt
+			if _, err := r.Cookie(sessionCookieName); err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="lnk"`)
+			}
+			writeError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		if !lf.csrfSafe(r) {
+			writeError(w, http.StatusForbidden, "Invalid or missing CSRF token")
+			return
+		}
+
+		ctx := withUser(r.Context(), user)
+		if token != nil {
+			ctx = withToken(ctx, token)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin wraps a handler that only admins may call. It must run
+// behind requireAuth. Until accounts exist nobody is an admin, so these
+// handlers refuse everyone: the first account comes from ADMIN_PASSWORD or
+// SSO, never from whoever reaches the server first.
+func (lf *LinkForwarder) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).IsAdmin() {
+			writeError(w, http.StatusForbidden, "Admin access required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (lf *LinkForwarder) handleMe(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusNotFound, "Authentication is not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Authenticated",
+		Data:    user,
+	})
+}
+
+type createUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+func (lf *LinkForwarder) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		users, err := lf.listUsers(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve users")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Users retrieved successfully",
+			Data:    users,
+		})
+
+	case "POST":
+		var req createUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		req.Username = strings.TrimSpace(req.Username)
+		if req.Username == "" || req.Password == "" {
+			writeError(w, http.StatusBadRequest, "Username and password are required")
+			return
+		}
+		if req.Role == "" {
+			req.Role = roleUser
+		}
+		if req.Role != roleUser && req.Role != roleAdmin {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("role must be %s or %s", roleUser, roleAdmin))
+			return
+		}
+		if _, err := lf.getUser(r.Context(), req.Username); err == nil {
+			writeError(w, http.StatusConflict, "User already exists")
+			return
+		}
+
+		user, err := lf.createUser(r.Context(), req.Username, req.Password, req.Role)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "User created successfully",
+			Data:    user,
+		})
+
+	case "DELETE":
+		username := mux.Vars(r)["username"]
+		if user := currentUser(r); user != nil && user.Username == username {
+			writeError(w, http.StatusBadRequest, "You can't delete your own account")
+			return
+		}
+		if err := lf.deleteUser(r.Context(), username); err != nil {
+			if errors.Is(err, errUserNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "Failed to delete user")
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "User deleted successfully",
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,104 @@
+package lnk
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// TestOwnership checks that regular users can only change the links they
+// own, however they go about it, while admins can change any.
+func TestOwnership(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+
+	// A link from before accounts existed has no owner
+	if w := serve(lf, "POST", "/api/v1/links", `{"shortcode":"legacy","url":"https://dest.example/legacy"}`, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating a link without accounts: status %d: %s", w.Code, w.Body)
+	}
+
+	admin := testUser(t, lf, "root", roleAdmin)
+	alice := testUser(t, lf, "alice", roleUser)
+	bob := testUser(t, lf, "bob", roleUser)
+	as := func(user *User) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user.Username, "password-"+user.Username) }
+	}
+
+	for _, code := range []string{"mine", "archiveme"} {
+		if w := serve(lf, "POST", "/api/v1/links", `{"shortcode":"`+code+`","url":"https://dest.example/`+code+`"}`, as(alice)); w.Code != http.StatusOK {
+			t.Fatalf("alice creating %s: status %d: %s", code, w.Code, w.Body)
+		}
+	}
+	link, err := lf.getLink(context.Background(), "", "mine")
+	if err != nil || link.Owner != "alice" {
+		t.Fatalf("owner of alice's link = %q, %v", link.Owner, err)
+	}
+
+	tests := []struct {
+		name   string
+		user   *User
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"replace with POST", bob, "POST", "/api/v1/links", `{"shortcode":"mine","url":"https://evil.example"}`, http.StatusForbidden},
+		{"update", bob, "PUT", "/api/v1/links/mine", `{"url":"https://evil.example"}`, http.StatusForbidden},
+		{"delete", bob, "DELETE", "/api/v1/links/mine", "", http.StatusForbidden},
+		{"bulk delete", bob, "DELETE", "/api/v1/links?shortcodes=mine", "", http.StatusForbidden},
+		{"batch update", bob, "POST", "/api/v1/links/batch", `[{"op":"update","link":{"shortcode":"mine","url":"https://evil.example"}}]`, http.StatusForbidden},
+		{"add an alias", bob, "POST", "/api/v1/links/mine/aliases", `{"alias":"bobs"}`, http.StatusForbidden},
+		{"archive", bob, "POST", "/api/v1/links/mine/archive", "", http.StatusForbidden},
+		{"update an ownerless link", alice, "PUT", "/api/v1/links/legacy", `{"url":"https://evil.example"}`, http.StatusForbidden},
+		{"delete an ownerless link", alice, "DELETE", "/api/v1/links/legacy", "", http.StatusForbidden},
+		{"owner updates", alice, "PUT", "/api/v1/links/mine", `{"url":"https://dest.example/new"}`, http.StatusOK},
+		{"owner archives", alice, "POST", "/api/v1/links/archiveme/archive", "", http.StatusOK},
+		{"admin updates another's link", admin, "PUT", "/api/v1/links/mine", `{"url":"https://dest.example/admin"}`, http.StatusOK},
+		{"admin updates an ownerless link", admin, "PUT", "/api/v1/links/legacy", `{"url":"https://dest.example/admin"}`, http.StatusOK},
+		{"admin deletes another's link", admin, "DELETE", "/api/v1/links/mine", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(lf, tt.method, tt.target, tt.body, as(tt.user))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// TestAdminOperations checks that admin operations need an admin account,
+// so nobody can make themselves one before the operator has.
+func TestAdminOperations(t *testing.T) {
+	body := `{"username":"mallory","password":"password","role":"admin"}`
+
+	lf := newTestForwarder(t, nil)
+	for _, method := range []string{"GET", "POST"} {
+		reqBody := ""
+		if method == "POST" {
+			reqBody = body
+		}
+		if w := serve(lf, method, "/api/v1/users", reqBody, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s /api/v1/users without accounts: status %d, want %d", method, w.Code, http.StatusForbidden)
+		}
+	}
+	if enabled, err := lf.authEnabled(context.Background()); err != nil || enabled {
+		t.Fatalf("an account was created anonymously (%v)", err)
+	}
+
+	lf = newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	alice := testUser(t, lf, "alice", roleUser)
+	tests := []struct {
+		name string
+		edit func(r *http.Request)
+		want int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"regular user", func(r *http.Request) { r.SetBasicAuth(alice.Username, "password-alice") }, http.StatusForbidden},
+		{"admin", func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if w := serve(lf, "POST", "/api/v1/users", body, tt.edit); w.Code != tt.want {
+			t.Errorf("%s creating an account: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBackupKeep = 7
+	backupPrefix      = "links-"
+	backupSuffix      = ".db"
+	backupTimeFormat  = "20060102T150405.000Z"
+	backupKeyPrefix   = "backups/" // of backups in object storage
+)
+
+// Backup is a snapshot of the database, in the backup directory or object
+// storage or both.
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Local     bool      `json:"local"`
+	Uploaded  bool      `json:"uploaded"` // to S3_BUCKET
+}
+
+// backupConfig says where and how often the database is backed up.
+type backupConfig struct {
+	dir      string
+	keep     int           // backups to keep; 0 keeps them all
+	interval time.Duration // between scheduled backups; 0 turns them off
+}
+
+// loadBackupConfig reads BACKUP_DIR (default: backups in the data
+// directory), BACKUP_KEEP, and BACKUP_INTERVAL.
+func loadBackupConfig(getenv func(string) string, dataDir string) (backupConfig, error) {
+	cfg := backupConfig{dir: getenv("BACKUP_DIR"), keep: defaultBackupKeep}
+	if cfg.dir == "" {
+		cfg.dir = filepath.Join(dataDir, "backups")
+	}
+	if v := getenv("BACKUP_KEEP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid BACKUP_KEEP %q: must be a non-negative integer", v)
+		}
+		cfg.keep = n
+	}
+	if v := getenv("BACKUP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid BACKUP_INTERVAL %q: must be a duration such as 24h, or 0 to turn scheduled backups off", v)
+		}
+		cfg.interval = d
+	}
+	return cfg, nil
+}
+
+// backupSQLite copies the database behind db to path with SQLite's online
+// backup API, which gives a consistent snapshot while the server keeps
+// serving and saving links.
+func backupSQLite(ctx context.Context, db *sql.DB, path string) error {
+	dest, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	if err := copySQLite(destConn, srcConn); err != nil {
+		return err
+	}
+	// The copy takes on the source's WAL mode; fold the log back in so the
+	// backup is a single self-contained file
+	_, err = destConn.ExecContext(ctx, `PRAGMA journal_mode = DELETE`)
+	return err
+}
+
+// copySQLite replaces the database on dest with the one on src in a single
+// transaction, so other connections to dest see either all of it or none.
+func copySQLite(dest, src *sql.Conn) error {
+	return dest.Raw(func(d any) error {
+		return src.Raw(func(s any) error {
+			destSQLite, ok1 := sqliteConn(d)
+			srcSQLite, ok2 := sqliteConn(s)
+			if !ok1 || !ok2 {
+				return errors.New("backups need a SQLite database")
+			}
+			b, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			// In WAL mode copying everything in one step doesn't block
+			// writers, and can't be restarted by them halfway through
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// checkSQLiteFile opens the database at path and checks it isn't corrupt.
+func checkSQLiteFile(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA quick_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("database is corrupt: %s", result)
+	}
+	return nil
+}
+
+// createBackup snapshots the database into the backup directory and, with
+// S3_BUCKET set, uploads it. The file only gets its final name once it's
+// complete and checked, so a crash never leaves a partial backup behind
+// that looks whole.
+func (lf *LinkForwarder) createBackup(ctx context.Context) (Backup, error) {
+	if err := os.MkdirAll(lf.backups.dir, 0700); err != nil {
+		return Backup{}, err
+	}
+	now := lf.now().UTC()
+	name := backupPrefix + now.Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(lf.backups.dir, name)
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+
+	if err := backupSQLite(ctx, lf.db, tmp); err != nil {
+		return Backup{}, err
+	}
+	if err := checkSQLiteFile(ctx, tmp); err != nil {
+		return Backup{}, err
+	}
+	// The database holds password hashes
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return Backup{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Backup{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	backup := Backup{Name: name, Size: info.Size(), CreatedAt: now, Local: true}
+	if lf.objects != nil {
+		if err := lf.objects.putFile(ctx, backupKeyPrefix+name, path); err != nil {
+			return backup, fmt.Errorf("backup %s saved, but uploading it failed: %v", name, err)
+		}
+		backup.Uploaded = true
+	}
+	return backup, nil
+}
+
+// parseBackupName returns when the backup called name was taken, or false
+// if name isn't a backup's.
+func parseBackupName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+	return created, err == nil
+}
+
+// listBackups returns the backups in the backup directory and object
+// storage, newest first.
+func (lf *LinkForwarder) listBackups(ctx context.Context) ([]Backup, error) {
+	found := map[string]*Backup{}
+	entries, err := os.ReadDir(lf.backups.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		created, ok := parseBackupName(entry.Name())
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		found[entry.Name()] = &Backup{Name: entry.Name(), Size: info.Size(), CreatedAt: created, Local: true}
+	}
+	if lf.objects != nil {
+		objects, err := lf.objects.list(ctx, backupKeyPrefix, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			name := strings.TrimPrefix(o.Key, backupKeyPrefix)
+			created, ok := parseBackupName(name)
+			if !ok {
+				continue
+			}
+			if b, ok := found[name]; ok {
+				b.Uploaded = true
+			} else {
+				found[name] = &Backup{Name: name, Size: o.Size, CreatedAt: created, Uploaded: true}
+			}
+		}
+	}
+
+	backups := []Backup{}
+	for _, b := range found {
+		backups = append(backups, *b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// pruneBackups deletes the oldest backups beyond BACKUP_KEEP, wherever
+// they're stored, returning how many it deleted.
+func (lf *LinkForwarder) pruneBackups(ctx context.Context) (int, error) {
+	if lf.backups.keep == 0 {
+		return 0, nil
+	}
+	backups, err := lf.listBackups(ctx)
+	if err != nil || len(backups) <= lf.backups.keep {
+		return 0, err
+	}
+	removed := 0
+	for _, b := range backups[lf.backups.keep:] {
+		if b.Local {
+			if err := os.Remove(filepath.Join(lf.backups.dir, b.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return removed, err
+			}
+		}
+		if b.Uploaded {
+			if err := lf.objects.delete(ctx, backupKeyPrefix+b.Name); err != nil {
+				return removed, err
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// backupAndPrune takes a backup and applies the retention policy.
+func (lf *LinkForwarder) backupAndPrune(ctx context.Context) (Backup, int, error) {
+	backup, err := lf.createBackup(ctx)
+	if err != nil {
+		return backup, 0, err
+	}
+	removed, err := lf.pruneBackups(ctx)
+	if err != nil {
+		return backup, removed, fmt.Errorf("backup %s saved, but removing old backups failed: %v", backup.Name, err)
+	}
+	return backup, removed, nil
+}
+
+// runBackup is the backup job.
+func (lf *LinkForwarder) runBackup(ctx context.Context) (string, error) {
+	backup, removed, err := lf.backupAndPrune(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Saved %s (%d bytes), removed %d old backups", backup.Name, backup.Size, removed), nil
+}
+
+// handleBackups lists the backups, or with POST takes one now.
+func (lf *LinkForwarder) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		backups, err := lf.listBackups(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve backups")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Backups retrieved successfully",
+			Data:    backups,
+		})
+
+	case "POST":
+		backup, _, err := lf.backupAndPrune(r.Context())
+		if err != nil {
+			lf.logf(r, "Backup failed: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to back up database")
+			return
+		}
+		lf.logf(r, "%s backed up the database to %s", lf.requestActor(r), backup.Name)
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "Backup created successfully",
+			Data:    backup,
+		})
+	}
+}
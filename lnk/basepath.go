@@ -0,0 +1,61 @@
+package lnk
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The base path is the URL prefix the forwarder is mounted under, such as
+// "/lnk", or "" when it owns the whole host. Routes are registered without
+// it; withBasePath strips it from requests and appPath adds it back to every
+// local URL the forwarder hands out.
+
+// normalizeBasePath gives a base path (from BASE_PATH or WithBasePath) a
+// leading slash and no trailing slash.
+func normalizeBasePath(raw string) (string, error) {
+	p := strings.Trim(strings.TrimSpace(raw), "/")
+	if p == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "?#\\ ") {
+		return "", fmt.Errorf("invalid base path %q", raw)
+	}
+	return "/" + p, nil
+}
+
+// appPath turns a path on this server into the URL visitors use for it.
+func (lf *LinkForwarder) appPath(p string) string {
+	return lf.basePath + p
+}
+
+// withBasePath serves the app under the base path and nothing outside it.
+func (lf *LinkForwarder) withBasePath(next http.Handler) http.Handler {
+	basePath := lf.basePath
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+
+		rest, ok := strings.CutPrefix(r.URL.Path, basePath+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + rest
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = "/" + strings.TrimPrefix(r.URL.RawPath, basePath+"/")
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
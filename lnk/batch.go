@@ -0,0 +1,212 @@
+package lnk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxBatchOperations bounds a batch, since it holds the database's write
+// lock while it's applied.
+const maxBatchOperations = 1000
+
+// BatchOperation is one change in a batch: a link to create or update, or
+// a shortcode to delete.
+type BatchOperation struct {
+	Op        string `json:"op"`                  // create, update, or delete
+	Link      *Link  `json:"link,omitempty"`      // for create and update
+	Shortcode string `json:"shortcode,omitempty"` // for delete
+	Domain    string `json:"domain,omitempty"`    // for delete; defaults to ?domain=
+}
+
+// BatchResult is the outcome of the operation at the same position in a
+// batch.
+type BatchResult struct {
+	Op        string `json:"op"`
+	Shortcode string `json:"shortcode,omitempty"`
+	Status    int    `json:"status,omitempty"` // unset for operations never attempted
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+	Link      *Link  `json:"link,omitempty"`
+}
+
+// prepareBatchOperation validates one operation of a batch the way the
+// single-link endpoints would, returning the link it changes.
+func (lf *LinkForwarder) prepareBatchOperation(r *http.Request, op BatchOperation) (Link, *apiError) {
+	switch op.Op {
+	case "create", "update":
+		if op.Link == nil {
+			return Link{}, &apiError{http.StatusBadRequest, "link is required"}
+		}
+		return lf.prepareRequestLink(r, *op.Link, op.Op == "update")
+
+	case "delete":
+		link := Link{Shortcode: lf.rules.normalize(op.Shortcode)}
+		if link.Shortcode == "" {
+			return link, &apiError{http.StatusBadRequest, "Shortcode is required"}
+		}
+		var err error
+		if op.Domain != "" {
+			link.Domain, err = lf.checkCustomDomain(op.Domain)
+		} else {
+			link.Domain, err = lf.apiDomain(r)
+		}
+		if err != nil {
+			return link, &apiError{http.StatusBadRequest, err.Error()}
+		}
+		return link, lf.checkDelete(r.Context(), requestEditor(r), link.Domain, link.Shortcode)
+	}
+	return Link{}, &apiError{http.StatusBadRequest, "op must be create, update, or delete"}
+}
+
+// handleBatch applies a list of operations in one transaction. Every
+// operation is validated first; if any fails, nothing is changed and the
+// results say which one failed and why.
+func (lf *LinkForwarder) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var ops []BatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if len(ops) == 0 {
+		writeError(w, http.StatusBadRequest, "The batch has no operations")
+		return
+	}
+	lf.applyBatch(w, r, ops)
+}
+
+// handleBulkDelete deletes the links listed in ?shortcodes=a,b,c as a
+// batch of deletes: all of them, or none if any can't be deleted.
+func (lf *LinkForwarder) handleBulkDelete(w http.ResponseWriter, r *http.Request) {
+	var ops []BatchOperation
+	for _, list := range r.URL.Query()["shortcodes"] {
+		for _, code := range strings.Split(list, ",") {
+			if code = strings.TrimSpace(code); code != "" {
+				ops = append(ops, BatchOperation{Op: "delete", Shortcode: code})
+			}
+		}
+	}
+	if len(ops) == 0 {
+		writeError(w, http.StatusBadRequest, "shortcodes is required")
+		return
+	}
+	lf.applyBatch(w, r, ops)
+}
+
+// applyBatch validates and applies ops for handleBatch and
+// handleBulkDelete.
+func (lf *LinkForwarder) applyBatch(w http.ResponseWriter, r *http.Request, ops []BatchOperation) {
+	if len(ops) > maxBatchOperations {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("A batch can have at most %d operations", maxBatchOperations))
+		return
+	}
+
+	links := make([]Link, len(ops))
+	results := make([]BatchResult, len(ops))
+	seen := map[string]bool{}
+	failed := -1
+	for i, op := range ops {
+		link, apiErr := lf.prepareBatchOperation(r, op)
+		// Operations are validated against the links as they are now, so
+		// one can't depend on another in the same batch
+		key := link.Domain + " " + link.Shortcode
+		if apiErr == nil && seen[key] {
+			apiErr = &apiError{http.StatusBadRequest, fmt.Sprintf("'%s' appears more than once in the batch", link.Shortcode)}
+		}
+		seen[key] = true
+
+		links[i] = link
+		results[i] = BatchResult{Op: op.Op, Shortcode: link.Shortcode}
+		if apiErr != nil {
+			results[i].Status = apiErr.status
+			results[i].Code = errorCode(apiErr.status)
+			results[i].Message = apiErr.message
+			if failed < 0 {
+				failed = i
+			}
+		}
+	}
+	if failed >= 0 {
+		rejectBatch(w, results, failed)
+		return
+	}
+
+	tx, err := lf.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to apply batch")
+		return
+	}
+	defer tx.Rollback()
+
+	actor := lf.requestActor(r)
+	actions := make([]string, len(ops))
+	deletedAliases := make([][]string, len(ops)) // purged from the CDN with the link
+	for i, op := range ops {
+		if op.Op == "delete" {
+			actions[i] = historyDelete
+			var previous Link
+			previous, err = deleteLinkTx(r.Context(), tx, links[i].Domain, links[i].Shortcode, actor)
+			deletedAliases[i] = previous.Aliases
+		} else {
+			actions[i], err = saveLinkTx(r.Context(), tx, links[i], actor)
+		}
+		if err != nil {
+			// The link changed since it was validated
+			status, message := http.StatusInternalServerError, "Failed to apply operation"
+			if errors.Is(err, errLinkNotFound) {
+				status, message = http.StatusNotFound, err.Error()
+			} else {
+				lf.logf(r, "Failed to apply batch operation %d (%s %s): %v", i+1, op.Op, links[i].Shortcode, err)
+			}
+			results[i].Status, results[i].Code, results[i].Message = status, errorCode(status), message
+			rejectBatch(w, results, i)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to apply batch")
+		return
+	}
+	lf.invalidateLinks()
+
+	for i, op := range ops {
+		lf.publish(Event{Type: actions[i], Domain: links[i].Domain, Shortcode: links[i].Shortcode, URL: links[i].URL, Actor: actor})
+		lf.purgeCDN(links[i].Domain, links[i].Shortcode, deletedAliases[i]...)
+		results[i].Status = http.StatusOK
+		if op.Op == "delete" {
+			results[i].Message = "Link deleted successfully"
+			continue
+		}
+		lf.queuePageInfo(links[i])
+		link := links[i]
+		link.ShortURL = lf.shortURL(r, link)
+		results[i].Message = "Link saved successfully"
+		results[i].Link = &link
+	}
+	lf.logf(r, "%s applied a batch of %d operations", actor, len(ops))
+
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Batch applied successfully",
+		Data:    results,
+	})
+}
+
+// rejectBatch reports a batch that wasn't applied because the operation at
+// index failed, with that operation's status.
+func rejectBatch(w http.ResponseWriter, results []BatchResult, index int) {
+	for i := range results {
+		if results[i].Status == 0 {
+			results[i].Message = "Not applied because another operation failed"
+		}
+	}
+	status := results[index].Status
+	writeJSON(w, status, Response{
+		Success: false,
+		Message: fmt.Sprintf("Operation %d failed: %s. Nothing was changed", index+1, results[index].Message),
+		Code:    errorCode(status),
+		Data:    results,
+	})
+}
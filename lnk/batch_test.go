@@ -0,0 +1,170 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// batchResponse is the body of a batch request's answer.
+type batchResponse struct {
+	Message string
+	Data    []BatchResult
+}
+
+func decodeBatch(t *testing.T, body []byte) batchResponse {
+	t.Helper()
+	var resp batchResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	return resp
+}
+
+// TestBatch checks that a batch creates, updates, and deletes links
+// together, reporting each operation's result in order.
+func TestBatch(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	ctx := context.Background()
+	for _, link := range []Link{
+		{Shortcode: "keep", URL: "https://dest.example/keep"},
+		{Shortcode: "old", URL: "https://dest.example/old"},
+	} {
+		if err := lf.saveLink(ctx, link, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := `[
+		{"op":"create","link":{"shortcode":"new","url":"https://dest.example/new"}},
+		{"op":"update","link":{"shortcode":"keep","url":"https://dest.example/kept"}},
+		{"op":"delete","shortcode":"old"}
+	]`
+	w := serve(lf, "POST", "/api/v1/links/batch", body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	resp := decodeBatch(t, w.Body.Bytes())
+	if len(resp.Data) != 3 {
+		t.Fatalf("got %d results, want 3", len(resp.Data))
+	}
+	for i, want := range []string{"create new", "update keep", "delete old"} {
+		if got := resp.Data[i]; got.Op+" "+got.Shortcode != want || got.Status != http.StatusOK {
+			t.Errorf("result %d: %+v, want %s to succeed", i, got, want)
+		}
+	}
+	if link := resp.Data[0].Link; link == nil || link.ShortURL != "http://example.com/new" {
+		t.Errorf("created link %+v, want it with its short URL", link)
+	}
+
+	if link, err := lf.getLink(ctx, "", "new"); err != nil || link.URL != "https://dest.example/new" {
+		t.Errorf("new = %q (%v), want it created", link.URL, err)
+	}
+	if link, err := lf.getLink(ctx, "", "keep"); err != nil || link.URL != "https://dest.example/kept" {
+		t.Errorf("keep = %q (%v), want it updated", link.URL, err)
+	}
+	if _, err := lf.getLink(ctx, "", "old"); !errors.Is(err, errLinkNotFound) {
+		t.Errorf("old: %v, want it deleted", err)
+	}
+}
+
+// TestBatchAllOrNothing checks that when any operation of a batch fails,
+// none is applied and the results say which failed and why.
+func TestBatchAllOrNothing(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	ctx := context.Background()
+	if err := lf.saveLink(ctx, Link{Shortcode: "old", URL: "https://dest.example/old"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		failed   int // index of the operation reported in the message
+		statuses []int
+	}{
+		{
+			"invalid link",
+			`[{"op":"delete","shortcode":"old"},{"op":"create","link":{"shortcode":"bad","url":"javascript:alert(1)"}},{"op":"update","link":{"shortcode":"missing","url":"https://dest.example/"}}]`,
+			http.StatusBadRequest, 1, []int{0, http.StatusBadRequest, http.StatusNotFound},
+		},
+		{
+			"missing link",
+			`[{"op":"delete","shortcode":"missing"},{"op":"delete","shortcode":"old"}]`,
+			http.StatusNotFound, 0, []int{http.StatusNotFound, 0},
+		},
+		{
+			"repeated shortcode",
+			`[{"op":"delete","shortcode":"old"},{"op":"update","link":{"shortcode":"old","url":"https://dest.example/"}}]`,
+			http.StatusBadRequest, 1, []int{0, http.StatusBadRequest},
+		},
+		{
+			"unknown op",
+			`[{"op":"rename","shortcode":"old"}]`,
+			http.StatusBadRequest, 0, []int{http.StatusBadRequest},
+		},
+	}
+	for _, tt := range tests {
+		w := serve(lf, "POST", "/api/v1/links/batch", tt.body, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		resp := decodeBatch(t, w.Body.Bytes())
+		if !strings.HasPrefix(resp.Message, fmt.Sprintf("Operation %d failed", tt.failed+1)) {
+			t.Errorf("%s: message %q, want operation %d to be blamed", tt.name, resp.Message, tt.failed+1)
+		}
+		for i, want := range tt.statuses {
+			if i >= len(resp.Data) || resp.Data[i].Status != want || resp.Data[i].Message == "" {
+				t.Errorf("%s: results %+v, want operation %d to have status %d", tt.name, resp.Data, i+1, want)
+				break
+			}
+		}
+	}
+
+	if _, err := lf.getLink(ctx, "", "old"); err != nil {
+		t.Errorf("old: %v, want it kept by every failed batch", err)
+	}
+	if w := serve(lf, "POST", "/api/v1/links/batch", `[]`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("empty batch: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// TestBatchOwnership checks that a batch may only change the links its
+// user could change one at a time.
+func TestBatchOwnership(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	alice := testUser(t, lf, "alice", roleUser)
+	bob := testUser(t, lf, "bob", roleUser)
+	ctx := context.Background()
+	for _, link := range []Link{
+		{Shortcode: "mine", URL: "https://dest.example/mine", Owner: alice.Username},
+		{Shortcode: "theirs", URL: "https://dest.example/theirs", Owner: bob.Username},
+	} {
+		if err := lf.saveLink(ctx, link, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	asAlice := func(r *http.Request) { r.SetBasicAuth("alice", "password-alice") }
+
+	w := serve(lf, "DELETE", "/api/v1/links?shortcodes=mine,theirs", "", asAlice)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("deleting another user's link: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, err := lf.getLink(ctx, "", "mine"); err != nil {
+		t.Errorf("mine: %v, want it kept when the batch failed", err)
+	}
+
+	w = serve(lf, "DELETE", "/api/v1/links?shortcodes=mine", "", asAlice)
+	if w.Code != http.StatusOK {
+		t.Errorf("deleting their own link: status %d: %s", w.Code, w.Body)
+	}
+	if _, err := lf.getLink(ctx, "", "mine"); !errors.Is(err, errLinkNotFound) {
+		t.Errorf("mine: %v, want it deleted", err)
+	}
+}
@@ -0,0 +1,187 @@
+package lnk
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// bitlyTimeFormat is how Bitly's API writes times.
+const bitlyTimeFormat = "2006-01-02T15:04:05-0700"
+
+// Bitlink is a link as Bitly's v4 API describes it, for the tools that
+// only speak Bitly. Its ID is the short URL without the scheme.
+type Bitlink struct {
+	CreatedAt      string            `json:"created_at"`
+	ID             string            `json:"id"`
+	Link           string            `json:"link"`
+	CustomBitlinks []string          `json:"custom_bitlinks"`
+	LongURL        string            `json:"long_url"`
+	Title          string            `json:"title,omitempty"`
+	Archived       bool              `json:"archived"`
+	Tags           []string          `json:"tags"`
+	Deeplinks      []string          `json:"deeplinks"`
+	References     map[string]string `json:"references"`
+}
+
+// bitlyError is an error in the shape of Bitly's: an upper-case code in
+// message, and what went wrong in description.
+type bitlyError struct {
+	Message     string `json:"message"`
+	Description string `json:"description"`
+	Resource    string `json:"resource"`
+}
+
+func writeBitlyError(w http.ResponseWriter, status int, description string) {
+	codes := map[int]string{
+		http.StatusBadRequest:          "INVALID_ARG",
+		http.StatusForbidden:           "FORBIDDEN",
+		http.StatusNotFound:            "NOT_FOUND",
+		http.StatusConflict:            "ALREADY_A_BITLINK",
+		http.StatusServiceUnavailable:  "TEMPORARILY_UNAVAILABLE",
+		http.StatusInternalServerError: "INTERNAL_ERROR",
+	}
+	message, ok := codes[status]
+	if !ok {
+		message = strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	writeBitlyJSON(w, status, bitlyError{Message: message, Description: description, Resource: "bitlinks"})
+}
+
+// writeBitlyJSON answers in Bitly's shapes, which aren't a Response.
+func writeBitlyJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newBitlink describes link the way Bitly would.
+func (lf *LinkForwarder) newBitlink(r *http.Request, link Link) Bitlink {
+	short := lf.shortURL(r, link)
+	b := Bitlink{
+		ID:             strings.TrimPrefix(strings.TrimPrefix(short, "https://"), "http://"),
+		Link:           short,
+		CustomBitlinks: []string{},
+		LongURL:        link.URL,
+		Title:          link.Title,
+		Archived:       link.ArchivedAt != nil,
+		Tags:           link.Tags,
+		Deeplinks:      []string{},
+		References:     map[string]string{},
+	}
+	if b.Tags == nil {
+		b.Tags = []string{}
+	}
+	if link.CreatedAt != nil {
+		b.CreatedAt = link.CreatedAt.UTC().Format(bitlyTimeFormat)
+	} else {
+		b.CreatedAt = lf.now().UTC().Format(bitlyTimeFormat)
+	}
+	return b
+}
+
+// bitlyDomain picks the namespace for a Bitly request's domain: one of
+// CUSTOM_DOMAINS, or the request's own. Tools send bit.ly by default, which
+// just means the usual one.
+func (lf *LinkForwarder) bitlyDomain(r *http.Request, domain string) *http.Request {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if !lf.customDomains[domain] {
+		return r
+	}
+	r2 := r.Clone(r.Context())
+	q := r2.URL.Query()
+	q.Set("domain", domain)
+	r2.URL.RawQuery = q.Encode()
+	return r2
+}
+
+// handleBitlyShorten is Bitly's POST /v4/shorten: it returns the link for
+// long_url, created with a generated shortcode if there isn't one yet, as
+// /api/v1/shorten does. Bitly answers 201 for a new link and 200 for an
+// existing one.
+func (lf *LinkForwarder) handleBitlyShorten(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		LongURL string `json:"long_url"`
+		Domain  string `json:"domain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBitlyError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.LongURL == "" {
+		writeBitlyError(w, http.StatusBadRequest, "long_url is required")
+		return
+	}
+	r = lf.bitlyDomain(r, req.Domain)
+
+	link, created, apiErr := lf.shortenRequest(r, req.LongURL, "")
+	if apiErr != nil {
+		writeBitlyError(w, apiErr.status, apiErr.message)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		lf.logf(r, "%s created %s -> %s through the Bitly API", lf.requestActor(r), link.Shortcode, link.URL)
+		// The saved link has its creation time
+		if saved, err := lf.getLink(r.Context(), link.Domain, link.Shortcode); err == nil {
+			link = saved
+		}
+	}
+	writeBitlyJSON(w, status, lf.newBitlink(r, link))
+}
+
+// bitlinkLink looks up the link a Bitlink ID, such as lnk.example.com/docs,
+// names. The host picks the custom domain, if it's one.
+func (lf *LinkForwarder) bitlinkLink(r *http.Request, id string) (Link, error) {
+	id = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(id), "https://"), "http://")
+	id = strings.TrimRight(id, "/")
+	host, _, _ := strings.Cut(id, "/")
+	shortcode := id[strings.LastIndex(id, "/")+1:]
+	if unescaped, err := url.PathUnescape(shortcode); err == nil {
+		shortcode = unescaped
+	}
+	if shortcode == "" || shortcode == id {
+		return Link{}, errLinkNotFound
+	}
+	return lf.getLinkOrAlias(r.Context(), lf.hostDomain(host), lf.rules.normalize(shortcode))
+}
+
+// writeBitlink answers with the link a Bitlink ID names.
+func (lf *LinkForwarder) writeBitlink(w http.ResponseWriter, r *http.Request, id string) {
+	link, err := lf.bitlinkLink(r, id)
+	if errors.Is(err, errLinkNotFound) {
+		writeBitlyError(w, http.StatusNotFound, "No link has this Bitlink")
+		return
+	} else if err != nil {
+		writeBitlyError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+	writeBitlyJSON(w, http.StatusOK, lf.newBitlink(r, link))
+}
+
+// handleBitlyExpand is Bitly's POST /v4/expand, which looks up the link
+// for a bitlink_id.
+func (lf *LinkForwarder) handleBitlyExpand(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BitlinkID string `json:"bitlink_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBitlyError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.BitlinkID == "" {
+		writeBitlyError(w, http.StatusBadRequest, "bitlink_id is required")
+		return
+	}
+	lf.writeBitlink(w, r, req.BitlinkID)
+}
+
+// handleBitlink is Bitly's GET /v4/bitlinks/{bitlink}.
+func (lf *LinkForwarder) handleBitlink(w http.ResponseWriter, r *http.Request) {
+	lf.writeBitlink(w, r, mux.Vars(r)["bitlink"])
+}
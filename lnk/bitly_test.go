@@ -0,0 +1,109 @@
+package lnk
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestBitlyAPI checks that a tool speaking Bitly's v4 API can shorten a URL
+// and look the Bitlink up again, with Bitly's status codes and shapes.
+func TestBitlyAPI(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{
+		"ADMIN_PASSWORD": "admin-password",
+		"BITLY_API":      "true",
+		"PUBLIC_URL":     "https://go.example",
+	})
+	alice := testUser(t, lf, "alice", roleUser)
+	token := testToken(t, lf, alice, scopeCreate, scopeRead)
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	decode := func(t *testing.T, body []byte, v any) {
+		t.Helper()
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+	}
+
+	w := serve(lf, "POST", "/v4/shorten", `{"long_url":"https://dest.example/page","domain":"bit.ly"}`, bearer)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("shorten: status %d: %s", w.Code, w.Body)
+	}
+	var created Bitlink
+	decode(t, w.Body.Bytes(), &created)
+	shortcode := strings.TrimPrefix(created.ID, "go.example/")
+	if shortcode == created.ID || created.Link != "https://"+created.ID || created.LongURL != "https://dest.example/page" || created.CreatedAt == "" {
+		t.Errorf("shorten returned %+v, want a Bitlink on go.example for the URL", created)
+	}
+	if created.Tags == nil || created.CustomBitlinks == nil || created.Deeplinks == nil || created.References == nil {
+		t.Errorf("shorten returned %+v, want empty lists rather than nulls", created)
+	}
+
+	w = serve(lf, "POST", "/v4/shorten", `{"long_url":"https://dest.example/page"}`, bearer)
+	var again Bitlink
+	decode(t, w.Body.Bytes(), &again)
+	if w.Code != http.StatusOK || again.ID != created.ID {
+		t.Errorf("shortening the URL again: status %d with %q, want %d with %q", w.Code, again.ID, http.StatusOK, created.ID)
+	}
+
+	for _, tt := range []struct {
+		method, target, body string
+	}{
+		{"POST", "/v4/expand", `{"bitlink_id":"` + created.ID + `"}`},
+		{"POST", "/v4/expand", `{"bitlink_id":"https://` + created.ID + `/"}`},
+		{"GET", "/v4/bitlinks/" + created.ID, ""},
+	} {
+		w := serve(lf, tt.method, tt.target, tt.body, bearer)
+		var got Bitlink
+		decode(t, w.Body.Bytes(), &got)
+		if w.Code != http.StatusOK || got.ID != created.ID || got.LongURL != "https://dest.example/page" {
+			t.Errorf("%s %s %s: status %d with %+v, want the Bitlink", tt.method, tt.target, tt.body, w.Code, got)
+		}
+	}
+	if w := serve(lf, "GET", "/"+shortcode, "", nil); w.Header().Get("Location") != "https://dest.example/page" {
+		t.Errorf("following the Bitlink went to %q", w.Header().Get("Location"))
+	}
+}
+
+// TestBitlyAPIErrors checks that failures are answered in Bitly's error
+// shape, and that the usual authentication and scopes apply.
+func TestBitlyAPIErrors(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password", "BITLY_API": "true"})
+	alice := testUser(t, lf, "alice", roleUser)
+	full := testToken(t, lf, alice, scopeCreate, scopeRead)
+	readOnly := testToken(t, lf, alice, scopeRead)
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		edit         func(r *http.Request)
+		status       int
+		message      string
+	}{
+		{"no long_url", "POST", "/v4/shorten", `{}`, bearer(full), http.StatusBadRequest, "INVALID_ARG"},
+		{"bad long_url", "POST", "/v4/shorten", `{"long_url":"javascript:alert(1)"}`, bearer(full), http.StatusBadRequest, "INVALID_ARG"},
+		{"bad JSON", "POST", "/v4/expand", `{`, bearer(full), http.StatusBadRequest, "INVALID_ARG"},
+		{"unknown Bitlink", "POST", "/v4/expand", `{"bitlink_id":"example.com/missing"}`, bearer(full), http.StatusNotFound, "NOT_FOUND"},
+		{"no shortcode", "GET", "/v4/bitlinks/example.com", "", bearer(full), http.StatusNotFound, "NOT_FOUND"},
+		{"read-only token", "POST", "/v4/shorten", `{"long_url":"https://dest.example/"}`, bearer(readOnly), http.StatusForbidden, ""},
+		{"no credentials", "POST", "/v4/shorten", `{"long_url":"https://dest.example/"}`, nil, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		w := serve(lf, tt.method, tt.path, tt.body, tt.edit)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.message == "" {
+			continue
+		}
+		var got bitlyError
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Message != tt.message || got.Description == "" {
+			t.Errorf("%s: error %s, want message %s with a description", tt.name, w.Body, tt.message)
+		}
+	}
+}
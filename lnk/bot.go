@@ -0,0 +1,175 @@
+package lnk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// botHelp lists the commands the chat bots understand.
+const botHelp = `Commands:
+/shorten <url> [shortcode] - create a short link
+/link <shortcode> - show where a link goes and how often it was clicked
+/qr <shortcode> - get a QR code for a link
+/help - show this message`
+
+// chatUsers maps the chat users allowed to use a bot, by numeric ID or
+// lowercased @username, to the lnk account each one acts as ("" for none).
+type chatUsers map[string]string
+
+// loadChatUsers parses the comma-separated list in the variable name, where
+// each entry is a user ID or @username, optionally followed by :account.
+func loadChatUsers(getenv func(string) string, name string) (chatUsers, error) {
+	users := chatUsers{}
+	for _, entry := range splitList(getenv(name)) {
+		id, account, _ := strings.Cut(entry, ":")
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" || id == "@" {
+			return nil, fmt.Errorf("invalid %s entry %q", name, entry)
+		}
+		users[id] = strings.TrimSpace(account)
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s must list who may use the bot", name)
+	}
+	return users, nil
+}
+
+// lookup returns the account of the chat user with the given ID and
+// username, and whether they may use the bot at all.
+func (u chatUsers) lookup(id, username string) (string, bool) {
+	if account, ok := u[id]; ok {
+		return account, true
+	}
+	if username == "" {
+		return "", false
+	}
+	account, ok := u["@"+strings.ToLower(username)]
+	return account, ok
+}
+
+// loadPublicURL reads PUBLIC_URL, the origin visitors reach the forwarder
+// at. Bots need it to hand out short links, since they have no request to
+// take the host from.
+func loadPublicURL(getenv func(string) string) (*url.URL, error) {
+	raw := getenv("PUBLIC_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid PUBLIC_URL %q: must be an origin such as https://go.example.com", raw)
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// botReply is a bot's answer to a command: text, plus a QR code image
+// captioned with it for /qr.
+type botReply struct {
+	text string
+	qr   []byte
+}
+
+// botCommand runs a chat command for an allowed chat user, acting as the
+// lnk account they map to. actor identifies them in link history.
+func (lf *LinkForwarder) botCommand(ctx context.Context, account, actor, text string) botReply {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return botReply{text: botHelp}
+	}
+	// Telegram addresses commands in groups as /command@botname
+	command, _, _ := strings.Cut(strings.TrimPrefix(strings.ToLower(fields[0]), "/"), "@")
+	args := fields[1:]
+
+	var by editor
+	if account != "" {
+		var err error
+		if by.user, err = lf.getUser(ctx, account); err != nil {
+			lf.logger.Printf("Bot user %s maps to account %s: %v", actor, account, err)
+			return botReply{text: "Your chat account is linked to an lnk account that doesn't exist."}
+		}
+	} else if enabled, err := lf.authEnabled(ctx); err != nil || enabled {
+		return botReply{text: "Your chat account isn't linked to an lnk account."}
+	}
+
+	switch command {
+	case "shorten":
+		return lf.botShorten(ctx, by, args, actor)
+	case "link":
+		return lf.botLink(ctx, args)
+	case "qr":
+		return lf.botQR(ctx, args)
+	case "help", "start":
+		return botReply{text: botHelp}
+	}
+	return botReply{text: fmt.Sprintf("Unknown command %q.\n\n%s", command, botHelp)}
+}
+
+// botShorten handles /shorten <url> [shortcode] like /api/v1/shorten
+// would at PUBLIC_URL, with the same checks for by: an existing link is
+// returned rather than replaced.
+func (lf *LinkForwarder) botShorten(ctx context.Context, by editor, args []string, actor string) botReply {
+	if len(args) == 0 || len(args) > 2 {
+		return botReply{text: "Usage: /shorten <url> [shortcode]"}
+	}
+	var shortcode string
+	if len(args) == 2 {
+		shortcode = args[1]
+	}
+	host := lf.publicURL.Host
+	want := Link{Domain: lf.hostDomain(host), Shortcode: shortcode, URL: args[0]}
+	link, created, apiErr := lf.shorten(ctx, by, host, want, actor)
+	if apiErr != nil {
+		return botReply{text: apiErr.message}
+	}
+	if created {
+		lf.logger.Printf("%s created %s -> %s from chat", actor, link.Shortcode, link.URL)
+	}
+	return botReply{text: lf.publicShortURL(link)}
+}
+
+// botFind looks up the link named by the only argument of command.
+func (lf *LinkForwarder) botFind(ctx context.Context, args []string, command string) (Link, string) {
+	if len(args) != 1 {
+		return Link{}, fmt.Sprintf("Usage: /%s <shortcode>", command)
+	}
+	shortcode := lf.rules.normalize(args[0])
+	link, err := lf.getLinkOrAlias(ctx, lf.hostDomain(lf.publicURL.Host), shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		return link, fmt.Sprintf("'%s' doesn't exist.", shortcode)
+	} else if err != nil {
+		lf.logger.Printf("Failed to look up %s: %v", shortcode, err)
+		return link, "Failed to look up link."
+	}
+	return link, ""
+}
+
+// botLink describes a link for /link <shortcode>.
+func (lf *LinkForwarder) botLink(ctx context.Context, args []string) botReply {
+	link, problem := lf.botFind(ctx, args, "link")
+	if problem != "" {
+		return botReply{text: problem}
+	}
+	text := lf.publicShortURL(link) + " → " + link.URL
+	if link.Title != "" {
+		text = link.Title + "\n" + text
+	}
+	return botReply{text: fmt.Sprintf("%s\nClicks: %d", text, link.Clicks)}
+}
+
+// botQR renders the QR code of a link for /qr <shortcode>.
+func (lf *LinkForwarder) botQR(ctx context.Context, args []string) botReply {
+	link, problem := lf.botFind(ctx, args, "qr")
+	if problem != "" {
+		return botReply{text: problem}
+	}
+	shortURL := lf.publicShortURL(link)
+	png, err := qrPNG(shortURL)
+	if err != nil {
+		lf.logger.Printf("Failed to render QR code for %s: %v", link.Shortcode, err)
+		return botReply{text: "Failed to render QR code."}
+	}
+	return botReply{text: shortURL, qr: png}
+}
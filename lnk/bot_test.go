@@ -0,0 +1,25 @@
+package lnk
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestBotCommands checks that chat commands make and describe links at
+// PUBLIC_URL, refusing links that would redirect back to it as the API
+// does.
+func TestBotCommands(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"PUBLIC_URL": "https://go.example"})
+	ctx := context.Background()
+
+	if reply := lf.botCommand(ctx, "", "telegram:@alice", "/shorten https://dest.example/docs docs"); reply.text != "https://go.example/docs" {
+		t.Errorf("/shorten replied %q, want the short link", reply.text)
+	}
+	if reply := lf.botCommand(ctx, "", "telegram:@alice", "/link docs"); !strings.Contains(reply.text, "https://go.example/docs → https://dest.example/docs") {
+		t.Errorf("/link replied %q", reply.text)
+	}
+	if reply := lf.botCommand(ctx, "", "telegram:@alice", "/shorten https://go.example/self self"); !strings.Contains(reply.text, "redirect loop") {
+		t.Errorf("/shorten of a link to itself replied %q, want a redirect loop error", reply.text)
+	}
+}
@@ -0,0 +1,161 @@
+package lnk
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultLinkCacheSize = 1000
+
+// linkCache keeps recently followed links in memory so popular shortcodes
+// don't cost a database query per redirect. Any write to links or aliases
+// purges it through invalidateLinks; writes are rare next to redirects, and
+// a purge can't miss an alias or domain that points at the changed link.
+type linkCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used at the front
+	entries map[string]*list.Element
+	// generation changes on every purge so a lookup that raced a write
+	// doesn't store what it read before the write
+	generation uint64
+}
+
+type linkCacheEntry struct {
+	key  string
+	link Link
+}
+
+// loadLinkCache reads LINK_CACHE_SIZE, the number of links to keep in
+// memory. Zero turns the cache off.
+func loadLinkCache(getenv func(string) string) (*linkCache, error) {
+	size := defaultLinkCacheSize
+	if v := getenv("LINK_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid LINK_CACHE_SIZE %q: must be a non-negative integer", v)
+		}
+		size = n
+	}
+	return &linkCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+func linkCacheKey(domain, code string) string {
+	return domain + "/" + code
+}
+
+// get returns the cached link for key and the current generation, to be
+// passed back to add.
+func (c *linkCache) get(key string) (Link, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*linkCacheEntry).link, c.generation, true
+	}
+	return Link{}, c.generation, false
+}
+
+// add stores a link read from the database, unless the cache was purged
+// since generation was handed out.
+func (c *linkCache) add(key string, link Link, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 || generation != c.generation {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*linkCacheEntry).link = link
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&linkCacheEntry{key: key, link: link})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*linkCacheEntry).key)
+	}
+}
+
+// purge empties the cache.
+func (c *linkCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// lookupLink finds the link a visitor asked for by shortcode or alias,
+// from the cache when possible. Links with a click limit are always read
+// fresh so their count is current.
+func (lf *LinkForwarder) lookupLink(ctx context.Context, domain, code string) (Link, error) {
+	key := linkCacheKey(domain, code)
+	link, generation, ok := lf.cache.get(key)
+	if ok {
+		return link, nil
+	}
+
+	var redisGeneration int64
+	if lf.redis != nil {
+		if link, redisGeneration, ok = lf.redis.get(ctx, domain, code); ok {
+			lf.cache.add(key, link, generation)
+			return link, nil
+		}
+	}
+
+	link, err := lf.getLinkOrAlias(ctx, domain, code)
+	if err != nil {
+		return link, err
+	}
+	if link.MaxClicks == 0 {
+		lf.cache.add(key, link, generation)
+		if lf.redis != nil {
+			lf.redis.add(ctx, domain, code, link, redisGeneration)
+		}
+	}
+	return link, nil
+}
+
+// invalidateLinks drops every cached link after a write to links or
+// aliases, on this replica and, with Redis, on all of them.
+func (lf *LinkForwarder) invalidateLinks() {
+	lf.cache.purge()
+	if lf.redis != nil {
+		// Not the request's context: the write is already committed, and a
+		// client hanging up mustn't leave other replicas serving the old link
+		lf.redis.purge(context.Background())
+	}
+}
+
+// rulesChanged tells the other replicas, with Redis, to reload the domain
+// and redirect rules after this one changed them.
+func (lf *LinkForwarder) rulesChanged() {
+	if lf.redis != nil {
+		lf.redis.rulesChanged(context.Background())
+	}
+}
+
+// reloadRules rereads the domain and redirect rules after another replica
+// changed them.
+func (lf *LinkForwarder) reloadRules() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := lf.loadDomainRules(ctx); err != nil {
+		lf.logger.Printf("Failed to reload domain rules: %v", err)
+	}
+	if err := lf.loadRedirectRules(ctx); err != nil {
+		lf.logger.Printf("Failed to reload redirect rules: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package lnk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CDN_PURGE providers.
+const (
+	cdnCloudflare = "cloudflare"
+	cdnFastly     = "fastly"
+)
+
+const (
+	defaultCloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+	defaultFastlyAPIURL     = "https://api.fastly.com"
+	cdnTimeout              = 30 * time.Second
+	cdnPurgeQueueSize       = 1000
+	cdnPurgeAttempts        = 3
+	cdnPurgeRetry           = 5 * time.Second
+	cloudflarePurgeBatch    = 30 // the most URLs Cloudflare purges in one call
+)
+
+// cdnPurger removes short URLs from a CDN's cache, so a redirect the CDN
+// kept doesn't outlive a change to its link.
+type cdnPurger interface {
+	purge(ctx context.Context, urls []string) error
+}
+
+// cdnPurge is a link whose short URLs are waiting to be purged, along with
+// aliases it had that are gone from the database, as when it's deleted.
+type cdnPurge struct {
+	domain    string
+	shortcode string
+	aliases   []string
+}
+
+// loadCDNPurge reads CDN_PURGE and the provider's credentials, returning
+// nil when CDN_PURGE isn't set. CDN_PURGE_API_URL points at another API
+// endpoint, such as a proxy.
+func loadCDNPurge(getenv func(string) string) (cdnPurger, error) {
+	provider := strings.ToLower(getenv("CDN_PURGE"))
+	api := strings.TrimSuffix(getenv("CDN_PURGE_API_URL"), "/")
+	client := &http.Client{Timeout: cdnTimeout}
+	switch provider {
+	case "":
+		return nil, nil
+	case cdnCloudflare:
+		zone, token := getenv("CLOUDFLARE_ZONE_ID"), getenv("CLOUDFLARE_API_TOKEN")
+		if zone == "" || token == "" {
+			return nil, fmt.Errorf("CLOUDFLARE_ZONE_ID and CLOUDFLARE_API_TOKEN are required with CDN_PURGE=cloudflare")
+		}
+		if api == "" {
+			api = defaultCloudflareAPIURL
+		}
+		return &cloudflarePurger{endpoint: api + "/zones/" + url.PathEscape(zone) + "/purge_cache", token: token, client: client}, nil
+	case cdnFastly:
+		token := getenv("FASTLY_API_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("FASTLY_API_TOKEN is required with CDN_PURGE=fastly")
+		}
+		soft := false
+		if v := getenv("FASTLY_SOFT_PURGE"); v != "" {
+			var err error
+			if soft, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid FASTLY_SOFT_PURGE %q: must be true or false", v)
+			}
+		}
+		if api == "" {
+			api = defaultFastlyAPIURL
+		}
+		return &fastlyPurger{api: api, token: token, soft: soft, client: client}, nil
+	default:
+		return nil, fmt.Errorf("invalid CDN_PURGE %q: must be cloudflare, fastly, or empty", provider)
+	}
+}
+
+// purgeCDN queues the short URLs of a link that was created, changed, or
+// deleted to be purged from the CDN, if there is one. The link's aliases
+// are looked up when the purge runs; deleted is the aliases a deleted link
+// had. When purges have been failing long enough to fill the queue, more
+// are dropped rather than holding up the change.
+func (lf *LinkForwarder) purgeCDN(domain, shortcode string, deleted ...string) {
+	if lf.cdnQueue == nil {
+		return
+	}
+	select {
+	case lf.cdnQueue <- cdnPurge{domain: domain, shortcode: shortcode, aliases: deleted}:
+	default:
+		lf.logger.Printf("CDN purge queue is full; /%s was not purged", shortcode)
+	}
+}
+
+// cdnURLs returns the short URLs the CDN may have cached for p: the
+// shortcode's and its aliases', on PUBLIC_URL or the link's custom domain.
+func (lf *LinkForwarder) cdnURLs(ctx context.Context, p cdnPurge) ([]string, error) {
+	shortcodes := append([]string{p.shortcode}, p.aliases...)
+	rows, err := lf.db.QueryContext(ctx, `SELECT alias FROM aliases WHERE domain = ? AND shortcode = ?`, p.domain, p.shortcode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		shortcodes = append(shortcodes, alias)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	host := lf.publicURL.Host
+	if p.domain != "" {
+		host = p.domain
+	}
+	urls := make([]string, len(shortcodes))
+	for i, shortcode := range shortcodes {
+		urls[i] = lf.publicURL.Scheme + "://" + host + lf.appPath("/"+url.PathEscape(shortcode))
+	}
+	return urls, nil
+}
+
+// runCDNPurge purges queued links until ctx is cancelled. A purge that
+// keeps failing is given up after a few attempts, since the CDN then
+// serves the old redirect only until it expires.
+func (lf *LinkForwarder) runCDNPurge(ctx context.Context) {
+	var pending []cdnPurge
+	defer func() { lf.flushCDNPurges(pending) }()
+	for {
+		var p cdnPurge
+		select {
+		case <-ctx.Done():
+			return
+		case p = <-lf.cdnQueue:
+		}
+		pending = []cdnPurge{p}
+
+		urls, err := lf.cdnURLs(ctx, p)
+		for attempt := 1; err == nil; attempt++ {
+			if err = lf.cdn.purge(ctx, urls); err == nil || attempt == cdnPurgeAttempts || ctx.Err() != nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cdnPurgeRetry):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		pending = nil
+		if err != nil {
+			lf.logger.Printf("Failed to purge /%s from the CDN: %v", p.shortcode, err)
+		}
+	}
+}
+
+// flushCDNPurges makes one last attempt at the purges still queued when
+// the forwarder closes, along with pending, within closeTimeout.
+func (lf *LinkForwarder) flushCDNPurges(pending []cdnPurge) {
+	for len(lf.cdnQueue) > 0 {
+		pending = append(pending, <-lf.cdnQueue)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	for _, p := range pending {
+		urls, err := lf.cdnURLs(ctx, p)
+		if err == nil {
+			err = lf.cdn.purge(ctx, urls)
+		}
+		if err != nil {
+			lf.logger.Printf("Failed to purge /%s from the CDN: %v", p.shortcode, err)
+		}
+	}
+}
+
+// cloudflarePurger purges URLs through Cloudflare's API, with a token
+// allowed to purge the zone's cache.
+type cloudflarePurger struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (p *cloudflarePurger) purge(ctx context.Context, urls []string) error {
+	for start := 0; start < len(urls); start += cloudflarePurgeBatch {
+		body, err := json.Marshal(map[string][]string{"files": urls[start:min(start+cloudflarePurgeBatch, len(urls))]})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.token)
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Success bool `json:"success"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		if json.Unmarshal(data, &result) != nil {
+			return fmt.Errorf("unexpected response from Cloudflare: %s", resp.Status)
+		}
+		if !result.Success {
+			if len(result.Errors) > 0 {
+				return fmt.Errorf("Cloudflare: %s", result.Errors[0].Message)
+			}
+			return fmt.Errorf("Cloudflare refused the purge: %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// fastlyPurger purges URLs through Fastly's API, one at a time as Fastly
+// purges them, with a token allowed to purge the service. A soft purge
+// marks the redirects stale instead of dropping them.
+type fastlyPurger struct {
+	api    string
+	token  string
+	soft   bool
+	client *http.Client
+}
+
+func (p *fastlyPurger) purge(ctx context.Context, urls []string) error {
+	for _, u := range urls {
+		target := strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.api+"/purge/"+target, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Fastly-Key", p.token)
+		req.Header.Set("Accept", "application/json")
+		if p.soft {
+			req.Header.Set("Fastly-Soft-Purge", "1")
+		}
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode/100 != 2 {
+			var e struct {
+				Msg string `json:"msg"`
+			}
+			if json.Unmarshal(data, &e) == nil && e.Msg != "" {
+				return fmt.Errorf("Fastly: %s", e.Msg)
+			}
+			return fmt.Errorf("unexpected response from Fastly: %s", resp.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package lnk
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// exhausted reports whether a link has used up its clicks.
+func (l Link) exhausted() bool {
+	return l.MaxClicks > 0 && l.Clicks >= l.MaxClicks
+}
+
+// normalizeClickLimit validates max_clicks and applies the one_time shorthand.
+func normalizeClickLimit(link *Link) error {
+	if link.MaxClicks < 0 {
+		return fmt.Errorf("max_clicks must not be negative")
+	}
+	if link.OneTime {
+		if link.MaxClicks > 1 {
+			return fmt.Errorf("one_time links can't set max_clicks above 1")
+		}
+		link.MaxClicks = 1
+	}
+	link.OneTime = link.MaxClicks == 1
+	return nil
+}
+
+// UnavailableData is passed to the unavailable.html template.
+type UnavailableData struct {
+	Shortcode string
+	Heading   string
+	Message   string
+}
+
+// renderClickLimitReached tells a visitor a link has used up its clicks,
+// either by sending them to CLICK_LIMIT_URL or with a 410 Gone page.
+func (lf *LinkForwarder) renderClickLimitReached(w http.ResponseWriter, r *http.Request, link Link) {
+	lf.logf(r, "Link %s has reached its limit of %d clicks", link.Shortcode, link.MaxClicks)
+	if lf.clickLimitURL != "" {
+		http.Redirect(w, r, lf.clickLimitURL, http.StatusFound)
+		return
+	}
+	lf.renderUnavailable(w, http.StatusGone, UnavailableData{
+		Shortcode: link.Shortcode,
+		Heading:   "This link has expired",
+		Message:   "It could only be used a limited number of times and is no longer available.",
+	})
+}
+
+// renderUnavailable shows a page explaining why a link can't be followed.
+func (lf *LinkForwarder) renderUnavailable(w http.ResponseWriter, status int, data UnavailableData) {
+	tmpl, err := lf.loadTemplate("unavailable.html")
+	if err != nil {
+		http.Error(w, data.Heading, status)
+		lf.logger.Printf("Template error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logger.Printf("Template execution error: %v", err)
+	}
+}
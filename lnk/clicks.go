@@ -0,0 +1,117 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// LinkStats summarizes the recorded clicks on a link.
+type LinkStats struct {
+	Domain    string           `json:"domain,omitempty"`
+	Shortcode string           `json:"shortcode"`
+	Clicks    int              `json:"clicks"`
+	Variants  map[string]int   `json:"variants,omitempty"`
+	Referrers []ReferrerClicks `json:"referrers,omitempty"` // the top sites, most clicks first
+}
+
+// recordClick counts a visit to a link, notes when it was last used, and,
+// with logged set, logs which variant was served and the site it came
+// from.
+// It returns false without counting if the link has already reached its
+// click limit; the check and increment happen in one statement so
+// concurrent visitors can't exceed the limit.
+func (lf *LinkForwarder) recordClick(ctx context.Context, link Link, variant, referrer string, logged bool) (bool, error) {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE links SET click_count = click_count + 1, last_accessed_at = ?
+		WHERE domain = ? AND shortcode = ? AND (max_clicks = 0 OR click_count < max_clicks)`,
+		lf.now().UTC(), link.Domain, link.Shortcode)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if !logged {
+		return true, tx.Commit()
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO clicks (domain, shortcode, variant, referrer) VALUES (?, ?, ?, ?)`,
+		link.Domain, link.Shortcode, variant, referrer); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// getStats returns the click totals for a link.
+func (lf *LinkForwarder) getStats(ctx context.Context, domain, shortcode string) (LinkStats, error) {
+	stats := LinkStats{Domain: domain, Shortcode: shortcode}
+	err := lf.db.QueryRowContext(ctx, `SELECT click_count FROM links WHERE domain = ? AND shortcode = ?`,
+		domain, shortcode).Scan(&stats.Clicks)
+	if err == sql.ErrNoRows {
+		return stats, errLinkNotFound
+	} else if err != nil {
+		return stats, err
+	}
+
+	rows, err := lf.db.QueryContext(ctx, `WITH `+clickCountsSQL+` SELECT variant, SUM(clicks) FROM click_counts
+		WHERE domain = ? AND shortcode = ? AND variant != '' GROUP BY variant ORDER BY variant`, domain, shortcode)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant string
+		var count int
+		if err := rows.Scan(&variant, &count); err != nil {
+			return stats, err
+		}
+		if stats.Variants == nil {
+			stats.Variants = map[string]int{}
+		}
+		stats.Variants[variant] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	stats.Referrers, err = lf.topReferrers(ctx, domain, shortcode, "", statsTopN)
+	return stats, err
+}
+
+func (lf *LinkForwarder) handleStats(w http.ResponseWriter, r *http.Request) {
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stats, err := lf.getStats(r.Context(), domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve stats")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Stats retrieved successfully",
+		Data:    stats,
+	})
+}
@@ -0,0 +1,209 @@
+package lnk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CLICK_STREAM brokers.
+const (
+	clickStreamNATS  = "nats"
+	clickStreamKafka = "kafka"
+)
+
+const (
+	defaultClickTopic = "lnk.clicks"
+	clickEventSchema  = "lnk.click.v1"
+	clickQueueSize    = 10000
+	clickBatchSize    = 500 // clicks sent to the broker at once
+	clickStreamRetry  = 5 * time.Second
+)
+
+// ClickEvent is what's published to the click stream for each logged
+// click. Fields are only ever added to a schema; a change in meaning gets
+// a new one.
+type ClickEvent struct {
+	Schema    string    `json:"schema"` // lnk.click.v1
+	ID        string    `json:"id"`     // unique, for dropping a click delivered twice
+	Time      time.Time `json:"time"`
+	Domain    string    `json:"domain,omitempty"`
+	Shortcode string    `json:"shortcode"`
+	URL       string    `json:"url"` // where the visitor was sent
+	Variant   string    `json:"variant,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"` // hostname
+	Device    string    `json:"device,omitempty"`   // ios, android, or desktop
+	Country   string    `json:"country,omitempty"`  // ISO code, with GEOIP_DB
+}
+
+// clickPublisher delivers clicks to a message broker. Publishing returns
+// once the broker has them; on an error the whole batch is tried again.
+type clickPublisher interface {
+	publish(ctx context.Context, events []ClickEvent) error
+	close()
+}
+
+// loadClickStream reads CLICK_STREAM, CLICK_STREAM_URL, and
+// CLICK_STREAM_TOPIC, returning nil when CLICK_STREAM isn't set. Nothing
+// connects until the first click, so a broker that's down doesn't keep
+// links from redirecting.
+func loadClickStream(getenv func(string) string) (clickPublisher, error) {
+	broker := strings.ToLower(getenv("CLICK_STREAM"))
+	if broker == "" {
+		return nil, nil
+	}
+	topic := getenv("CLICK_STREAM_TOPIC")
+	if topic == "" {
+		topic = defaultClickTopic
+	}
+	rawURL := getenv("CLICK_STREAM_URL")
+	if rawURL == "" {
+		return nil, fmt.Errorf("CLICK_STREAM_URL is required with CLICK_STREAM")
+	}
+	u, err := url.Parse(rawURL)
+
+	switch broker {
+	case clickStreamNATS:
+		if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			return nil, fmt.Errorf("invalid CLICK_STREAM_URL %q: must be a NATS URL such as nats://nats.example.com:4222", rawURL)
+		}
+		if strings.ContainsAny(topic, " \t\r\n") {
+			return nil, fmt.Errorf("invalid CLICK_STREAM_TOPIC %q: must be a NATS subject without spaces", topic)
+		}
+		return newNATSPublisher(u, topic), nil
+	case clickStreamKafka:
+		if err != nil || (u.Scheme != "kafka" && u.Scheme != "kafka+tls") || u.Host == "" {
+			return nil, fmt.Errorf("invalid CLICK_STREAM_URL %q: must list the Kafka brokers, such as kafka://broker1:9092,broker2:9092", rawURL)
+		}
+		return newKafkaPublisher(u, topic), nil
+	case "kafka-rest":
+		return nil, fmt.Errorf("invalid CLICK_STREAM %q: Kafka is reached directly now, with CLICK_STREAM=kafka and the brokers in CLICK_STREAM_URL", broker)
+	default:
+		return nil, fmt.Errorf("invalid CLICK_STREAM %q: must be nats, kafka, or empty", broker)
+	}
+}
+
+// streamClick queues a logged click for the click stream, if there is
+// one. When the broker has been down long enough to fill the queue, clicks
+// are dropped rather than holding up redirects.
+func (lf *LinkForwarder) streamClick(r *http.Request, link Link, destination, variant, referrer string) {
+	if lf.clickQueue == nil {
+		return
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return
+	}
+	event := ClickEvent{
+		Schema:    clickEventSchema,
+		ID:        hex.EncodeToString(id),
+		Time:      lf.now().UTC(),
+		Domain:    link.Domain,
+		Shortcode: link.Shortcode,
+		URL:       destination,
+		Variant:   variant,
+		Referrer:  referrer,
+		Device:    deviceClass(r.UserAgent()),
+		Country:   lf.country(r),
+	}
+	select {
+	case lf.clickQueue <- event:
+	default:
+		lf.droppedClicks.Add(1)
+	}
+}
+
+// country returns the ISO code of the country a request came from, or ""
+// without GEOIP_DB.
+func (lf *LinkForwarder) country(r *http.Request) string {
+	if lf.geoip == nil {
+		return ""
+	}
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return ""
+	}
+	record, err := lf.geoip.Country(ip)
+	if err != nil {
+		return ""
+	}
+	return record.Country.IsoCode
+}
+
+// runClickStream publishes queued clicks in batches until ctx is
+// cancelled, retrying a batch until the broker takes it.
+func (lf *LinkForwarder) runClickStream(ctx context.Context) {
+	defer lf.clickStream.close()
+	batch := make([]ClickEvent, 0, clickBatchSize)
+	defer func() { lf.flushClicks(batch) }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-lf.clickQueue:
+			batch = append(batch, event)
+		}
+		// Whatever else is waiting goes in the same batch
+	fill:
+		for len(batch) < clickBatchSize {
+			select {
+			case event := <-lf.clickQueue:
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+
+		failing := false
+		for {
+			err := lf.clickStream.publish(ctx, batch)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if !failing {
+				lf.logger.Printf("Click stream publishing failed, retrying every %s: %v", clickStreamRetry, err)
+				failing = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(clickStreamRetry):
+			}
+		}
+		if failing {
+			lf.logger.Printf("Click stream publishing recovered")
+		}
+		if dropped := lf.droppedClicks.Swap(0); dropped > 0 {
+			lf.logger.Printf("Click stream queue was full; %d clicks were not published", dropped)
+		}
+		batch = batch[:0]
+	}
+}
+
+// flushClicks publishes the clicks still queued when the forwarder closes,
+// along with batch, giving the broker up to closeTimeout to take them.
+func (lf *LinkForwarder) flushClicks(batch []ClickEvent) {
+	for len(lf.clickQueue) > 0 {
+		batch = append(batch, <-lf.clickQueue)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	for start := 0; start < len(batch); start += clickBatchSize {
+		if err := lf.clickStream.publish(ctx, batch[start:min(start+clickBatchSize, len(batch))]); err != nil {
+			lf.logger.Printf("Click stream publishing failed on close; %d clicks were not published: %v", len(batch)-start, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lnk
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingPublisher keeps the clicks published to it.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []ClickEvent
+	closed bool
+}
+
+func (p *recordingPublisher) publish(ctx context.Context, events []ClickEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, events...)
+	return nil
+}
+
+func (p *recordingPublisher) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// TestCloseFlushesClicks checks that clicks still queued when the
+// forwarder closes are published rather than lost.
+func TestCloseFlushesClicks(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	p := &recordingPublisher{}
+	lf.clickStream = p
+	lf.clickQueue = make(chan ClickEvent, clickQueueSize)
+	for i := 0; i < clickBatchSize+10; i++ {
+		lf.clickQueue <- ClickEvent{Shortcode: "queued"}
+	}
+	// Cancelled before the worker starts, so everything is left to Close
+	lf.stopBackground()
+	lf.flush(lf.runClickStream)
+	lf.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.events) != clickBatchSize+10 || !p.closed {
+		t.Errorf("published %d clicks (closed: %v), want %d", len(p.events), p.closed, clickBatchSize+10)
+	}
+}
+
+// TestLoadClickStream checks the brokers CLICK_STREAM_URL can name.
+func TestLoadClickStream(t *testing.T) {
+	load := func(env map[string]string) (clickPublisher, error) {
+		return loadClickStream(func(name string) string { return env[name] })
+	}
+	p, err := load(map[string]string{"CLICK_STREAM": "kafka", "CLICK_STREAM_URL": "kafka+tls://user:pw@b1.example,b2.example"})
+	if err != nil {
+		t.Fatalf("kafka: %v", err)
+	}
+	config := p.(*kafkaPublisher).config
+	if strings.Join(config.Brokers, " ") != "b1.example:9092 b2.example:9092" || config.Topic != defaultClickTopic {
+		t.Errorf("brokers %v, topic %q", config.Brokers, config.Topic)
+	}
+	if config.Dialer.TLS == nil || config.Dialer.SASLMechanism == nil {
+		t.Error("kafka+tls:// with a user should connect with TLS and SASL")
+	}
+
+	for _, env := range []map[string]string{
+		{"CLICK_STREAM": "kafka"},
+		{"CLICK_STREAM": "kafka", "CLICK_STREAM_URL": "https://kafka-rest.example"},
+		{"CLICK_STREAM": "kafka-rest", "CLICK_STREAM_URL": "https://kafka-rest.example"},
+		{"CLICK_STREAM": "nats", "CLICK_STREAM_URL": "kafka://b1.example:9092"},
+		{"CLICK_STREAM": "nats", "CLICK_STREAM_URL": "nats://nats.example", "CLICK_STREAM_TOPIC": "two words"},
+		{"CLICK_STREAM": "pigeon", "CLICK_STREAM_URL": "nats://nats.example"},
+	} {
+		if _, err := load(env); err == nil {
+			t.Errorf("%v: no error", env)
+		}
+	}
+}
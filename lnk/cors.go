@@ -0,0 +1,127 @@
+package lnk
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsConfig controls which cross-origin callers may use the API.
+type corsConfig struct {
+	origins          map[string]bool
+	allowAnyOrigin   bool
+	methods          string
+	headers          string
+	allowCredentials bool
+	maxAge           int
+}
+
+// loadCORS reads CORS settings from CORS_ALLOWED_ORIGINS,
+// CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS, CORS_ALLOW_CREDENTIALS, and
+// CORS_MAX_AGE. It returns nil when no origins are allowed.
+func loadCORS(getenv func(string) string) (*corsConfig, error) {
+	origins := splitList(getenv("CORS_ALLOWED_ORIGINS"))
+	if len(origins) == 0 {
+		return nil, nil
+	}
+
+	c := &corsConfig{
+		origins: make(map[string]bool),
+		methods: "GET, POST, PUT, DELETE, OPTIONS",
+		headers: "Content-Type, Authorization, X-Request-ID",
+		maxAge:  600,
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.allowAnyOrigin = true
+		}
+		c.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	if v := splitList(getenv("CORS_ALLOWED_METHODS")); len(v) > 0 {
+		c.methods = strings.ToUpper(strings.Join(v, ", "))
+	}
+	if v := splitList(getenv("CORS_ALLOWED_HEADERS")); len(v) > 0 {
+		c.headers = strings.Join(v, ", ")
+	}
+	if v := getenv("CORS_ALLOW_CREDENTIALS"); v != "" {
+		var err error
+		if c.allowCredentials, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS %q: must be true or false", v)
+		}
+	}
+	// Any site could read a logged-in user's API responses
+	if c.allowAnyOrigin && c.allowCredentials {
+		return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS can't be combined with CORS_ALLOWED_ORIGINS=*: list the origins instead")
+	}
+	if v := getenv("CORS_MAX_AGE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid CORS_MAX_AGE %q: must be a non-negative number of seconds", v)
+		}
+		c.maxAge = n
+	}
+	return c, nil
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func (c *corsConfig) allowed(origin string) bool {
+	return c.allowAnyOrigin || c.origins[origin]
+}
+
+// withCORS adds CORS headers to /api responses for allowed origins and
+// answers preflight requests before they reach the router, which would
+// otherwise reject OPTIONS as an unsupported method.
+func (lf *LinkForwarder) withCORS(next http.Handler) http.Handler {
+	c := lf.cors
+	if c == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.allowAnyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.allowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		h.Set("Access-Control-Expose-Headers", requestIDHeader)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", c.methods)
+			h.Set("Access-Control-Allow-Headers", c.headers)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package lnk
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLoadCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"off", nil, false},
+		{"listed origins with credentials", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example", "CORS_ALLOW_CREDENTIALS": "true"}, false},
+		{"any origin", map[string]string{"CORS_ALLOWED_ORIGINS": "*", "CORS_MAX_AGE": "60"}, false},
+		{"any origin with credentials", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example,*", "CORS_ALLOW_CREDENTIALS": "true"}, true},
+		{"bad credentials setting", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example", "CORS_ALLOW_CREDENTIALS": "yes please"}, true},
+		{"bad max age", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example", "CORS_MAX_AGE": "10m"}, true},
+		{"negative max age", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example", "CORS_MAX_AGE": "-1"}, true},
+	}
+	for _, tt := range tests {
+		_, err := loadCORS(func(name string) string { return tt.env[name] })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want an error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCORSHeaders(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             map[string]string
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"any origin", map[string]string{"CORS_ALLOWED_ORIGINS": "*"}, "https://other.example", "*", ""},
+		{"listed origin", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example", "CORS_ALLOW_CREDENTIALS": "true"}, "https://app.example", "https://app.example", "true"},
+		{"unlisted origin", map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example", "CORS_ALLOW_CREDENTIALS": "true"}, "https://evil.example", "", ""},
+	}
+	for _, tt := range tests {
+		lf := newTestForwarder(t, tt.env)
+		w := serve(lf, "GET", "/api/v1/links", "", func(r *http.Request) { r.Header.Set("Origin", tt.origin) })
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.name, got, tt.wantOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", tt.name, got, tt.wantCredentials)
+		}
+	}
+}
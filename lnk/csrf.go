@@ -0,0 +1,92 @@
+package lnk
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// csrfHeader carries the CSRF token on the web UI's API requests
+	csrfHeader = "X-CSRF-Token"
+	// csrfField carries it in the web UI's forms
+	csrfField = "csrf_token"
+)
+
+// csrfToken derives the CSRF token for a session. Only pages served to the
+// session's browser can read it, since the session cookie itself is
+// HttpOnly, and it changes with every login.
+func csrfToken(session string) string {
+	return hex.EncodeToString(hmacSHA256([]byte(session), "lnk-csrf"))
+}
+
+// requestCSRFToken returns the CSRF token to embed in pages served to r,
+// or "" when it has no session.
+func requestCSRFToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	return csrfToken(cookie.Value)
+}
+
+// csrfSafe reports whether r may change things on behalf of its session.
+// Requests that only read, and requests with a bearer token, which
+// browsers never add on their own, need no token. Anything else a browser
+// sends along by itself, the session cookie or Basic auth it remembers, so
+// such requests are refused when the browser says they come from another
+// site, unless it's one CORS_ALLOWED_ORIGINS names; and with a session
+// they must send its token in the X-CSRF-Token header or the csrf_token
+// form field.
+func (lf *LinkForwarder) csrfSafe(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if _, ok := bearerToken(r); ok {
+		return true
+	}
+	if lf.crossSite(r) {
+		return false
+	}
+	want := requestCSRFToken(r)
+	if want == "" {
+		return true
+	}
+	got := r.Header.Get(csrfHeader)
+	if got == "" {
+		got = r.PostFormValue(csrfField)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// crossSite reports whether a browser marked r as sent by a page of
+// another site than this one or an origin CORS_ALLOWED_ORIGINS lists: by
+// its Sec-Fetch-Site header, or, from browsers without one, its Origin.
+// Clients other than browsers send neither.
+func (lf *LinkForwarder) crossSite(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if lf.cors != nil && origin != "" && lf.cors.origins[origin] {
+		return false
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return true // including "null", from sandboxed pages and redirects
+	}
+	if lf.publicURL != nil && strings.EqualFold(u.Host, lf.publicURL.Host) {
+		return false
+	}
+	return !strings.EqualFold(u.Host, r.Host)
+}
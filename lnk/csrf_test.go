@@ -0,0 +1,88 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestCSRF checks which requests may change things, by how they
+// authenticate and where a browser says they come from.
+func TestCSRF(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{
+		"ADMIN_PASSWORD":       "admin-password",
+		"CORS_ALLOWED_ORIGINS": "https://app.example",
+	})
+	admin, err := lf.getUser(context.Background(), "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testSession(t, lf, admin)
+	csrf := csrfToken(cookie.Value)
+	token := testToken(t, lf, admin, scopeWrite)
+
+	basic := func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	session := func(r *http.Request) { r.AddCookie(cookie) }
+	withToken := func(r *http.Request) { r.Header.Set(csrfHeader, csrf) }
+	header := func(name, value string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set(name, value) }
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		edits  []func(r *http.Request)
+		want   int
+	}{
+		{"no credentials", "POST", nil, http.StatusUnauthorized},
+		{"basic", "POST", nil, http.StatusOK},
+		{"basic from this site", "POST", []func(*http.Request){header("Sec-Fetch-Site", "same-origin")}, http.StatusOK},
+		{"basic from another site", "POST", []func(*http.Request){header("Sec-Fetch-Site", "cross-site")}, http.StatusForbidden},
+		{"basic from a sibling site", "POST", []func(*http.Request){header("Sec-Fetch-Site", "same-site")}, http.StatusForbidden},
+		{"basic with this origin", "POST", []func(*http.Request){header("Origin", "http://example.com")}, http.StatusOK},
+		{"basic with another origin", "POST", []func(*http.Request){header("Origin", "https://evil.example")}, http.StatusForbidden},
+		{"basic with a null origin", "POST", []func(*http.Request){header("Origin", "null")}, http.StatusForbidden},
+		{"basic from an allowed origin", "POST", []func(*http.Request){header("Origin", "https://app.example"), header("Sec-Fetch-Site", "cross-site")}, http.StatusOK},
+		{"bearer from another site", "POST", []func(*http.Request){header("Sec-Fetch-Site", "cross-site"), header("Origin", "https://evil.example")}, http.StatusOK},
+		{"session without a token", "POST", nil, http.StatusForbidden},
+		{"session with a wrong token", "POST", []func(*http.Request){header(csrfHeader, strings.Repeat("0", 64))}, http.StatusForbidden},
+		{"session with its token", "POST", []func(*http.Request){withToken}, http.StatusOK},
+		{"session with its token from another site", "POST", []func(*http.Request){withToken, header("Sec-Fetch-Site", "cross-site")}, http.StatusForbidden},
+		{"session with its token and another origin", "POST", []func(*http.Request){withToken, header("Origin", "https://evil.example")}, http.StatusForbidden},
+		{"session reading from another site", "GET", []func(*http.Request){header("Sec-Fetch-Site", "cross-site")}, http.StatusOK},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var auth func(*http.Request)
+			switch {
+			case strings.HasPrefix(tt.name, "basic"):
+				auth = basic
+			case strings.HasPrefix(tt.name, "bearer"):
+				auth = bearer
+			case strings.HasPrefix(tt.name, "session"):
+				auth = session
+			}
+			body, target := "", "/api/v1/links"
+			if tt.method == "POST" {
+				body = fmt.Sprintf(`{"shortcode":"csrf%d","url":"https://dest.example/%d"}`, i, i)
+			}
+			w := serve(lf, tt.method, target, body, func(r *http.Request) {
+				if auth != nil {
+					auth(r)
+				}
+				for _, edit := range tt.edits {
+					edit(r)
+				}
+			})
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate challenge")
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 365
+	statsTopN        = 10 // links and referrers listed
+	statsRecentN     = 20 // unknown shortcodes listed
+
+	// unknownShortcodeRetention is how long an unknown shortcode is kept
+	// after it was last asked for.
+	unknownShortcodeRetention = 30 * 24 * time.Hour
+)
+
+// SiteStats summarizes the links and their clicks across the server.
+// Everything but the totals covers the last Days days.
+type SiteStats struct {
+	Links             int                `json:"links"`
+	Clicks            int                `json:"clicks"` // all time
+	Days              int                `json:"days"`
+	Daily             []DailyClicks      `json:"daily"` // oldest first, one per day
+	TopLinks          []LinkClicks       `json:"top_links"`
+	TopReferrers      []ReferrerClicks   `json:"top_referrers"`
+	UnknownShortcodes []UnknownShortcode `json:"unknown_shortcodes"` // most recently requested first
+}
+
+// DailyClicks counts the clicks on one day, in UTC.
+type DailyClicks struct {
+	Date   string `json:"date"` // YYYY-MM-DD
+	Clicks int    `json:"clicks"`
+}
+
+// LinkClicks counts the clicks on one link.
+type LinkClicks struct {
+	Domain      string `json:"domain,omitempty"`
+	Shortcode   string `json:"shortcode"`
+	URL         string `json:"url,omitempty"` // empty once the link is deleted
+	Clicks      int    `json:"clicks"`
+	TopReferrer string `json:"top_referrer,omitempty"`
+}
+
+// ReferrerClicks counts the clicks that came from one site.
+type ReferrerClicks struct {
+	Referrer string `json:"referrer"` // hostname
+	Clicks   int    `json:"clicks"`
+}
+
+// UnknownShortcode is a shortcode that was asked for but doesn't exist.
+type UnknownShortcode struct {
+	Domain      string    `json:"domain,omitempty"`
+	Shortcode   string    `json:"shortcode"`
+	Hits        int       `json:"hits"`
+	Referrer    string    `json:"referrer,omitempty"` // of the latest request that had one
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// StatsPageData is rendered by the stats page.
+type StatsPageData struct {
+	User      *User
+	Stats     SiteStats
+	Bars      []StatsBar
+	CSRFToken string
+}
+
+// StatsBar is one day in the stats page's chart of clicks.
+type StatsBar struct {
+	DailyClicks
+	Height int // percent of the busiest day
+}
+
+// referrerHost returns the hostname of the page a request came from, or
+// "" if the browser didn't send one. Only the host is kept: full referring
+// URLs can carry search terms and session IDs.
+func referrerHost(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// recordUnknownShortcode counts a request for a shortcode that doesn't
+// exist.
+func (lf *LinkForwarder) recordUnknownShortcode(ctx context.Context, domain, shortcode, referrer string) error {
+	now := lf.now().UTC()
+	_, err := lf.db.ExecContext(ctx, `INSERT INTO unknown_shortcodes (domain, shortcode, hits, referrer, first_seen_at, last_seen_at)
+		VALUES (?, ?, 1, ?, ?, ?)
+		ON CONFLICT(domain, shortcode) DO UPDATE SET hits = hits + 1, last_seen_at = excluded.last_seen_at,
+			referrer = CASE WHEN excluded.referrer != '' THEN excluded.referrer ELSE referrer END`,
+		domain, shortcode, referrer, now, now)
+	return err
+}
+
+// parseStatsDays reads the days parameter.
+func parseStatsDays(query url.Values) (int, error) {
+	v := query.Get("days")
+	if v == "" {
+		return defaultStatsDays, nil
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 1 || days > maxStatsDays {
+		return 0, fmt.Errorf("days must be between 1 and %d", maxStatsDays)
+	}
+	return days, nil
+}
+
+// siteStats gathers the stats for the last days days, today included.
+func (lf *LinkForwarder) siteStats(ctx context.Context, days int) (SiteStats, error) {
+	stats := SiteStats{
+		Days:              days,
+		TopLinks:          []LinkClicks{},
+		TopReferrers:      []ReferrerClicks{},
+		UnknownShortcodes: []UnknownShortcode{},
+	}
+	if err := lf.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(click_count), 0) FROM links`).
+		Scan(&stats.Links, &stats.Clicks); err != nil {
+		return stats, err
+	}
+
+	today := lf.now().UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-days)
+	since := first.Format("2006-01-02")
+
+	daily := map[string]int{}
+	rows, err := lf.db.QueryContext(ctx, `WITH `+clickCountsSQL+` SELECT day, SUM(clicks) FROM click_counts
+		WHERE day >= ? GROUP BY day`, since)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date string
+		var clicks int
+		if err := rows.Scan(&date, &clicks); err != nil {
+			return stats, err
+		}
+		daily[date] = clicks
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+	for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		stats.Daily = append(stats.Daily, DailyClicks{Date: date, Clicks: daily[date]})
+	}
+
+	rows, err = lf.db.QueryContext(ctx, `WITH `+clickCountsSQL+` SELECT c.domain, c.shortcode, COALESCE(l.url, ''), SUM(c.clicks) AS total
+		FROM click_counts c LEFT JOIN links l ON l.domain = c.domain AND l.shortcode = c.shortcode
+		WHERE c.day >= ? GROUP BY c.domain, c.shortcode ORDER BY total DESC, c.shortcode LIMIT ?`, since, statsTopN)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l LinkClicks
+		if err := rows.Scan(&l.Domain, &l.Shortcode, &l.URL, &l.Clicks); err != nil {
+			return stats, err
+		}
+		stats.TopLinks = append(stats.TopLinks, l)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+	for i, l := range stats.TopLinks {
+		referrers, err := lf.topReferrers(ctx, l.Domain, l.Shortcode, since, 1)
+		if err != nil {
+			return stats, err
+		}
+		if len(referrers) > 0 {
+			stats.TopLinks[i].TopReferrer = referrers[0].Referrer
+		}
+	}
+
+	rows, err = lf.db.QueryContext(ctx, `WITH `+clickCountsSQL+` SELECT referrer, SUM(clicks) AS total FROM click_counts
+		WHERE day >= ? AND referrer != '' GROUP BY referrer ORDER BY total DESC, referrer LIMIT ?`, since, statsTopN)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ref ReferrerClicks
+		if err := rows.Scan(&ref.Referrer, &ref.Clicks); err != nil {
+			return stats, err
+		}
+		stats.TopReferrers = append(stats.TopReferrers, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	rows, err = lf.db.QueryContext(ctx, `SELECT domain, shortcode, hits, referrer, first_seen_at, last_seen_at
+		FROM unknown_shortcodes ORDER BY last_seen_at DESC LIMIT ?`, statsRecentN)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u UnknownShortcode
+		if err := rows.Scan(&u.Domain, &u.Shortcode, &u.Hits, &u.Referrer, &u.FirstSeenAt, &u.LastSeenAt); err != nil {
+			return stats, err
+		}
+		stats.UnknownShortcodes = append(stats.UnknownShortcodes, u)
+	}
+	return stats, rows.Err()
+}
+
+// handleSiteStats returns the server-wide stats.
+func (lf *LinkForwarder) handleSiteStats(w http.ResponseWriter, r *http.Request) {
+	days, err := parseStatsDays(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stats, err := lf.siteStats(r.Context(), days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Stats retrieved successfully",
+		Data:    stats,
+	})
+}
+
+// handleStatsPage serves the admin dashboard of the server-wide stats.
+func (lf *LinkForwarder) handleStatsPage(w http.ResponseWriter, r *http.Request) {
+	if !currentUser(r).IsAdmin() {
+		http.Error(w, "Admin access required", http.StatusForbidden)
+		return
+	}
+	days, err := parseStatsDays(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := lf.siteStats(r.Context(), days)
+	if err != nil {
+		lf.logf(r, "Failed to gather stats: %v", err)
+		http.Error(w, "Failed to retrieve stats", http.StatusInternalServerError)
+		return
+	}
+
+	busiest := 0
+	for _, d := range stats.Daily {
+		if d.Clicks > busiest {
+			busiest = d.Clicks
+		}
+	}
+	bars := make([]StatsBar, len(stats.Daily))
+	for i, d := range stats.Daily {
+		bars[i].DailyClicks = d
+		if busiest > 0 {
+			bars[i].Height = d.Clicks * 100 / busiest
+		}
+	}
+
+	tmpl, err := lf.loadTemplate("stats.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := tmpl.Execute(w, StatsPageData{User: currentUser(r), Stats: stats, Bars: bars, CSRFToken: requestCSRFToken(r)}); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package lnk
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"testing"
+)
+
+// TestDebugEndpoints checks that profiling endpoints are only served to
+// admins, and to nobody before an admin account exists.
+func TestDebugEndpoints(t *testing.T) {
+	debug := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "profile")
+	})
+	newForwarder := func(env map[string]string) *LinkForwarder {
+		lf, err := New(
+			WithDataDir(t.TempDir()),
+			WithEnv(func(name string) string { return env[name] }),
+			WithLogger(log.New(io.Discard, "", 0)),
+			WithDebugHandler(debug),
+		)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { lf.Close() })
+		return lf
+	}
+
+	lf := newForwarder(nil)
+	if w := serve(lf, "GET", "/debug/pprof/cmdline", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("without accounts: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	lf = newForwarder(map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	alice := testUser(t, lf, "alice", roleUser)
+	tests := []struct {
+		name string
+		edit func(r *http.Request)
+		want int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"regular user", func(r *http.Request) { r.SetBasicAuth(alice.Username, "password-alice") }, http.StatusForbidden},
+		{"admin", func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serve(lf, "GET", "/debug/pprof/cmdline", "", tt.edit); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
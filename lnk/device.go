@@ -0,0 +1,74 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Device classes a link can send to different destinations.
+const (
+	deviceIOS     = "ios"
+	deviceAndroid = "android"
+	deviceDesktop = "desktop"
+)
+
+// deviceClass guesses the kind of device a User-Agent belongs to. It
+// returns "" for agents it can't place, such as other mobile platforms.
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return ""
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return deviceIOS
+	case strings.Contains(ua, "android"):
+		return deviceAndroid
+	case strings.Contains(ua, "mobile"):
+		return ""
+	case strings.Contains(ua, "windows"), strings.Contains(ua, "macintosh"),
+		strings.Contains(ua, "x11"), strings.Contains(ua, "cros"):
+		return deviceDesktop
+	}
+	return ""
+}
+
+// normalizeDeviceURLs validates a link's per-device destinations.
+func (lf *LinkForwarder) normalizeDeviceURLs(ctx context.Context, link *Link, requestHost string) error {
+	fields := []struct {
+		name string
+		url  *string
+	}{
+		{"ios_url", &link.IOSURL},
+		{"android_url", &link.AndroidURL},
+		{"desktop_url", &link.DesktopURL},
+	}
+	for _, f := range fields {
+		*f.url = strings.TrimSpace(*f.url)
+		if *f.url == "" {
+			continue
+		}
+		destination, err := lf.checkDestination(ctx, *link, *f.url, requestHost)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+		*f.url = destination
+	}
+	return nil
+}
+
+// deviceDestination returns the link's destination for the visitor's
+// device, if it has one.
+func deviceDestination(r *http.Request, link Link) (string, bool) {
+	var destination string
+	switch deviceClass(r.UserAgent()) {
+	case deviceIOS:
+		destination = link.IOSURL
+	case deviceAndroid:
+		destination = link.AndroidURL
+	case deviceDesktop:
+		destination = link.DesktopURL
+	}
+	return destination, destination != ""
+}
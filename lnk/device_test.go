@@ -0,0 +1,37 @@
+package lnk
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestDeviceDestination checks that a device's own URL wins over the
+// link's variants, and that caches are told the redirect varies by device.
+func TestDeviceDestination(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	link := Link{
+		Shortcode: "app",
+		URL:       "https://dest.example/",
+		IOSURL:    "https://apps.example/ios",
+		Variants: []Variant{
+			{Name: "a", URL: "https://dest.example/a", Weight: 1},
+			{Name: "b", URL: "https://dest.example/b", Weight: 1},
+		},
+	}
+	r := httptest.NewRequest("GET", "/app", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148")
+	w := httptest.NewRecorder()
+	destination, variant := lf.resolveDestination(w, r, link, "")
+	if destination != link.IOSURL || variant != "" {
+		t.Errorf("iPhone visit went to %q (variant %q), want %q", destination, variant, link.IOSURL)
+	}
+	if got := w.Header().Get("Vary"); got != "User-Agent" {
+		t.Errorf("Vary = %q, want User-Agent", got)
+	}
+
+	r = httptest.NewRequest("GET", "/app", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Mobile Safari/537.36")
+	if destination, _ := lf.resolveDestination(httptest.NewRecorder(), r, link, ""); destination == link.IOSURL {
+		t.Errorf("Android visit went to the iOS URL")
+	}
+}
@@ -0,0 +1,218 @@
+package lnk
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// discordAPIURL is where replies that can't be sent inline (QR codes) go.
+var discordAPIURL = "https://discord.com/api/v10"
+
+// Interaction and response types from the Discord API.
+const (
+	discordPing                   = 1
+	discordApplicationCommand     = 2
+	discordPong                   = 1
+	discordChannelMessage         = 4
+	discordDeferredChannelMessage = 5
+	discordEphemeral              = 1 << 6
+)
+
+// discordBot takes commands as Discord interactions: Discord POSTs each use
+// of the /lnk slash command to /api/v1/bots/discord.
+type discordBot struct {
+	publicKey ed25519.PublicKey
+	users     chatUsers
+	client    *http.Client
+}
+
+// loadDiscord configures the Discord bot from DISCORD_PUBLIC_KEY and
+// DISCORD_USERS. It returns nil when DISCORD_PUBLIC_KEY isn't set.
+func loadDiscord(getenv func(string) string) (*discordBot, error) {
+	raw := getenv("DISCORD_PUBLIC_KEY")
+	if raw == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(raw)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid DISCORD_PUBLIC_KEY: must be the hex public key from the Discord developer portal")
+	}
+	users, err := loadChatUsers(getenv, "DISCORD_USERS")
+	if err != nil {
+		return nil, err
+	}
+	return &discordBot{publicKey: key, users: users, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type discordInteraction struct {
+	Type          int    `json:"type"`
+	ApplicationID string `json:"application_id"`
+	Token         string `json:"token"`
+	Data          struct {
+		Options []struct {
+			Value any `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+	// Member is set in servers and User in direct messages
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User *discordUser `json:"user"`
+}
+
+// verify checks that a request was signed by Discord.
+func (b *discordBot) verify(r *http.Request, body []byte) bool {
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(b.publicKey, message, sig)
+}
+
+// handleDiscord answers Discord interactions. The command text is the
+// slash command's options, so "/lnk shorten https://example.com docs" runs
+// /shorten.
+func (lf *LinkForwarder) handleDiscord(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read request")
+		return
+	}
+	if !lf.discord.verify(r, body) {
+		writeError(w, http.StatusUnauthorized, "Invalid request signature")
+		return
+	}
+	var in discordInteraction
+	if err := json.Unmarshal(body, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	switch in.Type {
+	case discordPing:
+		writeDiscord(w, map[string]any{"type": discordPong})
+		return
+	case discordApplicationCommand:
+	default:
+		writeError(w, http.StatusBadRequest, "Unsupported interaction type")
+		return
+	}
+
+	user := in.User
+	if in.Member != nil {
+		user = &in.Member.User
+	}
+	if user == nil {
+		writeError(w, http.StatusBadRequest, "Interaction has no user")
+		return
+	}
+	account, ok := lf.discord.users.lookup(user.ID, user.Username)
+	if !ok {
+		lf.logf(r, "Refusing Discord command from user %s (%s): not in DISCORD_USERS", user.ID, user.Username)
+		writeDiscordMessage(w, "You aren't allowed to use this bot.", discordEphemeral)
+		return
+	}
+	actor := account
+	if actor == "" {
+		actor = "discord:" + user.ID
+	}
+
+	var words []string
+	for _, option := range in.Data.Options {
+		words = append(words, fmt.Sprint(option.Value))
+	}
+	reply := lf.botCommand(r.Context(), account, actor, strings.Join(words, " "))
+	if reply.qr == nil {
+		writeDiscordMessage(w, reply.text, 0)
+		return
+	}
+
+	// Images can't be sent in the response itself, so acknowledge the
+	// command and attach the QR code to the reply afterwards
+	writeDiscord(w, map[string]any{"type": discordDeferredChannelMessage})
+	go func() {
+		ctx, cancel := context.WithTimeout(lf.background, time.Minute)
+		defer cancel()
+		if err := lf.discord.editReply(ctx, in, reply); err != nil {
+			lf.logf(r, "Failed to send Discord QR code: %v", err)
+		}
+	}()
+}
+
+// editReply replaces the deferred reply to an interaction with reply.
+func (b *discordBot) editReply(ctx context.Context, in discordInteraction, reply botReply) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	payload, err := json.Marshal(map[string]any{
+		"content":          reply.text,
+		"attachments":      []map[string]any{{"id": 0, "filename": "qr.png"}},
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+	if err != nil {
+		return err
+	}
+	mw.WriteField("payload_json", string(payload))
+	part, err := mw.CreateFormFile("files[0]", "qr.png")
+	if err != nil {
+		return err
+	}
+	part.Write(reply.qr)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	endpoint := discordAPIURL + "/webhooks/" + url.PathEscape(in.ApplicationID) + "/" + url.PathEscape(in.Token) + "/messages/@original"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The URL holds the interaction token, so leave it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response from Discord: %s", resp.Status)
+	}
+	return nil
+}
+
+// writeDiscordMessage answers an interaction with a message.
+func writeDiscordMessage(w http.ResponseWriter, text string, flags int) {
+	writeDiscord(w, map[string]any{
+		"type": discordChannelMessage,
+		"data": map[string]any{
+			"content":          text,
+			"flags":            flags,
+			"allowed_mentions": map[string]any{"parse": []string{}},
+		},
+	})
+}
+
+func writeDiscord(w http.ResponseWriter, resp map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
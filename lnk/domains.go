@@ -0,0 +1,246 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Domain rule kinds. Blocked domains are always rejected; once any allow
+// rule exists, destinations must match one of them.
+const (
+	domainBlock = "block"
+	domainAllow = "allow"
+)
+
+var errDomainRuleNotFound = errors.New("domain rule not found")
+
+// DomainRule blocks or allows a destination domain. Patterns are either an
+// exact hostname ("example.com") or a wildcard for its subdomains
+// ("*.example.com").
+type DomainRule struct {
+	ID        int64     `json:"id"`
+	Pattern   string    `json:"pattern"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (d DomainRule) matches(host string) bool {
+	if suffix, ok := strings.CutPrefix(d.Pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == d.Pattern
+}
+
+// domainRuleSet keeps the rules in memory since they're checked on every
+// redirect.
+type domainRuleSet struct {
+	mu    sync.RWMutex
+	rules []DomainRule
+}
+
+func (s *domainRuleSet) set(rules []DomainRule) {
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+}
+
+func (s *domainRuleSet) has(pattern, kind string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rule := range s.rules {
+		if rule.Pattern == pattern && rule.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports why host isn't an allowed destination, if it isn't.
+func (s *domainRuleSet) check(host string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	hasAllow, allowed := false, false
+	for _, rule := range s.rules {
+		switch rule.Kind {
+		case domainBlock:
+			if rule.matches(host) {
+				return fmt.Errorf("destination domain %s is blocked", host)
+			}
+		case domainAllow:
+			hasAllow = true
+			if rule.matches(host) {
+				allowed = true
+			}
+		}
+	}
+	if hasAllow && !allowed {
+		return fmt.Errorf("destination domain %s is not on the allowlist", host)
+	}
+	return nil
+}
+
+// normalizeDomainPattern validates and lower-cases a rule pattern.
+func normalizeDomainPattern(pattern string) (string, error) {
+	pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+	host := strings.TrimPrefix(pattern, "*.")
+	if host == "" || strings.ContainsAny(host, "*/:@ ") || strings.HasPrefix(host, ".") {
+		return "", fmt.Errorf("pattern must be a domain like example.com or *.example.com")
+	}
+	return pattern, nil
+}
+
+// checkDomain applies the domain rules to a destination URL.
+func (lf *LinkForwarder) checkDomain(destination string) error {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" {
+		return nil
+	}
+	return lf.domainRules.check(u.Hostname())
+}
+
+// loadDomainRules refreshes the in-memory rules from the database.
+func (lf *LinkForwarder) loadDomainRules(ctx context.Context) error {
+	rules, err := lf.listDomainRules(ctx)
+	if err != nil {
+		return err
+	}
+	lf.domainRules.set(rules)
+	return nil
+}
+
+func (lf *LinkForwarder) listDomainRules(ctx context.Context) ([]DomainRule, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT id, pattern, kind, created_at FROM domain_rules ORDER BY kind, pattern`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []DomainRule{}
+	for rows.Next() {
+		var rule DomainRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.Kind, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (lf *LinkForwarder) createDomainRule(ctx context.Context, pattern, kind string) (DomainRule, error) {
+	rule := DomainRule{Pattern: pattern, Kind: kind, CreatedAt: lf.now().UTC()}
+	result, err := lf.db.ExecContext(ctx, `INSERT INTO domain_rules (pattern, kind) VALUES (?, ?)`, pattern, kind)
+	if err != nil {
+		return rule, err
+	}
+	if rule.ID, err = result.LastInsertId(); err != nil {
+		return rule, err
+	}
+	if err := lf.loadDomainRules(ctx); err != nil {
+		return rule, err
+	}
+	lf.rulesChanged()
+	return rule, nil
+}
+
+func (lf *LinkForwarder) deleteDomainRule(ctx context.Context, id int64) error {
+	result, err := lf.db.ExecContext(ctx, `DELETE FROM domain_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errDomainRuleNotFound
+	}
+	if err := lf.loadDomainRules(ctx); err != nil {
+		return err
+	}
+	lf.rulesChanged()
+	return nil
+}
+
+func (lf *LinkForwarder) handleDomainRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		rules, err := lf.listDomainRules(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve domain rules")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Domain rules retrieved successfully",
+			Data:    rules,
+		})
+
+	case "POST":
+		var req DomainRule
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if req.Kind != domainBlock && req.Kind != domainAllow {
+			writeError(w, http.StatusBadRequest, "kind must be block or allow")
+			return
+		}
+		pattern, err := normalizeDomainPattern(req.Pattern)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if lf.domainRules.has(pattern, req.Kind) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("%s rule for %s already exists", req.Kind, pattern))
+			return
+		}
+
+		rule, err := lf.createDomainRule(r.Context(), pattern, req.Kind)
+		if err != nil {
+			lf.logf(r, "Failed to create domain rule: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to create domain rule")
+			return
+		}
+		lf.logf(r, "%s added %s rule for %s", lf.requestActor(r), rule.Kind, rule.Pattern)
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "Domain rule created successfully",
+			Data:    rule,
+		})
+
+	case "DELETE":
+		id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err := lf.deleteDomainRule(r.Context(), id); err != nil {
+			if errors.Is(err, errDomainRuleNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "Failed to delete domain rule")
+			}
+			return
+		}
+		lf.logf(r, "%s deleted domain rule %d", lf.requestActor(r), id)
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Domain rule deleted successfully",
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,89 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestDomainRules checks that blocked domains are refused as destinations
+// and stop existing links from redirecting, and that once an allow rule
+// exists only allowed domains are accepted.
+func TestDomainRules(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }
+	if err := lf.saveLink(context.Background(), Link{Shortcode: "old", URL: "https://www.bad.example/"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	addRule := func(pattern, kind string) DomainRule {
+		t.Helper()
+		body := fmt.Sprintf(`{"pattern":%q,"kind":%q}`, pattern, kind)
+		w := serve(lf, "POST", "/api/v1/admin/domains", body, admin)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("adding %s rule %s: status %d: %s", kind, pattern, w.Code, w.Body)
+		}
+		var resp struct{ Data DomainRule }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+	create := func(shortcode, url string) int {
+		body := fmt.Sprintf(`{"shortcode":%q,"url":%q}`, shortcode, url)
+		return serve(lf, "POST", "/api/v1/links", body, admin).Code
+	}
+
+	if rule := addRule(" *.Bad.Example. ", domainBlock); rule.Pattern != "*.bad.example" {
+		t.Errorf("pattern %q, want it normalized to *.bad.example", rule.Pattern)
+	}
+	if code := create("sub", "https://shop.bad.example/"); code != http.StatusBadRequest {
+		t.Errorf("link to a blocked subdomain: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := create("apex", "https://bad.example/"); code != http.StatusOK {
+		t.Errorf("link to the wildcard's own domain: status %d, want %d", code, http.StatusOK)
+	}
+	if w := serve(lf, "GET", "/old", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("following a link saved before its domain was blocked: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	allow := addRule("dest.example", domainAllow)
+	if code := create("other", "https://other.example/"); code != http.StatusBadRequest {
+		t.Errorf("link to a domain not on the allowlist: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := create("dest", "https://dest.example/"); code != http.StatusOK {
+		t.Errorf("link to an allowed domain: status %d, want %d", code, http.StatusOK)
+	}
+
+	if w := serve(lf, "DELETE", fmt.Sprintf("/api/v1/admin/domains/%d", allow.ID), "", admin); w.Code != http.StatusOK {
+		t.Fatalf("deleting the allow rule: status %d: %s", w.Code, w.Body)
+	}
+	if code := create("other", "https://other.example/"); code != http.StatusOK {
+		t.Errorf("link after the allowlist was emptied: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+// TestDomainRuleValidation checks the rules the API refuses.
+func TestDomainRuleValidation(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"pattern":"bad.example","kind":"block"}`, http.StatusCreated},
+		{`{"pattern":"BAD.example","kind":"block"}`, http.StatusConflict},
+		{`{"pattern":"bad.example","kind":"allow"}`, http.StatusCreated},
+		{`{"pattern":"bad.example","kind":"maybe"}`, http.StatusBadRequest},
+		{`{"pattern":"https://bad.example/","kind":"block"}`, http.StatusBadRequest},
+		{`{"pattern":"*.*.bad.example","kind":"block"}`, http.StatusBadRequest},
+		{`{"pattern":"*.","kind":"block"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := serve(lf, "POST", "/api/v1/admin/domains", tt.body, admin); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+}
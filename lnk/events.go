@@ -0,0 +1,149 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types besides the history actions (create, update, and delete).
+const eventClick = "click"
+
+// eventHeartbeat is how often an idle event stream gets a comment, so
+// proxies don't close it and clients notice when it's gone.
+const eventHeartbeat = 30 * time.Second
+
+// Event is a click on a link or a change to one, as streamed by
+// /api/v1/events.
+type Event struct {
+	Type      string    `json:"type"` // click, create, update, or delete
+	Domain    string    `json:"domain,omitempty"`
+	Shortcode string    `json:"shortcode"`
+	URL       string    `json:"url,omitempty"`     // where a click was sent, or a saved link's destination
+	Variant   string    `json:"variant,omitempty"` // the A/B variant a click was sent to
+	Actor     string    `json:"actor,omitempty"`   // who changed the link
+	Time      time.Time `json:"time"`
+}
+
+// eventHub fans events out to the open streams.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[chan Event]bool{}}
+}
+
+func (h *eventHub) subscribe() chan Event {
+	ch := make(chan Event, 64)
+	h.mu.Lock()
+	h.subs[ch] = true
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// broadcast sends e to every stream. A stream that has fallen behind
+// misses it rather than holding up redirects.
+func (h *eventHub) broadcast(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publish reports an event to the streams of every replica: through Redis
+// when it's configured, which hands it back to this replica too, or
+// directly otherwise.
+func (lf *LinkForwarder) publish(e Event) {
+	e.Time = lf.now()
+	if lf.redis == nil {
+		lf.events.broadcast(e)
+		return
+	}
+	// Off the request path, so a slow Redis doesn't delay redirects
+	go func() {
+		if err := lf.redis.publishEvent(context.Background(), e); err != nil {
+			lf.logger.Printf("Redis event broadcast failed: %v", err)
+			lf.events.broadcast(e)
+		}
+	}()
+}
+
+// untimedContextKey holds a request's context from before
+// withRequestTimeout added its deadline.
+const untimedContextKey contextKey = "untimed"
+
+// withoutRequestTimeout returns the context of r without the REQUEST_TIMEOUT
+// deadline, for responses meant to stay open. It's still cancelled when
+// the client disconnects.
+func withoutRequestTimeout(r *http.Request) context.Context {
+	if ctx, ok := r.Context().Value(untimedContextKey).(context.Context); ok {
+		return ctx
+	}
+	return r.Context()
+}
+
+// handleEvents streams events as server-sent events until the client
+// disconnects. ?type= and ?shortcode= narrow the stream down.
+func (lf *LinkForwarder) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	eventType := r.URL.Query().Get("type")
+	shortcode := lf.rules.normalize(r.URL.Query().Get("shortcode"))
+
+	events := lf.events.subscribe()
+	defer lf.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tell nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ctx := withoutRequestTimeout(r)
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e := <-events:
+			if e.Domain != domain || (eventType != "" && e.Type != eventType) ||
+				(shortcode != "" && e.Shortcode != shortcode) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
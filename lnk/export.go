@@ -0,0 +1,245 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// exportFlushRows is how many rows are written between flushes, so a long
+// export reaches the client as it's read rather than all at the end.
+const exportFlushRows = 1000
+
+// ClickRecord is one click in a raw export.
+type ClickRecord struct {
+	ClickedAt time.Time `json:"clicked_at"`
+	Variant   string    `json:"variant"`
+	Referrer  string    `json:"referrer"`
+}
+
+// DailyClickRecord is one row of a daily export: the clicks on a day with
+// the same variant and referrer.
+type DailyClickRecord struct {
+	Date     string `json:"date"` // YYYY-MM-DD, in UTC
+	Variant  string `json:"variant"`
+	Referrer string `json:"referrer"`
+	Clicks   int    `json:"clicks"`
+}
+
+// exportOptions are the query parameters of a click export.
+type exportOptions struct {
+	format   string // csv or json
+	daily    bool
+	from, to time.Time // to is exclusive; zero when open-ended
+}
+
+// parseExportTime reads a from or to parameter: a date, or an RFC 3339
+// time. A date in to includes the whole day.
+func parseExportTime(name, v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date such as 2024-05-01 or an RFC 3339 time", name)
+	}
+	return t.UTC(), nil
+}
+
+// parseExportOptions reads the format, granularity, from, and to
+// parameters.
+func parseExportOptions(q url.Values) (exportOptions, error) {
+	opts := exportOptions{format: q.Get("format")}
+	switch opts.format {
+	case "":
+		opts.format = "csv"
+	case "csv", "json":
+	default:
+		return opts, fmt.Errorf("format must be csv or json")
+	}
+	switch q.Get("granularity") {
+	case "", "raw":
+	case "daily":
+		opts.daily = true
+	default:
+		return opts, fmt.Errorf("granularity must be raw or daily")
+	}
+	var err error
+	if opts.from, err = parseExportTime("from", q.Get("from"), false); err != nil {
+		return opts, err
+	}
+	if opts.to, err = parseExportTime("to", q.Get("to"), true); err != nil {
+		return opts, err
+	}
+	if !opts.from.IsZero() && !opts.to.IsZero() && !opts.from.Before(opts.to) {
+		return opts, fmt.Errorf("from must be before to")
+	}
+	return opts, nil
+}
+
+// clickExporter writes export rows as CSV or as JSON Lines.
+type clickExporter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func newClickExporter(w io.Writer, format string, header []string) *clickExporter {
+	if format == "json" {
+		return &clickExporter{json: json.NewEncoder(w)}
+	}
+	e := &clickExporter{csv: csv.NewWriter(w)}
+	e.csv.Write(header)
+	return e
+}
+
+// write adds a row, given both as a record for JSON and as fields for CSV.
+func (e *clickExporter) write(record any, fields []string) error {
+	if e.json != nil {
+		return e.json.Encode(record)
+	}
+	return e.csv.Write(fields)
+}
+
+func (e *clickExporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// queryClickExport runs the query for an export.
+func (lf *LinkForwarder) queryClickExport(ctx context.Context, domain, shortcode string, opts exportOptions) (*sql.Rows, error) {
+	if opts.daily {
+		query := `WITH ` + clickCountsSQL + ` SELECT day, variant, referrer, SUM(clicks) FROM click_counts
+			WHERE domain = ? AND shortcode = ?`
+		args := []any{domain, shortcode}
+		if !opts.from.IsZero() {
+			query += ` AND day >= ?`
+			args = append(args, opts.from.Format("2006-01-02"))
+		}
+		if !opts.to.IsZero() {
+			// A day is in the export if any of it is
+			query += ` AND day < ?`
+			args = append(args, opts.to.Add(24*time.Hour-time.Nanosecond).Format("2006-01-02"))
+		}
+		return lf.db.QueryContext(ctx, query+` GROUP BY day, variant, referrer ORDER BY day, variant, referrer`, args...)
+	}
+
+	// clicked_at is stored the way CURRENT_TIMESTAMP writes it
+	const stored = "2006-01-02 15:04:05"
+	query := `SELECT clicked_at, variant, referrer FROM clicks WHERE domain = ? AND shortcode = ?`
+	args := []any{domain, shortcode}
+	if !opts.from.IsZero() {
+		query += ` AND clicked_at >= ?`
+		args = append(args, opts.from.Format(stored))
+	}
+	if !opts.to.IsZero() {
+		query += ` AND clicked_at < ?`
+		args = append(args, opts.to.Format(stored))
+	}
+	return lf.db.QueryContext(ctx, query+` ORDER BY clicked_at, id`, args...)
+}
+
+// handleClickExport streams a link's clicks, one row per click or per day,
+// as CSV or JSON Lines for spreadsheets and BI tools.
+func (lf *LinkForwarder) handleClickExport(w http.ResponseWriter, r *http.Request) {
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := lf.getLink(r.Context(), domain, shortcode); errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+
+	// A large export can take longer than REQUEST_TIMEOUT
+	ctx := withoutRequestTimeout(r)
+	rows, err := lf.queryClickExport(ctx, domain, shortcode, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to export clicks")
+		return
+	}
+	defer rows.Close()
+
+	header := []string{"clicked_at", "variant", "referrer"}
+	name := shortcode + "-clicks"
+	if opts.daily {
+		header = []string{"date", "variant", "referrer", "clicks"}
+		name = shortcode + "-clicks-daily"
+	}
+	if opts.format == "json" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		name += ".jsonl"
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		name += ".csv"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	flusher, _ := w.(http.Flusher)
+	export := newClickExporter(w, opts.format, header)
+
+	n := 0
+	for rows.Next() {
+		if opts.daily {
+			var rec DailyClickRecord
+			if err = rows.Scan(&rec.Date, &rec.Variant, &rec.Referrer, &rec.Clicks); err == nil {
+				err = export.write(rec, []string{rec.Date, rec.Variant, rec.Referrer, strconv.Itoa(rec.Clicks)})
+			}
+		} else {
+			var rec ClickRecord
+			if err = rows.Scan(&rec.ClickedAt, &rec.Variant, &rec.Referrer); err == nil {
+				rec.ClickedAt = rec.ClickedAt.UTC()
+				err = export.write(rec, []string{rec.ClickedAt.Format(time.RFC3339), rec.Variant, rec.Referrer})
+			}
+		}
+		if err != nil {
+			break
+		}
+		if n++; n%exportFlushRows == 0 {
+			if err = export.flush(); err != nil {
+				break
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		err = export.flush()
+	}
+	if err != nil {
+		// The status has been sent, so all that's left is to cut the
+		// export short
+		lf.logf(r, "Export of clicks on %s failed after %d rows: %v", shortcode, n, err)
+	}
+}
@@ -0,0 +1,135 @@
+package lnk
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Ways of handling a request for a shortcode that doesn't exist.
+const (
+	fallbackHome     = "home"     // the management page, offering to create it
+	fallbackNotFound = "404"      // a 404 page, suggesting similar shortcodes
+	fallbackRedirect = "redirect" // a fixed URL
+	fallbackSearch   = "search"   // a search engine, searching for the shortcode
+)
+
+const defaultSearchURL = "https://www.google.com/search?q={shortcode}"
+
+type fallbackConfig struct {
+	mode    string
+	url     string
+	suggest bool // offer similar shortcodes on the 404 page
+}
+
+// loadFallback reads FALLBACK_MODE, FALLBACK_URL, and
+// FALLBACK_SUGGESTIONS.
+func loadFallback(getenv func(string) string) (fallbackConfig, error) {
+	cfg := fallbackConfig{
+		mode:    strings.ToLower(getenv("FALLBACK_MODE")),
+		url:     getenv("FALLBACK_URL"),
+		suggest: true,
+	}
+	if v := getenv("FALLBACK_SUGGESTIONS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid FALLBACK_SUGGESTIONS %q: must be true or false", v)
+		}
+		cfg.suggest = b
+	}
+	switch cfg.mode {
+	case "":
+		cfg.mode = fallbackNotFound
+	case fallbackHome, fallbackNotFound:
+	case fallbackRedirect:
+		if cfg.url == "" {
+			return cfg, fmt.Errorf("FALLBACK_MODE=redirect requires FALLBACK_URL")
+		}
+	case fallbackSearch:
+		if cfg.url == "" {
+			cfg.url = defaultSearchURL
+		}
+		if !strings.Contains(cfg.url, "{shortcode}") {
+			return cfg, fmt.Errorf("FALLBACK_URL for FALLBACK_MODE=search must contain {shortcode}")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid FALLBACK_MODE %q: must be home, 404, redirect, or search", cfg.mode)
+	}
+	return cfg, nil
+}
+
+// NotFoundData is passed to the notfound.html template.
+type NotFoundData struct {
+	Shortcode   string
+	Suggestions []string // similar shortcodes that do exist, closest first
+	CanCreate   bool     // the visitor may create the link
+}
+
+// handleUnknownShortcode responds to a request for a shortcode that
+// doesn't exist according to FALLBACK_MODE.
+func (lf *LinkForwarder) handleUnknownShortcode(w http.ResponseWriter, r *http.Request, shortcode string) {
+	switch lf.fallback.mode {
+	case fallbackNotFound:
+		lf.renderNotFound(w, r, shortcode)
+
+	case fallbackRedirect:
+		lf.logf(r, "Link not found for shortcode: %s, redirecting to %s", shortcode, lf.fallback.url)
+		http.Redirect(w, r, lf.fallback.url, http.StatusFound)
+
+	case fallbackSearch:
+		target := strings.ReplaceAll(lf.fallback.url, "{shortcode}", url.QueryEscape(shortcode))
+		lf.logf(r, "Link not found for shortcode: %s, redirecting to search", shortcode)
+		http.Redirect(w, r, target, http.StatusFound)
+
+	default:
+		// Redirect to home page with shortcode and error message
+		redirectURL := lf.appPath("/?") + url.Values{"shortcode": {shortcode}, "error": {"not_found"}}.Encode()
+		lf.logf(r, "Link not found for shortcode: %s, redirecting to home", shortcode)
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}
+}
+
+// renderNotFound shows the 404 page for an unknown shortcode. Deployments
+// can replace notfound.html with their own through THEME_DIR.
+func (lf *LinkForwarder) renderNotFound(w http.ResponseWriter, r *http.Request, shortcode string) {
+	tmpl, err := lf.loadTemplate("notfound.html")
+	if err != nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+
+	data := NotFoundData{Shortcode: shortcode, CanCreate: lf.canCreate(r)}
+	if lf.fallback.suggest {
+		if data.Suggestions, err = lf.suggestShortcodes(r.Context(), lf.requestDomain(r), shortcode); err != nil {
+			lf.logf(r, "Failed to find shortcodes like %s: %v", shortcode, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusNotFound)
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+}
+
+// canCreate reports whether the visitor could create links from the
+// management page: anyone until accounts exist, and then only those
+// logged in. Other visitors aren't pointed at a page that would only ask
+// them to log in.
+func (lf *LinkForwarder) canCreate(r *http.Request) bool {
+	if lf.readOnly {
+		return false
+	}
+	enabled, err := lf.authEnabled(r.Context())
+	if err != nil {
+		return false
+	}
+	if !enabled {
+		return true
+	}
+	_, err = lf.requestSessionUser(r)
+	return err == nil
+}
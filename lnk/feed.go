@@ -0,0 +1,275 @@
+package lnk
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// feedSize is how many of the newest links a feed lists.
+const feedSize = 50
+
+// atomFeed and the types below are an Atom (RFC 4287) feed.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"` // for entries without an owner
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary"`
+}
+
+// jsonFeed and the types below are a JSON Feed (version 1.1).
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	ExternalURL   string           `json:"external_url,omitempty"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+// feedEntry is a link as both feed formats show it.
+type feedEntry struct {
+	id       string
+	shortURL string
+	url      string // empty for password-protected links
+	title    string
+	summary  string
+	owner    string
+	tags     []string
+	created  time.Time
+}
+
+// newFeedEntry describes a new link for a feed reader. The ID stays the
+// same for as long as the link does, and changes if the shortcode is
+// deleted and created again, so readers show it as new.
+func (lf *LinkForwarder) newFeedEntry(r *http.Request, link Link) feedEntry {
+	e := feedEntry{
+		shortURL: lf.shortURL(r, link),
+		url:      link.URL,
+		title:    "/" + link.Shortcode,
+		owner:    link.Owner,
+		tags:     link.Tags,
+		created:  link.CreatedAt.UTC(),
+	}
+	host := e.shortURL
+	if u, err := url.Parse(e.shortURL); err == nil {
+		host = u.Hostname()
+	}
+	e.id = fmt.Sprintf("tag:%s,%s:%s/%d", host, e.created.Format("2006-01-02"), link.Shortcode, e.created.Unix())
+	if link.Group != "" {
+		e.tags = append([]string{link.Group}, e.tags...)
+	}
+
+	title := link.Title
+	if title == "" {
+		title = link.PageTitle
+	}
+	if title != "" {
+		e.title += ": " + title
+	}
+
+	// A password-protected link's destination is only for those with
+	// the password
+	if link.Protected {
+		e.url = ""
+	}
+	lines := []string{e.shortURL}
+	if e.url != "" {
+		lines[0] += " → " + e.url
+	}
+	if link.Description != "" {
+		lines = append(lines, link.Description)
+	}
+	e.summary = strings.Join(lines, "\n\n")
+	return e
+}
+
+// feedTitle is the feed's name: the theme's site name, as the pages show
+// it.
+func (lf *LinkForwarder) feedTitle() string {
+	name := "Link Forwarder"
+	if tmpl, err := lf.loadTemplate("home.html"); err == nil {
+		var b bytes.Buffer
+		if tmpl.ExecuteTemplate(&b, "name", nil) == nil && strings.TrimSpace(b.String()) != "" {
+			name = html.UnescapeString(strings.TrimSpace(b.String()))
+		}
+	}
+	return name
+}
+
+// handleFeed serves the newest links as an Atom feed on /feed.xml, or a
+// JSON Feed on /feed.json, for following new links in a feed reader or
+// chat. It takes the list filters, such as ?group= or ?tag=, so a team
+// can follow only its own links. Links that aren't active yet are left
+// out until they are.
+func (lf *LinkForwarder) handleFeed(w http.ResponseWriter, r *http.Request) {
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Feeds are always the newest links first
+	q := r.URL.Query()
+	for _, name := range []string{"sort", "order", "page", "per_page", "archived"} {
+		q.Del(name)
+	}
+	opts, err := parseListOptions(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Domain = domain
+	opts.Page, opts.PerPage = 1, feedSize
+
+	links, _, err := lf.listLinks(r.Context(), opts)
+	if err != nil {
+		lf.logf(r, "Failed to list links for the feed: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve links")
+		return
+	}
+
+	now := lf.now()
+	var entries []feedEntry
+	for _, link := range links {
+		if link.CreatedAt == nil || (link.ActiveFrom != nil && link.ActiveFrom.After(now)) {
+			continue
+		}
+		entries = append(entries, lf.newFeedEntry(r, link))
+	}
+
+	title := lf.feedTitle()
+	home := lf.shortURL(r, Link{Domain: domain})
+	self := home + strings.TrimPrefix(r.URL.Path, "/")
+	if r.URL.RawQuery != "" {
+		self += "?" + r.URL.RawQuery
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		lf.writeJSONFeed(w, title, home, self, entries)
+	} else {
+		lf.writeAtomFeed(w, r, title, home, self, entries)
+	}
+}
+
+func (lf *LinkForwarder) writeAtomFeed(w http.ResponseWriter, r *http.Request, title, home, self string, entries []feedEntry) {
+	feed := atomFeed{
+		Title:   "New links on " + title,
+		ID:      self,
+		Updated: lf.now().UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: title},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: home},
+		},
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].created.Format(time.RFC3339)
+	}
+	for _, e := range entries {
+		entry := atomEntry{
+			Title:     e.title,
+			ID:        e.id,
+			Published: e.created.Format(time.RFC3339),
+			Updated:   e.created.Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Href: e.shortURL}},
+			Summary:   e.summary,
+		}
+		if e.url != "" {
+			entry.Links = append(entry.Links, atomLink{Rel: "related", Href: e.url})
+		}
+		if e.owner != "" {
+			entry.Author = &atomPerson{Name: e.owner}
+		}
+		for _, tag := range e.tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		lf.logf(r, "Failed to write the feed: %v", err)
+	}
+}
+
+func (lf *LinkForwarder) writeJSONFeed(w http.ResponseWriter, title, home, self string, entries []feedEntry) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       "New links on " + title,
+		HomePageURL: home,
+		FeedURL:     self,
+		Authors:     []jsonFeedAuthor{{Name: title}},
+		Items:       []jsonFeedItem{},
+	}
+	for _, e := range entries {
+		item := jsonFeedItem{
+			ID:            e.id,
+			URL:           e.shortURL,
+			ExternalURL:   e.url,
+			Title:         e.title,
+			ContentText:   e.summary,
+			DatePublished: e.created.Format(time.RFC3339),
+			Tags:          e.tags,
+		}
+		if e.owner != "" {
+			item.Authors = []jsonFeedAuthor{{Name: e.owner}}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	json.NewEncoder(w).Encode(feed)
+}
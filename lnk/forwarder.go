@@ -0,0 +1,1349 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/oschwald/geoip2-golang"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LinkForwarder is the link shortener: its storage plus the handlers for
+// the management UI, the API, and short links. Create one with New and
+// serve it like any other http.Handler.
+type LinkForwarder struct {
+	handler             http.Handler
+	getenv              func(string) string
+	logger              *log.Logger
+	now                 func() time.Time
+	dataDir             string
+	basePath            string
+	db                  *sql.DB
+	ownsDB              bool // opened by New rather than passed in
+	defaultRedirectType int
+	redirectHeaders     map[string]string
+	redirectCache       redirectCache
+	reserved            map[string]bool
+	referrerSpam        referrerBlocklist
+	rules               shortcodeRules
+	allowedSchemes      map[string]bool
+	blockPrivate        bool
+	domainRules         domainRuleSet
+	redirectRules       redirectRuleSet
+	sessionTTL          time.Duration
+	oidc                *oidcAuth
+	cors                *corsConfig
+	selfHosts           []string
+	customDomains       map[string]bool
+	clickLimitURL       string
+	geoip               *geoip2.Reader
+	fallback            fallbackConfig
+	trustedProxies      []*net.IPNet
+	cache               *linkCache
+	redis               *redisCache
+	events              *eventHub
+	requestTimeout      time.Duration
+	swaggerUI           bool
+	dedupeURLs          bool
+	fetchPages          bool
+	publicFeed          bool
+	bitlyAPI            bool
+	pageClient          *http.Client
+	pageQueue           chan Link
+	linkCheckInterval   time.Duration
+	backups             backupConfig
+	rollups             rollupConfig
+	archive             archiveConfig
+	sync                syncConfig
+	privacy             privacyConfig
+	clickStream         clickPublisher
+	tracer              *sdktrace.TracerProvider
+	accessLog           *accessLog
+	flushing            sync.WaitGroup // workers sending what's queued on Close
+	clickQueue          chan ClickEvent
+	droppedClicks       atomic.Int64 // clicks the stream's queue had no room for
+	cdn                 cdnPurger
+	cdnQueue            chan cdnPurge
+	objects             *objectStore
+	replication         string
+	restoring           sync.Mutex
+	readOnly            bool         // READ_ONLY: serve links and reads, refuse changes
+	debug               http.Handler // profiling endpoints under /debug/
+	devMode             bool
+	themeDir            string
+	pages               fs.FS // templates and static files, the theme's over the embedded ones
+	reloadTheme         bool  // read the theme again on every page, in development mode
+	templates           map[string]*template.Template
+	assetsMu            sync.RWMutex
+	assets              assetSet
+	jobs                []*job
+	jobWake             chan struct{}
+	publicURL           *url.URL
+	telegram            *telegramBot
+	discord             *discordBot
+	// background is cancelled by Close, stopping work that outlives requests
+	background     context.Context
+	stopBackground context.CancelFunc
+}
+
+type Link struct {
+	Domain       string   `json:"domain,omitempty"`
+	Shortcode    string   `json:"shortcode"`
+	URL          string   `json:"url"`
+	RedirectType int      `json:"redirect_type,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	Pinned       bool     `json:"pinned,omitempty"` // listed before the rest
+	Owner        string   `json:"owner,omitempty"`
+	MaxClicks    int      `json:"max_clicks,omitempty"`
+	OneTime      bool     `json:"one_time,omitempty"`
+	Clicks       int      `json:"clicks,omitempty"`
+	ShortURL     string   `json:"short_url,omitempty"`
+
+	IOSURL         string            `json:"ios_url,omitempty"`
+	AndroidURL     string            `json:"android_url,omitempty"`
+	DesktopURL     string            `json:"desktop_url,omitempty"`
+	ForwardQuery   bool              `json:"forward_query,omitempty"`
+	ForwardPath    bool              `json:"forward_path,omitempty"`
+	UTM            *UTM              `json:"utm,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"` // sent with the redirect
+	Aliases        []string          `json:"aliases,omitempty"`
+	GeoRules       []GeoRule         `json:"geo_rules,omitempty"`
+	Variants       []Variant         `json:"variants,omitempty"`
+	StickyVariants bool              `json:"sticky_variants,omitempty"`
+	Untracked      bool              `json:"untracked,omitempty"` // clicks are counted but not logged
+
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"` // set on reads
+	// When the link last redirected someone, if it has; set on reads
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	// When the link was archived for going unused, if it is; set on reads
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+
+	// The destination page's own title and icon, fetched by the server
+	PageTitle     string     `json:"page_title,omitempty"`
+	FaviconURL    string     `json:"favicon_url,omitempty"`
+	PageFetchedAt *time.Time `json:"page_fetched_at,omitempty"`
+	Check         *LinkCheck `json:"check,omitempty"` // set once the link checker has run
+
+	// Password is only accepted on writes; reads report Protected instead.
+	Password       string `json:"password,omitempty"`
+	RemovePassword bool   `json:"remove_password,omitempty"`
+	Protected      bool   `json:"protected,omitempty"`
+	passwordHash   string
+}
+
+// linkColumns is the column list read by scanLink.
+const linkColumns = `domain, shortcode, url, redirect_type, title, description, tags, owner,
+	max_clicks, click_count, password_hash, active_from, active_until, variants, sticky_variants,
+	geo_rules, ios_url, android_url, desktop_url, forward_query, forward_path, utm, created_at,
+	page_title, favicon_url, page_fetched_at, check_status, check_error, broken, checked_at, untracked,
+	group_name, pinned, last_accessed_at, archived_at, headers, ` + aliasesColumn
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanLink reads a link selected with linkColumns.
+func scanLink(row rowScanner) (Link, error) {
+	var link Link
+	var tags string
+	var activeFrom, activeUntil, createdAt, lastAccessedAt, archivedAt, pageFetchedAt, checkedAt sql.NullTime
+	var check LinkCheck
+	var variants, geoRules, utm, headers, aliases string
+	err := row.Scan(&link.Domain, &link.Shortcode, &link.URL, &link.RedirectType, &link.Title, &link.Description, &tags, &link.Owner,
+		&link.MaxClicks, &link.Clicks, &link.passwordHash, &activeFrom, &activeUntil,
+		&variants, &link.StickyVariants, &geoRules,
+		&link.IOSURL, &link.AndroidURL, &link.DesktopURL, &link.ForwardQuery, &link.ForwardPath, &utm,
+		&createdAt, &link.PageTitle, &link.FaviconURL, &pageFetchedAt,
+		&check.Status, &check.Error, &check.Broken, &checkedAt, &link.Untracked, &link.Group, &link.Pinned, &lastAccessedAt, &archivedAt, &headers, &aliases)
+	if err != nil {
+		return link, err
+	}
+	link.Tags = splitTags(tags)
+	link.Aliases = splitAliases(aliases)
+	if link.Variants, err = splitVariants(variants); err != nil {
+		return link, err
+	}
+	if link.GeoRules, err = splitGeoRules(geoRules); err != nil {
+		return link, err
+	}
+	if link.UTM, err = splitUTM(utm); err != nil {
+		return link, err
+	}
+	if link.Headers, err = splitHeaders(headers); err != nil {
+		return link, err
+	}
+	if activeFrom.Valid {
+		link.ActiveFrom = &activeFrom.Time
+	}
+	if activeUntil.Valid {
+		link.ActiveUntil = &activeUntil.Time
+	}
+	if createdAt.Valid {
+		link.CreatedAt = &createdAt.Time
+	}
+	if lastAccessedAt.Valid {
+		link.LastAccessedAt = &lastAccessedAt.Time
+	}
+	if archivedAt.Valid {
+		link.ArchivedAt = &archivedAt.Time
+	}
+	if pageFetchedAt.Valid {
+		link.PageFetchedAt = &pageFetchedAt.Time
+	}
+	if checkedAt.Valid {
+		check.CheckedAt = checkedAt.Time
+		link.Check = &check
+	}
+	link.Protected = link.passwordHash != ""
+	link.OneTime = link.MaxClicks == 1
+	return link, nil
+}
+
+// validRedirectTypes are the HTTP status codes a link may redirect with.
+var validRedirectTypes = map[int]bool{
+	http.StatusMovedPermanently:  true, // 301
+	http.StatusFound:             true, // 302
+	http.StatusTemporaryRedirect: true, // 307
+	http.StatusPermanentRedirect: true, // 308
+}
+
+var errLinkNotFound = errors.New("shortcode not found")
+
+type Response struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	Code      string `json:"code,omitempty"`       // set on errors; see errorCodes
+	RequestID string `json:"request_id,omitempty"` // set on errors, to quote when reporting them
+	Data      any    `json:"data,omitempty"`
+	Meta      any    `json:"meta,omitempty"`
+}
+
+// New sets up a forwarder and its database. It only reads configuration
+// from the environment when given WithEnv; the options documented next to
+// each setting override it. Call Close when done with it.
+func New(opts ...Option) (*LinkForwarder, error) {
+	lf := &LinkForwarder{
+		getenv: func(string) string { return "" },
+		logger: log.Default(),
+		now:    time.Now,
+		events: newEventHub(),
+	}
+	lf.background, lf.stopBackground = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(lf)
+	}
+
+	if lf.dataDir == "" {
+		lf.dataDir = lf.getenv("DATA_DIR")
+	}
+	if lf.dataDir == "" {
+		lf.dataDir = ".crush"
+	}
+
+	// Queries are traced too, so the tracer comes before the database. The
+	// client addresses on spans are anonymized once IP_ANONYMIZATION is read
+	var err error
+	if lf.tracer, err = loadTracer(lf.getenv, func(ip string) string { return lf.privacy.anonymizeIP(ip) }); err != nil {
+		return nil, err
+	}
+	if lf.db == nil {
+		db, err := lf.openDatabase()
+		if err != nil {
+			lf.Close()
+			return nil, err
+		}
+		lf.db = db
+		lf.ownsDB = true
+	}
+
+	if err := lf.configure(context.Background()); err != nil {
+		lf.Close()
+		return nil, err
+	}
+	lf.handler = lf.routes()
+	return lf, nil
+}
+
+// openDatabase opens the SQLite database in the data directory, or at
+// DB_PATH.
+func (lf *LinkForwarder) openDatabase() (*sql.DB, error) {
+	// SQLite is the only supported database for now
+	if driver := lf.getenv("DB_DRIVER"); driver != "" && driver != "sqlite" && driver != "sqlite3" {
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q: only sqlite is supported", driver)
+	}
+
+	dbPath := lf.getenv("DB_PATH")
+	if dbPath == "" {
+		// Ensure data directory exists
+		if err := os.MkdirAll(lf.dataDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory %s: %v", lf.dataDir, err)
+		}
+		dbPath = filepath.Join(lf.dataDir, "links.db")
+	}
+	var err error
+	if lf.replication, err = loadReplication(lf.getenv); err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(dbPath, sqliteDriver(lf.replication), lf.tracer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	return db, nil
+}
+
+// configure loads the remaining settings and brings the database schema up
+// to date.
+func (lf *LinkForwarder) configure(ctx context.Context) error {
+	if lf.basePath == "" {
+		lf.basePath = lf.getenv("BASE_PATH")
+	}
+	var err error
+	if lf.basePath, err = normalizeBasePath(lf.basePath); err != nil {
+		return err
+	}
+
+	// Get the server-wide redirect status code, default to 302
+	lf.defaultRedirectType = http.StatusFound
+	if v := lf.getenv("DEFAULT_REDIRECT_TYPE"); v != "" {
+		code, err := strconv.Atoi(v)
+		if err != nil || !validRedirectTypes[code] {
+			return fmt.Errorf("invalid DEFAULT_REDIRECT_TYPE %q: must be 301, 302, 307, or 308", v)
+		}
+		lf.defaultRedirectType = code
+	}
+	if lf.redirectHeaders, err = loadRedirectHeaders(lf.getenv); err != nil {
+		return err
+	}
+	if lf.redirectCache, err = loadRedirectCache(lf.getenv); err != nil {
+		return err
+	}
+	if lf.cors, err = loadCORS(lf.getenv); err != nil {
+		return err
+	}
+
+	lf.reserved = loadReservedShortcodes(lf.getenv)
+	lf.referrerSpam = loadReferrerBlocklist(lf.getenv)
+	lf.selfHosts = loadSelfHosts(lf.getenv)
+	lf.customDomains = loadCustomDomains(lf.getenv)
+	lf.allowedSchemes = loadAllowedSchemes(lf.getenv)
+	lf.clickLimitURL = lf.getenv("CLICK_LIMIT_URL")
+	lf.blockPrivate, _ = strconv.ParseBool(lf.getenv("BLOCK_PRIVATE_DESTINATIONS"))
+	lf.swaggerUI, _ = strconv.ParseBool(lf.getenv("SWAGGER_UI"))
+	lf.dedupeURLs, _ = strconv.ParseBool(lf.getenv("DEDUPLICATE_URLS"))
+	lf.fetchPages, _ = strconv.ParseBool(lf.getenv("FETCH_PAGE_INFO"))
+	lf.publicFeed, _ = strconv.ParseBool(lf.getenv("PUBLIC_FEED"))
+	lf.bitlyAPI, _ = strconv.ParseBool(lf.getenv("BITLY_API"))
+	if lf.bitlyAPI {
+		lf.reserved["v4"] = true
+	}
+	if !lf.readOnly {
+		lf.readOnly, _ = strconv.ParseBool(lf.getenv("READ_ONLY"))
+	}
+	lf.pageClient = lf.newPageClient()
+	if err := lf.loadTheme(); err != nil {
+		return err
+	}
+	if lf.privacy, err = loadPrivacy(lf.getenv); err != nil {
+		return err
+	}
+	if lf.clickStream, err = loadClickStream(lf.getenv); err != nil {
+		return err
+	}
+	if lf.accessLog, err = loadAccessLog(lf.getenv); err != nil {
+		return err
+	}
+	if lf.rules, err = loadShortcodeRules(lf.getenv); err != nil {
+		return err
+	}
+	if lf.sessionTTL, err = loadSessionTTL(lf.getenv); err != nil {
+		return err
+	}
+	if lf.oidc, err = loadOIDC(ctx, lf.getenv); err != nil {
+		return err
+	}
+	if lf.requestTimeout, err = loadRequestTimeout(lf.getenv); err != nil {
+		return err
+	}
+	if lf.linkCheckInterval, err = loadLinkCheckInterval(lf.getenv); err != nil {
+		return err
+	}
+	if lf.objects, err = loadObjectStore(lf.getenv); err != nil {
+		return err
+	}
+	if lf.backups, err = loadBackupConfig(lf.getenv, lf.dataDir); err != nil {
+		return err
+	}
+	if lf.rollups, err = loadRollupConfig(lf.getenv); err != nil {
+		return err
+	}
+	if lf.archive, err = loadArchiveConfig(lf.getenv); err != nil {
+		return err
+	}
+	if lf.sync, err = loadSyncConfig(lf.getenv, lf.sync.file); err != nil {
+		return err
+	}
+	if lf.jobs, err = lf.loadJobs(); err != nil {
+		return err
+	}
+	if lf.cache, err = loadLinkCache(lf.getenv); err != nil {
+		return err
+	}
+	if lf.redis, err = loadRedis(lf.getenv, lf.logger); err != nil {
+		return err
+	}
+	if lf.trustedProxies, err = loadTrustedProxies(lf.getenv); err != nil {
+		return err
+	}
+	if lf.fallback, err = loadFallback(lf.getenv); err != nil {
+		return err
+	}
+	if lf.geoip, err = loadGeoIP(lf.getenv, lf.logger); err != nil {
+		return err
+	}
+	if lf.publicURL, err = loadPublicURL(lf.getenv); err != nil {
+		return err
+	}
+	if lf.telegram, err = loadTelegram(lf.getenv); err != nil {
+		return err
+	}
+	if lf.discord, err = loadDiscord(lf.getenv); err != nil {
+		return err
+	}
+	if (lf.telegram != nil || lf.discord != nil) && lf.publicURL == nil {
+		return errors.New("PUBLIC_URL is required with TELEGRAM_BOT_TOKEN or DISCORD_PUBLIC_KEY")
+	}
+	if lf.cdn, err = loadCDNPurge(lf.getenv); err != nil {
+		return err
+	}
+	// The CDN caches short URLs by the host visitors use
+	if lf.cdn != nil && lf.publicURL == nil {
+		return errors.New("PUBLIC_URL is required with CDN_PURGE")
+	}
+	if err := lf.initDB(ctx); err != nil {
+		return fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	if err := lf.loadDomainRules(ctx); err != nil {
+		return fmt.Errorf("failed to load domain rules: %v", err)
+	}
+
+	if err := lf.loadRedirectRules(ctx); err != nil {
+		return fmt.Errorf("failed to load redirect rules: %v", err)
+	}
+
+	if err := lf.bootstrapAdmin(ctx); err != nil {
+		return fmt.Errorf("failed to create admin account: %v", err)
+	}
+	if lf.debug != nil {
+		if enabled, err := lf.authEnabled(ctx); err == nil && !enabled {
+			lf.logger.Printf("Debug endpoints refuse every request until an admin account exists; set ADMIN_PASSWORD")
+		}
+	}
+
+	if lf.redis != nil {
+		go lf.redis.watch(lf.cache, lf.events, lf.reloadRules)
+	}
+	if lf.telegram != nil {
+		go lf.pollTelegram(lf.background)
+	}
+	if lf.fetchPages {
+		lf.pageQueue = make(chan Link, pageQueueSize)
+		go lf.fetchQueuedPages(lf.background)
+	}
+	if lf.clickStream != nil && !lf.readOnly {
+		lf.clickQueue = make(chan ClickEvent, clickQueueSize)
+		lf.flush(lf.runClickStream)
+	}
+	if lf.cdn != nil && !lf.readOnly {
+		lf.cdnQueue = make(chan cdnPurge, cdnPurgeQueueSize)
+		lf.flush(lf.runCDNPurge)
+	}
+	// A links file that can't be applied is caught on deploy, not a
+	// minute later in the log
+	if lf.sync.file != "" && !lf.readOnly {
+		result, err := lf.syncFromFile(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to sync links: %v", err)
+		}
+		lf.logger.Printf("Synced links from %s: %s", lf.sync.file, syncSummary(result))
+	}
+	lf.jobWake = make(chan struct{}, 1)
+	go lf.runScheduler(lf.background)
+
+	return nil
+}
+
+// DataDir returns the directory holding the database and other state.
+func (lf *LinkForwarder) DataDir() string {
+	return lf.dataDir
+}
+
+// CustomDomains returns the extra hostnames that serve their own links.
+func (lf *LinkForwarder) CustomDomains() []string {
+	domains := make([]string, 0, len(lf.customDomains))
+	for d := range lf.customDomains {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func (lf *LinkForwarder) initDB(ctx context.Context) error {
+	return lf.migrate(ctx)
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SeedDefaultLinks creates the demo links unless they already exist, so
+// restarting the server doesn't overwrite edits to them. A read-only
+// server, or one whose links come from SYNC_FILE, has nothing seeded.
+func (lf *LinkForwarder) SeedDefaultLinks(ctx context.Context) error {
+	if lf.readOnly || lf.sync.file != "" {
+		return nil
+	}
+	defaults := []Link{
+		{Shortcode: "google", URL: "https://www.google.com"},
+		{Shortcode: "github", URL: "https://github.com"},
+	}
+	for _, link := range defaults {
+		if _, err := lf.getLink(ctx, "", link.Shortcode); !errors.Is(err, errLinkNotFound) {
+			continue
+		}
+		if err := lf.saveLink(ctx, link, "system"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeTimeout is how long Close gives each worker to send what it still
+// has queued: spans, clicks, and CDN purges.
+const closeTimeout = 10 * time.Second
+
+// flush runs a worker in the background that, once Close cancels its
+// context, sends what it still has queued before Close returns.
+func (lf *LinkForwarder) flush(run func(ctx context.Context)) {
+	lf.flushing.Add(1)
+	go func() {
+		defer lf.flushing.Done()
+		run(lf.background)
+	}()
+}
+
+// Close releases the forwarder's connections, once the spans, clicks, and
+// CDN purges still queued are sent. Call it after the HTTP server has shut
+// down, so no more are queued. A database passed in with WithStorage is
+// left open for its owner to close.
+func (lf *LinkForwarder) Close() error {
+	lf.stopBackground()
+	lf.flushing.Wait()
+	if lf.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		if err := lf.tracer.Shutdown(ctx); err != nil {
+			lf.logger.Printf("Failed to export trace spans: %v", err)
+		}
+		cancel()
+	}
+	if lf.accessLog != nil {
+		lf.accessLog.close()
+	}
+	if lf.geoip != nil {
+		lf.geoip.Close()
+	}
+	if lf.redis != nil {
+		lf.redis.Close()
+	}
+	if !lf.ownsDB {
+		return nil
+	}
+	return lf.db.Close()
+}
+
+// saveLink creates or replaces a link and records the change in its history.
+// The URL must already have been checked with validateURL.
+func (lf *LinkForwarder) saveLink(ctx context.Context, link Link, actor string) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	action, err := saveLinkTx(ctx, tx, link, actor)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	lf.invalidateLinks()
+	lf.publish(Event{Type: action, Domain: link.Domain, Shortcode: link.Shortcode, URL: link.URL, Actor: actor})
+	lf.purgeCDN(link.Domain, link.Shortcode)
+	lf.queuePageInfo(link)
+	return nil
+}
+
+// saveLinkTx does the work of saveLink as part of tx, returning whether
+// the link was created or updated as a history action.
+func saveLinkTx(ctx context.Context, tx *sql.Tx, link Link, actor string) (string, error) {
+	previous, err := scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM links WHERE domain = ? AND shortcode = ?`,
+		link.Domain, link.Shortcode))
+	action := historyUpdate
+	if err == sql.ErrNoRows {
+		action = historyCreate
+	} else if err != nil {
+		return "", err
+	}
+
+	// The owner and click count are set when a link is created and kept on updates
+	if action == historyUpdate {
+		link.Owner = previous.Owner
+		link.Clicks = previous.Clicks
+	}
+
+	query := `INSERT INTO links (domain, shortcode, url, redirect_type, title, description, tags, owner,
+			max_clicks, click_count, password_hash, active_from, active_until, variants, sticky_variants, geo_rules,
+			ios_url, android_url, desktop_url, forward_query, forward_path, utm, untracked, group_name, pinned, headers)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain, shortcode) DO UPDATE SET
+			url = excluded.url,
+			redirect_type = excluded.redirect_type,
+			title = excluded.title,
+			description = excluded.description,
+			tags = excluded.tags,
+			max_clicks = excluded.max_clicks,
+			password_hash = excluded.password_hash,
+			active_from = excluded.active_from,
+			active_until = excluded.active_until,
+			variants = excluded.variants,
+			sticky_variants = excluded.sticky_variants,
+			geo_rules = excluded.geo_rules,
+			ios_url = excluded.ios_url,
+			android_url = excluded.android_url,
+			desktop_url = excluded.desktop_url,
+			forward_query = excluded.forward_query,
+			forward_path = excluded.forward_path,
+			utm = excluded.utm,
+			untracked = excluded.untracked,
+			group_name = excluded.group_name,
+			pinned = excluded.pinned,
+			headers = excluded.headers,
+			page_title = CASE WHEN links.url = excluded.url THEN links.page_title ELSE '' END,
+			favicon_url = CASE WHEN links.url = excluded.url THEN links.favicon_url ELSE '' END,
+			page_fetched_at = CASE WHEN links.url = excluded.url THEN links.page_fetched_at END,
+			check_status = CASE WHEN links.url = excluded.url THEN links.check_status ELSE 0 END,
+			check_error = CASE WHEN links.url = excluded.url THEN links.check_error ELSE '' END,
+			broken = CASE WHEN links.url = excluded.url THEN links.broken ELSE 0 END,
+			checked_at = CASE WHEN links.url = excluded.url THEN links.checked_at END`
+	variants, err := joinVariants(link.Variants)
+	if err != nil {
+		return "", err
+	}
+	geoRules, err := joinGeoRules(link.GeoRules)
+	if err != nil {
+		return "", err
+	}
+	utm, err := joinUTM(link.UTM)
+	if err != nil {
+		return "", err
+	}
+	headers, err := joinHeaders(link.Headers)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, query, link.Domain, link.Shortcode, link.URL, link.RedirectType,
+		link.Title, link.Description, joinTags(link.Tags), link.Owner, link.MaxClicks, link.Clicks, link.passwordHash,
+		link.ActiveFrom, link.ActiveUntil, variants, link.StickyVariants, geoRules,
+		link.IOSURL, link.AndroidURL, link.DesktopURL, link.ForwardQuery, link.ForwardPath, utm, link.Untracked, link.Group, link.Pinned, headers); err != nil {
+		return "", err
+	}
+	if err := ensureGroupTx(ctx, tx, link); err != nil {
+		return "", err
+	}
+
+	var prev *Link
+	if action == historyUpdate {
+		prev = &previous
+	}
+	return action, recordHistory(ctx, tx, link.Domain, link.Shortcode, action, actor, prev, &link)
+}
+
+func (lf *LinkForwarder) getLink(ctx context.Context, domain, shortcode string) (Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM links WHERE domain = ? AND shortcode = ?`
+	link, err := scanLink(lf.db.QueryRowContext(ctx, query, domain, shortcode))
+	if err == sql.ErrNoRows {
+		return Link{}, errLinkNotFound
+	}
+	return link, err
+}
+
+// redirectStatus returns the status code to redirect a link with, falling
+// back to the server-wide default when the link doesn't set one.
+func (lf *LinkForwarder) redirectStatus(link Link) int {
+	if link.RedirectType != 0 {
+		return link.RedirectType
+	}
+	return lf.defaultRedirectType
+}
+
+// deleteLink removes a link and records the deletion in its history.
+func (lf *LinkForwarder) deleteLink(ctx context.Context, domain, shortcode, actor string) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteLinkTx(ctx, tx, domain, shortcode, actor)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	lf.invalidateLinks()
+	lf.publish(Event{Type: historyDelete, Domain: domain, Shortcode: shortcode, Actor: actor})
+	lf.purgeCDN(domain, shortcode, deleted.Aliases...)
+	return nil
+}
+
+// deleteLinkTx does the work of deleteLink as part of tx, returning the
+// link as it was.
+func deleteLinkTx(ctx context.Context, tx *sql.Tx, domain, shortcode, actor string) (Link, error) {
+	previous, err := scanLink(tx.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM links WHERE domain = ? AND shortcode = ?`,
+		domain, shortcode))
+	if err == sql.ErrNoRows {
+		return Link{}, errLinkNotFound
+	} else if err != nil {
+		return Link{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM links WHERE domain = ? AND shortcode = ?`, domain, shortcode); err != nil {
+		return Link{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM aliases WHERE domain = ? AND shortcode = ?`, domain, shortcode); err != nil {
+		return Link{}, err
+	}
+
+	return previous, recordHistory(ctx, tx, domain, shortcode, historyDelete, actor, &previous, nil)
+}
+
+func (lf *LinkForwarder) handleForward(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortcode := lf.rules.normalize(vars["shortcode"])
+
+	lf.logf(r, "handleForward called for path: %s, shortcode: '%s'", r.URL.Path, shortcode)
+
+	// A trailing "+" asks for a preview of the destination instead of a redirect
+	preview := strings.HasSuffix(shortcode, "+")
+	shortcode = strings.TrimSuffix(shortcode, "+")
+
+	if shortcode == "" {
+		lf.logf(r, "Empty shortcode received, sending error")
+		http.Error(w, "Shortcode is required", http.StatusBadRequest)
+		return
+	}
+
+	// Anything after the shortcode, as in /docs/installation
+	suffix := vars["path"]
+	if suffix != "" && lf.reserved[strings.ToLower(shortcode)] {
+		// Unknown paths under /api, /static, etc. aren't short links
+		http.NotFound(w, r)
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("lnk.shortcode", shortcode))
+	link, err := lf.lookupLink(r.Context(), lf.requestDomain(r), shortcode)
+	if err == nil && suffix != "" && !link.acceptsSuffix() {
+		err = errLinkNotFound
+	}
+	if err != nil && !errors.Is(err, errLinkNotFound) {
+		// A timed-out or failed query doesn't mean the link is missing
+		lf.logf(r, "Failed to look up %s: %v", shortcode, err)
+		http.Error(w, "Failed to follow link", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		if destination, status, ok := lf.matchRedirectRule(r.URL.Path); ok {
+			lf.logf(r, "Forwarding %s to %s by redirect rule (%d)", r.URL.Path, destination, status)
+			http.Redirect(w, r, destination, status)
+			return
+		}
+
+		if r.Method != http.MethodHead && !lf.readOnly && !lf.referrerSpam.blocks(referrerHost(r)) {
+			referrer := ""
+			if lf.tracking(r) {
+				referrer = referrerHost(r)
+			}
+			if err := lf.recordUnknownShortcode(r.Context(), lf.requestDomain(r), shortcode, referrer); err != nil {
+				lf.logf(r, "Failed to record request for unknown shortcode %s: %v", shortcode, err)
+			}
+		}
+		lf.handleUnknownShortcode(w, r, shortcode)
+		return
+	}
+
+	if !link.activeAt(lf.now()) {
+		lf.renderOutsideWindow(w, link, lf.now())
+		return
+	}
+
+	if link.ArchivedAt != nil && !lf.archive.redirect {
+		lf.handleArchivedLink(w, r, link)
+		return
+	}
+
+	if link.Protected && !lf.unlockLink(w, r, link) {
+		return
+	}
+
+	destination, variant := link.URL, ""
+	if !preview {
+		destination, variant = lf.resolveDestination(w, r, link, suffix)
+	}
+
+	if err := lf.checkDomain(destination); err != nil {
+		lf.logf(r, "Refusing to forward %s: %v", shortcode, err)
+		http.Error(w, "This link's destination has been blocked", http.StatusForbidden)
+		return
+	}
+
+	if preview {
+		if link.exhausted() {
+			lf.renderClickLimitReached(w, r, link)
+			return
+		}
+		lf.renderPreview(w, link)
+		return
+	}
+
+	// HEAD requests (link checkers, unfurlers) and referrer spam don't use
+	// up a click, and a read-only server can't count one
+	referrer := referrerHost(r)
+	if r.Method == http.MethodHead || lf.readOnly || lf.referrerSpam.blocks(referrer) {
+		if link.exhausted() {
+			lf.renderClickLimitReached(w, r, link)
+			return
+		}
+	} else {
+		logged := lf.tracking(r) && !link.Untracked
+		ok, err := lf.recordClick(r.Context(), link, variant, referrer, logged)
+		if err != nil {
+			lf.logf(r, "Failed to record click for %s: %v", shortcode, err)
+			http.Error(w, "Failed to follow link", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			lf.renderClickLimitReached(w, r, link)
+			return
+		}
+		lf.publish(Event{Type: eventClick, Domain: link.Domain, Shortcode: link.Shortcode, URL: destination, Variant: variant})
+		if logged {
+			lf.streamClick(r, link, destination, variant, referrer)
+		}
+	}
+
+	status := lf.redirectStatus(link)
+	if r.Method == http.MethodPost {
+		// After a password form, a 307 or 308 would have the browser post
+		// the password on to the destination
+		status = http.StatusSeeOther
+	}
+	lf.logf(r, "Forwarding %s to %s (%d)", shortcode, destination, status)
+	notModified := lf.setRedirectCache(w, r, link, status, destination)
+	lf.setRedirectHeaders(w, link)
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	http.Redirect(w, r, destination, status)
+}
+
+func (lf *LinkForwarder) handleAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		opts, err := parseListOptions(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if opts.Domain, err = lf.apiDomain(r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Match URLs the way they were stored, with a scheme added
+		if opts.URL != "" {
+			if normalized, err := lf.validateURL(opts.URL); err == nil {
+				opts.URL = normalized
+			}
+		}
+
+		links, meta, err := lf.listLinks(r.Context(), opts)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve links")
+			return
+		}
+		for i := range links {
+			links[i].ShortURL = lf.shortURL(r, links[i])
+		}
+
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Links retrieved successfully",
+			Data:    links,
+			Meta:    &meta,
+		})
+
+	case "POST", "PUT":
+		var link Link
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+
+		// PUT replaces the link named in the path and never creates one
+		update := r.Method == "PUT"
+		if update {
+			shortcode := mux.Vars(r)["shortcode"]
+			if link.Shortcode != "" && lf.rules.normalize(link.Shortcode) != lf.rules.normalize(shortcode) {
+				writeError(w, http.StatusBadRequest, "Shortcode in the body doesn't match the URL")
+				return
+			}
+			link.Shortcode = shortcode
+		}
+
+		link, apiErr := lf.prepareRequestLink(r, link, update)
+		if apiErr != nil {
+			writeError(w, apiErr.status, apiErr.message)
+			return
+		}
+
+		if !update {
+			existing, found, err := lf.existingDuplicate(r.Context(), link)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to save link")
+				return
+			}
+			if found {
+				existing.ShortURL = lf.shortURL(r, existing)
+				writeJSON(w, http.StatusOK, Response{
+					Success: true,
+					Message: "Existing link returned",
+					Data:    existing,
+				})
+				return
+			}
+		}
+
+		if err := lf.saveLink(r.Context(), link, lf.requestActor(r)); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to save link")
+			return
+		}
+		link.ShortURL = lf.shortURL(r, link)
+
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Link saved successfully",
+			Data:    link,
+		})
+
+	case "DELETE":
+		vars := mux.Vars(r)
+		shortcode := lf.rules.normalize(vars["shortcode"])
+
+		if shortcode == "" {
+			writeError(w, http.StatusBadRequest, "Shortcode is required")
+			return
+		}
+
+		domain, err := lf.apiDomain(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if apiErr := lf.checkDelete(r.Context(), requestEditor(r), domain, shortcode); apiErr != nil {
+			writeError(w, apiErr.status, apiErr.message)
+			return
+		}
+
+		if err := lf.deleteLink(r.Context(), domain, shortcode, lf.requestActor(r)); err != nil {
+			if errors.Is(err, errLinkNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "Failed to delete link")
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Link deleted successfully",
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// editor is who links are changed for: an account and the API token it's
+// using, either of which may be nil, as when accounts are off or the
+// change comes from SYNC_FILE.
+type editor struct {
+	user  *User
+	token *APIToken
+}
+
+// requestEditor returns who's making r.
+func requestEditor(r *http.Request) editor {
+	return editor{user: currentUser(r), token: currentToken(r)}
+}
+
+// prepareRequestLink is prepareLink for a link sent in an API request,
+// which goes in the request's namespace unless it names its own.
+func (lf *LinkForwarder) prepareRequestLink(r *http.Request, link Link, update bool) (Link, *apiError) {
+	if link.Domain == "" {
+		domain, err := lf.apiDomain(r)
+		if err != nil {
+			return link, &apiError{http.StatusBadRequest, err.Error()}
+		}
+		link.Domain = domain
+	}
+	return lf.prepareLink(r.Context(), requestEditor(r), r.Host, link, update)
+}
+
+// prepareLink validates and normalizes a link to be created by by, or with
+// update set, to replace an existing link. link.Domain is its namespace,
+// and host the server host the change came in on, which the link mustn't
+// redirect back to. It fills in what's kept from the existing link, such
+// as its owner, and checks that by may change it.
+func (lf *LinkForwarder) prepareLink(ctx context.Context, by editor, host string, link Link, update bool) (Link, *apiError) {
+	if link.Shortcode == "" || link.URL == "" {
+		return link, &apiError{http.StatusBadRequest, "Shortcode and URL are required"}
+	}
+
+	var err error
+	if link.Domain, err = lf.checkCustomDomain(link.Domain); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	link.Shortcode = lf.rules.normalize(link.Shortcode)
+	if err := lf.validateShortcode(link.Shortcode); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if link.RedirectType != 0 && !validRedirectTypes[link.RedirectType] {
+		return link, &apiError{http.StatusBadRequest, "redirect_type must be 301, 302, 307, or 308"}
+	}
+
+	if err := normalizeActiveWindow(&link); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if err := normalizeClickLimit(&link); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	link.Title = strings.TrimSpace(link.Title)
+	link.Description = strings.TrimSpace(link.Description)
+	link.Tags = normalizeTags(link.Tags)
+	if err := validateMetadata(link); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	if link.Group, err = normalizeGroup(link.Group); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if err := normalizeUTM(&link); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if err := normalizeHeaders(&link); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	validURL, err := lf.checkDestination(ctx, link, link.URL, host)
+	if err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	link.URL = validURL
+
+	if err := lf.normalizeVariants(ctx, &link, host); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if err := lf.normalizeGeoRules(ctx, &link, host); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if err := lf.normalizeDeviceURLs(ctx, &link, host); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	// New links belong to their creator; existing ones keep their owner
+	existing, err := lf.getLink(ctx, link.Domain, link.Shortcode)
+	switch {
+	case err == nil:
+		if by.user != nil && !by.user.canEdit(existing) {
+			return link, &apiError{http.StatusForbidden, "You can only change links you own"}
+		}
+		if by.token != nil && !by.token.allows(scopeWrite) {
+			return link, &apiError{http.StatusForbidden, fmt.Sprintf("'%s' already exists and this token can only create links", link.Shortcode)}
+		}
+		link.Owner = existing.Owner
+		link.Clicks = existing.Clicks
+		if !link.RemovePassword {
+			link.passwordHash = existing.passwordHash
+		}
+	case errors.Is(err, errLinkNotFound) && update:
+		return link, &apiError{http.StatusNotFound, err.Error()}
+	case errors.Is(err, errLinkNotFound):
+		if target, err := lf.resolveAlias(ctx, link.Domain, link.Shortcode); err == nil {
+			return link, &apiError{http.StatusConflict, fmt.Sprintf("'%s' is already an alias of '%s'", link.Shortcode, target)}
+		}
+		link.Owner = ""
+		if by.user != nil {
+			link.Owner = by.user.Username
+		}
+		// Admins may carry clicks over from another shortener
+		if link.Clicks < 0 {
+			return link, &apiError{http.StatusBadRequest, "clicks must not be negative"}
+		}
+		if by.user != nil && !by.user.IsAdmin() {
+			link.Clicks = 0
+		}
+	default:
+		return link, &apiError{http.StatusInternalServerError, "Failed to save link"}
+	}
+
+	if err := setLinkPassword(&link); err != nil {
+		return link, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	return link, nil
+}
+
+// checkDelete reports why a link can't be deleted by by, if it can't.
+func (lf *LinkForwarder) checkDelete(ctx context.Context, by editor, domain, shortcode string) *apiError {
+	existing, err := lf.getLink(ctx, domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		return &apiError{http.StatusNotFound, err.Error()}
+	} else if err != nil {
+		return &apiError{http.StatusInternalServerError, "Failed to delete link"}
+	}
+	if by.user != nil && !by.user.canEdit(existing) {
+		return &apiError{http.StatusForbidden, "You can only delete links you own"}
+	}
+	return nil
+}
+
+// LinkDetail is a single link as returned by GET /api/v1/links/{shortcode},
+// with its click stats alongside.
+type LinkDetail struct {
+	Link
+	Stats LinkStats `json:"stats"`
+}
+
+func (lf *LinkForwarder) handleGetLink(w http.ResponseWriter, r *http.Request) {
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, err := lf.getLink(r.Context(), domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		resp := Response{
+			Success:   false,
+			Message:   err.Error(),
+			Code:      errorCode(http.StatusNotFound),
+			RequestID: w.Header().Get(requestIDHeader),
+		}
+		// ?suggest=1 asks what the caller might have meant instead
+		if r.URL.Query().Get("suggest") == "1" {
+			suggestions, err := lf.suggestShortcodes(r.Context(), domain, shortcode)
+			if err != nil {
+				lf.logf(r, "Failed to find shortcodes like %s: %v", shortcode, err)
+				writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+				return
+			}
+			resp.Data = Suggestions{Suggestions: suggestions}
+		}
+		writeJSON(w, http.StatusNotFound, resp)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+	stats, err := lf.getStats(r.Context(), domain, shortcode)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve stats")
+		return
+	}
+	link.ShortURL = lf.shortURL(r, link)
+
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Link retrieved successfully",
+		Data:    LinkDetail{Link: link, Stats: stats},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// errorCodes are the machine-readable codes of failed API requests, one
+// per status. They're part of the v1 API: codes may be added, but an
+// existing one never changes meaning, so scripts can match on code rather
+// than on the message, which is meant for people and may be reworded.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "bad_gateway",
+	http.StatusServiceUnavailable:  "service_unavailable",
+}
+
+// errorCode returns the code of failed requests with status.
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, Response{
+		Success:   false,
+		Message:   message,
+		Code:      errorCode(status),
+		RequestID: w.Header().Get(requestIDHeader),
+	})
+}
+
+// apiError is an API request that can't be carried out, with the status
+// and message to report it with.
+type apiError struct {
+	status  int
+	message string
+}
+
+type TemplateData struct {
+	Shortcode    string
+	ErrorMessage string
+	User         *User
+	ReadOnly     bool
+	Maintenance  bool   // shown to admins, who can still use the UI
+	CSRFToken    string // sent back with the page's changes
+}
+
+// PreviewData is rendered by the preview interstitial page.
+type PreviewData struct {
+	Shortcode    string
+	URL          string
+	RedirectType int
+}
+
+// renderPreview shows where a link goes without redirecting.
+func (lf *LinkForwarder) renderPreview(w http.ResponseWriter, link Link) {
+	tmpl, err := lf.loadTemplate("preview.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logger.Printf("Template error: %v", err)
+		return
+	}
+
+	data := PreviewData{
+		Shortcode:    link.Shortcode,
+		URL:          link.URL,
+		RedirectType: lf.redirectStatus(link),
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	// Previews are for humans; keep them out of search indexes
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logger.Printf("Template execution error: %v", err)
+	}
+}
+
+func (lf *LinkForwarder) handleHome(w http.ResponseWriter, r *http.Request) {
+	lf.logf(r, "handleHome called for path: %s", r.URL.Path)
+
+	tmpl, err := lf.loadTemplate("home.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+
+	lf.logf(r, "Template loaded successfully")
+
+	// Get query parameters
+	shortcode := r.URL.Query().Get("shortcode")
+	errorType := r.URL.Query().Get("error")
+
+	var errorMessage string
+	if errorType == "not_found" {
+		errorMessage = fmt.Sprintf("Link '/%s' doesn't exist yet. You can create it below!", shortcode)
+	}
+
+	data := TemplateData{
+		Shortcode:    shortcode,
+		ErrorMessage: errorMessage,
+		User:         currentUser(r),
+		ReadOnly:     lf.readOnly,
+		CSRFToken:    requestCSRFToken(r),
+	}
+	if m, err := lf.maintenance(r.Context()); err != nil {
+		lf.logf(r, "Failed to check maintenance mode: %v", err)
+	} else {
+		data.Maintenance = m.Enabled
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+		return
+	}
+	lf.logf(r, "Template executed successfully")
+}
@@ -0,0 +1,75 @@
+package lnk
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestForwarder returns a forwarder with a database of its own in a
+// temporary directory, configured by env.
+func newTestForwarder(t *testing.T, env map[string]string) *LinkForwarder {
+	t.Helper()
+	lf, err := New(
+		WithDataDir(t.TempDir()),
+		WithEnv(func(name string) string { return env[name] }),
+		WithLogger(log.New(io.Discard, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { lf.Close() })
+	return lf
+}
+
+// testUser creates an account.
+func testUser(t *testing.T, lf *LinkForwarder, username, role string) *User {
+	t.Helper()
+	user, err := lf.createUser(context.Background(), username, "password-"+username, role)
+	if err != nil {
+		t.Fatalf("createUser %s: %v", username, err)
+	}
+	return user
+}
+
+// testSession logs user in, returning the session cookie.
+func testSession(t *testing.T, lf *LinkForwarder, user *User) *http.Cookie {
+	t.Helper()
+	token, _, err := lf.createSession(context.Background(), user)
+	if err != nil {
+		t.Fatalf("createSession: %v", err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: token}
+}
+
+// testToken creates an API token for user with scopes.
+func testToken(t *testing.T, lf *LinkForwarder, user *User, scopes ...string) string {
+	t.Helper()
+	token, err := lf.createToken(context.Background(), user, "test", scopes, nil)
+	if err != nil {
+		t.Fatalf("createToken: %v", err)
+	}
+	return token.Token
+}
+
+// serve sends a request to the forwarder, with a JSON body unless body is
+// empty.
+func serve(lf *LinkForwarder, method, target, body string, edit func(r *http.Request)) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if edit != nil {
+		edit(r)
+	}
+	w := httptest.NewRecorder()
+	lf.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,133 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// maxGeoRules limits how many regional destinations one link can have.
+const maxGeoRules = 50
+
+var (
+	countryCodePattern   = regexp.MustCompile(`^[A-Z]{2}$`)
+	continentCodePattern = regexp.MustCompile(`^(AF|AN|AS|EU|NA|OC|SA)$`)
+)
+
+// GeoRule sends visitors from a country (ISO 3166-1 alpha-2 code such as
+// "DE") or continent ("EU") to a different destination. Rules are tried in
+// order and the link's URL is used when none match.
+type GeoRule struct {
+	Country   string `json:"country,omitempty"`
+	Continent string `json:"continent,omitempty"`
+	URL       string `json:"url"`
+}
+
+func (g GeoRule) matches(country, continent string) bool {
+	if g.Country != "" {
+		return g.Country == country
+	}
+	return g.Continent == continent
+}
+
+// loadGeoIP opens the MaxMind database named by GEOIP_DB, if any. Both the
+// Country and City editions (GeoLite2 or GeoIP2) work.
+func loadGeoIP(getenv func(string) string, logger *log.Logger) (*geoip2.Reader, error) {
+	path := getenv("GEOIP_DB")
+	if path == "" {
+		return nil, nil
+	}
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GEOIP_DB %s: %v", path, err)
+	}
+	logger.Printf("Loaded GeoIP database %s (%s)", path, db.Metadata().DatabaseType)
+	return db, nil
+}
+
+// joinGeoRules encodes geo rules for the geo_rules column.
+func joinGeoRules(rules []GeoRule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(rules)
+	return string(data), err
+}
+
+// splitGeoRules decodes the geo_rules column.
+func splitGeoRules(s string) ([]GeoRule, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var rules []GeoRule
+	err := json.Unmarshal([]byte(s), &rules)
+	return rules, err
+}
+
+// normalizeGeoRules validates a link's geo rules and their destinations.
+func (lf *LinkForwarder) normalizeGeoRules(ctx context.Context, link *Link, requestHost string) error {
+	if len(link.GeoRules) == 0 {
+		return nil
+	}
+	if lf.geoip == nil {
+		return fmt.Errorf("geo_rules require the server to be started with GEOIP_DB")
+	}
+	if len(link.GeoRules) > maxGeoRules {
+		return fmt.Errorf("a link can have at most %d geo rules", maxGeoRules)
+	}
+
+	for i := range link.GeoRules {
+		rule := &link.GeoRules[i]
+		rule.Country = strings.ToUpper(strings.TrimSpace(rule.Country))
+		rule.Continent = strings.ToUpper(strings.TrimSpace(rule.Continent))
+		switch {
+		case rule.Country != "" && rule.Continent != "":
+			return fmt.Errorf("geo rule %d: set either country or continent, not both", i+1)
+		case rule.Country != "" && !countryCodePattern.MatchString(rule.Country):
+			return fmt.Errorf("geo rule %d: country must be a two-letter ISO code", i+1)
+		case rule.Continent != "" && !continentCodePattern.MatchString(rule.Continent):
+			return fmt.Errorf("geo rule %d: continent must be one of AF, AN, AS, EU, NA, OC, SA", i+1)
+		case rule.Country == "" && rule.Continent == "":
+			return fmt.Errorf("geo rule %d: country or continent is required", i+1)
+		}
+
+		destination, err := lf.checkDestination(ctx, *link, rule.URL, requestHost)
+		if err != nil {
+			return fmt.Errorf("geo rule %d: %v", i+1, err)
+		}
+		rule.URL = destination
+	}
+	return nil
+}
+
+// geoDestination returns the destination of the first geo rule matching
+// the visitor's location.
+func (lf *LinkForwarder) geoDestination(r *http.Request, link Link) (string, bool) {
+	if lf.geoip == nil || len(link.GeoRules) == 0 {
+		return "", false
+	}
+
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return "", false
+	}
+	record, err := lf.geoip.Country(ip)
+	if err != nil {
+		lf.logf(r, "GeoIP lookup failed for %s: %v", ip, err)
+		return "", false
+	}
+
+	for _, rule := range link.GeoRules {
+		if rule.matches(record.Country.IsoCode, record.Continent.Code) {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,109 @@
+package lnk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestGeoIP writes a tiny GeoLite2-Country database that places
+// 0.0.0.0/2 in Germany, 64.0.0.0/2 in Japan and 128.0.0.0/1 in the US.
+func writeTestGeoIP(t *testing.T) string {
+	t.Helper()
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	uint32Field := func(key string, v uint32) []byte {
+		b := append(str(key), 0xc4, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], v)
+		return b
+	}
+	location := func(country, continent string) []byte {
+		b := []byte{0xe2}
+		b = append(append(append(b, str("continent")...), 0xe1), str("code")...)
+		b = append(b, str(continent)...)
+		b = append(append(append(b, str("country")...), 0xe1), str("iso_code")...)
+		return append(b, str(country)...)
+	}
+
+	data := [][]byte{location("DE", "EU"), location("JP", "AS"), location("US", "NA")}
+	const nodeCount = 2
+	var pointers []uint32
+	offset := 0
+	for _, d := range data {
+		pointers = append(pointers, uint32(nodeCount+16+offset))
+		offset += len(d)
+	}
+	record := func(v uint32) []byte { return []byte{byte(v >> 16), byte(v >> 8), byte(v)} }
+
+	var db bytes.Buffer
+	db.Write(record(1)) // node 0, first bit 0: node 1
+	db.Write(record(pointers[2]))
+	db.Write(record(pointers[0])) // node 1, second bit
+	db.Write(record(pointers[1]))
+	db.Write(make([]byte, 16))
+	for _, d := range data {
+		db.Write(d)
+	}
+	db.WriteString("\xab\xcd\xefMaxMind.com")
+	db.WriteByte(0xe6)
+	db.Write(append(str("database_type"), str("GeoLite2-Country")...))
+	db.Write(uint32Field("ip_version", 4))
+	db.Write(uint32Field("record_size", 24))
+	db.Write(uint32Field("node_count", nodeCount))
+	db.Write(uint32Field("binary_format_major_version", 2))
+	db.Write(uint32Field("binary_format_minor_version", 0))
+
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, db.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestGeoRules checks that visitors are sent to the first geo rule matching
+// their country or continent, and to the link's URL when none does.
+func TestGeoRules(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"GEOIP_DB": writeTestGeoIP(t)})
+
+	body := `{"shortcode":"shop","url":"https://dest.example/","geo_rules":[` +
+		`{"country":"us","url":"https://dest.example/us"},` +
+		`{"continent":" eu ","url":"https://dest.example/eu"}]}`
+	if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating the link: status %d: %s", w.Code, w.Body)
+	}
+
+	for ip, want := range map[string]string{
+		"192.0.2.1":  "https://dest.example/us",
+		"10.0.0.1":   "https://dest.example/eu",
+		"100.64.0.1": "https://dest.example/",
+	} {
+		w := serve(lf, "GET", "/shop", "", func(r *http.Request) { r.RemoteAddr = ip + ":1234" })
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("visit from %s: redirected to %q, want %q", ip, got, want)
+		}
+	}
+
+	for _, rules := range []string{
+		`[{"country":"US","continent":"NA","url":"https://dest.example/us"}]`,
+		`[{"country":"USA","url":"https://dest.example/us"}]`,
+		`[{"continent":"XX","url":"https://dest.example/us"}]`,
+		`[{"url":"https://dest.example/us"}]`,
+	} {
+		body := `{"shortcode":"refused","url":"https://dest.example/","geo_rules":` + rules + `}`
+		if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("geo rules %s: status %d, want %d", rules, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestGeoRulesWithoutDatabase checks that geo rules are refused when there's
+// no GeoIP database to apply them with.
+func TestGeoRulesWithoutDatabase(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	body := `{"shortcode":"shop","url":"https://dest.example/","geo_rules":[{"country":"US","url":"https://dest.example/us"}]}`
+	if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,335 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const maxGroupLength = 50
+
+var (
+	errGroupNotFound = errors.New("group not found")
+	errGroupExists   = errors.New("group already exists")
+)
+
+// Group is a folder of links, such as marketing or engineering, so each
+// team's links can be listed apart from the rest. A link is in at most
+// one group.
+type Group struct {
+	Domain      string     `json:"domain,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Links       int        `json:"links"`                // set on reads
+	CreatedAt   *time.Time `json:"created_at,omitempty"` // set on reads
+}
+
+// normalizeGroup lowercases and trims a group name, as with tags, and
+// checks it can be used in a path.
+func normalizeGroup(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if utf8.RuneCountInString(name) > maxGroupLength {
+		return "", fmt.Errorf("group must be at most %d characters", maxGroupLength)
+	}
+	if strings.ContainsAny(name, "/,") || strings.ContainsFunc(name, unicode.IsControl) {
+		return "", fmt.Errorf("group '%s' must not contain slashes, commas, or control characters", name)
+	}
+	return name, nil
+}
+
+// groupColumns is the column list read by scanGroup.
+const groupColumns = `domain, name, description, created_at,
+	(SELECT COUNT(*) FROM links WHERE links.domain = link_groups.domain AND links.group_name = link_groups.name)`
+
+func scanGroup(row rowScanner) (Group, error) {
+	var group Group
+	var createdAt sql.NullTime
+	if err := row.Scan(&group.Domain, &group.Name, &group.Description, &createdAt, &group.Links); err != nil {
+		return group, err
+	}
+	if createdAt.Valid {
+		group.CreatedAt = &createdAt.Time
+	}
+	return group, nil
+}
+
+// listGroups returns the groups on domain by name.
+func (lf *LinkForwarder) listGroups(ctx context.Context, domain string) ([]Group, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT `+groupColumns+` FROM link_groups WHERE domain = ? ORDER BY name`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func (lf *LinkForwarder) getGroup(ctx context.Context, domain, name string) (Group, error) {
+	group, err := scanGroup(lf.db.QueryRowContext(ctx, `SELECT `+groupColumns+` FROM link_groups WHERE domain = ? AND name = ?`,
+		domain, name))
+	if err == sql.ErrNoRows {
+		return Group{}, errGroupNotFound
+	}
+	return group, err
+}
+
+// ensureGroupTx creates a link's group, if it has one that doesn't exist
+// yet, as part of saving the link in tx.
+func ensureGroupTx(ctx context.Context, tx *sql.Tx, link Link) error {
+	if link.Group == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO link_groups (domain, name) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		link.Domain, link.Group)
+	return err
+}
+
+func (lf *LinkForwarder) createGroup(ctx context.Context, group Group) error {
+	result, err := lf.db.ExecContext(ctx, `INSERT INTO link_groups (domain, name, description) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`, group.Domain, group.Name, group.Description)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errGroupExists
+	}
+	return nil
+}
+
+// updateGroup renames the group name on domain and sets its description.
+// Its links move along with it.
+func (lf *LinkForwarder) updateGroup(ctx context.Context, domain, name string, group Group) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if group.Name != name {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM link_groups WHERE domain = ? AND name = ?`,
+			domain, group.Name).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return errGroupExists
+		}
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE link_groups SET name = ?, description = ? WHERE domain = ? AND name = ?`,
+		group.Name, group.Description, domain, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errGroupNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE links SET group_name = ? WHERE domain = ? AND group_name = ?`,
+		group.Name, domain, name); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	lf.invalidateLinks()
+	return nil
+}
+
+// deleteGroup removes a group. Its links are kept, in no group.
+func (lf *LinkForwarder) deleteGroup(ctx context.Context, domain, name string) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM link_groups WHERE domain = ? AND name = ?`, domain, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errGroupNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE links SET group_name = '' WHERE domain = ? AND group_name = ?`,
+		domain, name); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	lf.invalidateLinks()
+	return nil
+}
+
+// decodeGroup reads and checks the group in a request body.
+func decodeGroup(r *http.Request) (Group, error) {
+	var group Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		return group, errors.New("Invalid JSON")
+	}
+	name, err := normalizeGroup(group.Name)
+	if err != nil {
+		return group, err
+	}
+	group.Name = name
+	group.Description = strings.TrimSpace(group.Description)
+	if utf8.RuneCountInString(group.Description) > maxDescriptionLength {
+		return group, fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+	}
+	return group, nil
+}
+
+func (lf *LinkForwarder) handleGroups(w http.ResponseWriter, r *http.Request) {
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := strings.ToLower(mux.Vars(r)["name"])
+
+	switch {
+	case r.Method == "GET" && name == "":
+		groups, err := lf.listGroups(r.Context(), domain)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve groups")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Groups retrieved successfully",
+			Data:    groups,
+		})
+
+	case r.Method == "GET":
+		group, err := lf.getGroup(r.Context(), domain, name)
+		if errors.Is(err, errGroupNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve group")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Group retrieved successfully",
+			Data:    group,
+		})
+
+	case r.Method == "POST":
+		group, err := decodeGroup(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if group.Name == "" {
+			writeError(w, http.StatusBadRequest, "Name is required")
+			return
+		}
+		group.Domain = domain
+		if err := lf.createGroup(r.Context(), group); err != nil {
+			if errors.Is(err, errGroupExists) {
+				writeError(w, http.StatusConflict, fmt.Sprintf("group '%s' already exists", group.Name))
+			} else {
+				lf.logf(r, "Failed to create group %s: %v", group.Name, err)
+				writeError(w, http.StatusInternalServerError, "Failed to create group")
+			}
+			return
+		}
+		lf.logf(r, "%s created group %s", lf.requestActor(r), group.Name)
+		group, err = lf.getGroup(r.Context(), domain, group.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve group")
+			return
+		}
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "Group created successfully",
+			Data:    group,
+		})
+
+	case r.Method == "PUT":
+		group, err := decodeGroup(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Leaving out the name keeps it
+		if group.Name == "" {
+			group.Name = name
+		}
+		if err := lf.updateGroup(r.Context(), domain, name, group); err != nil {
+			switch {
+			case errors.Is(err, errGroupNotFound):
+				writeError(w, http.StatusNotFound, err.Error())
+			case errors.Is(err, errGroupExists):
+				writeError(w, http.StatusConflict, fmt.Sprintf("group '%s' already exists", group.Name))
+			default:
+				lf.logf(r, "Failed to update group %s: %v", name, err)
+				writeError(w, http.StatusInternalServerError, "Failed to update group")
+			}
+			return
+		}
+		if group.Name != name {
+			lf.logf(r, "%s renamed group %s to %s", lf.requestActor(r), name, group.Name)
+		} else {
+			lf.logf(r, "%s updated group %s", lf.requestActor(r), name)
+		}
+		group, err = lf.getGroup(r.Context(), domain, group.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve group")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Group updated successfully",
+			Data:    group,
+		})
+
+	case r.Method == "DELETE":
+		if err := lf.deleteGroup(r.Context(), domain, name); err != nil {
+			if errors.Is(err, errGroupNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				lf.logf(r, "Failed to delete group %s: %v", name, err)
+				writeError(w, http.StatusInternalServerError, "Failed to delete group")
+			}
+			return
+		}
+		lf.logf(r, "%s deleted group %s", lf.requestActor(r), name)
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Group deleted successfully",
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
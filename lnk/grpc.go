@@ -0,0 +1,305 @@
+package lnk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nryberg/lnk/lnkpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The messages of the gRPC API have the fields of the REST API's JSON
+// objects under the same names, so they convert through JSON.
+var (
+	grpcMarshal   = protojson.MarshalOptions{UseProtoNames: true}
+	grpcUnmarshal = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+// grpcCodes are the gRPC status codes of failed API requests, by HTTP
+// status. Anything else is Internal.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:         codes.InvalidArgument,
+	http.StatusUnauthorized:       codes.Unauthenticated,
+	http.StatusForbidden:          codes.PermissionDenied,
+	http.StatusNotFound:           codes.NotFound,
+	http.StatusConflict:           codes.AlreadyExists,
+	http.StatusTooManyRequests:    codes.ResourceExhausted,
+	http.StatusServiceUnavailable: codes.Unavailable,
+	http.StatusGatewayTimeout:     codes.DeadlineExceeded,
+}
+
+// grpcService serves lnkpb.LinkService by making the matching API request
+// in process with the caller's credentials, so the two APIs share their
+// authentication, token scopes, validation, maintenance and read-only
+// modes, and link history.
+type grpcService struct {
+	lnkpb.UnimplementedLinkServiceServer
+	lf *LinkForwarder
+}
+
+// GRPCServer returns a gRPC server for the link service in lnkpb, to serve
+// on a port of its own next to the forwarder's HTTP handler. It answers
+// reflection requests, so tools such as grpcurl can list its calls. Short
+// URLs in its responses are on PUBLIC_URL, or else the host clients dialed.
+func (lf *LinkForwarder) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	lnkpb.RegisterLinkServiceServer(s, &grpcService{lf: lf})
+	reflection.Register(s)
+	return s
+}
+
+// grpcResponse collects the API's answer to a gRPC call.
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *grpcResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *grpcResponse) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// call makes the API request for a gRPC call, with the authorization,
+// request ID, and user agent in its metadata, and returns the data and
+// meta of the response. A failed request is returned as a gRPC status
+// with the API's message.
+func (s *grpcService) call(ctx context.Context, method, path string, query url.Values, body proto.Message) (json.RawMessage, json.RawMessage, error) {
+	var reqBody io.Reader = http.NoBody
+	if body != nil {
+		data, err := grpcMarshal.Marshal(body)
+		if err != nil {
+			return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	target := s.lf.appPath(apiV1 + path)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	r, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.Host = "localhost"
+	if authority := md.Get(":authority"); len(authority) > 0 {
+		r.Host = authority[0]
+	}
+	for header, key := range map[string]string{
+		"Authorization": "authorization",
+		requestIDHeader: "x-request-id",
+		"User-Agent":    "user-agent",
+	} {
+		if values := md.Get(key); len(values) > 0 {
+			r.Header.Set(header, values[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	w := &grpcResponse{header: http.Header{}}
+	s.lf.ServeHTTP(w, r)
+	if id := w.header.Get(requestIDHeader); id != "" {
+		grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	}
+
+	var resp struct {
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+		Meta    json.RawMessage `json:"meta"`
+	}
+	if err := json.Unmarshal(w.body.Bytes(), &resp); err != nil || resp.Message == "" {
+		resp.Message = http.StatusText(w.status)
+	}
+	if w.status/100 != 2 {
+		code, ok := grpcCodes[w.status]
+		if !ok {
+			code = codes.Internal
+		}
+		return nil, nil, status.Error(code, resp.Message)
+	}
+	return resp.Data, resp.Meta, nil
+}
+
+// unmarshal reads the JSON of an API object into the matching message.
+func (s *grpcService) unmarshal(data json.RawMessage, m proto.Message) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := grpcUnmarshal.Unmarshal(data, m); err != nil {
+		return status.Errorf(codes.Internal, "failed to convert the response: %v", err)
+	}
+	return nil
+}
+
+// grpcLinkPath returns the API path of a link, rejecting calls without a
+// shortcode.
+func grpcLinkPath(shortcode, rest string) (string, error) {
+	if shortcode == "" {
+		return "", status.Error(codes.InvalidArgument, "shortcode is required")
+	}
+	return "/links/" + url.PathEscape(shortcode) + rest, nil
+}
+
+// domainQuery names the namespace a call works on. Unlike the REST API,
+// an empty domain is always the default namespace, whatever host the
+// client dialed.
+func domainQuery(domain string) url.Values {
+	return url.Values{"domain": {domain}}
+}
+
+func (s *grpcService) ListLinks(ctx context.Context, req *lnkpb.ListLinksRequest) (*lnkpb.ListLinksResponse, error) {
+	q := domainQuery(req.Domain)
+	for name, value := range map[string]string{
+		"q":      req.Q,
+		"url":    req.Url,
+		"status": req.Status,
+		"group":  req.Group,
+		"sort":   req.Sort,
+		"order":  req.Order,
+	} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	q["tag"] = req.Tag
+	if req.Pinned != nil {
+		q.Set("pinned", strconv.FormatBool(*req.Pinned))
+	}
+	if req.Archived {
+		q.Set("archived", "true")
+	}
+	if req.Page != 0 {
+		q.Set("page", strconv.Itoa(int(req.Page)))
+	}
+	if req.PerPage != 0 {
+		q.Set("per_page", strconv.Itoa(int(req.PerPage)))
+	}
+
+	data, meta, err := s.call(ctx, http.MethodGet, "/links", q, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &lnkpb.ListLinksResponse{}
+	if err := s.unmarshal(meta, resp); err != nil {
+		return nil, err
+	}
+	var links []json.RawMessage
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to convert the response: %v", err)
+	}
+	for _, raw := range links {
+		link := &lnkpb.Link{}
+		if err := s.unmarshal(raw, link); err != nil {
+			return nil, err
+		}
+		resp.Links = append(resp.Links, link)
+	}
+	return resp, nil
+}
+
+func (s *grpcService) GetLink(ctx context.Context, req *lnkpb.GetLinkRequest) (*lnkpb.GetLinkResponse, error) {
+	path, err := grpcLinkPath(req.Shortcode, "")
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := s.call(ctx, http.MethodGet, path, domainQuery(req.Domain), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The link's fields and its stats come in one object
+	var detail struct {
+		Stats json.RawMessage `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &detail); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to convert the response: %v", err)
+	}
+	resp := &lnkpb.GetLinkResponse{Link: &lnkpb.Link{}, Stats: &lnkpb.LinkStats{}}
+	if err := s.unmarshal(data, resp.Link); err != nil {
+		return nil, err
+	}
+	if err := s.unmarshal(detail.Stats, resp.Stats); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *grpcService) CreateLink(ctx context.Context, req *lnkpb.CreateLinkRequest) (*lnkpb.Link, error) {
+	if req.Link == nil {
+		return nil, status.Error(codes.InvalidArgument, "link is required")
+	}
+	data, _, err := s.call(ctx, http.MethodPost, "/links", domainQuery(req.Link.Domain), req.Link)
+	if err != nil {
+		return nil, err
+	}
+	link := &lnkpb.Link{}
+	return link, s.unmarshal(data, link)
+}
+
+func (s *grpcService) UpdateLink(ctx context.Context, req *lnkpb.UpdateLinkRequest) (*lnkpb.Link, error) {
+	if req.Link == nil {
+		return nil, status.Error(codes.InvalidArgument, "link is required")
+	}
+	path, err := grpcLinkPath(req.Link.Shortcode, "")
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := s.call(ctx, http.MethodPut, path, domainQuery(req.Link.Domain), req.Link)
+	if err != nil {
+		return nil, err
+	}
+	link := &lnkpb.Link{}
+	return link, s.unmarshal(data, link)
+}
+
+func (s *grpcService) DeleteLink(ctx context.Context, req *lnkpb.DeleteLinkRequest) (*lnkpb.DeleteLinkResponse, error) {
+	path, err := grpcLinkPath(req.Shortcode, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := s.call(ctx, http.MethodDelete, path, domainQuery(req.Domain), nil); err != nil {
+		return nil, err
+	}
+	return &lnkpb.DeleteLinkResponse{}, nil
+}
+
+func (s *grpcService) GetStats(ctx context.Context, req *lnkpb.GetStatsRequest) (*lnkpb.LinkStats, error) {
+	path, err := grpcLinkPath(req.Shortcode, "/stats")
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := s.call(ctx, http.MethodGet, path, domainQuery(req.Domain), nil)
+	if err != nil {
+		return nil, err
+	}
+	stats := &lnkpb.LinkStats{}
+	return stats, s.unmarshal(data, stats)
+}
@@ -0,0 +1,157 @@
+package lnk
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/nryberg/lnk/lnkpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testGRPCClient serves the forwarder's gRPC API in memory and returns a
+// client for it.
+func testGRPCClient(t *testing.T, lf *LinkForwarder) lnkpb.LinkServiceClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	server := lf.GRPCServer()
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///go.example",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return lnkpb.NewLinkServiceClient(conn)
+}
+
+// TestGRPC checks that links can be created, read, listed, updated, and
+// deleted over gRPC, with the caller's token.
+func TestGRPC(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	client := testGRPCClient(t, lf)
+	alice := testUser(t, lf, "alice", roleUser)
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer "+testToken(t, lf, alice, scopeRead, scopeWrite))
+
+	var header metadata.MD
+	link, err := client.CreateLink(ctx, &lnkpb.CreateLinkRequest{Link: &lnkpb.Link{
+		Shortcode: "docs", Url: "https://dest.example/docs", Tags: []string{"guides"},
+	}}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("CreateLink: %v", err)
+	}
+	if link.Shortcode != "docs" || link.Owner != "alice" || link.ShortUrl != "http://go.example/docs" {
+		t.Errorf("CreateLink returned %v, want docs owned by alice on the dialed host", link)
+	}
+	if len(header.Get("x-request-id")) == 0 {
+		t.Errorf("header %v, want the request ID", header)
+	}
+
+	if w := serve(lf, "GET", "/docs", "", nil); w.Header().Get("Location") != "https://dest.example/docs" {
+		t.Fatalf("following the link went to %q", w.Header().Get("Location"))
+	}
+	got, err := client.GetLink(ctx, &lnkpb.GetLinkRequest{Shortcode: "docs"})
+	if err != nil {
+		t.Fatalf("GetLink: %v", err)
+	}
+	if got.Link.Url != "https://dest.example/docs" || got.Link.CreatedAt == nil || got.Stats.Clicks != 1 {
+		t.Errorf("GetLink returned %v, want the link with its click", got)
+	}
+
+	list, err := client.ListLinks(ctx, &lnkpb.ListLinksRequest{Tag: []string{"guides"}})
+	if err != nil {
+		t.Fatalf("ListLinks: %v", err)
+	}
+	if list.Total != 1 || len(list.Links) != 1 || list.Links[0].Shortcode != "docs" {
+		t.Errorf("ListLinks returned %v, want docs", list)
+	}
+
+	link, err = client.UpdateLink(ctx, &lnkpb.UpdateLinkRequest{Link: &lnkpb.Link{Shortcode: "docs", Url: "https://dest.example/v2/docs"}})
+	if err != nil || link.Url != "https://dest.example/v2/docs" {
+		t.Fatalf("UpdateLink returned %v (%v), want the new URL", link, err)
+	}
+	stats, err := client.GetStats(ctx, &lnkpb.GetStatsRequest{Shortcode: "docs"})
+	if err != nil || stats.Clicks != 1 {
+		t.Errorf("GetStats returned %v (%v), want 1 click", stats, err)
+	}
+
+	if _, err := client.DeleteLink(ctx, &lnkpb.DeleteLinkRequest{Shortcode: "docs"}); err != nil {
+		t.Fatalf("DeleteLink: %v", err)
+	}
+	if _, err := client.GetLink(ctx, &lnkpb.GetLinkRequest{Shortcode: "docs"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetLink after DeleteLink: %v, want NotFound", err)
+	}
+}
+
+// TestGRPCErrors checks that failed API requests come back with the
+// matching gRPC status codes and the API's messages.
+func TestGRPCErrors(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	client := testGRPCClient(t, lf)
+	alice := testUser(t, lf, "alice", roleUser)
+	withToken := func(scopes ...string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testToken(t, lf, alice, scopes...))
+	}
+	writer := withToken(scopeRead, scopeWrite)
+	link := Link{Shortcode: "docs", URL: "https://dest.example/"}
+	if err := lf.saveLink(context.Background(), link, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.addAlias(context.Background(), link, "manual"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"no credentials", func() error {
+			_, err := client.ListLinks(context.Background(), &lnkpb.ListLinksRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"read-only token", func() error {
+			_, err := client.DeleteLink(withToken(scopeRead), &lnkpb.DeleteLinkRequest{Shortcode: "docs"})
+			return err
+		}, codes.PermissionDenied},
+		{"create-only token replacing a link", func() error {
+			_, err := client.CreateLink(withToken(scopeCreate), &lnkpb.CreateLinkRequest{Link: &lnkpb.Link{Shortcode: "docs", Url: "https://dest.example/"}})
+			return err
+		}, codes.PermissionDenied},
+		{"shortcode taken by an alias", func() error {
+			_, err := client.CreateLink(writer, &lnkpb.CreateLinkRequest{Link: &lnkpb.Link{Shortcode: "manual", Url: "https://dest.example/"}})
+			return err
+		}, codes.AlreadyExists},
+		{"bad URL", func() error {
+			_, err := client.CreateLink(writer, &lnkpb.CreateLinkRequest{Link: &lnkpb.Link{Shortcode: "bad", Url: "javascript:alert(1)"}})
+			return err
+		}, codes.InvalidArgument},
+		{"no link", func() error {
+			_, err := client.CreateLink(writer, &lnkpb.CreateLinkRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"no shortcode", func() error {
+			_, err := client.GetStats(writer, &lnkpb.GetStatsRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown link", func() error {
+			_, err := client.GetLink(writer, &lnkpb.GetLinkRequest{Shortcode: "missing"})
+			return err
+		}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		err := tt.call()
+		if s, _ := status.FromError(err); s.Code() != tt.code || s.Message() == "" {
+			t.Errorf("%s: %v, want %v with a message", tt.name, err, tt.code)
+		}
+	}
+}
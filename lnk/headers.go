@@ -0,0 +1,146 @@
+package lnk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	// maxLinkHeaders limits how many response headers a link may set.
+	maxLinkHeaders = 20
+	// maxHeaderValueLength limits each response header value.
+	maxHeaderValueLength = 1024
+)
+
+// reservedHeaders are the response headers links can't set: those the
+// redirect itself needs or the server sets on it (caching validators and
+// the request ID), hop-by-hop headers, and those that would apply to the
+// whole host rather than one link.
+var reservedHeaders = map[string]bool{
+	"Alt-Svc":                   true,
+	"Clear-Site-Data":           true,
+	"Connection":                true,
+	"Content-Encoding":          true,
+	"Content-Length":            true,
+	"Content-Type":              true,
+	"Etag":                      true,
+	"Keep-Alive":                true,
+	"Location":                  true,
+	"Set-Cookie":                true,
+	"Strict-Transport-Security": true,
+	"Te":                        true,
+	"Trailer":                   true,
+	"Transfer-Encoding":         true,
+	"Upgrade":                   true,
+	"Vary":                      true,
+	"X-Request-Id":              true,
+}
+
+// checkHeader reports why a link or REDIRECT_HEADERS can't send a header,
+// if it can't.
+func checkHeader(name, value string) error {
+	if !httpguts.ValidHeaderFieldName(name) {
+		return fmt.Errorf("%q is not a valid header name", name)
+	}
+	if reservedHeaders[http.CanonicalHeaderKey(name)] {
+		return fmt.Errorf("the %s header can't be set", http.CanonicalHeaderKey(name))
+	}
+	if value == "" {
+		return fmt.Errorf("the %s header needs a value", http.CanonicalHeaderKey(name))
+	}
+	if len(value) > maxHeaderValueLength {
+		return fmt.Errorf("header values must be at most %d characters", maxHeaderValueLength)
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("the value of %s is not a valid header value", http.CanonicalHeaderKey(name))
+	}
+	return nil
+}
+
+// loadRedirectHeaders reads REDIRECT_HEADERS, the response headers sent
+// with every link's redirect, as comma-separated name=value pairs. Values
+// are URL-decoded, so a comma in one is written %2C.
+func loadRedirectHeaders(getenv func(string) string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range splitList(getenv("REDIRECT_HEADERS")) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid REDIRECT_HEADERS: %q must be name=value", pair)
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		name, value = http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if err := checkHeader(name, value); err != nil {
+			return nil, fmt.Errorf("invalid REDIRECT_HEADERS: %v", err)
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// normalizeHeaders checks a link's response headers and puts their names
+// in canonical form.
+func normalizeHeaders(link *Link) error {
+	if len(link.Headers) == 0 {
+		link.Headers = nil
+		return nil
+	}
+	if len(link.Headers) > maxLinkHeaders {
+		return fmt.Errorf("a link can set at most %d headers", maxLinkHeaders)
+	}
+	headers := make(map[string]string, len(link.Headers))
+	for name, value := range link.Headers {
+		name, value = http.CanonicalHeaderKey(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if err := checkHeader(name, value); err != nil {
+			return err
+		}
+		if _, ok := headers[name]; ok {
+			return fmt.Errorf("the %s header is set more than once", name)
+		}
+		headers[name] = value
+	}
+	link.Headers = headers
+	return nil
+}
+
+// joinHeaders encodes response headers for the headers column.
+func joinHeaders(headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(headers)
+	return string(data), err
+}
+
+// splitHeaders decodes the headers column.
+func splitHeaders(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(s), &headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// setRedirectHeaders adds REDIRECT_HEADERS and then the link's own headers
+// to its redirect, so the link's win. Empty values and reserved headers,
+// which links saved before they were refused may still have, are skipped
+// rather than clearing or replacing the server's own.
+func (lf *LinkForwarder) setRedirectHeaders(w http.ResponseWriter, link Link) {
+	h := w.Header()
+	for _, headers := range []map[string]string{lf.redirectHeaders, link.Headers} {
+		for name, value := range headers {
+			if value != "" && !reservedHeaders[name] {
+				h.Set(name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package lnk
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// TestLinkHeaders checks which response headers a link may set, and that
+// headers saved before they were refused don't strip the server's own.
+func TestLinkHeaders(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"REDIRECT_HEADERS": "X-Robots-Tag=noindex"})
+
+	for _, headers := range []string{
+		`{"Vary":"Cookie"}`,
+		`{"ETag":"\"v1\""}`,
+		`{"X-Request-ID":"fixed"}`,
+		`{"Clear-Site-Data":"\"*\""}`,
+		`{"Alt-Svc":"h3=\":443\""}`,
+		`{"X-Robots-Tag":""}`,
+	} {
+		body := `{"shortcode":"refused","url":"https://dest.example/","headers":` + headers + `}`
+		if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("headers %s: status %d, want %d", headers, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	body := `{"shortcode":"vendor","url":"https://dest.example/","headers":{"referrer-policy":"no-referrer"}}`
+	if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating the link: status %d: %s", w.Code, w.Body)
+	}
+	w := serve(lf, "GET", "/vendor", "", nil)
+	if w.Header().Get("Referrer-Policy") != "no-referrer" || w.Header().Get("X-Robots-Tag") != "noindex" {
+		t.Errorf("headers %v, want the link's and REDIRECT_HEADERS", w.Header())
+	}
+
+	legacy := Link{Shortcode: "legacy", URL: "https://dest.example/", Headers: map[string]string{
+		"X-Request-Id": "", "Vary": "", "X-Robots-Tag": "",
+	}}
+	if err := lf.saveLink(context.Background(), legacy, "test"); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(lf, "GET", "/legacy", "", nil)
+	if w.Header().Get("X-Request-ID") == "" || w.Header().Get("X-Robots-Tag") != "noindex" {
+		t.Errorf("headers %v, want the request ID and REDIRECT_HEADERS kept", w.Header())
+	}
+}
@@ -0,0 +1,145 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// History actions recorded in link_history.
+const (
+	historyCreate = "create"
+	historyUpdate = "update"
+	historyDelete = "delete"
+)
+
+// HistoryEntry is one recorded change to a link. Previous is empty for
+// creates and Current is empty for deletes.
+type HistoryEntry struct {
+	ID        int64     `json:"id"`
+	Domain    string    `json:"domain,omitempty"`
+	Shortcode string    `json:"shortcode"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Previous  *Link     `json:"previous,omitempty"`
+	Current   *Link     `json:"current,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// recordHistory appends a change to the audit log as part of tx.
+func recordHistory(ctx context.Context, tx *sql.Tx, domain, shortcode, action, actor string, previous, current *Link) error {
+	prevJSON, err := marshalLink(previous)
+	if err != nil {
+		return err
+	}
+	currJSON, err := marshalLink(current)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO link_history (domain, shortcode, action, actor, previous, current)
+		VALUES (?, ?, ?, ?, ?, ?)`, domain, shortcode, action, actor, prevJSON, currJSON)
+	return err
+}
+
+func marshalLink(link *Link) (sql.NullString, error) {
+	if link == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(link)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalLink(s sql.NullString) (*Link, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var link Link
+	if err := json.Unmarshal([]byte(s.String), &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// getHistory returns every recorded change to a shortcode, newest first.
+// History outlives the link itself, so deleted links can still be audited.
+func (lf *LinkForwarder) getHistory(ctx context.Context, domain, shortcode string) ([]HistoryEntry, error) {
+	query := `SELECT id, domain, shortcode, action, actor, previous, current, changed_at
+		FROM link_history WHERE domain = ? AND shortcode = ? ORDER BY id DESC`
+	rows, err := lf.db.QueryContext(ctx, query, domain, shortcode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		var previous, current sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Domain, &entry.Shortcode, &entry.Action, &entry.Actor,
+			&previous, &current, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		if entry.Previous, err = unmarshalLink(previous); err != nil {
+			return nil, err
+		}
+		if entry.Current, err = unmarshalLink(current); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (lf *LinkForwarder) handleHistory(w http.ResponseWriter, r *http.Request) {
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, err := lf.getHistory(r.Context(), domain, shortcode)
+	if err != nil {
+		lf.logf(r, "Failed to load history for %s: %v", shortcode, err)
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve history")
+		return
+	}
+	if len(entries) == 0 {
+		writeError(w, http.StatusNotFound, errLinkNotFound.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "History retrieved successfully",
+		Data:    entries,
+	})
+}
+
+// requestActor identifies who made a request for the audit log: the
+// authenticated user, or the client IP, anonymized per IP_ANONYMIZATION,
+// when authentication is disabled.
+func (lf *LinkForwarder) requestActor(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		return user.Username
+	}
+	return lf.privacy.anonymizeIP(clientIP(r))
+}
+
+// clientIP returns the address of the client that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,177 @@
+package lnk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxETagBody is the largest API response given an ETag. Larger ones, such
+// as click exports, are streamed as they're written instead of held to be
+// hashed.
+const maxETagBody = 1 << 20
+
+// redirectCache is how long browsers and CDNs may cache link redirects,
+// from PERMANENT_REDIRECT_MAX_AGE for 301 and 308 and
+// TEMPORARY_REDIRECT_MAX_AGE for 302 and 307. A nil max age leaves those
+// redirects without caching headers, as before they were configurable.
+type redirectCache struct {
+	permanent *time.Duration
+	temporary *time.Duration
+}
+
+// loadRedirectCache reads PERMANENT_REDIRECT_MAX_AGE and
+// TEMPORARY_REDIRECT_MAX_AGE.
+func loadRedirectCache(getenv func(string) string) (redirectCache, error) {
+	var cache redirectCache
+	for name, maxAge := range map[string]**time.Duration{
+		"PERMANENT_REDIRECT_MAX_AGE": &cache.permanent,
+		"TEMPORARY_REDIRECT_MAX_AGE": &cache.temporary,
+	} {
+		v := getenv(name)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cache, fmt.Errorf("invalid %s %q: must be a duration such as 1h, or 0 to have caches check every time", name, v)
+		}
+		*maxAge = &d
+	}
+	return cache, nil
+}
+
+// maxAge returns how long a redirect with status may be cached, if caching
+// headers are configured for it.
+func (c redirectCache) maxAge(status int) *time.Duration {
+	if status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect {
+		return c.permanent
+	}
+	return c.temporary
+}
+
+// cacheable reports whether every visitor following link gets the same
+// redirect for as long as it stays unchanged, so a shared cache may keep
+// it. Devices, countries, and A/B variants pick destinations per visitor;
+// click limits and activation windows end links at some point; and
+// password-protected links are only for those who unlocked them.
+func (link Link) cacheable() bool {
+	return len(link.Variants) == 0 && len(link.GeoRules) == 0 &&
+		link.IOSURL == "" && link.AndroidURL == "" && link.DesktopURL == "" &&
+		link.MaxClicks == 0 && link.ActiveUntil == nil && !link.Protected
+}
+
+// setRedirectCache adds the configured caching headers to a link's
+// redirect, with an ETag of where it goes, and reports whether the client
+// already has that redirect, so a 304 Not Modified will do.
+func (lf *LinkForwarder) setRedirectCache(w http.ResponseWriter, r *http.Request, link Link, status int, destination string) bool {
+	maxAge := lf.redirectCache.maxAge(status)
+	if maxAge == nil {
+		return false
+	}
+	if !link.cacheable() {
+		w.Header().Set("Cache-Control", "private, no-store")
+		return false
+	}
+	if *maxAge == 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	}
+	etag := newETag(fmt.Sprint(status, destination, lf.redirectHeaders, link.Headers))
+	w.Header().Set("ETag", etag)
+	return etagMatches(r.Header.Get("If-None-Match"), etag)
+}
+
+// newETag returns a strong ETag for content.
+func newETag(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// ETags match their strong form, as conditional GETs compare them.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter holds an API response to give it an ETag once it's complete.
+// A response that grows past maxETagBody is passed through as it is.
+type etagWriter struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.body.Len()+len(b) <= maxETagBody {
+		return w.body.Write(b)
+	}
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withETag answers an API read with an ETag of its response, and with 304
+// Not Modified when the client sends that ETag in If-None-Match, so
+// clients and caches polling the API only download what changed. Responses
+// are marked private and to be revalidated on every use, since they depend
+// on who's asking and on links that change at any time.
+func withETag(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ew := &etagWriter{ResponseWriter: w}
+		next(ew, r)
+		if ew.passthrough {
+			return
+		}
+		if ew.status == 0 {
+			ew.status = http.StatusOK
+		}
+		if ew.status == http.StatusOK {
+			etag := newETag(ew.body.String())
+			w.Header().Set("ETag", etag)
+			if w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", "private, no-cache")
+			}
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Type")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(ew.body.Len()))
+		}
+		w.WriteHeader(ew.status)
+		w.Write(ew.body.Bytes())
+	}
+}
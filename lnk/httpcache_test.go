@@ -0,0 +1,65 @@
+package lnk
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheable(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	tests := []struct {
+		name string
+		link Link
+		want bool
+	}{
+		{"plain", Link{URL: "https://dest.example"}, true},
+		{"forwarding the query", Link{URL: "https://dest.example", ForwardQuery: true, UTM: &UTM{Source: "news"}}, true},
+		{"variants", Link{Variants: []Variant{{Name: "a", URL: "https://a.example", Weight: 1}}}, false},
+		{"geo rules", Link{GeoRules: []GeoRule{{Country: "DE", URL: "https://de.example"}}}, false},
+		{"iOS URL", Link{IOSURL: "https://apps.apple.com/app"}, false},
+		{"Android URL", Link{AndroidURL: "https://play.google.com/app"}, false},
+		{"desktop URL", Link{DesktopURL: "https://dest.example/desktop"}, false},
+		{"click limit", Link{MaxClicks: 10}, false},
+		{"expiry", Link{ActiveUntil: &until}, false},
+		{"password", Link{Protected: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.link.cacheable(); got != tt.want {
+			t.Errorf("%s: cacheable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRedirectCacheHeaders checks that only redirects every visitor gets
+// alike are cacheable, and that the ETag answers repeat visits.
+func TestRedirectCacheHeaders(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"TEMPORARY_REDIRECT_MAX_AGE": "5m"})
+	for _, body := range []string{
+		`{"shortcode":"plain","url":"https://dest.example/plain"}`,
+		`{"shortcode":"limited","url":"https://dest.example/limited","max_clicks":100}`,
+	} {
+		if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusOK {
+			t.Fatalf("creating a link: status %d: %s", w.Code, w.Body)
+		}
+	}
+
+	w := serve(lf, "GET", "/plain", "", nil)
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Cache-Control"), "max-age=300") {
+		t.Fatalf("plain link: status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("plain link has no ETag")
+	}
+	w = serve(lf, "GET", "/plain", "", func(r *http.Request) { r.Header.Set("If-None-Match", etag) })
+	if w.Code != http.StatusNotModified {
+		t.Errorf("repeat visit with the ETag: status %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	w = serve(lf, "GET", "/limited", "", nil)
+	if got := w.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Errorf("link with a click limit: Cache-Control %q, want private, no-store", got)
+	}
+}
@@ -0,0 +1,86 @@
+package lnk
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	kafkaTimeout      = 10 * time.Second
+	kafkaDefaultPort  = "9092"
+	kafkaBatchTimeout = 10 * time.Millisecond
+)
+
+// kafkaPublisher produces to a Kafka topic over Kafka's own protocol.
+// CLICK_STREAM_URL lists the brokers to bootstrap from, as
+// kafka://broker1:9092,broker2:9092; kafka+tls:// connects with TLS, and
+// a user and password log in with SASL/PLAIN.
+type kafkaPublisher struct {
+	config kafka.WriterConfig
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(u *url.URL, topic string) *kafkaPublisher {
+	var brokers []string
+	for _, host := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, kafkaDefaultPort)
+		}
+		brokers = append(brokers, host)
+	}
+	dialer := &kafka.Dialer{Timeout: kafkaTimeout, ClientID: "lnk"}
+	if u.Scheme == "kafka+tls" {
+		dialer.TLS = &tls.Config{}
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		dialer.SASLMechanism = plain.Mechanism{Username: u.User.Username(), Password: password}
+	}
+	return &kafkaPublisher{config: kafka.WriterConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		Dialer:  dialer,
+		// Keyed by link, so each link's clicks stay in order on one
+		// partition
+		Balancer:     &kafka.Hash{},
+		BatchSize:    clickBatchSize,
+		BatchTimeout: kafkaBatchTimeout,
+		MaxAttempts:  3,
+		RequiredAcks: -1, // every in-sync replica
+		ReadTimeout:  kafkaTimeout,
+		WriteTimeout: kafkaTimeout,
+	}}
+}
+
+// publish produces events and waits for the brokers to acknowledge them.
+// The writer, which connects as soon as it's made, is made on the first
+// batch.
+func (p *kafkaPublisher) publish(ctx context.Context, events []ClickEvent) error {
+	if p.writer == nil {
+		p.writer = kafka.NewWriter(p.config)
+	}
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = kafka.Message{Key: []byte(event.Domain + "/" + event.Shortcode), Value: value}
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+func (p *kafkaPublisher) close() {
+	if p.writer != nil {
+		p.writer.Close()
+		p.writer = nil
+	}
+}
@@ -0,0 +1,166 @@
+package lnk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// linkCheckWorkers is how many destinations are checked at once.
+const linkCheckWorkers = 4
+
+// LinkCheck is the result of the last time a link's destination was checked.
+type LinkCheck struct {
+	Status    int       `json:"status,omitempty"` // final HTTP status; 0 without a response
+	Error     string    `json:"error,omitempty"`
+	Broken    bool      `json:"broken"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// loadLinkCheckInterval reads LINK_CHECK_INTERVAL, how often each link's
+// destination is checked. Zero, the default, turns checking off.
+func loadLinkCheckInterval(getenv func(string) string) (time.Duration, error) {
+	v := getenv("LINK_CHECK_INTERVAL")
+	if v == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid LINK_CHECK_INTERVAL %q: must be a duration such as 24h, or 0 to turn checking off", v)
+	}
+	return interval, nil
+}
+
+// requestStatus makes a request to destination, following redirects, and
+// returns the status it ended with.
+func (lf *LinkForwarder) requestStatus(ctx context.Context, method, destination string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, destination, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "lnk link checker")
+	resp, err := lf.pageClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxPageBytes))
+	return resp.StatusCode, nil
+}
+
+// probeDestination reports whether destination still works. Many
+// servers answer HEAD wrongly, so anything but success is retried with a
+// GET. Pages that want a login or are rate limiting aren't broken.
+func (lf *LinkForwarder) probeDestination(ctx context.Context, destination string) LinkCheck {
+	status, err := lf.requestStatus(ctx, http.MethodHead, destination)
+	if err != nil || status >= 400 {
+		status, err = lf.requestStatus(ctx, http.MethodGet, destination)
+	}
+	check := LinkCheck{Status: status, CheckedAt: lf.now().UTC()}
+	switch {
+	case err != nil:
+		check.Error = err.Error()
+		check.Broken = true
+	case status == http.StatusUnauthorized, status == http.StatusForbidden,
+		status == http.StatusProxyAuthRequired, status == http.StatusTooManyRequests:
+	case status >= 400:
+		check.Broken = true
+	}
+	return check
+}
+
+// runLinkCheck is the link-check job: it checks the links that are due
+// and sums up what it found.
+func (lf *LinkForwarder) runLinkCheck(ctx context.Context) (string, error) {
+	checked, broken, err := lf.checkDueLinks(ctx)
+	return fmt.Sprintf("Checked %d links: %d broken", checked, broken), err
+}
+
+// checkDueLinks checks every link that hasn't been checked recently,
+// returning how many were checked and found broken. Links checked late in
+// the previous run count as recent only for half an interval, so a long run
+// doesn't make them skip the next one. Links with a click limit are skipped
+// since their destinations are often single-use, and so are URL templates.
+func (lf *LinkForwarder) checkDueLinks(ctx context.Context) (int, int, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT domain, shortcode, url FROM links
+		WHERE max_clicks = 0 AND (checked_at IS NULL OR checked_at < ?)
+		ORDER BY checked_at, created_at`, lf.now().UTC().Add(-lf.linkCheckInterval/2))
+	if err != nil {
+		return 0, 0, err
+	}
+	var due []Link
+	for rows.Next() {
+		var link Link
+		if err := rows.Scan(&link.Domain, &link.Shortcode, &link.URL); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if !isTemplate(link.URL) {
+			due = append(due, link)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(due) == 0 {
+		return 0, 0, nil
+	}
+
+	var mu sync.Mutex
+	var checked, broken int
+	var firstErr error
+	queue := make(chan Link)
+	var wg sync.WaitGroup
+	for i := 0; i < linkCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range queue {
+				check := lf.probeDestination(ctx, link.URL)
+				if ctx.Err() != nil {
+					return
+				}
+				// A link whose URL changed during the check keeps its reset state
+				_, err := lf.db.ExecContext(ctx, `UPDATE links SET check_status = ?, check_error = ?, broken = ?, checked_at = ?
+					WHERE domain = ? AND shortcode = ? AND url = ?`,
+					check.Status, check.Error, check.Broken, check.CheckedAt, link.Domain, link.Shortcode, link.URL)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					checked++
+					if check.Broken {
+						broken++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, link := range due {
+		select {
+		case queue <- link:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if checked > 0 {
+		lf.invalidateLinks()
+	}
+	return checked, broken, firstErr
+}
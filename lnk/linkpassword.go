@@ -0,0 +1,71 @@
+package lnk
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt ignores everything past the first 72 bytes of a password.
+const maxLinkPasswordLength = 72
+
+// setLinkPassword hashes a newly supplied link password. The plain text is
+// cleared so it's never stored, logged in history, or echoed back.
+func setLinkPassword(link *Link) error {
+	if link.Password != "" {
+		if len(link.Password) > maxLinkPasswordLength {
+			return fmt.Errorf("password must be at most %d bytes", maxLinkPasswordLength)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(link.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		link.passwordHash = string(hash)
+	}
+	link.Password = ""
+	link.RemovePassword = false
+	link.Protected = link.passwordHash != ""
+	return nil
+}
+
+// PasswordData is passed to the password.html template.
+type PasswordData struct {
+	Shortcode    string
+	Action       string
+	ErrorMessage string
+}
+
+// unlockLink checks the password posted for a protected link. It reports
+// whether the visitor may continue; otherwise it has shown them the
+// password form.
+func (lf *LinkForwarder) unlockLink(w http.ResponseWriter, r *http.Request, link Link) bool {
+	data := PasswordData{Shortcode: link.Shortcode, Action: lf.appPath(r.URL.RequestURI())}
+	status := http.StatusOK
+
+	if r.Method == http.MethodPost {
+		password := r.FormValue("password")
+		if bcrypt.CompareHashAndPassword([]byte(link.passwordHash), []byte(password)) == nil {
+			return true
+		}
+		lf.logf(r, "Wrong password for protected link %s from %s", link.Shortcode, lf.requestActor(r))
+		data.ErrorMessage = "Incorrect password"
+		status = http.StatusForbidden
+	}
+
+	tmpl, err := lf.loadTemplate("password.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logf(r, "Template error: %v", err)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+	return false
+}
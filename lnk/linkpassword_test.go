@@ -0,0 +1,39 @@
+package lnk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestUnlockLink checks that a protected link only redirects once the
+// right password is posted, and then with 303 See Other, so browsers
+// don't post the password on even when the link redirects with a 307.
+func TestUnlockLink(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	body := `{"shortcode":"deck","url":"https://dest.example/deck","password":"s3cret","redirect_type":307}`
+	if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating the link: status %d: %s", w.Code, w.Body)
+	}
+
+	if w := serve(lf, "GET", "/deck", "", nil); w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Fatalf("GET: status %d, Location %q, want the password form", w.Code, w.Header().Get("Location"))
+	}
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/deck", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		lf.ServeHTTP(w, r)
+		return w
+	}
+	if w := unlock("wrong"); w.Code != http.StatusForbidden || w.Header().Get("Location") != "" {
+		t.Errorf("wrong password: status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	w := unlock("s3cret")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://dest.example/deck" {
+		t.Errorf("right password: status %d, Location %q, want %d to the destination", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,206 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const maxPerPage = 1000
+
+// ListOptions filters, sorts, and paginates the link list.
+type ListOptions struct {
+	Domain   string   // namespace to list; "" is the default one
+	Query    string   // substring match over shortcode and URL
+	URL      string   // exact destination URL, for reverse lookups
+	Status   string   // broken, ok, or unchecked, by the last link check
+	Tags     []string // links must carry every one of these tags
+	Group    string   // only links in this group
+	Pinned   *bool    // only pinned, or only unpinned, links
+	Archived bool     // list the archived links instead of the rest
+	Sort     string   // created_at, shortcode, url, clicks, or last_used
+	Order    string   // asc or desc
+	Page     int      // 1-based; 0 disables pagination
+	PerPage  int
+}
+
+// ListMeta describes the page of results returned by the list endpoint.
+type ListMeta struct {
+	Total   int    `json:"total"`
+	Page    int    `json:"page,omitempty"`
+	PerPage int    `json:"per_page,omitempty"`
+	Pages   int    `json:"pages,omitempty"`
+	Sort    string `json:"sort"`
+	Order   string `json:"order"`
+}
+
+// sortColumns maps the accepted ?sort values to columns and their default order.
+var sortColumns = map[string]struct {
+	column string
+	order  string
+}{
+	"created_at": {"created_at", "desc"},
+	"shortcode":  {"shortcode", "asc"},
+	"url":        {"url", "asc"},
+	"clicks":     {"click_count", "desc"},
+	"last_used":  {"last_accessed_at", "desc"}, // links never used sort last, or first ascending
+}
+
+// statusFilters maps the accepted ?status values to conditions on the
+// result of the last link check.
+var statusFilters = map[string]string{
+	"broken":    "broken = 1",
+	"ok":        "checked_at IS NOT NULL AND broken = 0",
+	"unchecked": "checked_at IS NULL",
+}
+
+// parseListOptions reads list options from query parameters. Pagination is
+// only applied when page or per_page is given, so existing clients that
+// expect every link keep working.
+func parseListOptions(q url.Values) (ListOptions, error) {
+	opts := ListOptions{
+		Query:  strings.TrimSpace(q.Get("q")),
+		URL:    strings.TrimSpace(q.Get("url")),
+		Status: q.Get("status"),
+		Tags:   normalizeTags(q["tag"]),
+		Group:  strings.ToLower(strings.TrimSpace(q.Get("group"))),
+		Sort:   q.Get("sort"),
+		Order:  strings.ToLower(q.Get("order")),
+	}
+
+	if v := q.Get("pinned"); v != "" {
+		pinned, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("pinned must be true or false")
+		}
+		opts.Pinned = &pinned
+	}
+
+	if v := q.Get("archived"); v != "" {
+		archived, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("archived must be true or false")
+		}
+		opts.Archived = archived
+	}
+
+	if _, ok := statusFilters[opts.Status]; !ok && opts.Status != "" {
+		return opts, fmt.Errorf("status must be broken, ok, or unchecked")
+	}
+
+	if opts.Sort == "" {
+		opts.Sort = "created_at"
+	}
+	col, ok := sortColumns[opts.Sort]
+	if !ok {
+		return opts, fmt.Errorf("sort must be created_at, shortcode, url, clicks, or last_used")
+	}
+	switch opts.Order {
+	case "":
+		opts.Order = col.order
+	case "asc", "desc":
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := q.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return opts, fmt.Errorf("page must be a positive integer")
+		}
+		opts.Page = page
+	}
+	if v := q.Get("per_page"); v != "" {
+		perPage, err := strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return opts, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		opts.PerPage = perPage
+	}
+	if opts.Page > 0 && opts.PerPage == 0 {
+		opts.PerPage = 50
+	}
+	if opts.PerPage > 0 && opts.Page == 0 {
+		opts.Page = 1
+	}
+
+	return opts, nil
+}
+
+// likePattern escapes a user-supplied substring for use in a LIKE clause.
+func likePattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(s) + "%"
+}
+
+// listLinks returns the links matching opts along with the total number of
+// matches before pagination.
+func (lf *LinkForwarder) listLinks(ctx context.Context, opts ListOptions) ([]Link, ListMeta, error) {
+	meta := ListMeta{Sort: opts.Sort, Order: opts.Order}
+
+	where := []string{"domain = ?", "archived_at IS NULL"}
+	if opts.Archived {
+		where[1] = "archived_at IS NOT NULL"
+	}
+	args := []any{opts.Domain}
+	if opts.Query != "" {
+		pattern := likePattern(opts.Query)
+		where = append(where, `(shortcode LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	if opts.URL != "" {
+		where = append(where, "url = ?")
+		args = append(args, opts.URL)
+	}
+	if opts.Status != "" {
+		where = append(where, statusFilters[opts.Status])
+	}
+	if opts.Group != "" {
+		where = append(where, "group_name = ?")
+		args = append(args, opts.Group)
+	}
+	if opts.Pinned != nil {
+		where = append(where, "pinned = ?")
+		args = append(args, *opts.Pinned)
+	}
+	for _, tag := range opts.Tags {
+		where = append(where, `tags LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(","+tag+","))
+	}
+
+	whereClause := " WHERE " + strings.Join(where, " AND ")
+
+	if err := lf.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM links`+whereClause, args...).Scan(&meta.Total); err != nil {
+		return nil, meta, err
+	}
+
+	// Sort and order are validated against sortColumns, so they are safe to
+	// inline. Pinned links come first whatever the order.
+	query := `SELECT ` + linkColumns + ` FROM links` + whereClause +
+		fmt.Sprintf(" ORDER BY pinned DESC, %s %s, shortcode ASC", sortColumns[opts.Sort].column, strings.ToUpper(opts.Order))
+	if opts.PerPage > 0 {
+		meta.Page = opts.Page
+		meta.PerPage = opts.PerPage
+		meta.Pages = (meta.Total + opts.PerPage - 1) / opts.PerPage
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, opts.PerPage, (opts.Page-1)*opts.PerPage)
+	}
+
+	rows, err := lf.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, meta, err
+	}
+	defer rows.Close()
+
+	links := []Link{}
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, meta, err
+		}
+		links = append(links, link)
+	}
+	return links, meta, rows.Err()
+}
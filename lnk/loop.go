@@ -0,0 +1,91 @@
+package lnk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maxRedirectChain is how many short links may point at each other before
+// a chain is rejected as too long to be intentional.
+const maxRedirectChain = 5
+
+// loadSelfHosts reads the extra hostnames the forwarder is served under
+// from SELF_HOSTS. The Host header of each request is always included.
+func loadSelfHosts(getenv func(string) string) []string {
+	var hosts []string
+	for _, host := range splitList(getenv("SELF_HOSTS")) {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	return hosts
+}
+
+// selfShortcode returns the domain and shortcode a destination points to
+// when it is a short link on this forwarder.
+func (lf *LinkForwarder) selfShortcode(destination, requestHost string) (string, string, bool) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", "", false
+	}
+
+	host := strings.ToLower(u.Host)
+	self := host == strings.ToLower(requestHost) || lf.customDomains[u.Hostname()]
+	for _, h := range lf.selfHosts {
+		if host == h || u.Hostname() == h {
+			self = true
+		}
+	}
+	if !self {
+		return "", "", false
+	}
+
+	shortcode, ok := strings.CutPrefix(u.Path, lf.appPath("/"))
+	if !ok || shortcode == "" || strings.Contains(shortcode, "/") {
+		return "", "", false
+	}
+	return lf.hostDomain(u.Host), lf.rules.normalize(strings.TrimSuffix(shortcode, "+")), true
+}
+
+// linkPath formats a short link for error messages, including its domain
+// when it has one.
+func linkPath(domain, shortcode string) string {
+	return domain + "/" + shortcode
+}
+
+// checkRedirectLoop follows a destination through any short links on this
+// forwarder and rejects it if the chain leads back to the link or is too
+// long to be intentional.
+func (lf *LinkForwarder) checkRedirectLoop(ctx context.Context, link Link, destination, requestHost string) error {
+	start := linkPath(link.Domain, link.Shortcode)
+	chain := []string{start}
+	seen := map[string]bool{start: true}
+
+	for {
+		domain, shortcode, ok := lf.selfShortcode(destination, requestHost)
+		if !ok {
+			return nil
+		}
+
+		next := linkPath(domain, shortcode)
+		chain = append(chain, next)
+		if seen[next] {
+			return fmt.Errorf("redirect loop: %s", strings.Join(chain, " → "))
+		}
+		if len(chain) > maxRedirectChain {
+			return fmt.Errorf("redirect chain is longer than %d links: %s", maxRedirectChain, strings.Join(chain, " → "))
+		}
+		seen[next] = true
+
+		target, err := lf.getLinkOrAlias(ctx, domain, shortcode)
+		if errors.Is(err, errLinkNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// An alias leads to its link; a loop back to it counts too
+		seen[linkPath(target.Domain, target.Shortcode)] = true
+		destination = target.URL
+	}
+}
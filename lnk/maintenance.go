@@ -0,0 +1,174 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// defaultMaintenanceMessage is shown when maintenance is turned on
+// without a message of its own.
+const defaultMaintenanceMessage = "Link management is down for maintenance. Short links keep working; please try again later."
+
+// Maintenance describes maintenance mode, in which short links keep
+// redirecting but only admins can use the management UI and API.
+type Maintenance struct {
+	Enabled   bool       `json:"enabled"`
+	Message   string     `json:"message,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	StartedBy string     `json:"started_by,omitempty"`
+}
+
+// MaintenanceRequest turns maintenance mode on or off.
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"` // shown to visitors; a default if empty
+}
+
+// MaintenanceData is rendered by the maintenance page.
+type MaintenanceData struct {
+	Message string
+	SSO     bool
+}
+
+// maintenance returns the maintenance mode. It's kept in the database so
+// turning it on reaches every replica.
+func (lf *LinkForwarder) maintenance(ctx context.Context) (Maintenance, error) {
+	var m Maintenance
+	var started time.Time
+	err := lf.db.QueryRowContext(ctx, `SELECT message, started_at, started_by FROM maintenance WHERE id = 1`).
+		Scan(&m.Message, &started, &m.StartedBy)
+	if err == sql.ErrNoRows {
+		return Maintenance{}, nil
+	} else if err != nil {
+		return m, err
+	}
+	m.Enabled, m.StartedAt = true, &started
+	if m.Message == "" {
+		m.Message = defaultMaintenanceMessage
+	}
+	return m, nil
+}
+
+// setMaintenance turns maintenance mode on, or replaces its message, or
+// turns it off.
+func (lf *LinkForwarder) setMaintenance(ctx context.Context, req MaintenanceRequest, actor string) error {
+	if !req.Enabled {
+		_, err := lf.db.ExecContext(ctx, `DELETE FROM maintenance`)
+		return err
+	}
+	_, err := lf.db.ExecContext(ctx, `INSERT INTO maintenance (id, message, started_at, started_by) VALUES (1, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET message = excluded.message`, req.Message, lf.now().UTC(), actor)
+	return err
+}
+
+// maintenanceBypass reports whether the request may go ahead during
+// maintenance: admins keep working, so they can finish it.
+func maintenanceBypass(r *http.Request) bool {
+	user := currentUser(r)
+	return user != nil && user.IsAdmin()
+}
+
+// refuseDuringMaintenance wraps the handler of an API operation that
+// non-admins may call so it answers 503 while maintenance mode is on. It
+// must run behind requireAuth.
+func (lf *LinkForwarder) refuseDuringMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceBypass(r) {
+			next(w, r)
+			return
+		}
+		m, err := lf.maintenance(r.Context())
+		if err != nil {
+			lf.logger.Printf("Failed to check maintenance mode: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to check maintenance mode")
+			return
+		}
+		if m.Enabled {
+			writeError(w, http.StatusServiceUnavailable, m.Message)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// maintenancePage wraps a page of the management UI so visitors get the
+// maintenance page while maintenance mode is on. It must run behind
+// requireLogin.
+func (lf *LinkForwarder) maintenancePage(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceBypass(r) {
+			next(w, r)
+			return
+		}
+		m, err := lf.maintenance(r.Context())
+		if err != nil {
+			lf.logger.Printf("Failed to check maintenance mode: %v", err)
+			http.Error(w, "Failed to check maintenance mode", http.StatusInternalServerError)
+			return
+		}
+		if !m.Enabled {
+			next(w, r)
+			return
+		}
+
+		tmpl, err := lf.loadTemplate("maintenance.html")
+		if err != nil {
+			http.Error(w, m.Message, http.StatusServiceUnavailable)
+			lf.logger.Printf("Template error: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := tmpl.Execute(w, MaintenanceData{Message: m.Message, SSO: lf.oidc != nil}); err != nil {
+			lf.logger.Printf("Template execution error: %v", err)
+		}
+	}
+}
+
+// handleMaintenance reports on maintenance mode, or with PUT turns it on
+// or off.
+func (lf *LinkForwarder) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		m, err := lf.maintenance(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve maintenance mode")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Maintenance mode retrieved successfully",
+			Data:    m,
+		})
+
+	case "PUT":
+		var req MaintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := lf.setMaintenance(r.Context(), req, lf.requestActor(r)); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to change maintenance mode")
+			return
+		}
+		m, err := lf.maintenance(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve maintenance mode")
+			return
+		}
+		if m.Enabled {
+			lf.logf(r, "%s turned on maintenance mode", lf.requestActor(r))
+		} else {
+			lf.logf(r, "%s turned off maintenance mode", lf.requestActor(r))
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Maintenance mode updated",
+			Data:    m,
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations are SQL files named NNNN_description.sql, applied in order of
+// their number. Never edit one that has been released; add a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const migrationsSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+// migration is one embedded schema change.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the embedded migrations, sorted by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := map[int]string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s must start with a positive version number", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrate brings the database schema up to date, applying each pending
+// migration in its own transaction.
+func (lf *LinkForwarder) migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	legacy, err := lf.legacySchema(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := lf.db.ExecContext(ctx, migrationsSchema); err != nil {
+		return err
+	}
+
+	applied := map[int]bool{}
+	rows, err := lf.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	latest := 0
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+		if version > latest {
+			latest = version
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if known := migrations[len(migrations)-1].version; latest > known {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", latest, known)
+	}
+
+	for i, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		apply := lf.applyMigration
+		if i == 0 && legacy {
+			apply = lf.adoptLegacySchema
+		}
+		if err := apply(ctx, m); err != nil {
+			return fmt.Errorf("migration %s failed: %v", m.name, err)
+		}
+		lf.logger.Printf("Applied migration %s", m.name)
+	}
+	return nil
+}
+
+// legacySchema reports whether the database was created before migrations
+// existed: it has links but no record of any migration.
+func (lf *LinkForwarder) legacySchema(ctx context.Context) (bool, error) {
+	names, err := queryNames(ctx, lf.db, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name IN ('links', 'schema_migrations')`)
+	return len(names) == 1 && names[0] == "links", err
+}
+
+func (lf *LinkForwarder) applyMigration(ctx context.Context, m migration) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptLegacySchema applies the initial migration to a database created
+// before migrations existed. Its tables may be missing columns or have
+// older keys depending on the release that created them, so each is moved
+// aside, recreated by the migration, and refilled from the columns both
+// versions share.
+func (lf *LinkForwarder) adoptLegacySchema(ctx context.Context, m migration) error {
+	lf.logger.Printf("Upgrading database created before schema migrations")
+
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Rows are copied table by table, so references only need to hold once
+	// everything is in place
+	if _, err := tx.ExecContext(ctx, `PRAGMA defer_foreign_keys = ON`); err != nil {
+		return err
+	}
+
+	tables, err := queryNames(ctx, tx, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		// Indexes would keep their names when the table is renamed and
+		// collide with the ones the migration creates
+		indexes, err := queryNames(ctx, tx, `SELECT name FROM sqlite_master
+			WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL`, table)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP INDEX "%s"`, index)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "legacy_%s"`, table, table)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+
+	var copied []string
+	for _, table := range tables {
+		legacy := "legacy_" + table
+		columns, err := tableColumns(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			// Not one of ours; leave it as it was
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, legacy, table)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		oldColumns, err := tableColumns(ctx, tx, legacy)
+		if err != nil {
+			return err
+		}
+		present := map[string]bool{}
+		for _, c := range oldColumns {
+			present[c] = true
+		}
+		var shared []string
+		for _, c := range columns {
+			if present[c] {
+				shared = append(shared, c)
+			}
+		}
+
+		list := strings.Join(shared, ", ")
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT %s FROM "%s"`, table, list, list, legacy)); err != nil {
+			return err
+		}
+		copied = append(copied, legacy)
+	}
+
+	// Only drop the old tables once every row is copied: their foreign keys
+	// point at each other, and dropping one cascades into the rest
+	for _, legacy := range copied {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE "%s"`, legacy)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryNames runs a query returning a single text column.
+func queryNames(ctx context.Context, q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// tableColumns lists the columns of a table, or none if it doesn't exist.
+func tableColumns(ctx context.Context, q querier, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info("%s")`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,78 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// TestAdoptLegacySchema opens a database as the first release left it, with
+// nothing but a links table, and checks its links come through the
+// migrations.
+func TestAdoptLegacySchema(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS links (
+			shortcode TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO links (shortcode, url, created_at) VALUES ('docs', 'https://dest.example/docs', '2023-04-01 12:00:00')`,
+		`INSERT INTO links (shortcode, url) VALUES ('blog', 'https://dest.example/blog')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("creating the legacy database: %v", err)
+		}
+	}
+	db.Close()
+
+	lf, err := New(WithDataDir(dir), WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer lf.Close()
+	ctx := context.Background()
+
+	link, err := lf.getLink(ctx, "", "docs")
+	if err != nil {
+		t.Fatalf("getLink docs: %v", err)
+	}
+	if link.URL != "https://dest.example/docs" {
+		t.Errorf("docs URL = %q, want https://dest.example/docs", link.URL)
+	}
+	if link.CreatedAt == nil || link.CreatedAt.Year() != 2023 {
+		t.Errorf("docs created_at = %v, want the legacy 2023-04-01", link.CreatedAt)
+	}
+
+	w := serve(lf, "GET", "/blog", "", nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://dest.example/blog" {
+		t.Errorf("GET /blog: status %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied int
+	if err := lf.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+	}
+	var legacy int
+	if err := lf.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'legacy_%'`).Scan(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy != 0 {
+		t.Errorf("%d legacy_ tables left behind", legacy)
+	}
+}
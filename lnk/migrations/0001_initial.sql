@@ -0,0 +1,93 @@
+-- Schema as of the switch to versioned migrations. Databases created
+-- before then are adopted by copying their rows into these tables.
+
+CREATE TABLE links (
+	domain TEXT NOT NULL DEFAULT '',
+	shortcode TEXT NOT NULL,
+	url TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	redirect_type INTEGER NOT NULL DEFAULT 0,
+	title TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	-- Tags are stored as ",tag1,tag2," so a single tag can be matched with LIKE
+	tags TEXT NOT NULL DEFAULT '',
+	owner TEXT NOT NULL DEFAULT '',
+	max_clicks INTEGER NOT NULL DEFAULT 0,
+	click_count INTEGER NOT NULL DEFAULT 0,
+	password_hash TEXT NOT NULL DEFAULT '',
+	active_from DATETIME,
+	active_until DATETIME,
+	-- Variants, geo rules, and UTM parameters are stored as JSON
+	variants TEXT NOT NULL DEFAULT '',
+	sticky_variants INTEGER NOT NULL DEFAULT 0,
+	geo_rules TEXT NOT NULL DEFAULT '',
+	ios_url TEXT NOT NULL DEFAULT '',
+	android_url TEXT NOT NULL DEFAULT '',
+	desktop_url TEXT NOT NULL DEFAULT '',
+	forward_query INTEGER NOT NULL DEFAULT 0,
+	forward_path INTEGER NOT NULL DEFAULT 0,
+	utm TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (domain, shortcode)
+);
+
+CREATE TABLE aliases (
+	domain TEXT NOT NULL DEFAULT '',
+	alias TEXT NOT NULL,
+	shortcode TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (domain, alias)
+);
+CREATE INDEX idx_aliases_shortcode ON aliases (shortcode);
+
+CREATE TABLE link_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	domain TEXT NOT NULL DEFAULT '',
+	shortcode TEXT NOT NULL,
+	action TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	previous TEXT,
+	current TEXT,
+	changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_link_history_shortcode ON link_history (shortcode, id);
+
+CREATE TABLE clicks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	domain TEXT NOT NULL DEFAULT '',
+	shortcode TEXT NOT NULL,
+	variant TEXT NOT NULL DEFAULT '',
+	clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_clicks_shortcode ON clicks (shortcode, clicked_at);
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE sessions (
+	token_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE domain_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	pattern TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (pattern, kind)
+);
+
+CREATE TABLE redirect_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	pattern TEXT NOT NULL,
+	destination TEXT NOT NULL,
+	redirect_type INTEGER NOT NULL DEFAULT 0,
+	priority INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- Lets /api/v1/shorten find an existing link to a URL without a table scan
+CREATE INDEX idx_links_url ON links (domain, url);
@@ -0,0 +1,11 @@
+CREATE TABLE api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token_hash TEXT NOT NULL UNIQUE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	expires_at DATETIME,
+	last_used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_api_tokens_user ON api_tokens (user_id);
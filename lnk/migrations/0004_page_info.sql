@@ -0,0 +1,4 @@
+-- Title and favicon of each link's destination page, fetched by the server
+ALTER TABLE links ADD COLUMN page_title TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN favicon_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN page_fetched_at DATETIME;
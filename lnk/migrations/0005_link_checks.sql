@@ -0,0 +1,6 @@
+-- Result of the last check of each link's destination
+ALTER TABLE links ADD COLUMN check_status INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN check_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN broken BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN checked_at DATETIME;
+CREATE INDEX idx_links_checked_at ON links (checked_at);
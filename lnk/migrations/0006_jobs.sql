@@ -0,0 +1,10 @@
+-- When each scheduled job runs next and how its last run went, shared by
+-- every replica so each run happens once
+CREATE TABLE jobs (
+	name TEXT PRIMARY KEY,
+	next_run_at DATETIME NOT NULL,
+	started_at DATETIME,
+	finished_at DATETIME,
+	result TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT ''
+);
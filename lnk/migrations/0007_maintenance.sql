@@ -0,0 +1,7 @@
+-- Maintenance mode, on while the row exists; shared by every replica
+CREATE TABLE maintenance (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	message TEXT NOT NULL DEFAULT '',
+	started_at DATETIME NOT NULL,
+	started_by TEXT NOT NULL DEFAULT ''
+);
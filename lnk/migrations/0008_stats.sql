@@ -0,0 +1,16 @@
+-- The site a click came from, as its hostname; empty when the browser
+-- didn't say
+ALTER TABLE clicks ADD COLUMN referrer TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_clicks_clicked_at ON clicks (clicked_at);
+
+-- Requests for shortcodes that don't exist, one row per shortcode
+CREATE TABLE unknown_shortcodes (
+	domain TEXT NOT NULL DEFAULT '',
+	shortcode TEXT NOT NULL,
+	hits INTEGER NOT NULL DEFAULT 0,
+	referrer TEXT NOT NULL DEFAULT '', -- of the latest request that had one
+	first_seen_at DATETIME NOT NULL,
+	last_seen_at DATETIME NOT NULL,
+	PRIMARY KEY (domain, shortcode)
+);
+CREATE INDEX idx_unknown_shortcodes_last_seen_at ON unknown_shortcodes (last_seen_at);
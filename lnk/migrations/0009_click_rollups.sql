@@ -0,0 +1,12 @@
+-- Clicks counted per link and day (in UTC), so raw clicks can be pruned
+-- without losing the stats they add up to
+CREATE TABLE click_rollups (
+	domain TEXT NOT NULL DEFAULT '',
+	shortcode TEXT NOT NULL,
+	day TEXT NOT NULL, -- YYYY-MM-DD
+	variant TEXT NOT NULL DEFAULT '',
+	referrer TEXT NOT NULL DEFAULT '',
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (domain, shortcode, day, variant, referrer)
+);
+CREATE INDEX idx_click_rollups_day ON click_rollups (day);
@@ -0,0 +1,2 @@
+-- Links whose clicks are only counted, never logged
+ALTER TABLE links ADD COLUMN untracked INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- Groups file links into folders, such as marketing or engineering, one
+-- group per link. A link's group is created along with it if need be.
+CREATE TABLE link_groups (
+	domain TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (domain, name)
+);
+ALTER TABLE links ADD COLUMN group_name TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_links_group ON links (domain, group_name);
@@ -0,0 +1,3 @@
+-- Pinned links sort before the rest, so the most used aren't lost among
+-- one-offs
+ALTER TABLE links ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- When each link last redirected someone, so stale links stand out;
+-- filled in from the click log kept so far
+ALTER TABLE links ADD COLUMN last_accessed_at DATETIME;
+UPDATE links SET last_accessed_at = (SELECT MAX(clicked_at) FROM clicks
+	WHERE clicks.domain = links.domain AND clicks.shortcode = links.shortcode);
+CREATE INDEX idx_links_last_accessed ON links (domain, last_accessed_at);
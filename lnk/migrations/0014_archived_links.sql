@@ -0,0 +1,2 @@
+-- Links archived for going unused; they drop out of the default listing
+ALTER TABLE links ADD COLUMN archived_at DATETIME;
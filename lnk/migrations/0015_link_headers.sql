@@ -0,0 +1,2 @@
+-- Extra response headers sent with a link's redirect, as JSON
+ALTER TABLE links ADD COLUMN headers TEXT NOT NULL DEFAULT '';
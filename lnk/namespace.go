@@ -0,0 +1,56 @@
+package lnk
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// loadCustomDomains reads CUSTOM_DOMAINS, the hostnames that get their own
+// link namespace. Requests for any other host use the default namespace.
+func loadCustomDomains(getenv func(string) string) map[string]bool {
+	domains := map[string]bool{}
+	for _, d := range splitList(getenv("CUSTOM_DOMAINS")) {
+		domains[strings.TrimSuffix(strings.ToLower(d), ".")] = true
+	}
+	return domains
+}
+
+// hostDomain maps a Host header to the namespace it serves: the hostname
+// itself for a custom domain, otherwise "" for the default namespace.
+func (lf *LinkForwarder) hostDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if lf.customDomains[host] {
+		return host
+	}
+	return ""
+}
+
+// requestDomain returns the namespace a short link request is served from.
+func (lf *LinkForwarder) requestDomain(r *http.Request) string {
+	return lf.hostDomain(r.Host)
+}
+
+// apiDomain returns the namespace an API request works on. A ?domain=
+// parameter lets one host manage every namespace; without it the request's
+// own host decides.
+func (lf *LinkForwarder) apiDomain(r *http.Request) (string, error) {
+	if _, ok := r.URL.Query()["domain"]; !ok {
+		return lf.requestDomain(r), nil
+	}
+	return lf.checkCustomDomain(r.URL.Query().Get("domain"))
+}
+
+// checkCustomDomain normalizes an explicitly requested domain, which must be
+// empty (the default namespace) or one of CUSTOM_DOMAINS.
+func (lf *LinkForwarder) checkCustomDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain != "" && !lf.customDomains[domain] {
+		return "", fmt.Errorf("unknown domain '%s'", domain)
+	}
+	return domain, nil
+}
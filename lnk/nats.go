@@ -0,0 +1,62 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const natsTimeout = 10 * time.Second
+
+// natsPublisher publishes to a NATS subject. nats.go reads credentials
+// from the URL: user:password, or a token as a URL with only a username.
+type natsPublisher struct {
+	url     string
+	subject string
+	conn    *nats.Conn
+}
+
+func newNATSPublisher(u *url.URL, subject string) *natsPublisher {
+	return &natsPublisher{url: u.String(), subject: subject}
+}
+
+// publish sends events to the subject and waits for the server to take
+// them. The connection is made on the first batch; while it's being
+// re-established, publishing fails rather than buffering, so the batch is
+// retried whole.
+func (p *natsPublisher) publish(ctx context.Context, events []ClickEvent) error {
+	if p.conn == nil {
+		conn, err := nats.Connect(p.url,
+			nats.Name("lnk"),
+			nats.Timeout(natsTimeout),
+			nats.MaxReconnects(-1),
+			nats.ReconnectBufSize(-1),
+		)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := p.conn.Publish(p.subject, payload); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,103 @@
+package lnk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNATS is just enough of a NATS server for a client to log in and
+// publish, keeping what it's sent.
+type fakeNATS struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	connect  map[string]any
+	messages map[string][]string // payloads by subject
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNATS{ln: ln, messages: map[string][]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.0.0\",\"max_payload\":1048576,\"proto\":1}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch op {
+		case "CONNECT":
+			s.mu.Lock()
+			json.Unmarshal([]byte(args), &s.connect)
+			s.mu.Unlock()
+		case "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case "PUB":
+			fields := strings.Fields(args)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages[fields[0]] = append(s.messages[fields[0]], string(payload[:size]))
+			s.mu.Unlock()
+		}
+	}
+}
+
+// TestNATSPublisher checks that clicks reach the subject as JSON, logging
+// in with the URL's credentials.
+func TestNATSPublisher(t *testing.T) {
+	server := newFakeNATS(t)
+	u, _ := url.Parse("nats://clicks:s3cret@" + server.ln.Addr().String())
+	p := newNATSPublisher(u, "lnk.clicks")
+	defer p.close()
+
+	events := []ClickEvent{{Schema: clickEventSchema, ID: "1", Shortcode: "docs"}, {Schema: clickEventSchema, ID: "2", Shortcode: "blog"}}
+	if err := p.publish(context.Background(), events); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.connect["user"] != "clicks" || server.connect["pass"] != "s3cret" {
+		t.Errorf("CONNECT %v, want the URL's user and password", server.connect)
+	}
+	got := server.messages["lnk.clicks"]
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, want 2", len(got))
+	}
+	var event ClickEvent
+	if err := json.Unmarshal([]byte(got[1]), &event); err != nil || event.ID != "2" || event.Shortcode != "blog" {
+		t.Errorf("second message %s (%v), want click 2 on blog", got[1], err)
+	}
+}
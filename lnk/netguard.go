@@ -0,0 +1,115 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// blockedNetworks are address ranges that shouldn't be reachable through a
+// public short link: loopback, private, link-local, carrier-grade NAT, and
+// other special-purpose ranges.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isBlockedIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardedDialer returns the dialer for connections the server makes to
+// destinations. With BLOCK_PRIVATE_DESTINATIONS it refuses internal
+// addresses, checked after DNS resolution so neither redirects nor DNS
+// changes since the link was saved can get around it.
+func (lf *LinkForwarder) guardedDialer() *net.Dialer {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if lf.blockPrivate {
+		d.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && isBlockedIP(ip) {
+				return fmt.Errorf("%s is an internal address", host)
+			}
+			return nil
+		}
+	}
+	return d
+}
+
+// checkDestinationAddress rejects destinations whose host is, or resolves
+// to, an internal address. It only runs when BLOCK_PRIVATE_DESTINATIONS is
+// enabled.
+func (lf *LinkForwarder) checkDestinationAddress(ctx context.Context, destination string) error {
+	if !lf.blockPrivate {
+		return nil
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedIP(ip) {
+			return fmt.Errorf("destination %s is an internal address", host)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("destination host %s could not be resolved", host)
+	}
+	for _, addr := range addrs {
+		if isBlockedIP(addr.IP) {
+			return fmt.Errorf("destination %s resolves to an internal address", host)
+		}
+	}
+	return nil
+}
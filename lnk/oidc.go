@@ -0,0 +1,266 @@
+package lnk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie = "lnk_oidc_state"
+	oidcNonceCookie = "lnk_oidc_nonce"
+	oidcNextCookie  = "lnk_oidc_next"
+)
+
+// oidcAuth signs users in with an OpenID Connect provider such as Google
+// Workspace, Okta, or Azure AD.
+type oidcAuth struct {
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	config   oauth2.Config
+	// admins are the emails that are given the admin role on sign in
+	admins map[string]bool
+}
+
+// loadOIDC configures single sign-on from OIDC_ISSUER, OIDC_CLIENT_ID,
+// OIDC_CLIENT_SECRET, and OIDC_REDIRECT_URL. It returns nil when
+// OIDC_ISSUER isn't set.
+func loadOIDC(ctx context.Context, getenv func(string) string) (*oidcAuth, error) {
+	issuer := getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	clientID := getenv("OIDC_CLIENT_ID")
+	redirectURL := getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %v", issuer, err)
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+	if v := getenv("OIDC_SCOPES"); v != "" {
+		scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+
+	admins := make(map[string]bool)
+	for _, email := range strings.Split(getenv("OIDC_ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+
+	return &oidcAuth{
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: getenv("OIDC_CLIENT_SECRET"),
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		admins: admins,
+	}, nil
+}
+
+// oidcClaims are the ID token claims used to identify a user.
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// username picks the account name for a verified ID token.
+func (c oidcClaims) username(subject string) (string, error) {
+	if c.Email != "" {
+		if c.EmailVerified != nil && !*c.EmailVerified {
+			return "", errors.New("email address is not verified")
+		}
+		return strings.ToLower(c.Email), nil
+	}
+	if c.PreferredUsername != "" {
+		return c.PreferredUsername, nil
+	}
+	return subject, nil
+}
+
+// userForIDToken verifies an ID token and returns its user, creating the
+// account on first sign in.
+func (lf *LinkForwarder) userForIDToken(ctx context.Context, rawIDToken, nonce string) (*User, error) {
+	idToken, err := lf.oidc.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce != "" && idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	username, err := claims.username(idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	role := roleUser
+	if lf.oidc.admins[username] {
+		role = roleAdmin
+	}
+
+	user, err := lf.getUser(ctx, username)
+	if errors.Is(err, errUserNotFound) {
+		lf.logger.Printf("Creating account for SSO user %s", username)
+		return lf.createSSOUser(ctx, username, role)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Keep admin grants in sync with OIDC_ADMIN_EMAILS
+	if role == roleAdmin && user.Role != roleAdmin {
+		if _, err := lf.db.ExecContext(ctx, `UPDATE users SET role = ? WHERE id = ?`, roleAdmin, user.ID); err != nil {
+			return nil, err
+		}
+		user.Role = roleAdmin
+	}
+	return user, nil
+}
+
+// createSSOUser creates an account that can only sign in through OIDC; its
+// empty password hash never matches a password.
+func (lf *LinkForwarder) createSSOUser(ctx context.Context, username, role string) (*User, error) {
+	result, err := lf.db.ExecContext(ctx, `INSERT INTO users (username, password_hash, role) VALUES (?, '', ?)`, username, role)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: id, Username: username, Role: role, CreatedAt: lf.now().UTC()}, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (lf *LinkForwarder) setOIDCCookie(w http.ResponseWriter, r *http.Request, name, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     lf.appPath("/auth/oidc"),
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// handleOIDCLogin sends the browser to the identity provider.
+func (lf *LinkForwarder) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if lf.readOnly {
+		lf.renderLogin(w, http.StatusServiceUnavailable, LoginData{Next: "/", SSO: true, ErrorMessage: readOnlyLoginMessage})
+		return
+	}
+	state, err := randomState()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomState()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	lf.setOIDCCookie(w, r, oidcStateCookie, state)
+	lf.setOIDCCookie(w, r, oidcNonceCookie, nonce)
+	lf.setOIDCCookie(w, r, oidcNextCookie, safeRedirectTarget(r.URL.Query().Get("next")))
+	http.Redirect(w, r, lf.oidc.config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+// handleOIDCCallback completes the login started by handleOIDCLogin.
+func (lf *LinkForwarder) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	state, err := r.Cookie(oidcStateCookie)
+	if err != nil || r.URL.Query().Get("state") != state.Value {
+		http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+		return
+	}
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		lf.renderLogin(w, http.StatusUnauthorized, LoginData{Next: "/", SSO: true, ErrorMessage: "Single sign-on failed: " + msg})
+		return
+	}
+	nonce, err := r.Cookie(oidcNonceCookie)
+	if err != nil {
+		http.Error(w, "Invalid login state, please try again", http.StatusBadRequest)
+		return
+	}
+	next := "/"
+	if c, err := r.Cookie(oidcNextCookie); err == nil {
+		next = safeRedirectTarget(c.Value)
+	}
+	if lf.readOnly {
+		lf.renderLogin(w, http.StatusServiceUnavailable, LoginData{Next: next, SSO: true, ErrorMessage: readOnlyLoginMessage})
+		return
+	}
+
+	token, err := lf.oidc.config.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		lf.logf(r, "OIDC code exchange failed: %v", err)
+		http.Error(w, "Failed to complete login", http.StatusBadGateway)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Identity provider did not return an ID token", http.StatusBadGateway)
+		return
+	}
+
+	user, err := lf.userForIDToken(r.Context(), rawIDToken, nonce.Value)
+	if err != nil {
+		lf.logf(r, "OIDC login rejected: %v", err)
+		lf.renderLogin(w, http.StatusUnauthorized, LoginData{Next: next, SSO: true, ErrorMessage: "Single sign-on failed"})
+		return
+	}
+
+	sessionToken, expires, err := lf.createSession(r.Context(), user)
+	if err != nil {
+		lf.logf(r, "Failed to create session for %s: %v", user.Username, err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	for _, name := range []string{oidcStateCookie, oidcNonceCookie, oidcNextCookie} {
+		http.SetCookie(w, &http.Cookie{Name: name, Path: lf.appPath("/auth/oidc"), MaxAge: -1})
+	}
+	lf.logf(r, "User %s logged in via SSO", user.Username)
+	lf.setSessionCookie(w, r, sessionToken, expires)
+	http.Redirect(w, r, lf.appPath(next), http.StatusSeeOther)
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:]), true
+	}
+	return "", false
+}
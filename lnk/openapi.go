@@ -0,0 +1,442 @@
+package lnk
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiOperation is one method on one API path. The router registers the
+// API from the same list the OpenAPI spec is generated from, so the spec
+// can't describe an endpoint that doesn't exist or miss one that does.
+type apiOperation struct {
+	method  string
+	path    string // relative to /api/v1, in gorilla/mux syntax
+	summary string
+	handler http.HandlerFunc
+	admin   bool
+	scope   string // token scope needed; read for GET and write otherwise if unset
+
+	domain bool       // accepts ?domain= to pick a custom domain's namespace
+	query  []apiParam // other query parameters
+	body   any        // zero value of the request body's type, if any
+	data   any        // zero value of the response's data, if any
+	meta   any        // zero value of the response's meta, if any
+	status int        // success status; 200 if unset
+	stream any        // zero value of the events, for a text/event-stream response
+}
+
+// apiParam documents a query parameter.
+type apiParam struct {
+	name, kind, description string
+}
+
+// apiOperations lists every API endpoint.
+func (lf *LinkForwarder) apiOperations() []apiOperation {
+	return []apiOperation{
+		{method: "GET", path: "/links", summary: "List links", handler: lf.handleAPI, domain: true,
+			query: []apiParam{
+				{"q", "string", "Only links whose shortcode or URL contains this text"},
+				{"tag", "string", "Only links with this tag; repeat for links with all of them"},
+				{"group", "string", "Only links in this group"},
+				{"archived", "boolean", "true to list archived links, which are otherwise left out"},
+				{"pinned", "boolean", "true for only pinned links, false for only the rest; pinned links are always listed first"},
+				{"url", "string", "Only links to exactly this destination"},
+				{"status", "string", "broken, ok, or unchecked: only links with this result from the link checker"},
+				{"sort", "string", "created_at (default), shortcode, url, clicks, or last_used"},
+				{"order", "string", "asc or desc"},
+				{"page", "integer", "1-based page number; omit to get every match"},
+				{"per_page", "integer", "Links per page"},
+			},
+			data: []Link{}, meta: ListMeta{}},
+		{method: "POST", path: "/links", summary: "Create a link, or replace the one with the same shortcode; with DEDUPLICATE_URLS, an existing link to the URL is returned instead of a new one", handler: lf.handleAPI, domain: true,
+			scope: scopeCreate, body: Link{}, data: Link{}},
+		{method: "GET", path: "/shorten", summary: "Create a link from query parameters, or return an existing one; answers with the bare short URL unless JSON is requested. A browser that sends the session cookie from another site gets a page to confirm the link on instead", handler: lf.handleShorten, domain: true,
+			scope: scopeCreate,
+			query: []apiParam{
+				{"url", "string", "Destination to shorten (required)"},
+				{"code", "string", "Shortcode to use; generated when omitted"},
+				{"format", "string", "text or json; by default JSON only if the Accept header asks for it"},
+			},
+			data: Link{}},
+		{method: "POST", path: "/shorten", summary: "Create a link like GET /shorten, from a form or query parameters; with the session cookie, the form must carry its csrf_token", handler: lf.handleShorten, domain: true,
+			scope: scopeCreate,
+			query: []apiParam{
+				{"url", "string", "Destination to shorten (required); may also be a form field"},
+				{"code", "string", "Shortcode to use; generated when omitted; may also be a form field"},
+				{"format", "string", "text or json; may also be a form field"},
+			},
+			data: Link{}},
+		{method: "DELETE", path: "/links", summary: "Delete several links in one transaction: all of them, or none if any can't be deleted", handler: lf.handleBulkDelete, domain: true,
+			query: []apiParam{
+				{"shortcodes", "string", "Comma-separated shortcodes to delete (required)"},
+			},
+			data: []BatchResult{}},
+		{method: "POST", path: "/links/batch", summary: "Create, update, and delete links in one transaction", handler: lf.handleBatch, domain: true,
+			body: []BatchOperation{}, data: []BatchResult{}},
+		{method: "POST", path: "/links/sync", summary: "Make the links match a links file in YAML or JSON: create and update the links it lists, and optionally delete the rest, in one transaction", handler: lf.handleSync, domain: true,
+			query: []apiParam{
+				{"prune", "boolean", "true to delete links the file doesn't list, on the domains it has links on"},
+				{"dry_run", "boolean", "true to report the changes without making them"},
+			},
+			body: SyncFile{}, data: SyncResult{}},
+		{method: "GET", path: "/links/{shortcode}", summary: "Get a link and its click stats", handler: lf.handleGetLink, domain: true,
+			query: []apiParam{
+				{"suggest", "integer", "1 to get similar shortcodes, as {\"suggestions\": [...]} in the data of a 404"},
+			},
+			data: LinkDetail{}},
+		{method: "PUT", path: "/links/{shortcode}", summary: "Update an existing link", handler: lf.handleAPI, domain: true,
+			body: Link{}, data: Link{}},
+		{method: "DELETE", path: "/links/{shortcode}", summary: "Delete a link", handler: lf.handleAPI, domain: true},
+		{method: "GET", path: "/links/{shortcode}/qr", summary: "The QR code of a link's short URL, as a PNG", handler: lf.handleQRCode, domain: true},
+		{method: "GET", path: "/links/{shortcode}/stats", summary: "Click totals for a link", handler: lf.handleStats, domain: true,
+			data: LinkStats{}},
+		{method: "GET", path: "/links/{shortcode}/clicks/export", summary: "Download a link's clicks as CSV (the default) or JSON Lines, one row per click or per day", handler: lf.handleClickExport, domain: true,
+			query: []apiParam{
+				{"format", "string", "csv (default) or json, for JSON Lines"},
+				{"granularity", "string", "raw (default), one row per click, or daily, clicks added up per day, variant, and referrer"},
+				{"from", "string", "Only clicks from this date (YYYY-MM-DD) or RFC 3339 time on"},
+				{"to", "string", "Only clicks up to this date, included, or before this RFC 3339 time"},
+			}},
+		{method: "GET", path: "/stats/top", summary: "The most clicked links over a window, and those gaining the most clicks on the window before", handler: lf.handleTopLinks,
+			query: []apiParam{
+				{"window", "string", "24h (default), 7d, or 30d"},
+			},
+			data: TopLinks{}},
+		{method: "POST", path: "/links/{shortcode}/page-info", summary: "Fetch the title and favicon of a link's destination page", handler: lf.handlePageInfo, domain: true,
+			data: Link{}},
+		{method: "POST", path: "/links/{shortcode}/archive", summary: "Archive a link, taking it out of the default listing", handler: lf.handleArchive, domain: true,
+			data: Link{}},
+		{method: "POST", path: "/links/{shortcode}/restore", summary: "Restore an archived link", handler: lf.handleArchive, domain: true,
+			data: Link{}},
+		{method: "GET", path: "/links/{shortcode}/aliases", summary: "List a link's aliases", handler: lf.handleAliases, domain: true,
+			data: []string{}},
+		{method: "POST", path: "/links/{shortcode}/aliases", summary: "Add an alias for a link", handler: lf.handleAliases, domain: true,
+			body: aliasRequest{}, data: map[string]string{}, status: http.StatusCreated},
+		{method: "DELETE", path: "/links/{shortcode}/aliases/{alias}", summary: "Remove an alias", handler: lf.handleAliases, domain: true},
+		{method: "GET", path: "/links/{shortcode}/history", summary: "Audit log of a shortcode", handler: lf.handleHistory, domain: true,
+			data: []HistoryEntry{}},
+		{method: "GET", path: "/groups", summary: "List link groups and how many links each holds", handler: lf.handleGroups, domain: true,
+			data: []Group{}},
+		{method: "POST", path: "/groups", summary: "Create a link group; saving a link in a group that doesn't exist creates it too", handler: lf.handleGroups, domain: true,
+			body: Group{}, data: Group{}, status: http.StatusCreated},
+		{method: "GET", path: "/groups/{name}", summary: "Get a link group", handler: lf.handleGroups, domain: true,
+			data: Group{}},
+		{method: "PUT", path: "/groups/{name}", summary: "Rename a link group, moving its links along, or change its description", handler: lf.handleGroups, domain: true, admin: true,
+			body: Group{}, data: Group{}},
+		{method: "DELETE", path: "/groups/{name}", summary: "Delete a link group; its links are kept, in no group", handler: lf.handleGroups, domain: true, admin: true},
+		{method: "GET", path: "/events", summary: "Server-sent events for clicks and link changes", handler: lf.handleEvents, domain: true,
+			query: []apiParam{
+				{"type", "string", "Only events of this type: click, create, update, or delete"},
+				{"shortcode", "string", "Only events for this shortcode"},
+			},
+			stream: Event{}},
+		{method: "GET", path: "/me", summary: "The authenticated account", handler: lf.handleMe,
+			data: User{}},
+		{method: "GET", path: "/tokens", summary: "List your API tokens", handler: lf.handleTokens, scope: scopeSession,
+			data: []APIToken{}},
+		{method: "POST", path: "/tokens", summary: "Create an API token; its secret is only returned here", handler: lf.handleTokens, scope: scopeSession,
+			body: createTokenRequest{}, data: APIToken{}, status: http.StatusCreated},
+		{method: "DELETE", path: "/tokens/{id:[0-9]+}", summary: "Revoke one of your API tokens", handler: lf.handleTokens, scope: scopeSession},
+		{method: "GET", path: "/users", summary: "List accounts", handler: lf.handleUsers, admin: true,
+			data: []User{}},
+		{method: "POST", path: "/users", summary: "Create an account", handler: lf.handleUsers, admin: true,
+			body: createUserRequest{}, data: User{}, status: http.StatusCreated},
+		{method: "DELETE", path: "/users/{username}", summary: "Delete an account", handler: lf.handleUsers, admin: true},
+		{method: "GET", path: "/admin/domains", summary: "List blocked and allowed domains", handler: lf.handleDomainRules, admin: true,
+			data: []DomainRule{}},
+		{method: "POST", path: "/admin/domains", summary: "Add a domain rule", handler: lf.handleDomainRules, admin: true,
+			body: DomainRule{}, data: DomainRule{}, status: http.StatusCreated},
+		{method: "DELETE", path: "/admin/domains/{id:[0-9]+}", summary: "Remove a domain rule", handler: lf.handleDomainRules, admin: true},
+		{method: "GET", path: "/admin/rules", summary: "List regex redirect rules", handler: lf.handleRedirectRules, admin: true,
+			data: []RedirectRule{}},
+		{method: "POST", path: "/admin/rules", summary: "Add a regex redirect rule", handler: lf.handleRedirectRules, admin: true,
+			body: RedirectRule{}, data: RedirectRule{}, status: http.StatusCreated},
+		{method: "DELETE", path: "/admin/rules/{id:[0-9]+}", summary: "Remove a regex redirect rule", handler: lf.handleRedirectRules, admin: true},
+		{method: "GET", path: "/admin/backups", summary: "List database backups, newest first", handler: lf.handleBackups, admin: true,
+			data: []Backup{}},
+		{method: "POST", path: "/admin/backup", summary: "Back up the database now", handler: lf.handleBackups, admin: true,
+			data: Backup{}, status: http.StatusCreated},
+		{method: "POST", path: "/admin/restore", summary: "Replace the database with a backup, named in the body or uploaded as it", handler: lf.handleRestore, admin: true,
+			body: RestoreRequest{}, data: Restore{}},
+		{method: "GET", path: "/admin/replication", summary: "Report on the write-ahead log and replication", handler: lf.handleReplication, admin: true,
+			data: ReplicationStatus{}},
+		{method: "POST", path: "/admin/checkpoint", summary: "Copy the write-ahead log into the database without truncating it", handler: lf.handleReplication, admin: true,
+			data: Checkpoint{}},
+		{method: "GET", path: "/admin/stats", summary: "Totals, daily clicks, top links and referrers, and recent 404s", handler: lf.handleSiteStats, admin: true,
+			query: []apiParam{
+				{"days", "integer", "Days of clicks to cover, today included (default 30, at most 365)"},
+			},
+			data: SiteStats{}},
+		{method: "GET", path: "/admin/maintenance", summary: "Report whether maintenance mode is on", handler: lf.handleMaintenance, admin: true,
+			data: Maintenance{}},
+		{method: "PUT", path: "/admin/maintenance", summary: "Turn maintenance mode on or off", handler: lf.handleMaintenance, admin: true,
+			body: MaintenanceRequest{}, data: Maintenance{}},
+		{method: "GET", path: "/admin/jobs", summary: "List scheduled jobs and how their last runs went", handler: lf.handleJobs, admin: true,
+			data: []JobStatus{}},
+		{method: "POST", path: "/admin/jobs/{name}/run", summary: "Run a scheduled job now", handler: lf.handleJobs, admin: true,
+			status: http.StatusAccepted},
+	}
+}
+
+// pathParam matches a gorilla/mux path variable, with its optional pattern.
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPISpec describes the API as an OpenAPI 3 document.
+func (lf *LinkForwarder) openAPISpec() map[string]any {
+	schemas := map[string]any{}
+	gen := schemaGenerator{schemas: schemas}
+	gen.schemaOf(reflect.TypeOf(Response{}))
+	var codes []string
+	for _, code := range errorCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	codes = append(codes, "error")
+	code := schemas["Response"].(map[string]any)["properties"].(map[string]any)["code"].(map[string]any)
+	code["enum"] = codes
+	code["description"] = "Why the request failed; error is used for statuses without a code of their own"
+
+	paths := map[string]map[string]any{}
+	for _, op := range lf.apiOperations() {
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			kind := "string"
+			if m[2] == ":[0-9]+" {
+				kind = "integer"
+			}
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": kind},
+			})
+		}
+		if op.domain {
+			params = append(params, map[string]any{
+				"name": "domain", "in": "query",
+				"description": "Custom domain whose links to use; defaults to the request's host",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range op.query {
+			params = append(params, map[string]any{
+				"name": q.name, "in": "query", "description": q.description,
+				"schema": map[string]any{"type": q.kind},
+			})
+		}
+
+		envelope := map[string]any{}
+		if op.data != nil {
+			envelope["data"] = gen.schemaOf(reflect.TypeOf(op.data))
+		}
+		if op.meta != nil {
+			envelope["meta"] = gen.schemaOf(reflect.TypeOf(op.meta))
+		}
+		result := map[string]any{"$ref": "#/components/schemas/Response"}
+		if len(envelope) > 0 {
+			result = map[string]any{"allOf": []any{
+				result,
+				map[string]any{"type": "object", "properties": envelope},
+			}}
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		success := jsonContent(http.StatusText(status), result)
+		if op.stream != nil {
+			success = map[string]any{
+				"description": "A stream of events, each sent as an SSE event named after its type with the event as JSON data",
+				"content": map[string]any{
+					"text/event-stream": map[string]any{"schema": gen.schemaOf(reflect.TypeOf(op.stream))},
+				},
+			}
+		}
+		operation := map[string]any{
+			"summary": op.summary,
+			"responses": map[string]any{
+				strconv.Itoa(status): success,
+				"default": jsonContent("Error, with the reason in message",
+					map[string]any{"$ref": "#/components/schemas/Response"}),
+			},
+		}
+		var notes []string
+		if op.admin {
+			notes = append(notes, "Admin only.")
+		}
+		if scope := op.tokenScope(); scope == scopeSession {
+			notes = append(notes, "Not available to API tokens.")
+		} else {
+			notes = append(notes, "API tokens need the "+scope+" scope.")
+		}
+		operation["description"] = strings.Join(notes, " ")
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.body != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": gen.schemaOf(reflect.TypeOf(op.body))},
+				},
+			}
+		}
+
+		path := apiV1 + pathParam.ReplaceAllString(op.path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.method)] = operation
+	}
+
+	server := lf.basePath
+	if server == "" {
+		server = "/"
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Link Forwarder API",
+			"version": "1",
+			"description": "Every response is a JSON envelope: success, a human-readable message, " +
+				"and the result in data (and meta for lists). Failed requests also carry a stable " +
+				"code. Authentication is only required when accounts are enabled. The same " +
+				"endpoints are still served without the /v1 prefix, but those paths are deprecated.",
+		},
+		"servers": []any{map[string]any{"url": server}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"basicAuth":  map[string]any{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "description": "API token (lnk_...) or OpenID Connect ID token"},
+				"session":    map[string]any{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		},
+		"security": []any{
+			map[string]any{"basicAuth": []string{}},
+			map[string]any{"bearerAuth": []string{}},
+			map[string]any{"session": []string{}},
+		},
+	}
+}
+
+func jsonContent(description string, schema any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schema},
+		},
+	}
+}
+
+// schemaGenerator derives JSON schemas from Go types through their json
+// tags, collecting named structs as reusable components.
+type schemaGenerator struct {
+	schemas map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g schemaGenerator) schemaOf(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		// Unexported request types are still named like the others
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil // mark it so recursive types terminate
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{} // any value
+}
+
+func (g schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			// encoding/json promotes an embedded struct's fields
+			embedded := g.structSchema(f.Type)
+			for name, prop := range embedded["properties"].(map[string]any) {
+				properties[name] = prop
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// handleOpenAPI serves the spec. It's public so tools can fetch it before
+// they have credentials.
+func (lf *LinkForwarder) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(lf.openAPISpec())
+}
+
+// handleAPIDocs serves Swagger UI for the spec, when SWAGGER_UI is set.
+func (lf *LinkForwarder) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := lf.loadTemplate("apidocs.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := tmpl.Execute(w, nil); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package lnk
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Option changes how New sets up a forwarder. Options win over the
+// matching environment variables.
+type Option func(*LinkForwarder)
+
+// WithEnv reads the settings documented in the README through getenv,
+// usually os.Getenv. Without it every setting keeps its default, so a
+// forwarder embedded in another program isn't configured by accident.
+func WithEnv(getenv func(string) string) Option {
+	return func(lf *LinkForwarder) {
+		lf.getenv = getenv
+	}
+}
+
+// WithDataDir keeps the database and other state in dir, like DATA_DIR.
+func WithDataDir(dir string) Option {
+	return func(lf *LinkForwarder) {
+		lf.dataDir = dir
+	}
+}
+
+// WithStorage uses an already opened SQLite database instead of opening
+// one in the data directory. Its schema is migrated by New; Close leaves it
+// open.
+func WithStorage(db *sql.DB) Option {
+	return func(lf *LinkForwarder) {
+		lf.db = db
+	}
+}
+
+// WithLogger sends the forwarder's log output to logger instead of the
+// standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(lf *LinkForwarder) {
+		lf.logger = logger
+	}
+}
+
+// WithClock makes the forwarder tell time with now, which decides session
+// expiry and link activation windows.
+func WithClock(now func() time.Time) Option {
+	return func(lf *LinkForwarder) {
+		lf.now = now
+	}
+}
+
+// WithBasePath serves the forwarder under a URL prefix such as "/lnk",
+// like BASE_PATH. Use it when mounting the forwarder in another mux:
+//
+//	mux.Handle("/lnk/", lf)
+func WithBasePath(path string) Option {
+	return func(lf *LinkForwarder) {
+		lf.basePath = path
+	}
+}
+
+// WithReadOnly serves links and reads but refuses changes, like
+// READ_ONLY=true.
+func WithReadOnly() Option {
+	return func(lf *LinkForwarder) {
+		lf.readOnly = true
+	}
+}
+
+// WithDebugHandler serves h under /debug/ to admins, for profiling a
+// running server with net/http/pprof and expvar. Until an admin account
+// exists it refuses everyone. Requests reach h with their full path,
+// /debug/pprof/heap and so on, and without REQUEST_TIMEOUT, so CPU
+// profiles can run as long as they're asked to.
+func WithDebugHandler(h http.Handler) Option {
+	return func(lf *LinkForwarder) {
+		lf.debug = h
+	}
+}
+
+// WithThemeDir overrides the embedded templates, partials, and static
+// files with those in dir, like THEME_DIR.
+func WithThemeDir(dir string) Option {
+	return func(lf *LinkForwarder) {
+		lf.themeDir = dir
+	}
+}
+
+// WithDevMode reads the theme directory again on every page and static
+// file served, so changes to it show up without a restart.
+func WithDevMode() Option {
+	return func(lf *LinkForwarder) {
+		lf.devMode = true
+	}
+}
+
+// WithSyncFile keeps the links in line with the links file at path, like
+// SYNC_FILE.
+func WithSyncFile(path string) Option {
+	return func(lf *LinkForwarder) {
+		lf.sync.file = path
+	}
+}
@@ -0,0 +1,259 @@
+package lnk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// Limits on fetching a destination page for its title and favicon.
+const (
+	pageFetchTimeout = 10 * time.Second
+	maxPageBytes     = 1 << 20 // the title is in the head, near the start
+	pageQueueSize    = 1000
+)
+
+// pageInfo is what a destination page says about itself.
+type pageInfo struct {
+	title   string
+	favicon string
+}
+
+// newPageClient returns the client used to fetch destination pages. With
+// BLOCK_PRIVATE_DESTINATIONS it can't connect to internal addresses, even
+// through a redirect.
+func (lf *LinkForwarder) newPageClient() *http.Client {
+	return &http.Client{
+		Timeout: pageFetchTimeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         lf.guardedDialer().DialContext,
+			TLSHandshakeTimeout: pageFetchTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+	}
+}
+
+// fetchPageInfo reads the title and favicon of the page at destination.
+// Pages that aren't HTML have no title but may still have a favicon at the
+// site root.
+func (lf *LinkForwarder) fetchPageInfo(ctx context.Context, destination string) (pageInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return pageInfo{}, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	req.Header.Set("User-Agent", "lnk link title fetcher")
+	resp, err := lf.pageClient.Do(req)
+	if err != nil {
+		return pageInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return pageInfo{}, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	// Relative favicon links are relative to where redirects ended up
+	base := resp.Request.URL
+	info := pageInfo{favicon: (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}).String()}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return info, nil
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxPageBytes), contentType)
+	if err != nil {
+		return info, err
+	}
+	title, icon := parsePageHead(body)
+	info.title = cleanPageTitle(title)
+	if icon != "" {
+		if u, err := base.Parse(icon); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			info.favicon = u.String()
+		}
+	}
+	return info, nil
+}
+
+// parsePageHead returns the text of a page's <title> and the href of its
+// first icon link, reading no further than the start of the body.
+func parsePageHead(r io.Reader) (title, icon string) {
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return title, icon
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return title, icon
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = title == ""
+			case "body":
+				return title, icon
+			case "link":
+				var rel, href string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "rel":
+						rel = strings.ToLower(string(val))
+					case "href":
+						href = string(val)
+					}
+				}
+				if icon == "" && href != "" && isIconRel(rel) {
+					icon = href
+				}
+			}
+		}
+	}
+}
+
+// isIconRel reports whether a <link rel> value names a favicon, as in
+// "icon" or the older "shortcut icon".
+func isIconRel(rel string) bool {
+	for _, r := range strings.Fields(rel) {
+		if r == "icon" {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPageTitle collapses the whitespace in a title and shortens it to
+// what a link's own title may hold.
+func cleanPageTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) <= maxTitleLength {
+		return title
+	}
+	return string([]rune(title)[:maxTitleLength-1]) + "…"
+}
+
+// refreshPageInfo fetches the page a link points to and stores its title
+// and favicon with the link.
+func (lf *LinkForwarder) refreshPageInfo(ctx context.Context, link Link) (Link, error) {
+	info, err := lf.fetchPageInfo(ctx, link.URL)
+	if err != nil {
+		return link, err
+	}
+	now := lf.now().UTC()
+	// The URL check skips storing the page of a destination that was
+	// changed while it was being fetched
+	result, err := lf.db.ExecContext(ctx, `UPDATE links SET page_title = ?, favicon_url = ?, page_fetched_at = ?
+		WHERE domain = ? AND shortcode = ? AND url = ?`,
+		info.title, info.favicon, now, link.Domain, link.Shortcode, link.URL)
+	if err != nil {
+		return link, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return link, errLinkNotFound
+	}
+	lf.invalidateLinks()
+	link.PageTitle, link.FaviconURL, link.PageFetchedAt = info.title, info.favicon, &now
+	return link, nil
+}
+
+// queuePageInfo asks for the page of a saved link to be fetched in the
+// background, when FETCH_PAGE_INFO is on. Links with a click limit are
+// left alone since their destinations are often single-use, and so are
+// URL templates, which aren't pages until a visit fills them in.
+func (lf *LinkForwarder) queuePageInfo(link Link) {
+	if lf.pageQueue == nil || link.MaxClicks != 0 || isTemplate(link.URL) {
+		return
+	}
+	select {
+	case lf.pageQueue <- link:
+	default:
+		lf.logger.Printf("Page fetch queue is full; skipping %s", link.Shortcode)
+	}
+}
+
+// fetchQueuedPages fetches the pages of queued links until ctx is
+// cancelled, one at a time so a large batch doesn't flood other sites.
+func (lf *LinkForwarder) fetchQueuedPages(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case queued := <-lf.pageQueue:
+			link, err := lf.getLink(ctx, queued.Domain, queued.Shortcode)
+			// Saves that kept the URL keep the page info fetched before
+			if err != nil || link.PageFetchedAt != nil || link.URL != queued.URL {
+				continue
+			}
+			if _, err := lf.refreshPageInfo(ctx, link); err != nil && !errors.Is(err, errLinkNotFound) {
+				lf.logger.Printf("Failed to fetch page info for %s: %v", link.Shortcode, err)
+			}
+		}
+	}
+}
+
+// handlePageInfo fetches a link's title and favicon on demand, whether or
+// not FETCH_PAGE_INFO is on.
+func (lf *LinkForwarder) handlePageInfo(w http.ResponseWriter, r *http.Request) {
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, err := lf.getLink(r.Context(), domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+	if user := currentUser(r); user != nil && !user.canEdit(link) {
+		writeError(w, http.StatusForbidden, "You can only change links you own")
+		return
+	}
+	if isTemplate(link.URL) {
+		writeError(w, http.StatusBadRequest, "URL templates have no page to fetch")
+		return
+	}
+
+	link, err = lf.refreshPageInfo(r.Context(), link)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusConflict, "The link changed while its page was being fetched")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch %s: %v", link.URL, err))
+		return
+	}
+	link.ShortURL = lf.shortURL(r, link)
+
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Page info fetched successfully",
+		Data:    link,
+	})
+}
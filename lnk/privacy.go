@@ -0,0 +1,86 @@
+package lnk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// IP_ANONYMIZATION modes.
+const (
+	ipTruncate = "truncate" // zero the host part: IPv4 to /24, IPv6 to /48
+	ipHash     = "hash"     // HMAC with IP_HASH_KEY, so one address keeps one pseudonym
+)
+
+// privacyConfig says what the server may keep about visitors.
+type privacyConfig struct {
+	clickLogging bool // log each click, not only count it
+	respectDNT   bool // no click log for browsers sending DNT or Sec-GPC
+	ipMode       string
+	ipKey        []byte
+}
+
+// loadPrivacy reads CLICK_LOGGING, RESPECT_DNT, IP_ANONYMIZATION, and
+// IP_HASH_KEY.
+func loadPrivacy(getenv func(string) string) (privacyConfig, error) {
+	cfg := privacyConfig{clickLogging: true}
+	if v := getenv("CLICK_LOGGING"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CLICK_LOGGING %q: must be true or false", v)
+		}
+		cfg.clickLogging = b
+	}
+	cfg.respectDNT, _ = strconv.ParseBool(getenv("RESPECT_DNT"))
+
+	switch cfg.ipMode = strings.ToLower(getenv("IP_ANONYMIZATION")); cfg.ipMode {
+	case "", ipTruncate:
+	case ipHash:
+		key := getenv("IP_HASH_KEY")
+		if key == "" {
+			return cfg, errors.New("IP_HASH_KEY is required with IP_ANONYMIZATION=hash")
+		}
+		cfg.ipKey = []byte(key)
+	default:
+		return cfg, fmt.Errorf("invalid IP_ANONYMIZATION %q: must be truncate, hash, or empty", cfg.ipMode)
+	}
+	return cfg, nil
+}
+
+// anonymizeIP returns ip as it may be stored.
+func (p privacyConfig) anonymizeIP(ip string) string {
+	switch p.ipMode {
+	case ipTruncate:
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ip
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	case ipHash:
+		mac := hmac.New(sha256.New, p.ipKey)
+		mac.Write([]byte(ip))
+		return "ip-" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return ip
+}
+
+// doNotTrack reports whether the browser asked not to be tracked, with
+// the Do Not Track header or Global Privacy Control.
+func doNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// tracking reports whether details of a visit (when it was, the referrer)
+// may be logged. Totals, such as a link's click count, are kept either way.
+func (lf *LinkForwarder) tracking(r *http.Request) bool {
+	return lf.privacy.clickLogging && !(lf.privacy.respectDNT && doNotTrack(r))
+}
@@ -0,0 +1,129 @@
+package lnk
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// loadTrustedProxies reads TRUSTED_PROXIES, the addresses or CIDR ranges of
+// reverse proxies whose X-Forwarded-* headers are believed.
+func loadTrustedProxies(getenv func(string) string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range splitList(getenv("TRUSTED_PROXIES")) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether addr belongs to a configured reverse proxy.
+func (lf *LinkForwarder) trustedProxy(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, n := range lf.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withProxyHeaders applies X-Forwarded-For, X-Forwarded-Host, and
+// X-Forwarded-Proto from trusted proxies to the request, so everything
+// downstream sees the visitor's address, host, and scheme. Headers from
+// anyone else are ignored since clients can set them freely.
+func (lf *LinkForwarder) withProxyHeaders(next http.Handler) http.Handler {
+	if len(lf.trustedProxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !lf.trustedProxy(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Walk the chain from the nearest hop; the first address that isn't
+		// one of our proxies is the visitor
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(strings.Join(xff, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if net.ParseIP(hop) == nil {
+					break
+				}
+				r.RemoteAddr = net.JoinHostPort(hop, "0")
+				if !lf.trustedProxy(hop) {
+					break
+				}
+			}
+		}
+		if host := firstForwarded(r.Header.Get("X-Forwarded-Host")); host != "" {
+			r.Host = host
+		}
+		if proto := strings.ToLower(firstForwarded(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// firstForwarded returns the first entry of a comma-separated forwarding
+// header, which the outermost proxy set.
+func firstForwarded(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// isHTTPS reports whether the visitor reached us over HTTPS, directly or
+// through a trusted proxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
+
+// shortURL returns the absolute URL visitors use for a link: at
+// PUBLIC_URL when it's set, since the request may have come in by an
+// internal name, and otherwise at the host and scheme the request used.
+func (lf *LinkForwarder) shortURL(r *http.Request, link Link) string {
+	if lf.publicURL != nil {
+		return lf.publicShortURL(link)
+	}
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return lf.linkURL(scheme, r.Host, link)
+}
+
+// publicShortURL is shortURL without a request, for the bots, which need
+// PUBLIC_URL.
+func (lf *LinkForwarder) publicShortURL(link Link) string {
+	return lf.linkURL(lf.publicURL.Scheme, lf.publicURL.Host, link)
+}
+
+// linkURL puts together a short link at host, or at its own domain if it
+// has one.
+func (lf *LinkForwarder) linkURL(scheme, host string, link Link) string {
+	if link.Domain != "" {
+		host = link.Domain
+	}
+	return scheme + "://" + host + lf.appPath("/"+link.Shortcode)
+}
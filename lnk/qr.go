@@ -0,0 +1,48 @@
+package lnk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrCodeSize is the width and height of generated QR codes, in pixels.
+const qrCodeSize = 512
+
+// qrPNG renders content as a QR code image.
+func qrPNG(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, qrCodeSize)
+}
+
+// handleQRCode serves the QR code of a link's short URL as a PNG, for
+// printing or showing on screen.
+func (lf *LinkForwarder) handleQRCode(w http.ResponseWriter, r *http.Request) {
+	shortcode := lf.rules.normalize(mux.Vars(r)["shortcode"])
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, err := lf.getLink(r.Context(), domain, shortcode)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve link")
+		return
+	}
+	png, err := qrPNG(lf.shortURL(r, link))
+	if err != nil {
+		lf.logf(r, "Failed to render QR code for %s: %v", shortcode, err)
+		writeError(w, http.StatusInternalServerError, "Failed to render QR code")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", shortcode+".png"))
+	w.Write(png)
+}
@@ -0,0 +1,29 @@
+package lnk
+
+import "net/http"
+
+// readOnlyMessage explains why a change was refused.
+const readOnlyMessage = "The server is read-only; changes are turned off"
+
+// readOnlyLoginMessage is shown instead of logging in, since sessions are
+// kept in the database.
+const readOnlyLoginMessage = "Logging in is unavailable while the server is read-only"
+
+// writes reports whether an API operation changes anything, and so is
+// refused in read-only mode. Creating a link through GET /shorten counts.
+func (op apiOperation) writes() bool {
+	return op.method != http.MethodGet || op.scope == scopeCreate
+}
+
+// refuseWhenReadOnly wraps the handler of an operation that writes so it
+// answers 503 in read-only mode, telling clients to try the primary (or
+// again after maintenance) rather than that they did something wrong.
+func (lf *LinkForwarder) refuseWhenReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if lf.readOnly {
+			writeError(w, http.StatusServiceUnavailable, readOnlyMessage)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,239 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errRedirectRuleNotFound = errors.New("redirect rule not found")
+
+// RedirectRule redirects request paths that match a regular expression.
+// Rules are only consulted when no shortcode matches, lowest priority
+// first, and the destination may refer to capture groups as $1 or ${name}.
+type RedirectRule struct {
+	ID           int64     `json:"id"`
+	Pattern      string    `json:"pattern"`
+	Destination  string    `json:"destination"`
+	RedirectType int       `json:"redirect_type,omitempty"`
+	Priority     int       `json:"priority"`
+	CreatedAt    time.Time `json:"created_at"`
+	re           *regexp.Regexp
+}
+
+// redirectRuleSet keeps the compiled rules in memory.
+type redirectRuleSet struct {
+	mu    sync.RWMutex
+	rules []RedirectRule
+}
+
+func (s *redirectRuleSet) set(rules []RedirectRule) {
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+}
+
+// match returns the first rule matching path and its expanded destination.
+func (s *redirectRuleSet) match(path string) (RedirectRule, string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rule := range s.rules {
+		m := rule.re.FindStringSubmatchIndex(path)
+		if m == nil {
+			continue
+		}
+		destination := rule.re.ExpandString(nil, rule.Destination, path, m)
+		return rule, string(destination), true
+	}
+	return RedirectRule{}, "", false
+}
+
+// matchRedirectRule finds where a request path that isn't a shortcode
+// should go according to the redirect rules.
+func (lf *LinkForwarder) matchRedirectRule(path string) (string, int, bool) {
+	rule, destination, ok := lf.redirectRules.match(path)
+	if !ok {
+		return "", 0, false
+	}
+
+	destination, err := lf.validateURL(destination)
+	if err == nil {
+		err = lf.checkDomain(destination)
+	}
+	if err != nil {
+		lf.logger.Printf("Redirect rule %d produced an invalid destination for %s: %v", rule.ID, path, err)
+		return "", 0, false
+	}
+
+	status := rule.RedirectType
+	if status == 0 {
+		status = lf.defaultRedirectType
+	}
+	return destination, status, true
+}
+
+// loadRedirectRules refreshes the in-memory rules from the database.
+func (lf *LinkForwarder) loadRedirectRules(ctx context.Context) error {
+	rules, err := lf.listRedirectRules(ctx)
+	if err != nil {
+		return err
+	}
+	lf.redirectRules.set(rules)
+	return nil
+}
+
+func (lf *LinkForwarder) listRedirectRules(ctx context.Context) ([]RedirectRule, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT id, pattern, destination, redirect_type, priority, created_at
+		FROM redirect_rules ORDER BY priority, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RedirectRule{}
+	for rows.Next() {
+		var rule RedirectRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.Destination, &rule.RedirectType,
+			&rule.Priority, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("redirect rule %d: %v", rule.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (lf *LinkForwarder) createRedirectRule(ctx context.Context, rule RedirectRule) (RedirectRule, error) {
+	rule.CreatedAt = lf.now().UTC()
+	result, err := lf.db.ExecContext(ctx, `INSERT INTO redirect_rules (pattern, destination, redirect_type, priority)
+		VALUES (?, ?, ?, ?)`, rule.Pattern, rule.Destination, rule.RedirectType, rule.Priority)
+	if err != nil {
+		return rule, err
+	}
+	if rule.ID, err = result.LastInsertId(); err != nil {
+		return rule, err
+	}
+	if err := lf.loadRedirectRules(ctx); err != nil {
+		return rule, err
+	}
+	lf.rulesChanged()
+	return rule, nil
+}
+
+func (lf *LinkForwarder) deleteRedirectRule(ctx context.Context, id int64) error {
+	result, err := lf.db.ExecContext(ctx, `DELETE FROM redirect_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errRedirectRuleNotFound
+	}
+	if err := lf.loadRedirectRules(ctx); err != nil {
+		return err
+	}
+	lf.rulesChanged()
+	return nil
+}
+
+// validateRedirectRule checks a new rule's pattern, destination, and
+// redirect type.
+func (lf *LinkForwarder) validateRedirectRule(rule *RedirectRule) error {
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Destination = strings.TrimSpace(rule.Destination)
+	if rule.Pattern == "" || rule.Destination == "" {
+		return fmt.Errorf("pattern and destination are required")
+	}
+
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	rule.re = re
+
+	if rule.RedirectType != 0 && !validRedirectTypes[rule.RedirectType] {
+		return fmt.Errorf("redirect_type must be 301, 302, 307, or 308")
+	}
+
+	// Check the destination as written; capture groups are filled in later
+	destination, err := lf.validateURL(rule.Destination)
+	if err != nil {
+		return err
+	}
+	rule.Destination = destination
+	return lf.checkDomain(destination)
+}
+
+func (lf *LinkForwarder) handleRedirectRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		rules, err := lf.listRedirectRules(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve redirect rules")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Redirect rules retrieved successfully",
+			Data:    rules,
+		})
+
+	case "POST":
+		var rule RedirectRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := lf.validateRedirectRule(&rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		rule, err := lf.createRedirectRule(r.Context(), rule)
+		if err != nil {
+			lf.logf(r, "Failed to create redirect rule: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to create redirect rule")
+			return
+		}
+		lf.logf(r, "%s added redirect rule %s -> %s", lf.requestActor(r), rule.Pattern, rule.Destination)
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "Redirect rule created successfully",
+			Data:    rule,
+		})
+
+	case "DELETE":
+		id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err := lf.deleteRedirectRule(r.Context(), id); err != nil {
+			if errors.Is(err, errRedirectRuleNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "Failed to delete redirect rule")
+			}
+			return
+		}
+		lf.logf(r, "%s deleted redirect rule %d", lf.requestActor(r), id)
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Redirect rule deleted successfully",
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,89 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestRedirectRules checks that paths no shortcode matches are redirected by
+// the first matching rule in priority order, with capture groups filled in.
+func TestRedirectRules(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }
+	if err := lf.saveLink(context.Background(), Link{Shortcode: "blog", URL: "https://dest.example/blog"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	for _, body := range []string{
+		`{"pattern":"^/old/(\\d+)$","destination":"https://dest.example/new/$1","priority":10}`,
+		`{"pattern":"^/old/(?P<slug>[a-z-]+)$","destination":"https://dest.example/posts/${slug}","redirect_type":301,"priority":20}`,
+		`{"pattern":"^/old/","destination":"https://dest.example/archive","priority":30}`,
+		`{"pattern":"^/blog$","destination":"https://dest.example/not-the-link","priority":0}`,
+	} {
+		w := serve(lf, "POST", "/api/v1/admin/rules", body, admin)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("creating rule %s: status %d: %s", body, w.Code, w.Body)
+		}
+		var resp struct{ Data RedirectRule }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.Data.ID)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/old/42", http.StatusFound, "https://dest.example/new/42"},
+		{"/old/hello-world", http.StatusMovedPermanently, "https://dest.example/posts/hello-world"},
+		{"/old/42/comments", http.StatusFound, "https://dest.example/archive"},
+		{"/blog", http.StatusFound, "https://dest.example/blog"},
+		{"/elsewhere", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(lf, "GET", tt.path, "", nil)
+		if w.Code != tt.status || w.Header().Get("Location") != tt.want {
+			t.Errorf("%s: %d to %q, want %d to %q", tt.path, w.Code, w.Header().Get("Location"), tt.status, tt.want)
+		}
+	}
+
+	if w := serve(lf, "DELETE", fmt.Sprintf("/api/v1/admin/rules/%d", ids[0]), "", admin); w.Code != http.StatusOK {
+		t.Fatalf("deleting rule: status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(lf, "GET", "/old/42", "", nil); w.Header().Get("Location") != "https://dest.example/archive" {
+		t.Errorf("after deleting the first rule, /old/42 went to %q, want the next rule's", w.Header().Get("Location"))
+	}
+	if w := serve(lf, "DELETE", fmt.Sprintf("/api/v1/admin/rules/%d", ids[0]), "", admin); w.Code != http.StatusNotFound {
+		t.Errorf("deleting the rule again: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestRedirectRuleValidation checks the rules the API refuses, and that
+// only administrators may add them.
+func TestRedirectRuleValidation(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") }
+	for _, body := range []string{
+		`{"pattern":"^/old/(","destination":"https://dest.example/"}`,
+		`{"pattern":"^/old/","destination":""}`,
+		`{"pattern":"^/old/","destination":"javascript:alert(1)"}`,
+		`{"pattern":"^/old/","destination":"https://dest.example/","redirect_type":303}`,
+	} {
+		if w := serve(lf, "POST", "/api/v1/admin/rules", body, admin); w.Code != http.StatusBadRequest {
+			t.Errorf("rule %s: status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	user := testUser(t, lf, "alice", roleUser)
+	body := `{"pattern":"^/old/","destination":"https://dest.example/"}`
+	w := serve(lf, "POST", "/api/v1/admin/rules", body, func(r *http.Request) { r.SetBasicAuth(user.Username, "password-alice") })
+	if w.Code != http.StatusForbidden {
+		t.Errorf("rule from a non-admin: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,208 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisCacheTTL = time.Hour
+
+	// Cached links are stored under the current generation; bumping it
+	// invalidates every replica's cache at once.
+	redisGenerationKey = "lnk:links:generation"
+	redisPurgeChannel  = "lnk:links:purge"
+	redisEventsChannel = "lnk:events"
+	redisRulesChannel  = "lnk:rules"
+)
+
+// redisCache is a read-through link cache shared by every replica pointed
+// at the same Redis. Each replica still keeps its in-memory cache; purges
+// are broadcast so those are emptied everywhere when a link changes, and
+// so are changes to the rules each replica keeps in memory.
+type redisCache struct {
+	logger     *log.Logger
+	client     *redis.Client
+	ttl        time.Duration
+	generation atomic.Int64
+}
+
+// redisLink is what gets cached for a link. The password hash isn't part
+// of Link's JSON but is needed to unlock protected links.
+type redisLink struct {
+	Link         Link   `json:"link"`
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// loadRedis connects to REDIS_URL, if set. REDIS_CACHE_TTL bounds how long
+// a link stays cached.
+func loadRedis(getenv func(string) string, logger *log.Logger) (*redisCache, error) {
+	rawURL := getenv("REDIS_URL")
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	c := &redisCache{logger: logger, client: redis.NewClient(opts), ttl: defaultRedisCacheTTL}
+
+	if v := getenv("REDIS_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			c.client.Close()
+			return nil, fmt.Errorf("invalid REDIS_CACHE_TTL %q: must be a positive duration such as 30m", v)
+		}
+		c.ttl = ttl
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.refreshGeneration(ctx); err != nil {
+		c.client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	return c, nil
+}
+
+func (c *redisCache) refreshGeneration(ctx context.Context) error {
+	gen, err := c.client.Get(ctx, redisGenerationKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		gen, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+	c.generation.Store(gen)
+	return nil
+}
+
+func (c *redisCache) key(generation int64, domain, code string) string {
+	return "lnk:links:" + strconv.FormatInt(generation, 10) + ":" + linkCacheKey(domain, code)
+}
+
+// get returns the cached link and the generation it was looked up under.
+func (c *redisCache) get(ctx context.Context, domain, code string) (Link, int64, bool) {
+	generation := c.generation.Load()
+	data, err := c.client.Get(ctx, c.key(generation, domain, code)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.logger.Printf("Redis cache lookup failed: %v", err)
+		}
+		return Link{}, generation, false
+	}
+
+	var cached redisLink
+	if err := json.Unmarshal(data, &cached); err != nil {
+		c.logger.Printf("Ignoring unreadable cached link %s: %v", linkCacheKey(domain, code), err)
+		return Link{}, generation, false
+	}
+	link := cached.Link
+	link.passwordHash = cached.PasswordHash
+	return link, generation, true
+}
+
+// add caches a link read from the database under the generation current
+// before the read, so a purge in between leaves it unreachable.
+func (c *redisCache) add(ctx context.Context, domain, code string, link Link, generation int64) {
+	data, err := json.Marshal(redisLink{Link: link, PasswordHash: link.passwordHash})
+	if err != nil {
+		return
+	}
+	if err := c.client.Set(ctx, c.key(generation, domain, code), data, c.ttl).Err(); err != nil {
+		c.logger.Printf("Redis cache store failed: %v", err)
+	}
+}
+
+// purge invalidates every cached link and tells the other replicas.
+func (c *redisCache) purge(ctx context.Context) {
+	gen, err := c.client.Incr(ctx, redisGenerationKey).Result()
+	if err != nil {
+		c.logger.Printf("Redis cache purge failed: %v", err)
+		return
+	}
+	c.generation.Store(gen)
+	if err := c.client.Publish(ctx, redisPurgeChannel, gen).Err(); err != nil {
+		c.logger.Printf("Redis purge broadcast failed: %v", err)
+	}
+}
+
+// rulesChanged tells every replica to reload its rules from the database.
+func (c *redisCache) rulesChanged(ctx context.Context) {
+	if err := c.client.Publish(ctx, redisRulesChannel, "").Err(); err != nil {
+		c.logger.Printf("Redis rules broadcast failed: %v", err)
+	}
+}
+
+// publishEvent sends an event to every replica's event streams.
+func (c *redisCache) publishEvent(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, redisEventsChannel, data).Err()
+}
+
+// watch empties the local cache whenever another replica changes a link,
+// calls reloadRules when one changes the rules, and passes events on to
+// this replica's streams, until the client is closed. Purges and rule
+// changes missed while disconnected are covered by re-reading the
+// generation and the rules on every resubscribe; missed events are gone.
+func (c *redisCache) watch(local *linkCache, events *eventHub, reloadRules func()) {
+	ctx := context.Background()
+	sub := c.client.Subscribe(ctx, redisPurgeChannel, redisEventsChannel, redisRulesChannel)
+	defer sub.Close()
+
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			c.logger.Printf("Redis subscription failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if err := c.refreshGeneration(ctx); err != nil {
+				c.logger.Printf("Failed to read Redis cache generation: %v", err)
+			}
+			local.purge()
+			// Only once subscribed to every channel, not after each one
+			if m.Count == 3 {
+				reloadRules()
+			}
+		case *redis.Message:
+			switch m.Channel {
+			case redisEventsChannel:
+				var e Event
+				if err := json.Unmarshal([]byte(m.Payload), &e); err == nil {
+					events.broadcast(e)
+				}
+				continue
+			case redisRulesChannel:
+				reloadRules()
+				continue
+			}
+			if gen, err := strconv.ParseInt(m.Payload, 10, 64); err == nil && gen > c.generation.Load() {
+				c.generation.Store(gen)
+			}
+			local.purge()
+		}
+	}
+}
+
+func (c *redisCache) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,72 @@
+package lnk
+
+import (
+	"context"
+	"strings"
+)
+
+// defaultReferrerSpam lists sites known for referrer spam: fake visits
+// whose only purpose is to get their Referer into analytics.
+var defaultReferrerSpam = []string{
+	"4webmasters.org",
+	"best-seo-offer.com",
+	"buttons-for-website.com",
+	"darodar.com",
+	"free-social-buttons.com",
+	"get-free-traffic-now.com",
+	"hulfingtonpost.com",
+	"ilovevitaly.com",
+	"priceg.com",
+	"semalt.com",
+	"trafficmonetize.com",
+}
+
+// referrerBlocklist holds referrer spam hostnames. Each also covers its
+// subdomains.
+type referrerBlocklist map[string]bool
+
+// loadReferrerBlocklist returns the built-in blocklist plus any extra
+// comma-separated hostnames from REFERRER_BLOCKLIST.
+func loadReferrerBlocklist(getenv func(string) string) referrerBlocklist {
+	blocked := referrerBlocklist{}
+	for _, host := range defaultReferrerSpam {
+		blocked[host] = true
+	}
+	for _, host := range splitList(getenv("REFERRER_BLOCKLIST")) {
+		blocked[strings.TrimPrefix(strings.ToLower(host), "*.")] = true
+	}
+	return blocked
+}
+
+// blocks reports whether host, a referrer's hostname, is spam.
+func (b referrerBlocklist) blocks(host string) bool {
+	for host != "" {
+		if b[host] {
+			return true
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return false
+}
+
+// topReferrers returns the sites that sent the most clicks to a link
+// since the day since (YYYY-MM-DD), or ever when since is empty.
+func (lf *LinkForwarder) topReferrers(ctx context.Context, domain, shortcode, since string, limit int) ([]ReferrerClicks, error) {
+	rows, err := lf.db.QueryContext(ctx, `WITH `+clickCountsSQL+` SELECT referrer, SUM(clicks) AS total FROM click_counts
+		WHERE domain = ? AND shortcode = ? AND day >= ? AND referrer != ''
+		GROUP BY referrer ORDER BY total DESC, referrer LIMIT ?`,
+		domain, shortcode, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var referrers []ReferrerClicks
+	for rows.Next() {
+		var ref ReferrerClicks
+		if err := rows.Scan(&ref.Referrer, &ref.Clicks); err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, ref)
+	}
+	return referrers, rows.Err()
+}
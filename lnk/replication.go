@@ -0,0 +1,142 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// replicationLitestream is the REPLICATION mode for running under
+// Litestream (https://litestream.io), which streams the WAL to a replica.
+const replicationLitestream = "litestream"
+
+// sqliteLitestreamDriver is SQLite with automatic checkpoints turned off on
+// every connection. Litestream checkpoints once it has copied the WAL, so
+// none of it is checkpointed away before it's replicated.
+const sqliteLitestreamDriver = "sqlite3_litestream"
+
+var litestreamDriver = &sqlite3.SQLiteDriver{
+	ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		_, err := conn.Exec(`PRAGMA wal_autocheckpoint = 0`, nil)
+		return err
+	},
+}
+
+func init() {
+	sql.Register(sqliteLitestreamDriver, litestreamDriver)
+}
+
+// ReplicationStatus describes the database's WAL and whether an external
+// replicator is keeping up with it.
+type ReplicationStatus struct {
+	Mode           string `json:"mode,omitempty"` // REPLICATION
+	JournalMode    string `json:"journal_mode"`
+	AutoCheckpoint int    `json:"autocheckpoint"` // WAL pages; 0 when off
+	WALSize        int64  `json:"wal_size"`       // bytes
+	Litestream     bool   `json:"litestream"`     // Litestream has attached to the database
+}
+
+// Checkpoint is the outcome of copying the WAL back into the database.
+type Checkpoint struct {
+	Busy               bool `json:"busy"` // readers or writers kept it from finishing
+	WALFrames          int  `json:"wal_frames"`
+	CheckpointedFrames int  `json:"checkpointed_frames"`
+}
+
+// loadReplication reads REPLICATION, which is empty or "litestream".
+func loadReplication(getenv func(string) string) (string, error) {
+	switch mode := strings.ToLower(getenv("REPLICATION")); mode {
+	case "", replicationLitestream:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid REPLICATION %q: must be litestream, or empty", mode)
+	}
+}
+
+// sqliteDriver returns the driver to open the database with in a
+// replication mode.
+func sqliteDriver(mode string) string {
+	if mode == replicationLitestream {
+		return sqliteLitestreamDriver
+	}
+	return "sqlite3"
+}
+
+// replicationStatus looks at the database's WAL.
+func (lf *LinkForwarder) replicationStatus(ctx context.Context) (ReplicationStatus, error) {
+	status := ReplicationStatus{Mode: lf.replication}
+	if err := lf.db.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&status.JournalMode); err != nil {
+		return status, err
+	}
+	if err := lf.db.QueryRowContext(ctx, `PRAGMA wal_autocheckpoint`).Scan(&status.AutoCheckpoint); err != nil {
+		return status, err
+	}
+	// Databases in memory have no file, nor a WAL
+	var file string
+	if err := lf.db.QueryRowContext(ctx, `SELECT file FROM pragma_database_list WHERE name = 'main'`).Scan(&file); err != nil {
+		return status, err
+	}
+	if file != "" {
+		info, err := os.Stat(file + "-wal")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return status, err
+		} else if err == nil {
+			status.WALSize = info.Size()
+		}
+	}
+	// Litestream keeps its own tables in the database it replicates
+	var tables int
+	if err := lf.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name = '_litestream_seq'`).Scan(&tables); err != nil {
+		return status, err
+	}
+	status.Litestream = tables > 0
+	return status, nil
+}
+
+// checkpoint copies what it can of the WAL into the database without
+// waiting for readers, which Litestream allows alongside its own
+// checkpoints. Truncating the WAL would make it start a new snapshot.
+func (lf *LinkForwarder) checkpoint(ctx context.Context) (Checkpoint, error) {
+	var cp Checkpoint
+	var busy int
+	err := lf.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(PASSIVE)`).Scan(&busy, &cp.WALFrames, &cp.CheckpointedFrames)
+	cp.Busy = busy != 0
+	return cp, err
+}
+
+// handleReplication reports on the WAL, or with POST checkpoints it.
+func (lf *LinkForwarder) handleReplication(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		status, err := lf.replicationStatus(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve replication status")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Replication status retrieved successfully",
+			Data:    status,
+		})
+
+	case "POST":
+		cp, err := lf.checkpoint(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to checkpoint database")
+			return
+		}
+		lf.logf(r, "%s checkpointed the database: %d of %d WAL frames", lf.requestActor(r), cp.CheckpointedFrames, cp.WALFrames)
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Database checkpointed",
+			Data:    cp,
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package lnk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDHeader carries a request's ID, from the client or a proxy in
+// front of the server, and back in the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs accepted from clients, which end up in
+// logs.
+const maxRequestIDLength = 128
+
+const requestIDContextKey contextKey = "request_id"
+
+// validRequestID reports whether id, sent by a client, is safe to log and
+// echo: printable ASCII without spaces, of a sensible length.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random ID for a request that didn't bring one.
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// withRequestID gives each request an ID, keeping the one in X-Request-ID
+// when the client or a proxy sent one, so a request can be followed from
+// service to service. The ID is echoed in the response, shows up in error
+// responses and the log lines written while serving the request, and is
+// available to handlers via requestID.
+func (lf *LinkForwarder) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
+	})
+}
+
+// requestID returns the ID of the request ctx belongs to, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// logf logs a message about r, tagged with its request ID.
+func (lf *LinkForwarder) logf(r *http.Request, format string, args ...any) {
+	lf.logContextf(r.Context(), format, args...)
+}
+
+// logContextf is logf for work that has the request's context but not the
+// request.
+func (lf *LinkForwarder) logContextf(ctx context.Context, format string, args ...any) {
+	if id := requestID(ctx); id != "" {
+		format, args = "[%s] "+format, append([]any{id}, args...)
+	}
+	lf.logger.Printf(format, args...)
+}
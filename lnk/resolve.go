@@ -0,0 +1,62 @@
+package lnk
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// resolveDestination works out where a visit to link should go, applying
+// the link's per-visitor rules, and returns the destination along with the
+// A/B variant served (if any) for click analytics. suffix is the part of
+// the request path after the shortcode.
+func (lf *LinkForwarder) resolveDestination(w http.ResponseWriter, r *http.Request, link Link, suffix string) (string, string) {
+	if link.IOSURL != "" || link.AndroidURL != "" || link.DesktopURL != "" {
+		// Keep caches from serving one device's redirect to another
+		w.Header().Add("Vary", "User-Agent")
+	}
+
+	destination, variant := link.URL, ""
+	if d, ok := deviceDestination(r, link); ok {
+		destination = d
+	} else if d, ok := lf.geoDestination(r, link); ok {
+		destination = d
+	} else {
+		destination, variant = lf.chooseVariant(w, r, link)
+	}
+
+	// The link's own UTM parameters win over any the visitor's query has
+	destination = expandTemplate(destination, r, suffix)
+	destination = passThrough(destination, link, r, suffix)
+	return addUTM(destination, link.UTM), variant
+}
+
+// passThrough appends the request's path suffix and query string to the
+// destination when the link asks for them.
+func passThrough(destination string, link Link, r *http.Request, suffix string) string {
+	if !(link.ForwardPath && suffix != "") && !(link.ForwardQuery && r.URL.RawQuery != "") {
+		return destination
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	// Like {path} in URL templates, the suffix can't climb out of the
+	// destination's path
+	if suffix = escapePathSuffix(suffix); link.ForwardPath && suffix != "" {
+		escaped := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + suffix
+		if u.Path, err = url.PathUnescape(escaped); err != nil {
+			return destination
+		}
+		u.RawPath = escaped
+	}
+	if link.ForwardQuery && r.URL.RawQuery != "" {
+		if u.RawQuery == "" {
+			u.RawQuery = r.URL.RawQuery
+		} else {
+			u.RawQuery += "&" + r.URL.RawQuery
+		}
+	}
+	return u.String()
+}
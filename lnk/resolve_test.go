@@ -0,0 +1,53 @@
+package lnk
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestPassThrough checks that the request's path suffix and query string
+// are added to the destination's own.
+func TestPassThrough(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	link := Link{
+		Shortcode:    "promo",
+		URL:          "https://dest.example/page?campaign=spring",
+		ForwardPath:  true,
+		ForwardQuery: true,
+	}
+	r := httptest.NewRequest("GET", "/promo/extra?ref=x", nil)
+	destination, _ := lf.resolveDestination(httptest.NewRecorder(), r, link, "extra")
+	u, err := url.Parse(destination)
+	if err != nil {
+		t.Fatalf("resolveDestination returned %q: %v", destination, err)
+	}
+	if u.Path != "/page/extra" {
+		t.Errorf("path = %q, want /page/extra", u.Path)
+	}
+	for name, want := range map[string]string{"ref": "x", "campaign": "spring"} {
+		if got := u.Query().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestPassThroughTraversal checks that a forwarded path suffix stays under
+// the destination's path, with its segments escaped.
+func TestPassThroughTraversal(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	link := Link{Shortcode: "docs", URL: "https://dest.example/docs/", ForwardPath: true}
+	for suffix, want := range map[string]string{
+		"../../admin":          "https://dest.example/docs/admin",
+		"./a/../../b":          "https://dest.example/docs/a/b",
+		"guide/x y?z#frag":     "https://dest.example/docs/guide/x%20y%3Fz%23frag",
+		"..%2F..%2Fadmin":      "https://dest.example/docs/..%252F..%252Fadmin",
+		"//evil.example/phish": "https://dest.example/docs/evil.example/phish",
+		"../..":                "https://dest.example/docs/",
+	} {
+		r := httptest.NewRequest("GET", "/docs/x", nil)
+		if got, _ := lf.resolveDestination(httptest.NewRecorder(), r, link, suffix); got != want {
+			t.Errorf("suffix %q: destination %q, want %q", suffix, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxRestoreBytes is the largest backup that can be uploaded to restore.
+const maxRestoreBytes = 1 << 30
+
+var errBackupNotFound = errors.New("backup not found")
+
+// Restore describes a database restore.
+type Restore struct {
+	Source         string    `json:"source"` // the backup's name, or "upload"
+	Links          int       `json:"links"`
+	PreviousBackup string    `json:"previous_backup"` // the database as it was before
+	RestoredAt     time.Time `json:"restored_at"`
+}
+
+// RestoreRequest names one of the server's own backups to restore.
+type RestoreRequest struct {
+	Name string `json:"name"`
+}
+
+// stageBackup copies a backup into a working file in the backup directory,
+// so checking and upgrading it never touches the original. The caller
+// removes the file with removeSQLiteFiles.
+func (lf *LinkForwarder) stageBackup(r io.Reader) (string, error) {
+	if err := os.MkdirAll(lf.backups.dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(lf.backups.dir, ".restore-*.db")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		removeSQLiteFiles(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		removeSQLiteFiles(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// removeSQLiteFiles deletes a database file along with any journal SQLite
+// left next to it.
+func removeSQLiteFiles(path string) {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
+
+// prepareRestore checks that the staged file at path is an intact links
+// database and brings its schema up to date, returning how many links it
+// holds. A backup from a newer release is refused.
+func (lf *LinkForwarder) prepareRestore(ctx context.Context, path string) (int, error) {
+	if err := checkSQLiteFile(ctx, path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var tables int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name = 'links'`).Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, errors.New("it isn't a Link Forwarder database")
+	}
+	staged := &LinkForwarder{db: db, logger: lf.logger, now: lf.now}
+	if err := staged.migrate(ctx); err != nil {
+		return 0, err
+	}
+	var links int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM links`).Scan(&links)
+	return links, err
+}
+
+// restoreDatabase replaces the live database with the prepared backup at
+// path, after backing up the database as it is. The copy is one
+// transaction: requests keep reading the old links until it commits, and
+// writes wait for it.
+func (lf *LinkForwarder) restoreDatabase(ctx context.Context, path string) (string, error) {
+	previous, err := lf.createBackup(ctx)
+	if err != nil && !previous.Local {
+		return "", fmt.Errorf("failed to back up the current database: %v", err)
+	} else if err != nil {
+		// The local copy is enough to undo the restore
+		lf.logger.Printf("Restore: %v", err)
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer srcConn.Close()
+	destConn, err := lf.db.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer destConn.Close()
+	if err := copySQLite(destConn, srcConn); err != nil {
+		return "", err
+	}
+
+	// Reload what's kept in memory from the restored tables
+	lf.invalidateLinks()
+	if err := lf.loadDomainRules(ctx); err != nil {
+		return previous.Name, fmt.Errorf("failed to load domain rules: %v", err)
+	}
+	if err := lf.loadRedirectRules(ctx); err != nil {
+		return previous.Name, fmt.Errorf("failed to load redirect rules: %v", err)
+	}
+	lf.rulesChanged()
+	if err := lf.bootstrapAdmin(ctx); err != nil {
+		return previous.Name, fmt.Errorf("failed to create admin account: %v", err)
+	}
+	return previous.Name, nil
+}
+
+// handleRestore restores the database from one of the server's backups,
+// named in a JSON body, or from a backup file uploaded as the body.
+func (lf *LinkForwarder) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if !lf.restoring.TryLock() {
+		writeError(w, http.StatusConflict, "A restore is already running")
+		return
+	}
+	defer lf.restoring.Unlock()
+	// Copying a large database can take longer than REQUEST_TIMEOUT
+	ctx := withoutRequestTimeout(r)
+
+	source := "upload"
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxRestoreBytes)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req RestoreRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil || req.Name == "" {
+			writeError(w, http.StatusBadRequest, "Request must name a backup, or upload a backup file")
+			return
+		}
+		f, err := lf.openBackup(ctx, req.Name)
+		if errors.Is(err, errBackupNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to open backup")
+			return
+		}
+		defer f.Close()
+		source, body = req.Name, f
+	}
+
+	staged, err := lf.stageBackup(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Backup is too large")
+		} else {
+			writeError(w, http.StatusBadRequest, "Failed to read backup")
+		}
+		return
+	}
+	defer removeSQLiteFiles(staged)
+
+	links, err := lf.prepareRestore(ctx, staged)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid backup: %v", err))
+		return
+	}
+	previous, err := lf.restoreDatabase(ctx, staged)
+	if err != nil {
+		lf.logf(r, "Restore from %s failed: %v", source, err)
+		writeError(w, http.StatusInternalServerError, "Failed to restore database")
+		return
+	}
+	lf.logf(r, "%s restored the database from %s (%d links); the previous database was saved as %s",
+		lf.requestActor(r), source, links, previous)
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Database restored successfully",
+		Data:    Restore{Source: source, Links: links, PreviousBackup: previous, RestoredAt: lf.now().UTC()},
+	})
+}
+
+// openBackup opens the backup called name, downloading it when it's only
+// in object storage. Only names listBackups returns are accepted, so name
+// can't reach other files.
+func (lf *LinkForwarder) openBackup(ctx context.Context, name string) (io.ReadCloser, error) {
+	backups, err := lf.listBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backups {
+		switch {
+		case b.Name != name:
+		case b.Local:
+			return os.Open(filepath.Join(lf.backups.dir, name))
+		default:
+			return lf.objects.get(ctx, backupKeyPrefix+name)
+		}
+	}
+	return nil, errBackupNotFound
+}
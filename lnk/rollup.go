@@ -0,0 +1,112 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRollupInterval = time.Hour
+	defaultClickRetention = 90 // days
+
+	// rollupGrace is how long after midnight a day's clicks are rolled up,
+	// for clicks being saved right as the day ends.
+	rollupGrace = time.Hour
+)
+
+// clickCountsSQL counts clicks per link, day, variant, and referrer: the
+// rolled-up days, then the raw clicks since. Queries for click stats read
+// it as a WITH clause so they see every click once, whether or not it's
+// been rolled up or pruned.
+const clickCountsSQL = `click_counts AS (
+	SELECT domain, shortcode, day, variant, referrer, clicks FROM click_rollups
+	UNION ALL
+	SELECT domain, shortcode, date(clicked_at), variant, referrer, 1 FROM clicks
+	WHERE clicked_at >= (SELECT COALESCE(date(MAX(day), '+1 day'), '') FROM click_rollups)
+)`
+
+// rollupConfig says how often clicks are rolled up and how long raw clicks
+// are kept afterwards.
+type rollupConfig struct {
+	interval  time.Duration // 0 turns rollups off
+	retention int           // days; 0 keeps raw clicks forever
+}
+
+// loadRollupConfig reads CLICK_ROLLUP_INTERVAL and CLICK_RETENTION_DAYS.
+func loadRollupConfig(getenv func(string) string) (rollupConfig, error) {
+	cfg := rollupConfig{interval: defaultRollupInterval, retention: defaultClickRetention}
+	if v := getenv("CLICK_ROLLUP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid CLICK_ROLLUP_INTERVAL %q: must be a duration such as 1h, or 0 to turn rollups off", v)
+		}
+		cfg.interval = d
+	}
+	if v := getenv("CLICK_RETENTION_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid CLICK_RETENTION_DAYS %q: must be a non-negative integer", v)
+		}
+		cfg.retention = n
+	}
+	return cfg, nil
+}
+
+// rollupClicks adds up the raw clicks of each finished day that hasn't
+// been rolled up yet, returning how many rows it added. Days are rolled up
+// in order, so every day up to the latest in click_rollups is done.
+func (lf *LinkForwarder) rollupClicks(ctx context.Context) (int64, error) {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var from string
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(date(MAX(day), '+1 day'), '') FROM click_rollups`).Scan(&from); err != nil {
+		return 0, err
+	}
+	until := lf.now().UTC().Add(-rollupGrace).Format("2006-01-02")
+	result, err := tx.ExecContext(ctx, `INSERT INTO click_rollups (domain, shortcode, day, variant, referrer, clicks)
+		SELECT domain, shortcode, date(clicked_at), variant, referrer, COUNT(*) FROM clicks
+		WHERE clicked_at >= ? AND clicked_at < ?
+		GROUP BY domain, shortcode, date(clicked_at), variant, referrer`, from, until)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+// pruneClicks deletes raw clicks older than CLICK_RETENTION_DAYS once
+// they've been rolled up, returning how many it deleted.
+func (lf *LinkForwarder) pruneClicks(ctx context.Context) (int64, error) {
+	if lf.rollups.retention == 0 {
+		return 0, nil
+	}
+	before := lf.now().UTC().AddDate(0, 0, -lf.rollups.retention).Format("2006-01-02")
+	result, err := lf.db.ExecContext(ctx, `DELETE FROM clicks WHERE clicked_at < ?
+		AND clicked_at < (SELECT COALESCE(date(MAX(day), '+1 day'), '') FROM click_rollups)`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// runRollup is the click-rollup job.
+func (lf *LinkForwarder) runRollup(ctx context.Context) (string, error) {
+	rows, err := lf.rollupClicks(ctx)
+	if err != nil {
+		return "", err
+	}
+	pruned, err := lf.pruneClicks(ctx)
+	if err != nil {
+		return "", fmt.Errorf("clicks rolled up, but removing old ones failed: %v", err)
+	}
+	return fmt.Sprintf("Added %d daily click rows, removed %d raw clicks", rows, pruned), nil
+}
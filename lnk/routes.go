@@ -0,0 +1,117 @@
+package lnk
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiV1 is the prefix of the current API version.
+const apiV1 = "/api/v1"
+
+// routes builds the router for the management UI, API, and short links,
+// wrapped in the middleware every request passes through.
+func (lf *LinkForwarder) routes() http.Handler {
+	r := mux.NewRouter()
+	r.Use(lf.nameSpan)
+
+	// Static files (stylesheets, scripts, favicon)
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static", http.HandlerFunc(lf.handleStatic)))
+	r.HandleFunc("/favicon.ico", lf.handleStatic)
+
+	// Home page with management interface
+	r.HandleFunc("/", lf.requireLogin(lf.maintenancePage(lf.handleHome))).Methods("GET")
+	r.HandleFunc("/login", lf.handleLogin).Methods("GET", "POST")
+	r.HandleFunc("/logout", lf.handleLogout).Methods("POST")
+	r.HandleFunc("/tokens", lf.requireLogin(lf.maintenancePage(lf.handleTokensPage))).Methods("GET")
+	r.HandleFunc("/admin/stats", lf.requireLogin(lf.handleStatsPage)).Methods("GET")
+	if lf.oidc != nil {
+		r.HandleFunc("/auth/oidc/login", lf.handleOIDCLogin).Methods("GET")
+		r.HandleFunc("/auth/oidc/callback", lf.handleOIDCCallback).Methods("GET")
+	}
+
+	// API endpoints, under /api/v1 and, deprecated, directly under /api.
+	// The spec and its docs page are public; the rest require an account
+	// when accounts are enabled.
+	if lf.swaggerUI {
+		r.HandleFunc("/api/docs", lf.handleAPIDocs).Methods("GET")
+	}
+	if lf.discord != nil {
+		// Discord signs its requests instead of logging in
+		r.HandleFunc(apiV1+"/bots/discord", lf.handleDiscord).Methods("POST")
+	}
+	v1 := r.PathPrefix(apiV1).Subrouter()
+	legacy := r.PathPrefix("/api").Subrouter()
+	legacy.Use(lf.deprecatedAPI)
+	for _, api := range []*mux.Router{v1, legacy} {
+		api.HandleFunc("/openapi.json", lf.handleOpenAPI).Methods("GET")
+		api := api.NewRoute().Subrouter()
+		api.Use(lf.requireAuth)
+		for _, op := range lf.apiOperations() {
+			handler := op.handler
+			if !op.writes() && op.stream == nil {
+				handler = withETag(handler)
+			}
+			handler = lf.requireScope(op.tokenScope(), handler)
+			if op.admin {
+				handler = lf.requireAdmin(handler)
+			} else {
+				handler = lf.refuseDuringMaintenance(handler)
+			}
+			if op.writes() {
+				handler = lf.refuseWhenReadOnly(handler)
+			}
+			api.HandleFunc(op.path, handler).Methods(op.method)
+		}
+	}
+
+	// Feeds of new links. Like the API they need an account once accounts
+	// are enabled, unless PUBLIC_FEED is set for readers that can't log in.
+	feed := http.Handler(http.HandlerFunc(lf.refuseDuringMaintenance(lf.handleFeed)))
+	if !lf.publicFeed {
+		feed = lf.requireAuth(lf.requireScope(scopeRead, feed.ServeHTTP))
+	}
+	r.Handle("/feed.xml", feed).Methods("GET")
+	r.Handle("/feed.json", feed).Methods("GET")
+
+	// Bitly's v4 API, with BITLY_API, for tools that can only shorten
+	// through Bitly. It takes the same credentials as the API.
+	if lf.bitlyAPI {
+		bitly := r.PathPrefix("/v4").Subrouter()
+		bitly.Use(lf.requireAuth)
+		bitly.HandleFunc("/shorten", lf.requireScope(scopeCreate, lf.refuseDuringMaintenance(lf.refuseWhenReadOnly(lf.handleBitlyShorten)))).Methods("POST")
+		bitly.HandleFunc("/expand", lf.requireScope(scopeRead, lf.refuseDuringMaintenance(lf.handleBitlyExpand))).Methods("POST")
+		bitly.HandleFunc("/bitlinks/{bitlink:.+}", lf.requireScope(scopeRead, lf.refuseDuringMaintenance(lf.handleBitlink))).Methods("GET")
+	}
+
+	if lf.debug != nil {
+		debug := lf.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			lf.debug.ServeHTTP(w, r.WithContext(withoutRequestTimeout(r)))
+		})
+		r.PathPrefix("/debug/").Handler(lf.requireAuth(debug))
+	}
+
+	// Forward shortcodes (this should be last to catch all other routes)
+	r.HandleFunc("/{shortcode}", lf.handleForward).Methods("GET", "HEAD", "POST")
+	r.HandleFunc("/{shortcode}/{path:.*}", lf.handleForward).Methods("GET", "HEAD", "POST")
+
+	return lf.withRequestID(lf.withProxyHeaders(lf.withAccessLog(lf.withTracing(lf.withBasePath(lf.withCORS(lf.withRequestTimeout(r)))))))
+}
+
+// ServeHTTP serves the management UI, the API, and short links, so the
+// forwarder can be mounted in another mux or passed to an http.Server.
+func (lf *LinkForwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lf.handler.ServeHTTP(w, r)
+}
+
+// deprecatedAPI marks responses from the unversioned /api paths, which
+// predate /api/v1 and are kept so existing scripts keep working.
+func (lf *LinkForwarder) deprecatedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := lf.appPath(apiV1 + strings.TrimPrefix(r.URL.Path, "/api"))
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,329 @@
+package lnk
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	s3Timeout       = 10 * time.Minute // uploads of large backups take a while
+	// emptySHA256 is the payload hash of a request without a body
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// objectStore keeps files in an S3-compatible bucket (AWS S3, MinIO,
+// Cloudflare R2, Google Cloud Storage's XML API, ...), so they outlive the
+// server's disk. Requests are signed with AWS Signature Version 4.
+type objectStore struct {
+	endpoint     *url.URL
+	bucket       string
+	pathStyle    bool // bucket in the path instead of the hostname
+	region       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time
+}
+
+// objectInfo describes a stored object.
+type objectInfo struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// loadObjectStore reads the S3_* settings, returning nil when S3_BUCKET
+// isn't set. Without S3_ENDPOINT the bucket is on AWS; credentials fall
+// back to the standard AWS_* variables.
+func loadObjectStore(getenv func(string) string) (*objectStore, error) {
+	bucket := getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	firstOf := func(names ...string) string {
+		for _, name := range names {
+			if v := getenv(name); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	s := &objectStore{
+		bucket:       bucket,
+		region:       firstOf("S3_REGION", "AWS_REGION", "AWS_DEFAULT_REGION"),
+		prefix:       strings.Trim(getenv("S3_PREFIX"), "/"),
+		accessKey:    firstOf("S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		secretKey:    firstOf("S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		sessionToken: firstOf("S3_SESSION_TOKEN", "AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: s3Timeout},
+		now:          time.Now,
+	}
+	if s.region == "" {
+		s.region = defaultS3Region
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY) are required with S3_BUCKET")
+	}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+
+	if v := getenv("S3_ENDPOINT"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3_ENDPOINT %q: must be an http or https URL such as https://minio.example.com:9000", v)
+		}
+		// Self-hosted stores rarely have a DNS name per bucket
+		s.endpoint, s.pathStyle = &url.URL{Scheme: u.Scheme, Host: u.Host}, true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: bucket + ".s3." + s.region + ".amazonaws.com"}
+	}
+
+	// Fail at startup rather than at the first backup
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := s.list(ctx, "", 1); err != nil {
+		return nil, fmt.Errorf("failed to reach S3 bucket %s: %v", bucket, err)
+	}
+	return s, nil
+}
+
+// s3Error is the error document S3 answers failed requests with.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request for key (relative to S3_PREFIX), or for the
+// bucket itself when key is empty, and fails on any status but 2xx.
+func (s *objectStore) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := "/"
+	if key != "" {
+		objectPath += s.prefix + key
+	}
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	}
+	u.Path = objectPath
+	u.RawPath = awsURIEscape(objectPath, true)
+	u.RawQuery = awsCanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if payloadHash == "" {
+		payloadHash = emptySHA256
+	}
+	s.sign(req, payloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, errObjectNotFound
+	}
+	var e s3Error
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+}
+
+// sign adds the Signature Version 4 Authorization header to req, covering
+// the host and every header already set.
+func (s *objectStore) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsURIEscape percent-encodes everything but unreserved characters, and
+// slashes when keepSlash is set, as Signature Version 4 expects.
+func awsURIEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && keepSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery encodes query sorted by name, as Signature Version 4
+// expects, so the query sent is the one signed.
+func awsCanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsURIEscape(name, false)+"="+awsURIEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// putFile uploads the file at path as key. The file is read twice, once
+// to sign its contents and once to send them.
+func (s *objectStore) putFile(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, f, size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// get returns the contents of key, or errObjectNotFound.
+func (s *objectStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// delete removes key. Deleting a key that doesn't exist succeeds.
+func (s *objectStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// list returns the objects whose keys start with prefix, with S3_PREFIX
+// trimmed from their keys. limit stops it early when it's above 0.
+func (s *objectStore) list(ctx context.Context, prefix string, limit int) ([]objectInfo, error) {
+	var objects []objectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents              []objectInfo `xml:"Contents"`
+			IsTruncated           bool         `xml:"IsTruncated"`
+			NextContinuationToken string       `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket listing: %v", err)
+		}
+		for _, o := range page.Contents {
+			o.Key = strings.TrimPrefix(o.Key, s.prefix)
+			objects = append(objects, o)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" || (limit > 0 && len(objects) >= limit) {
+			return objects, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
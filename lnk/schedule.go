@@ -0,0 +1,53 @@
+package lnk
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// activeAt reports whether a link resolves at the given time. Links without
+// active_from or active_until are open-ended on that side.
+func (l Link) activeAt(t time.Time) bool {
+	if l.ActiveFrom != nil && t.Before(*l.ActiveFrom) {
+		return false
+	}
+	if l.ActiveUntil != nil && !t.Before(*l.ActiveUntil) {
+		return false
+	}
+	return true
+}
+
+// normalizeActiveWindow stores the window in UTC and checks it isn't empty.
+func normalizeActiveWindow(link *Link) error {
+	if link.ActiveFrom != nil {
+		from := link.ActiveFrom.UTC()
+		link.ActiveFrom = &from
+	}
+	if link.ActiveUntil != nil {
+		until := link.ActiveUntil.UTC()
+		link.ActiveUntil = &until
+	}
+	if link.ActiveFrom != nil && link.ActiveUntil != nil && !link.ActiveUntil.After(*link.ActiveFrom) {
+		return fmt.Errorf("active_until must be after active_from")
+	}
+	return nil
+}
+
+// renderOutsideWindow explains that a link isn't live yet (404) or has
+// expired (410).
+func (lf *LinkForwarder) renderOutsideWindow(w http.ResponseWriter, link Link, now time.Time) {
+	if link.ActiveFrom != nil && now.Before(*link.ActiveFrom) {
+		lf.renderUnavailable(w, http.StatusNotFound, UnavailableData{
+			Shortcode: link.Shortcode,
+			Heading:   "This link isn't live yet",
+			Message:   "It becomes available on " + link.ActiveFrom.Format("January 2, 2006 at 15:04 MST") + ".",
+		})
+		return
+	}
+	lf.renderUnavailable(w, http.StatusGone, UnavailableData{
+		Shortcode: link.Shortcode,
+		Heading:   "This link has expired",
+		Message:   "It stopped working on " + link.ActiveUntil.Format("January 2, 2006 at 15:04 MST") + ".",
+	})
+}
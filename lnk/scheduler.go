@@ -0,0 +1,257 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// schedulerTick is how often the scheduler looks for jobs that are due.
+const schedulerTick = 30 * time.Second
+
+// jobJitter is the largest share of its interval a job's next run is
+// pushed back by, so replicas and jobs started together drift apart.
+const jobJitter = 0.1
+
+const defaultPurgeInterval = time.Hour
+
+var errJobNotFound = errors.New("job not found")
+
+// job is a background task run by the scheduler every interval. run
+// returns a short summary of what it did.
+type job struct {
+	name     string
+	interval time.Duration // 0 turns the job off
+	enabled  bool
+	run      func(ctx context.Context) (string, error)
+}
+
+// JobStatus describes a scheduled job and how its last run went. Run
+// times are shared by every replica using the database.
+type JobStatus struct {
+	Name           string     `json:"name"`
+	Enabled        bool       `json:"enabled"` // on this replica
+	Interval       string     `json:"interval,omitempty"`
+	Running        bool       `json:"running"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastResult     string     `json:"last_result,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// loadJobs sets up the scheduled jobs. Each job's interval comes from
+// its own variable, and JOBS_DISABLED lists jobs, or "all", that this
+// replica shouldn't run. A read-only replica runs none.
+func (lf *LinkForwarder) loadJobs() ([]*job, error) {
+	purgeInterval := defaultPurgeInterval
+	if v := lf.getenv("PURGE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid PURGE_INTERVAL %q: must be a duration such as 1h, or 0 to turn purging off", v)
+		}
+		purgeInterval = d
+	}
+
+	jobs := []*job{
+		{name: "purge-expired", interval: purgeInterval, run: lf.purgeExpired},
+		{name: "link-check", interval: lf.linkCheckInterval, run: lf.runLinkCheck},
+		{name: "backup", interval: lf.backups.interval, run: lf.runBackup},
+		{name: "click-rollup", interval: lf.rollups.interval, run: lf.runRollup},
+		{name: "archive-stale", interval: lf.archive.interval(), run: lf.runArchive},
+		{name: "sync-file", interval: lf.sync.interval, run: lf.runSync},
+	}
+
+	disabled := map[string]bool{}
+	for _, name := range splitList(lf.getenv("JOBS_DISABLED")) {
+		disabled[strings.ToLower(name)] = true
+	}
+	known := map[string]bool{"all": true}
+	for _, j := range jobs {
+		known[j.name] = true
+		j.enabled = j.interval > 0 && !disabled[j.name] && !disabled["all"] && !lf.readOnly
+	}
+	for name := range disabled {
+		if !known[name] {
+			return nil, fmt.Errorf("invalid JOBS_DISABLED: unknown job %q", name)
+		}
+	}
+	return jobs, nil
+}
+
+// findJob returns the job called name.
+func (lf *LinkForwarder) findJob(name string) (*job, error) {
+	for _, j := range lf.jobs {
+		if j.name == name {
+			return j, nil
+		}
+	}
+	return nil, errJobNotFound
+}
+
+// runScheduler runs the enabled jobs as they come due until ctx is
+// cancelled. Jobs are claimed through the database, so with several
+// replicas each run happens on only one of them.
+func (lf *LinkForwarder) runScheduler(ctx context.Context) {
+	running := map[string]bool{}
+	done := make(chan string)
+	for {
+		for _, j := range lf.jobs {
+			if !j.enabled || running[j.name] {
+				continue
+			}
+			claimed, err := lf.claimJob(ctx, j)
+			if err != nil {
+				if ctx.Err() == nil {
+					lf.logger.Printf("Failed to schedule job %s: %v", j.name, err)
+				}
+				continue
+			}
+			if claimed {
+				running[j.name] = true
+				go func(j *job) {
+					lf.runJob(ctx, j)
+					done <- j.name
+				}(j)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			// Let running jobs see the cancellation and stop
+			for len(running) > 0 {
+				delete(running, <-done)
+			}
+			return
+		case name := <-done:
+			delete(running, name)
+		case <-lf.jobWake:
+		case <-time.After(schedulerTick):
+		}
+	}
+}
+
+// claimJob takes the next run of j if it's due, moving the following run
+// an interval (plus jitter) ahead. A run scheduled further out than one
+// interval is due too, so shortening an interval takes effect at once.
+func (lf *LinkForwarder) claimJob(ctx context.Context, j *job) (bool, error) {
+	now := lf.now().UTC()
+	if _, err := lf.db.ExecContext(ctx, `INSERT INTO jobs (name, next_run_at) VALUES (?, ?)
+		ON CONFLICT(name) DO NOTHING`, j.name, now); err != nil {
+		return false, err
+	}
+	jitter := time.Duration(rand.Float64() * jobJitter * float64(j.interval))
+	result, err := lf.db.ExecContext(ctx, `UPDATE jobs SET next_run_at = ?, started_at = ?
+		WHERE name = ? AND (next_run_at <= ? OR next_run_at > ?)`,
+		now.Add(j.interval+jitter), now, j.name, now, now.Add(j.interval+time.Duration(jobJitter*float64(j.interval))))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// runJob runs a claimed job and records how it went.
+func (lf *LinkForwarder) runJob(ctx context.Context, j *job) {
+	summary, err := j.run(ctx)
+	var message string
+	if err != nil {
+		message = err.Error()
+		lf.logger.Printf("Job %s failed: %v", j.name, err)
+	} else if summary != "" {
+		lf.logger.Printf("Job %s: %s", j.name, summary)
+	}
+	// Record the outcome even when shutting down cut the job short
+	record, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := lf.db.ExecContext(record, `UPDATE jobs SET finished_at = ?, result = ?, error = ? WHERE name = ?`,
+		lf.now().UTC(), summary, message, j.name); err != nil {
+		lf.logger.Printf("Failed to record run of job %s: %v", j.name, err)
+	}
+}
+
+// triggerJob makes a job due now and wakes the scheduler to run it.
+func (lf *LinkForwarder) triggerJob(ctx context.Context, j *job) error {
+	if _, err := lf.db.ExecContext(ctx, `INSERT INTO jobs (name, next_run_at) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET next_run_at = excluded.next_run_at`, j.name, lf.now().UTC()); err != nil {
+		return err
+	}
+	select {
+	case lf.jobWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// jobStatuses returns the status of every job.
+func (lf *LinkForwarder) jobStatuses(ctx context.Context) ([]JobStatus, error) {
+	statuses := []JobStatus{}
+	for _, j := range lf.jobs {
+		status := JobStatus{Name: j.name, Enabled: j.enabled}
+		if j.interval > 0 {
+			status.Interval = j.interval.String()
+		}
+		var next, started, finished sql.NullTime
+		err := lf.db.QueryRowContext(ctx, `SELECT next_run_at, started_at, finished_at, result, error FROM jobs WHERE name = ?`, j.name).
+			Scan(&next, &started, &finished, &status.LastResult, &status.LastError)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if next.Valid && j.enabled {
+			status.NextRunAt = &next.Time
+		}
+		if started.Valid {
+			status.LastStartedAt = &started.Time
+			status.Running = !finished.Valid || finished.Time.Before(started.Time)
+		}
+		if finished.Valid {
+			status.LastFinishedAt = &finished.Time
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// handleJobs lists the scheduled jobs, or with POST runs one now.
+func (lf *LinkForwarder) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		statuses, err := lf.jobStatuses(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve jobs")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Jobs retrieved successfully",
+			Data:    statuses,
+		})
+
+	case "POST":
+		j, err := lf.findJob(mux.Vars(r)["name"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if !j.enabled {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Job %s is not enabled on this server", j.name))
+			return
+		}
+		if err := lf.triggerJob(r.Context(), j); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to start job")
+			return
+		}
+		lf.logf(r, "%s started job %s", lf.requestActor(r), j.name)
+		writeJSON(w, http.StatusAccepted, Response{
+			Success: true,
+			Message: "Job started",
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package lnk
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookieName = "lnk_session"
+	defaultSessionTTL = 7 * 24 * time.Hour
+)
+
+// loadSessionTTL reads how long a login lasts from SESSION_TTL (a Go
+// duration such as "12h"), defaulting to a week.
+func loadSessionTTL(getenv func(string) string) (time.Duration, error) {
+	v := getenv("SESSION_TTL")
+	if v == "" {
+		return defaultSessionTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, errors.New("invalid SESSION_TTL " + v)
+	}
+	return ttl, nil
+}
+
+// hashToken stores session tokens hashed so a leaked database can't be
+// used to hijack sessions.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createSession starts a session for user and returns its token.
+func (lf *LinkForwarder) createSession(ctx context.Context, user *User) (string, time.Time, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := lf.now().UTC().Add(lf.sessionTTL)
+	_, err = lf.db.ExecContext(ctx, `INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		hashToken(token), user.ID, expires)
+	return token, expires, err
+}
+
+// sessionUser returns the user for a session token if it hasn't expired.
+func (lf *LinkForwarder) sessionUser(ctx context.Context, token string) (*User, error) {
+	var u User
+	var expires time.Time
+	err := lf.db.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.role, u.created_at, s.expires_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ?`, hashToken(token)).
+		Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &expires)
+	if err == sql.ErrNoRows {
+		return nil, errUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if lf.now().After(expires) {
+		lf.deleteSession(ctx, token)
+		return nil, errUserNotFound
+	}
+	return &u, nil
+}
+
+func (lf *LinkForwarder) deleteSession(ctx context.Context, token string) error {
+	_, err := lf.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// purgeExpired is the purge-expired job: it deletes sessions and API
+// tokens that have expired, which are otherwise only removed when used,
+// and unknown shortcodes nobody has asked for in a while.
+func (lf *LinkForwarder) purgeExpired(ctx context.Context) (string, error) {
+	now := lf.now().UTC()
+	sessions, err := lf.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < ?`, now)
+	if err != nil {
+		return "", err
+	}
+	tokens, err := lf.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE expires_at IS NOT NULL AND expires_at < ?`, now)
+	if err != nil {
+		return "", err
+	}
+	unknown, err := lf.db.ExecContext(ctx, `DELETE FROM unknown_shortcodes WHERE last_seen_at < ?`, now.Add(-unknownShortcodeRetention))
+	if err != nil {
+		return "", err
+	}
+	s, _ := sessions.RowsAffected()
+	t, _ := tokens.RowsAffected()
+	u, _ := unknown.RowsAffected()
+	return fmt.Sprintf("Removed %d expired sessions, %d expired API tokens, and %d unknown shortcodes", s, t, u), nil
+}
+
+// requestSessionUser returns the user logged in via the session cookie.
+func (lf *LinkForwarder) requestSessionUser(r *http.Request) (*User, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, errUserNotFound
+	}
+	return lf.sessionUser(r.Context(), cookie.Value)
+}
+
+func (lf *LinkForwarder) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     lf.appPath("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (lf *LinkForwarder) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     lf.appPath("/"),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeRedirectTarget only allows redirects back to local paths after login.
+// Browsers drop tabs and newlines from URLs and read a backslash as a
+// slash, so a path is checked both as given and as a browser would see it,
+// and control characters are refused outright.
+func safeRedirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") {
+		return "/"
+	}
+	for _, c := range next {
+		if c < 0x20 || c == 0x7f {
+			return "/"
+		}
+	}
+	stripped := strings.NewReplacer("\t", "", "\r", "", "\n", "").Replace(next)
+	for _, target := range []string{next, stripped} {
+		target = strings.ReplaceAll(target, "\\", "/")
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(target, "//") {
+			return "/"
+		}
+	}
+	return next
+}
+
+// LoginData is rendered by the login page.
+type LoginData struct {
+	Next         string
+	Username     string
+	ErrorMessage string
+	SSO          bool // offer single sign-on
+}
+
+func (lf *LinkForwarder) renderLogin(w http.ResponseWriter, status int, data LoginData) {
+	tmpl, err := lf.loadTemplate("login.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logger.Printf("Template error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logger.Printf("Template execution error: %v", err)
+	}
+}
+
+func (lf *LinkForwarder) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeRedirectTarget(r.FormValue("next"))
+
+	if r.Method == "GET" {
+		if _, err := lf.requestSessionUser(r); err == nil {
+			http.Redirect(w, r, lf.appPath(next), http.StatusSeeOther)
+			return
+		}
+		lf.renderLogin(w, http.StatusOK, LoginData{Next: next, SSO: lf.oidc != nil})
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	if lf.readOnly {
+		lf.renderLogin(w, http.StatusServiceUnavailable, LoginData{
+			Next:         next,
+			Username:     username,
+			ErrorMessage: readOnlyLoginMessage,
+			SSO:          lf.oidc != nil,
+		})
+		return
+	}
+	user, err := lf.checkPassword(r.Context(), username, r.FormValue("password"))
+	if err != nil {
+		if !errors.Is(err, errUserNotFound) {
+			lf.logf(r, "Login failed for %s: %v", username, err)
+		}
+		lf.renderLogin(w, http.StatusUnauthorized, LoginData{
+			Next:         next,
+			Username:     username,
+			ErrorMessage: "Invalid username or password",
+			SSO:          lf.oidc != nil,
+		})
+		return
+	}
+
+	token, expires, err := lf.createSession(r.Context(), user)
+	if err != nil {
+		lf.logf(r, "Failed to create session for %s: %v", username, err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	lf.logf(r, "User %s logged in", user.Username)
+	lf.setSessionCookie(w, r, token, expires)
+	http.Redirect(w, r, lf.appPath(next), http.StatusSeeOther)
+}
+
+func (lf *LinkForwarder) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if !lf.csrfSafe(r) {
+		http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && !lf.readOnly {
+		if err := lf.deleteSession(r.Context(), cookie.Value); err != nil {
+			lf.logf(r, "Failed to delete session: %v", err)
+		}
+	}
+	lf.clearSessionCookie(w, r)
+	http.Redirect(w, r, lf.appPath("/login"), http.StatusSeeOther)
+}
+
+// requireLogin sends visitors without a session to the login page once
+// accounts exist, and makes the logged-in user available via currentUser.
+func (lf *LinkForwarder) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, err := lf.authEnabled(r.Context())
+		if err != nil {
+			lf.logger.Printf("Failed to check accounts: %v", err)
+			http.Error(w, "Failed to check credentials", http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			next(w, r)
+			return
+		}
+
+		user, err := lf.requestSessionUser(r)
+		if err != nil {
+			http.Redirect(w, r, lf.appPath("/login?next="+url.QueryEscape(r.URL.RequestURI())), http.StatusSeeOther)
+			return
+		}
+		next(w, r.WithContext(withUser(r.Context(), user)))
+	}
+}
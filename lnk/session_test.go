@@ -0,0 +1,35 @@
+package lnk
+
+import "testing"
+
+func TestSafeRedirectTarget(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/stats/abc", "/stats/abc"},
+		{"/tokens?page=2#new", "/tokens?page=2#new"},
+		{"/a//b", "/a//b"},
+		{"https://evil.example", "/"},
+		{"evil.example", "/"},
+		{"//evil.example", "/"},
+		{"/\\evil.example", "/"},
+		{"/\\/evil.example", "/"},
+		{"\\\\evil.example", "/"},
+		{"/\t/evil.example", "/"},
+		{"/\n/evil.example", "/"},
+		{"/\r/evil.example", "/"},
+		{"/\x00/evil.example", "/"},
+		{"/\x7f", "/"},
+		{"/%09/evil.example", "/%09/evil.example"},
+		{"javascript:alert(1)", "/"},
+		{"/:evil", "/:evil"},
+	}
+	for _, tt := range tests {
+		if got := safeRedirectTarget(tt.next); got != tt.want {
+			t.Errorf("safeRedirectTarget(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// findReusableLink returns a link in domain that sends every visitor to
+// destination, for shortening a URL that already has one. Links with a password,
+// click limit, or activation window, and untracked and archived links,
+// aren't reused.
+func (lf *LinkForwarder) findReusableLink(ctx context.Context, domain, destination string) (Link, error) {
+	link, err := scanLink(lf.db.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM links
+		WHERE domain = ? AND url = ? AND password_hash = '' AND max_clicks = 0
+			AND active_from IS NULL AND active_until IS NULL AND untracked = 0 AND archived_at IS NULL
+		ORDER BY created_at LIMIT 1`, domain, destination))
+	if err == sql.ErrNoRows {
+		return link, errLinkNotFound
+	}
+	return link, err
+}
+
+// existingDuplicate returns the link POSTing link would duplicate when
+// DEDUPLICATE_URLS is on: one already sending everyone to the same
+// destination, found with findReusableLink. Links that restrict visits,
+// and posts that replace an existing shortcode, never count as duplicates.
+func (lf *LinkForwarder) existingDuplicate(ctx context.Context, link Link) (Link, bool, error) {
+	if !lf.dedupeURLs || link.passwordHash != "" || link.MaxClicks != 0 || link.ActiveFrom != nil || link.ActiveUntil != nil || link.Untracked {
+		return Link{}, false, nil
+	}
+	if _, err := lf.getLink(ctx, link.Domain, link.Shortcode); err == nil {
+		return Link{}, false, nil
+	} else if !errors.Is(err, errLinkNotFound) {
+		return Link{}, false, err
+	}
+	existing, err := lf.findReusableLink(ctx, link.Domain, link.URL)
+	if errors.Is(err, errLinkNotFound) {
+		return Link{}, false, nil
+	}
+	return existing, err == nil, err
+}
+
+// shortenRequest is shorten for an API request, in the request's
+// namespace.
+func (lf *LinkForwarder) shortenRequest(r *http.Request, rawURL, shortcode string) (Link, bool, *apiError) {
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		return Link{}, false, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	return lf.shorten(r.Context(), requestEditor(r), r.Host, Link{Domain: domain, Shortcode: shortcode, URL: rawURL}, lf.requestActor(r))
+}
+
+// shorten returns a short link to want.URL in want.Domain, reporting
+// whether it was created by by, recorded as actor. host is the server host
+// the request came in on, as for prepareLink. With want.Shortcode, an
+// existing link under it is returned if it already points to the URL.
+// Without one, an existing link to the URL is reused, or a new one gets a
+// generated shortcode.
+func (lf *LinkForwarder) shorten(ctx context.Context, by editor, host string, want Link, actor string) (Link, bool, *apiError) {
+	if lf.readOnly {
+		return Link{}, false, &apiError{http.StatusServiceUnavailable, readOnlyMessage}
+	}
+	if want.URL == "" {
+		return Link{}, false, &apiError{http.StatusBadRequest, "url is required"}
+	}
+	domain, shortcode := want.Domain, want.Shortcode
+	// Normalize the URL first so it compares equal to the stored ones
+	destination, err := lf.checkDestination(ctx, Link{Domain: domain}, want.URL, host)
+	if err != nil {
+		return Link{}, false, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	var existing Link
+	if shortcode != "" {
+		shortcode = lf.rules.normalize(shortcode)
+		existing, err = lf.getLinkOrAlias(ctx, domain, shortcode)
+		if err == nil && existing.URL != destination {
+			return Link{}, false, &apiError{http.StatusConflict, fmt.Sprintf("'%s' already points to %s", shortcode, existing.URL)}
+		}
+	} else {
+		existing, err = lf.findReusableLink(ctx, domain, destination)
+	}
+	if err == nil {
+		return existing, false, nil
+	} else if !errors.Is(err, errLinkNotFound) {
+		return Link{}, false, &apiError{http.StatusInternalServerError, "Failed to save link"}
+	}
+
+	if shortcode == "" {
+		if shortcode, err = lf.generateShortcode(ctx, domain); err != nil {
+			lf.logContextf(ctx, "Failed to generate a shortcode: %v", err)
+			return Link{}, false, &apiError{http.StatusInternalServerError, "Failed to save link"}
+		}
+	}
+	link, apiErr := lf.prepareLink(ctx, by, host, Link{Domain: domain, Shortcode: shortcode, URL: destination}, false)
+	if apiErr != nil {
+		return link, false, apiErr
+	}
+	if err := lf.saveLink(ctx, link, actor); err != nil {
+		return link, false, &apiError{http.StatusInternalServerError, "Failed to save link"}
+	}
+	return link, true, nil
+}
+
+// wantsText reports whether a client of /api/v1/shorten asked for a plain
+// text answer rather than JSON: with format=text, or by not accepting
+// JSON, as browsers and curl don't.
+func wantsText(r *http.Request) bool {
+	switch r.FormValue("format") {
+	case "text":
+		return true
+	case "json":
+		return false
+	}
+	return !strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// ShortenData is passed to the shorten.html template.
+type ShortenData struct {
+	URL       string
+	Shortcode string
+	Action    string // where the form posts
+	CSRFToken string
+}
+
+// shortenNeedsConfirmation reports whether a GET /api/v1/shorten must be
+// confirmed on a page of this site before it makes a link. That's when the
+// browser sent the credentials along on its own, a session cookie or
+// remembered Basic auth, and didn't mark the request as coming from this
+// site or from the user, so another site can't make links in a visitor's
+// name by linking to it. API tokens and scripts go straight through.
+func shortenNeedsConfirmation(r *http.Request) bool {
+	if currentUser(r) == nil {
+		return false // no accounts
+	}
+	if _, ok := bearerToken(r); ok {
+		return false
+	}
+	site := r.Header.Get("Sec-Fetch-Site")
+	if site == "same-origin" || site == "none" {
+		return false
+	}
+	if _, _, ok := r.BasicAuth(); ok {
+		return site != ""
+	}
+	return true
+}
+
+// handleShorten creates or finds a short link from query parameters or a
+// form, for bookmarklets and one-line scripts. The answer is the bare
+// short URL unless the client asks for JSON.
+func (lf *LinkForwarder) handleShorten(w http.ResponseWriter, r *http.Request) {
+	text := wantsText(r)
+	if r.Method == http.MethodGet && shortenNeedsConfirmation(r) {
+		if !text {
+			writeError(w, http.StatusForbidden, "Links can only be made with the session cookie by a POST with its CSRF token")
+			return
+		}
+		lf.confirmShorten(w, r)
+		return
+	}
+
+	link, created, apiErr := lf.shortenRequest(r, r.FormValue("url"), r.FormValue("code"))
+	if apiErr != nil {
+		if text {
+			http.Error(w, apiErr.message, apiErr.status)
+		} else {
+			writeError(w, apiErr.status, apiErr.message)
+		}
+		return
+	}
+	link.ShortURL = lf.shortURL(r, link)
+
+	if text {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, link.ShortURL)
+		return
+	}
+	message := "Existing link returned"
+	if created {
+		message = "Link saved successfully"
+	}
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    link,
+	})
+}
+
+// confirmShorten shows the page that asks the visitor to confirm a link
+// the bookmarklet asked for, which posts it back with the CSRF token.
+func (lf *LinkForwarder) confirmShorten(w http.ResponseWriter, r *http.Request) {
+	data := ShortenData{
+		URL:       r.FormValue("url"),
+		Shortcode: r.FormValue("code"),
+		Action:    lf.appPath(r.URL.RequestURI()),
+		CSRFToken: requestCSRFToken(r),
+	}
+	tmpl, err := lf.loadTemplate("shorten.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, data); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+}
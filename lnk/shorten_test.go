@@ -0,0 +1,99 @@
+package lnk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestShortenCSRF checks that GET /api/v1/shorten only makes links for
+// browsers that sent the session cookie from another site once they've
+// confirmed it on a page that posts the CSRF token.
+func TestShortenCSRF(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"ADMIN_PASSWORD": "admin-password"})
+	admin, err := lf.getUser(context.Background(), "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := testSession(t, lf, admin)
+	token := testToken(t, lf, admin, scopeCreate)
+
+	exists := func(code string) bool {
+		_, err := lf.getLink(context.Background(), "", code)
+		return err == nil
+	}
+	shorten := func(code string) string {
+		return "/api/v1/shorten?url=" + url.QueryEscape("https://dest.example/"+code) + "&code=" + code
+	}
+
+	// Another site sends the visitor here with their cookie
+	w := serve(lf, "GET", shorten("crosssite"), "", func(r *http.Request) {
+		r.AddCookie(cookie)
+		r.Header.Set("Sec-Fetch-Site", "cross-site")
+	})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="csrf_token" value="`+csrfToken(cookie.Value)+`"`) {
+		t.Fatalf("cross-site GET: status %d, want the confirmation page: %s", w.Code, w.Body)
+	}
+	if exists("crosssite") {
+		t.Fatal("cross-site GET made a link")
+	}
+
+	// Browsers too old to say where requests come from confirm as well
+	serve(lf, "GET", shorten("nometadata"), "", func(r *http.Request) { r.AddCookie(cookie) })
+	if exists("nometadata") {
+		t.Fatal("GET with only the cookie made a link")
+	}
+
+	w = serve(lf, "GET", shorten("json")+"&format=json", "", func(r *http.Request) {
+		r.AddCookie(cookie)
+		r.Header.Set("Sec-Fetch-Site", "cross-site")
+	})
+	if w.Code != http.StatusForbidden || exists("json") {
+		t.Fatalf("cross-site GET for JSON: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	post := func(code, csrf string) int {
+		form := url.Values{"url": {"https://dest.example/" + code}, "code": {code}, csrfField: {csrf}}
+		r := httptest.NewRequest("POST", "/api/v1/shorten", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Sec-Fetch-Site", "same-origin")
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		lf.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post("notoken", ""); code != http.StatusForbidden || exists("notoken") {
+		t.Errorf("POST without the CSRF token: status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := post("confirmed", csrfToken(cookie.Value)); code != http.StatusOK || !exists("confirmed") {
+		t.Errorf("confirmed POST: status %d, want a link", code)
+	}
+
+	for name, edit := range map[string]func(r *http.Request){
+		"sameorigin": func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("Sec-Fetch-Site", "same-origin")
+		},
+		"bearer": func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		},
+		"script": func(r *http.Request) { r.SetBasicAuth("admin", "admin-password") },
+	} {
+		if w := serve(lf, "GET", shorten(name), "", edit); w.Code != http.StatusOK || !exists(name) {
+			t.Errorf("GET %s: status %d, want a link: %s", name, w.Code, w.Body)
+		}
+	}
+
+	// Basic auth the browser remembered counts as much as the cookie
+	serve(lf, "GET", shorten("rememberedbasic"), "", func(r *http.Request) {
+		r.SetBasicAuth("admin", "admin-password")
+		r.Header.Set("Sec-Fetch-Site", "cross-site")
+	})
+	if exists("rememberedbasic") {
+		t.Error("cross-site GET with Basic auth made a link")
+	}
+}
@@ -0,0 +1,49 @@
+package lnk
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SQLite connection settings. WAL lets redirects keep reading while a link
+// is being saved, and the busy timeout makes writers wait for each other
+// instead of failing with "database is locked".
+const (
+	sqliteBusyTimeout  = 5 * time.Second
+	sqliteMaxOpenConns = 10
+	sqliteMaxIdleConns = 5
+)
+
+// openSQLite opens the database at path with the pragmas the server relies
+// on, through driver (see sqliteDriver), tracing queries with tracer when
+// it isn't nil.
+func openSQLite(path, driver string, tracer *sdktrace.TracerProvider) (*sql.DB, error) {
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_synchronous", "NORMAL")
+	params.Set("_busy_timeout", fmt.Sprint(sqliteBusyTimeout.Milliseconds()))
+	params.Set("_foreign_keys", "on")
+	// Transactions that read before they write would otherwise fail
+	// outright when another connection holds the write lock, since SQLite
+	// can't wait on a lock upgrade
+	params.Set("_txlock", "immediate")
+
+	db, err := openTraced(driver, "file:"+path+"?"+params.Encode(), tracer)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(sqliteMaxOpenConns)
+	db.SetMaxIdleConns(sqliteMaxIdleConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	// sql.Open is lazy; surface a bad path or locked file at startup
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
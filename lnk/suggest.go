@@ -0,0 +1,131 @@
+package lnk
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is how many similar shortcodes are offered for a miss.
+const maxSuggestions = 3
+
+// minSimilarity is how alike a shortcode must be to the one requested to
+// be suggested, from 0 for nothing in common to 1 for the same.
+const minSimilarity = 0.5
+
+// Suggestions is the data of a 404 from the API when similar shortcodes
+// were asked for with ?suggest=1.
+type Suggestions struct {
+	Suggestions []string `json:"suggestions"` // closest first; empty when none are close
+}
+
+// suggestShortcodes returns the shortcodes and aliases on domain most like
+// code, a shortcode that doesn't exist, for the visitor who probably
+// mistyped it: /ghub finds /github. The closest come first.
+func (lf *LinkForwarder) suggestShortcodes(ctx context.Context, domain, code string) ([]string, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT shortcode FROM links WHERE domain = ?
+		UNION SELECT alias FROM aliases WHERE domain = ?`, domain, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type match struct {
+		code  string
+		score float64
+	}
+	target := strings.ToLower(code)
+	targetTrigrams := trigrams(target)
+	var matches []match
+	for rows.Next() {
+		var candidate string
+		if err := rows.Scan(&candidate); err != nil {
+			return nil, err
+		}
+		lower := strings.ToLower(candidate)
+		if lower == target {
+			continue
+		}
+		score := max(editSimilarity(target, lower), trigramSimilarity(targetTrigrams, trigrams(lower)))
+		if score >= minSimilarity {
+			matches = append(matches, match{candidate, score})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].code < matches[j].code
+	})
+	suggestions := []string{}
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, matches[i].code)
+	}
+	return suggestions, nil
+}
+
+// editSimilarity scores a and b by their Levenshtein distance relative to
+// the longer of the two, which suits typos: a slip or two in a short
+// shortcode.
+func editSimilarity(a, b string) float64 {
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// trigrams returns the three-character sequences of s, padded so its
+// start and end count too.
+func trigrams(s string) map[string]bool {
+	r := []rune("  " + s + " ")
+	set := map[string]bool{}
+	for i := 0; i+3 <= len(r); i++ {
+		set[string(r[i:i+3])] = true
+	}
+	return set
+}
+
+// trigramSimilarity scores two trigram sets by the share they have in
+// common (their Jaccard index). It catches longer shortcodes with words
+// added or left out, which are many edits apart.
+func trigramSimilarity(a, b map[string]bool) float64 {
+	shared := 0
+	for t := range a {
+		if b[t] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// levenshtein counts the insertions, deletions, and substitutions that
+// turn a into b.
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
@@ -0,0 +1,424 @@
+package lnk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// maxSyncFileBytes bounds a links file uploaded to POST /links/sync.
+const maxSyncFileBytes = 10 << 20
+
+// defaultSyncInterval is how often the sync-file job applies SYNC_FILE
+// again, picking up a new version of it and undoing edits made elsewhere.
+const defaultSyncInterval = time.Minute
+
+// syncActor is who changes made from SYNC_FILE are recorded as.
+const syncActor = "sync-file"
+
+// syncConfig is the links file the server keeps its links in line with.
+type syncConfig struct {
+	file     string        // empty turns syncing off
+	prune    bool          // delete links the file doesn't list
+	interval time.Duration // how often the sync-file job runs; 0 only syncs on startup
+}
+
+// loadSyncConfig reads SYNC_FILE, SYNC_PRUNE, and SYNC_INTERVAL. A file
+// set with WithSyncFile wins over SYNC_FILE.
+func loadSyncConfig(getenv func(string) string, file string) (syncConfig, error) {
+	cfg := syncConfig{file: file, interval: defaultSyncInterval}
+	if cfg.file == "" {
+		cfg.file = getenv("SYNC_FILE")
+	}
+	if v := getenv("SYNC_PRUNE"); v != "" {
+		prune, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SYNC_PRUNE %q: must be true or false", v)
+		}
+		cfg.prune = prune
+	}
+	if v := getenv("SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid SYNC_INTERVAL %q: must be a duration such as 1m, or 0 to only sync on startup", v)
+		}
+		cfg.interval = d
+	}
+	if cfg.file == "" {
+		cfg.interval = 0
+	}
+	return cfg, nil
+}
+
+// SyncFile is a links file: every link that should exist, with the same
+// fields the API takes. It's written in YAML or JSON.
+type SyncFile struct {
+	Links []Link `json:"links"`
+}
+
+// parseSyncFile reads a links file. Unknown fields are refused, so a typo
+// doesn't quietly leave a setting out.
+func parseSyncFile(data []byte) (SyncFile, error) {
+	var file SyncFile
+	// YAML is read through JSON so links have the field names of the API
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return file, fmt.Errorf("invalid links file: %v", err)
+	}
+	if _, ok := doc.(map[string]any); !ok {
+		return file, errors.New("invalid links file: must be a mapping with a list of links")
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return file, fmt.Errorf("invalid links file: %v", err)
+	}
+	var parsed struct {
+		Links *[]Link `json:"links"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&parsed); err != nil {
+		return file, fmt.Errorf("invalid links file: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	// An empty file is more likely a mistake than a wish to prune every link
+	if parsed.Links == nil {
+		return file, errors.New("invalid links file: links is required; use links: [] for none")
+	}
+	file.Links = *parsed.Links
+	return file, nil
+}
+
+// SyncResult is what syncing a links file changed, or on a dry run, would
+// change. Links on a custom domain are listed as domain/shortcode.
+type SyncResult struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+}
+
+// syncRequest is a links file to sync, and who for: what the API request
+// carrying it would give, or for SYNC_FILE, no account on the public host.
+type syncRequest struct {
+	file   SyncFile
+	by     editor
+	actor  string // as recorded in the history
+	host   string // the server host, which links mustn't redirect back to
+	domain string // namespace of the links that don't name one
+	prune  bool
+	dryRun bool
+}
+
+// syncChange is one link to save or delete to match a links file.
+type syncChange struct {
+	action string // historyCreate, historyUpdate, or historyDelete
+	link   Link
+}
+
+// syncedSettings is the part of a link a links file sets, for telling
+// whether a link needs saving: what's set on reads, or kept from the
+// existing link, is left out.
+func syncedSettings(link Link) ([]byte, error) {
+	link.Owner, link.Clicks, link.ShortURL, link.Aliases = "", 0, "", nil
+	link.CreatedAt, link.LastAccessedAt, link.ArchivedAt = nil, nil, nil
+	link.PageTitle, link.FaviconURL, link.PageFetchedAt, link.Check = "", "", nil, nil
+	link.Password, link.RemovePassword, link.Protected = "", false, false
+	return json.Marshal(link)
+}
+
+// sameSettings reports whether saving link over existing would change
+// anything a links file sets.
+func sameSettings(existing, link Link) bool {
+	if existing.passwordHash != link.passwordHash {
+		return false
+	}
+	a, err := syncedSettings(existing)
+	if err != nil {
+		return false
+	}
+	b, err := syncedSettings(link)
+	return err == nil && bytes.Equal(a, b)
+}
+
+// planSync works out the changes that make the links match req's file,
+// checking each link as the API would for req.by. A link the file leaves
+// without a password loses the one it has. With prune, links the file
+// doesn't list are deleted from the domains it has links on, and
+// req.domain.
+func (lf *LinkForwarder) planSync(ctx context.Context, req syncRequest) ([]syncChange, int, *apiError) {
+	domains := map[string]bool{req.domain: true}
+	listed := map[string]bool{}
+
+	var changes []syncChange
+	unchanged := 0
+	for i, link := range req.file.Links {
+		password := link.Password
+		link.RemovePassword = password == ""
+		if link.Domain == "" {
+			link.Domain = req.domain
+		}
+		prepared, apiErr := lf.prepareLink(ctx, req.by, req.host, link, false)
+		if apiErr != nil {
+			return nil, 0, &apiError{apiErr.status, fmt.Sprintf("link %d (%s): %s", i+1, link.Shortcode, apiErr.message)}
+		}
+		key := prepared.Domain + " " + prepared.Shortcode
+		if listed[key] {
+			return nil, 0, &apiError{http.StatusBadRequest, fmt.Sprintf("link %d: '%s' appears more than once in the file", i+1, prepared.Shortcode)}
+		}
+		listed[key] = true
+		domains[prepared.Domain] = true
+
+		existing, err := lf.getLink(ctx, prepared.Domain, prepared.Shortcode)
+		switch {
+		case errors.Is(err, errLinkNotFound):
+			changes = append(changes, syncChange{historyCreate, prepared})
+			continue
+		case err != nil:
+			return nil, 0, &apiError{http.StatusInternalServerError, "Failed to retrieve links"}
+		}
+		// Passwords are hashed with a new salt each time, so check the
+		// file's against the existing hash instead
+		if password != "" && bcrypt.CompareHashAndPassword([]byte(existing.passwordHash), []byte(password)) == nil {
+			prepared.passwordHash = existing.passwordHash
+			prepared.Protected = true
+		}
+		if sameSettings(existing, prepared) {
+			unchanged++
+		} else {
+			changes = append(changes, syncChange{historyUpdate, prepared})
+		}
+	}
+	if !req.prune {
+		return changes, unchanged, nil
+	}
+
+	pruned := make([]string, 0, len(domains))
+	for d := range domains {
+		pruned = append(pruned, d)
+	}
+	sort.Strings(pruned)
+	for _, d := range pruned {
+		codes, err := lf.shortcodes(ctx, d)
+		if err != nil {
+			return nil, 0, &apiError{http.StatusInternalServerError, "Failed to retrieve links"}
+		}
+		for _, code := range codes {
+			if listed[d+" "+code] {
+				continue
+			}
+			if apiErr := lf.checkDelete(ctx, req.by, d, code); apiErr != nil {
+				return nil, 0, &apiError{apiErr.status, fmt.Sprintf("pruning %s: %s", code, apiErr.message)}
+			}
+			changes = append(changes, syncChange{historyDelete, Link{Domain: d, Shortcode: code}})
+		}
+	}
+	return changes, unchanged, nil
+}
+
+// shortcodes returns every shortcode on domain, archived links included.
+func (lf *LinkForwarder) shortcodes(ctx context.Context, domain string) ([]string, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT shortcode FROM links WHERE domain = ? ORDER BY shortcode`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+// applySync saves and deletes links in one transaction, so a links file
+// is applied entirely or not at all.
+func (lf *LinkForwarder) applySync(ctx context.Context, changes []syncChange, actor string) error {
+	tx, err := lf.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deletedAliases := make([][]string, len(changes)) // purged from the CDN with the link
+	for i, c := range changes {
+		if c.action == historyDelete {
+			var previous Link
+			previous, err = deleteLinkTx(ctx, tx, c.link.Domain, c.link.Shortcode, actor)
+			deletedAliases[i] = previous.Aliases
+		} else {
+			_, err = saveLinkTx(ctx, tx, c.link, actor)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", c.action, c.link.Shortcode, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	lf.invalidateLinks()
+
+	for i, c := range changes {
+		lf.publish(Event{Type: c.action, Domain: c.link.Domain, Shortcode: c.link.Shortcode, URL: c.link.URL, Actor: actor})
+		lf.purgeCDN(c.link.Domain, c.link.Shortcode, deletedAliases[i]...)
+		if c.action != historyDelete {
+			lf.queuePageInfo(c.link)
+		}
+	}
+	return nil
+}
+
+// syncLinks makes the links match req's file, or with dryRun, only says
+// what that would change.
+func (lf *LinkForwarder) syncLinks(ctx context.Context, req syncRequest) (SyncResult, *apiError) {
+	result := SyncResult{Created: []string{}, Updated: []string{}, Deleted: []string{}, DryRun: req.dryRun}
+	changes, unchanged, apiErr := lf.planSync(ctx, req)
+	if apiErr != nil {
+		return result, apiErr
+	}
+	result.Unchanged = unchanged
+	for _, c := range changes {
+		name := c.link.Shortcode
+		if c.link.Domain != "" {
+			name = c.link.Domain + "/" + name
+		}
+		switch c.action {
+		case historyCreate:
+			result.Created = append(result.Created, name)
+		case historyUpdate:
+			result.Updated = append(result.Updated, name)
+		default:
+			result.Deleted = append(result.Deleted, name)
+		}
+	}
+	if req.dryRun || len(changes) == 0 {
+		return result, nil
+	}
+	if err := lf.applySync(ctx, changes, req.actor); err != nil {
+		lf.logContextf(ctx, "Failed to sync links: %v", err)
+		return result, &apiError{http.StatusInternalServerError, "Failed to sync links"}
+	}
+	return result, nil
+}
+
+// handleSync makes the links match the links file in the request body:
+// links it lists are created or updated, and with ?prune=true, those it
+// doesn't are deleted. ?dry_run=true reports the changes without making
+// them.
+func (lf *LinkForwarder) handleSync(w http.ResponseWriter, r *http.Request) {
+	var flags [2]bool
+	for i, name := range []string{"prune", "dry_run"} {
+		if v := r.URL.Query().Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("%s must be true or false", name))
+				return
+			}
+			flags[i] = b
+		}
+	}
+	prune, dryRun := flags[0], flags[1]
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSyncFileBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Links file is too large")
+		} else {
+			writeError(w, http.StatusBadRequest, "Failed to read links file")
+		}
+		return
+	}
+	file, err := parseSyncFile(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	domain, err := lf.apiDomain(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor := lf.requestActor(r)
+	result, apiErr := lf.syncLinks(r.Context(), syncRequest{
+		file:   file,
+		by:     requestEditor(r),
+		actor:  actor,
+		host:   r.Host,
+		domain: domain,
+		prune:  prune,
+		dryRun: dryRun,
+	})
+	if apiErr != nil {
+		writeError(w, apiErr.status, apiErr.message)
+		return
+	}
+
+	message := "Links synced successfully"
+	if dryRun {
+		message = "Dry run: no links were changed"
+	} else {
+		lf.logf(r, "%s synced links: %d created, %d updated, %d deleted", actor,
+			len(result.Created), len(result.Updated), len(result.Deleted))
+	}
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    result,
+	})
+}
+
+// syncFromFile makes the links match SYNC_FILE. The changes are checked
+// as they would be for an API request without an account to PUBLIC_URL,
+// when it's set, in the default namespace.
+func (lf *LinkForwarder) syncFromFile(ctx context.Context) (SyncResult, error) {
+	data, err := os.ReadFile(lf.sync.file)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	file, err := parseSyncFile(data)
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("%s: %v", lf.sync.file, err)
+	}
+	req := syncRequest{file: file, actor: syncActor, prune: lf.sync.prune}
+	if lf.publicURL != nil {
+		req.host = lf.publicURL.Host
+	}
+	result, apiErr := lf.syncLinks(ctx, req)
+	if apiErr != nil {
+		return result, fmt.Errorf("%s: %s", lf.sync.file, apiErr.message)
+	}
+	return result, nil
+}
+
+// runSync is the sync-file job.
+func (lf *LinkForwarder) runSync(ctx context.Context) (string, error) {
+	result, err := lf.syncFromFile(ctx)
+	if err != nil {
+		return "", err
+	}
+	return syncSummary(result), nil
+}
+
+// syncSummary describes a sync for the log.
+func syncSummary(result SyncResult) string {
+	return fmt.Sprintf("Created %d, updated %d, and deleted %d links; %d unchanged",
+		len(result.Created), len(result.Updated), len(result.Deleted), result.Unchanged)
+}
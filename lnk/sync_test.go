@@ -0,0 +1,91 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSyncFromFile checks that SYNC_FILE creates and updates the links it
+// lists, prunes the rest, and leaves links alone once they match.
+func TestSyncFromFile(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	ctx := context.Background()
+	for _, link := range []Link{
+		{Shortcode: "docs", URL: "https://dest.example/old-docs"},
+		{Shortcode: "stale", URL: "https://dest.example/stale"},
+	} {
+		if err := lf.saveLink(ctx, link, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lf.sync.file = filepath.Join(t.TempDir(), "links.yaml")
+	lf.sync.prune = true
+	file := "links:\n" +
+		"  - shortcode: docs\n    url: https://dest.example/docs\n" +
+		"  - shortcode: blog\n    url: https://dest.example/blog\n    tags: [news]\n"
+	if err := os.WriteFile(lf.sync.file, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := lf.syncFromFile(ctx)
+	if err != nil {
+		t.Fatalf("syncFromFile: %v", err)
+	}
+	if strings.Join(result.Created, ",") != "blog" || strings.Join(result.Updated, ",") != "docs" || strings.Join(result.Deleted, ",") != "stale" {
+		t.Errorf("result %+v, want blog created, docs updated, stale deleted", result)
+	}
+	if link, err := lf.getLink(ctx, "", "docs"); err != nil || link.URL != "https://dest.example/docs" {
+		t.Errorf("docs = %q (%v), want the file's URL", link.URL, err)
+	}
+	if _, err := lf.getLink(ctx, "", "stale"); !errors.Is(err, errLinkNotFound) {
+		t.Errorf("stale: %v, want it pruned", err)
+	}
+
+	result, err = lf.syncFromFile(ctx)
+	if err != nil || result.Unchanged != 2 || len(result.Created)+len(result.Updated)+len(result.Deleted) != 0 {
+		t.Errorf("second sync %+v (%v), want both links unchanged", result, err)
+	}
+}
+
+// TestSyncDryRun checks that ?dry_run=true reports the changes of a sync
+// through the API without making them.
+func TestSyncDryRun(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	body := `{"links":[{"shortcode":"docs","url":"https://dest.example/docs"}]}`
+	w := serve(lf, "POST", "/api/v1/links/sync?dry_run=true", body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct{ Data SyncResult }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Data.DryRun || strings.Join(resp.Data.Created, ",") != "docs" {
+		t.Errorf("result %+v, want docs to be created on a dry run", resp.Data)
+	}
+	if _, err := lf.getLink(context.Background(), "", "docs"); !errors.Is(err, errLinkNotFound) {
+		t.Errorf("docs: %v, want it not created", err)
+	}
+}
+
+// TestSyncFromFileRedirectLoop checks that SYNC_FILE links are checked for
+// redirecting back to the server at PUBLIC_URL, as they would be through
+// the API.
+func TestSyncFromFileRedirectLoop(t *testing.T) {
+	lf := newTestForwarder(t, map[string]string{"PUBLIC_URL": "https://go.example"})
+	lf.sync.file = filepath.Join(t.TempDir(), "links.yaml")
+	file := "links:\n  - shortcode: loop\n    url: https://go.example/loop\n"
+	if err := os.WriteFile(lf.sync.file, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lf.syncFromFile(context.Background()); err == nil || !strings.Contains(err.Error(), "redirect loop") {
+		t.Errorf("syncFromFile: %v, want a redirect loop error", err)
+	}
+}
@@ -0,0 +1,191 @@
+package lnk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+// telegramPollTimeout is how long each getUpdates call waits for messages
+// before returning empty-handed.
+const telegramPollTimeout = 30 * time.Second
+
+// telegramBot is a Telegram bot that takes commands through long polling,
+// so it works without a public URL for Telegram to call.
+type telegramBot struct {
+	endpoint string // the Bot API URL, including the token
+	users    chatUsers
+	client   *http.Client
+}
+
+// loadTelegram configures the Telegram bot from TELEGRAM_BOT_TOKEN,
+// TELEGRAM_USERS, and TELEGRAM_API_URL. It returns nil when
+// TELEGRAM_BOT_TOKEN isn't set.
+func loadTelegram(getenv func(string) string) (*telegramBot, error) {
+	token := getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+	users, err := loadChatUsers(getenv, "TELEGRAM_USERS")
+	if err != nil {
+		return nil, err
+	}
+	api := getenv("TELEGRAM_API_URL")
+	if api == "" {
+		api = defaultTelegramAPIURL
+	}
+	return &telegramBot{
+		endpoint: strings.TrimSuffix(api, "/") + "/bot" + token,
+		users:    users,
+		client:   &http.Client{Timeout: telegramPollTimeout + 10*time.Second},
+	}, nil
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	From *struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	} `json:"from"`
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+// call invokes a Bot API method and decodes its result into result, if
+// it's not nil.
+func (b *telegramBot) call(ctx context.Context, method, contentType string, body io.Reader, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+"/"+method, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The URL holds the token, so leave it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// send answers in a chat with reply's text, or its QR code captioned with
+// the text.
+func (b *telegramBot) send(ctx context.Context, chatID int64, reply botReply) error {
+	if reply.qr == nil {
+		body, err := json.Marshal(map[string]any{
+			"chat_id":                  chatID,
+			"text":                     reply.text,
+			"disable_web_page_preview": true,
+		})
+		if err != nil {
+			return err
+		}
+		return b.call(ctx, "sendMessage", "application/json", bytes.NewReader(body), nil)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", strconv.FormatInt(chatID, 10))
+	mw.WriteField("caption", reply.text)
+	part, err := mw.CreateFormFile("photo", "qr.png")
+	if err != nil {
+		return err
+	}
+	part.Write(reply.qr)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return b.call(ctx, "sendPhoto", mw.FormDataContentType(), &body, nil)
+}
+
+// pollTelegram answers Telegram commands until ctx is cancelled.
+func (lf *LinkForwarder) pollTelegram(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		body, err := json.Marshal(map[string]any{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		})
+		if err != nil {
+			return
+		}
+		var updates []telegramUpdate
+		if err := lf.telegram.call(ctx, "getUpdates", "application/json", bytes.NewReader(body), &updates); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			lf.logger.Printf("Telegram polling failed: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message != nil {
+				lf.handleTelegramMessage(ctx, update.Message)
+			}
+		}
+	}
+}
+
+// handleTelegramMessage runs the command in a message, if it has one.
+func (lf *LinkForwarder) handleTelegramMessage(ctx context.Context, m *telegramMessage) {
+	if m.From == nil || !strings.HasPrefix(m.Text, "/") {
+		return
+	}
+	id := strconv.FormatInt(m.From.ID, 10)
+	var reply botReply
+	if account, ok := lf.telegram.users.lookup(id, m.From.Username); ok {
+		actor := account
+		if actor == "" {
+			actor = "telegram:" + id
+		}
+		reply = lf.botCommand(ctx, account, actor, m.Text)
+	} else {
+		lf.logger.Printf("Refusing Telegram command from user %s (@%s): not in TELEGRAM_USERS", id, m.From.Username)
+		reply = botReply{text: "You aren't allowed to use this bot."}
+	}
+	if err := lf.telegram.send(ctx, m.Chat.ID, reply); err != nil {
+		lf.logger.Printf("Failed to answer Telegram command: %v", err)
+	}
+}
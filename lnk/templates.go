@@ -0,0 +1,43 @@
+package lnk
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+)
+
+// The page templates and static files are compiled in, so the package
+// works wherever it's imported without a templates directory alongside.
+//
+//go:embed templates
+var templateFiles embed.FS
+
+// pageFiles holds the templates, the partials they share under partials/,
+// and what's served under /static/.
+var pageFiles, _ = fs.Sub(templateFiles, "templates")
+
+// loadTemplate returns one of the page templates, parsed along with the
+// partials. They're parsed once, at startup, unless a theme is being
+// worked on in development mode.
+func (lf *LinkForwarder) loadTemplate(name string) (*template.Template, error) {
+	if !lf.reloadTheme {
+		if tmpl, ok := lf.templates[name]; ok {
+			return tmpl, nil
+		}
+	} else if err := lf.loadAssets(); err != nil {
+		return nil, err
+	}
+	return lf.parseTemplate(name)
+}
+
+// parseTemplate parses a page template from the embedded files or the
+// theme.
+func (lf *LinkForwarder) parseTemplate(name string) (*template.Template, error) {
+	funcs := template.FuncMap{
+		// path prefixes a local URL with the base path
+		"path": lf.appPath,
+		// asset links to a file under static/ by its fingerprinted name
+		"asset": lf.assetPath,
+	}
+	return template.New(name).Funcs(funcs).ParseFS(lf.pages, name, "partials/*.html")
+}
@@ -0,0 +1,6 @@
+// The spec's URL depends on the base path, so the page passes it in
+SwaggerUIBundle({
+    url: document.body.dataset.spec,
+    dom_id: "#swagger-ui",
+    withCredentials: true
+});
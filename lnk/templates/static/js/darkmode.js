@@ -0,0 +1,3 @@
+if (localStorage.getItem("darkMode") === "true") {
+    document.body.classList.add("dark-mode");
+}
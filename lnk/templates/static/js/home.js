@@ -0,0 +1,1071 @@
+// URL prefix the server is mounted under (BASE_PATH)
+const basePath = document.body.dataset.basePath;
+// Sent with every change, so other sites can't make them
+// through the session cookie
+const csrfToken = document.body.dataset.csrfToken;
+
+// Links from the last load, keyed by shortcode, for editing
+let linksByCode = {};
+
+// The page of the link list on show; the server searches, sorts, and
+// pages it, so large link lists stay quick
+const listState = {
+    q: "",
+    group: "",
+    pinnedOnly: false,
+    archived: false,
+    sort: "created_at",
+    order: "desc",
+    page: 1,
+    perPage: parseInt(localStorage.getItem("perPage"), 10) || 50,
+};
+
+// Shortcodes ticked on the current page, and their checkboxes
+const selected = new Set();
+let checkboxes = {};
+
+// The direction each column sorts in when first picked
+const defaultOrders = {
+    shortcode: "asc",
+    url: "asc",
+    clicks: "desc",
+    created_at: "desc",
+    last_used: "desc",
+};
+
+// Convert between API timestamps and datetime-local input values
+function toLocalInput(timestamp) {
+    if (!timestamp) return "";
+    const date = new Date(timestamp);
+    date.setMinutes(date.getMinutes() - date.getTimezoneOffset());
+    return date.toISOString().slice(0, 16);
+}
+
+function fromLocalInput(value) {
+    return value ? new Date(value).toISOString() : null;
+}
+
+const utmFields = {
+    source: "utmSource",
+    medium: "utmMedium",
+    campaign: "utmCampaign",
+};
+
+// Read the UTM inputs, keeping term/content set through the API
+function readUTM(existing) {
+    const utm = Object.assign({}, existing);
+    Object.entries(utmFields).forEach(([key, id]) => {
+        utm[key] = document.getElementById(id).value.trim();
+    });
+    return utm;
+}
+
+function fillUTM(utm) {
+    Object.entries(utmFields).forEach(([key, id]) => {
+        document.getElementById(id).value = (utm && utm[key]) || "";
+    });
+}
+
+// Send the user back to the login page when their session expires
+function checkAuth(response) {
+    if (response.status === 401) {
+        window.location =
+            basePath +
+            "/login?next=" +
+            encodeURIComponent(
+                window.location.pathname.slice(basePath.length),
+            );
+        throw new Error("Authentication required");
+    }
+    return response;
+}
+
+// Builds an element whose text is set as text, never as HTML:
+// shortcodes, URLs, titles, and tags are typed in by users or come
+// from other sites, and must not be able to inject markup
+function element(tag, className, text) {
+    const el = document.createElement(tag);
+    if (className) el.className = className;
+    if (text) el.textContent = text;
+    return el;
+}
+
+function shortLink(link) {
+    const a = element("a", "", "/" + link.shortcode);
+    a.href = basePath + "/" + encodeURIComponent(link.shortcode);
+    a.target = "_blank";
+    return a;
+}
+
+function button(className, text, onClick, title) {
+    const b = element("button", className, text);
+    if (title) b.title = title;
+    b.addEventListener("click", onClick);
+    return b;
+}
+
+function linkItem(link) {
+    const shortcode = element("div", "shortcode");
+    shortcode.appendChild(
+        button(
+            "pin-btn",
+            link.pinned ? "\u2605" : "\u2606",
+            () => updateLink(link, { pinned: !link.pinned }),
+            link.pinned ? "Unpin" : "Pin to the top of the list",
+        ),
+    );
+    if (link.favicon_url) {
+        const icon = element("img", "favicon");
+        icon.alt = "";
+        icon.src = link.favicon_url;
+        icon.addEventListener("error", () => icon.remove());
+        shortcode.appendChild(icon);
+    }
+    shortcode.appendChild(shortLink(link));
+    if (link.protected) {
+        const lock = element("span", "", "\u{1F512}");
+        lock.title = "Password protected";
+        shortcode.append(" ", lock);
+    }
+    if (link.archived_at) {
+        const archived = element("span", "archived", "archived");
+        archived.title =
+            "Archived " + new Date(link.archived_at).toLocaleDateString();
+        shortcode.append(" ", archived);
+    }
+    if (link.check && link.check.broken) {
+        const broken = element("span", "broken", "broken");
+        broken.title = link.check.error || "HTTP " + link.check.status;
+        shortcode.append(" ", broken);
+    }
+    if (link.group) {
+        const group = element("span", "group", link.group);
+        group.title = "Show only the links in " + link.group;
+        group.addEventListener("click", () => filterGroup(link.group));
+        shortcode.append(" ", group);
+    }
+    if (link.title || link.page_title) {
+        shortcode.append(
+            " ",
+            element("span", "title", link.title || link.page_title),
+        );
+    }
+
+    const check = element("input", "select");
+    check.type = "checkbox";
+    check.title = "Select /" + link.shortcode;
+    check.addEventListener("change", () => {
+        if (check.checked) {
+            selected.add(link.shortcode);
+        } else {
+            selected.delete(link.shortcode);
+        }
+        showSelection();
+    });
+    checkboxes[link.shortcode] = check;
+
+    const info = element("div", "link-info");
+    info.appendChild(shortcode);
+    const url = element("div", "url editable", link.url);
+    url.title = "Click to edit the URL, title, tags, and group";
+    info.appendChild(url);
+    if (link.description) {
+        info.appendChild(
+            element("div", "description", link.description),
+        );
+    }
+    if (link.aliases) {
+        info.appendChild(
+            element(
+                "div",
+                "description",
+                "Also " +
+                    link.aliases.map((alias) => "/" + alias).join(", "),
+            ),
+        );
+    }
+    const stats = [
+        (link.clicks || 0) + (link.clicks === 1 ? " click" : " clicks"),
+    ];
+    if (link.created_at) {
+        stats.push(
+            "created " + new Date(link.created_at).toLocaleDateString(),
+        );
+    }
+    stats.push(
+        link.last_accessed_at
+            ? "last used " +
+                  new Date(link.last_accessed_at).toLocaleDateString()
+            : "never used",
+    );
+    info.appendChild(
+        element("div", "description", stats.join(" \u00b7 ")),
+    );
+    if (link.max_clicks) {
+        info.appendChild(
+            element(
+                "div",
+                "description",
+                (link.clicks || 0) +
+                    " of " +
+                    link.max_clicks +
+                    " clicks used",
+            ),
+        );
+    }
+    if (link.tags) {
+        const tags = element("div", "tags editable");
+        tags.title = url.title;
+        link.tags.forEach((tag) =>
+            tags.appendChild(element("span", "tag", tag)),
+        );
+        info.appendChild(tags);
+    }
+
+    const actions = element("div");
+    const copy = button(
+        "copy-btn",
+        "Copy",
+        () => copyShortURL(link, copy),
+        "Copy " + link.short_url,
+    );
+    actions.appendChild(copy);
+    actions.appendChild(
+        button("qr-btn", "QR", () => showQR(link), "Show the QR code"),
+    );
+    actions.appendChild(
+        button("edit-btn", "Edit", () => editLink(link.shortcode)),
+    );
+    actions.appendChild(
+        button(
+            "refresh-btn",
+            "Refresh",
+            () => refreshPageInfo(link.shortcode),
+            "Fetch the title and icon of the destination page",
+        ),
+    );
+    actions.appendChild(
+        link.archived_at
+            ? button(
+                  "archive-btn",
+                  "Restore",
+                  () => archiveLink(link.shortcode, false),
+                  "Bring the link back into the list",
+              )
+            : button(
+                  "archive-btn",
+                  "Archive",
+                  () => archiveLink(link.shortcode, true),
+                  "Take the link out of the list",
+              ),
+    );
+    actions.appendChild(
+        button("delete-btn", "Delete", () =>
+            deleteLink(link.shortcode),
+        ),
+    );
+
+    const item = element(
+        "div",
+        link.pinned ? "link-item pinned" : "link-item",
+    );
+    item.appendChild(check);
+    item.appendChild(info);
+    item.appendChild(actions);
+    info.querySelectorAll(".editable").forEach((el) =>
+        el.addEventListener("click", () => editInline(item, link)),
+    );
+    return item;
+}
+
+// Copies a link's short URL as the server gives it, at PUBLIC_URL when
+// that's set, so it's right even when this page came through a proxy
+function copyShortURL(link, b) {
+    const copied = () => {
+        b.textContent = "Copied!";
+        setTimeout(() => (b.textContent = "Copy"), 1500);
+    };
+    const ask = () => prompt("Copy the short URL:", link.short_url);
+    // The clipboard API is only there on HTTPS and localhost
+    if (navigator.clipboard && window.isSecureContext) {
+        navigator.clipboard.writeText(link.short_url).then(copied, ask);
+    } else {
+        ask();
+    }
+}
+
+function showQR(link) {
+    const src =
+        basePath +
+        "/api/v1/links/" +
+        encodeURIComponent(link.shortcode) +
+        "/qr";
+    document.getElementById("qrImage").src = src;
+    const download = document.getElementById("qrDownload");
+    download.href = src;
+    download.download = link.shortcode + ".png";
+    document.getElementById("qrURL").textContent = link.short_url;
+    document.getElementById("qrDialog").showModal();
+}
+
+function input(className, value, placeholder) {
+    const el = element("input", className);
+    el.type = "text";
+    el.value = value;
+    el.placeholder = placeholder;
+    return el;
+}
+
+// Swaps a link's row for a small form to change its URL, title, tags,
+// and group in place; the Edit button still opens every setting above
+function editInline(item, link) {
+    const original = Array.from(item.children);
+    const url = input("inline-url", link.url, "URL");
+    url.required = true;
+    const title = input("", link.title || "", "Title");
+    const tags = input(
+        "",
+        (link.tags || []).join(", "),
+        "Tags, comma-separated",
+    );
+    const group = input("", link.group || "", "Group");
+    group.setAttribute("list", "groupOptions");
+    const cancel = () => item.replaceChildren(...original);
+
+    const form = element("form", "inline-edit");
+    form.append(element("strong", "shortcode", "/" + link.shortcode));
+    form.append(url, title, tags, group);
+    const save = element("button", "", "Save");
+    save.type = "submit";
+    form.append(save, button("cancel-btn", "Cancel", cancel));
+    form.addEventListener("keydown", (e) => {
+        if (e.key === "Escape") cancel();
+    });
+    form.addEventListener("submit", (e) => {
+        e.preventDefault();
+        updateLink(link, {
+            url: url.value.trim(),
+            title: title.value.trim(),
+            tags: tags.value
+                .split(",")
+                .map((tag) => tag.trim())
+                .filter((tag) => tag),
+            group: group.value.trim(),
+        });
+    });
+
+    item.replaceChildren(form);
+    url.focus();
+}
+
+// Replaces a link with changes applied, keeping everything else as is
+function updateLink(link, changes) {
+    fetch(
+        basePath + "/api/v1/links/" + encodeURIComponent(link.shortcode),
+        {
+            method: "PUT",
+            headers: {
+                "Content-Type": "application/json",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: JSON.stringify(Object.assign({}, link, changes)),
+        },
+    )
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.success) {
+                loadLinks();
+            } else {
+                alert("Error: " + data.message);
+            }
+        });
+}
+
+function listQuery() {
+    const params = new URLSearchParams({
+        sort: listState.sort,
+        order: listState.order,
+        page: listState.page,
+        per_page: listState.perPage,
+    });
+    if (listState.q) params.set("q", listState.q);
+    if (listState.group) params.set("group", listState.group);
+    if (listState.pinnedOnly) params.set("pinned", "true");
+    if (listState.archived) params.set("archived", "true");
+    return params.toString();
+}
+
+// Fills the group filter and the group suggestions of the forms, along
+// with how many links each group holds
+function loadGroups() {
+    fetch(basePath + "/api/v1/groups")
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (!data.success) return;
+            const filter = document.getElementById("groupFilter");
+            const options = [element("option", "", "All groups")];
+            options[0].value = "";
+            const suggestions = [];
+            data.data.forEach((group) => {
+                const option = element(
+                    "option",
+                    "",
+                    group.name + " (" + group.links + ")",
+                );
+                option.value = group.name;
+                options.push(option);
+                const suggestion = element("option");
+                suggestion.value = group.name;
+                suggestions.push(suggestion);
+            });
+            filter.replaceChildren(...options);
+            filter.value = listState.group;
+            document
+                .getElementById("groupOptions")
+                .replaceChildren(...suggestions);
+        });
+}
+
+function filterGroup(group) {
+    listState.group = group;
+    listState.page = 1;
+    document.getElementById("groupFilter").value = group;
+    loadLinks();
+}
+
+function loadLinks() {
+    // Group counts change along with the links
+    loadGroups();
+    fetch(basePath + "/api/v1/links?" + listQuery())
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            const linksDiv = document.getElementById("links");
+            if (!data.success) {
+                linksDiv.replaceChildren(element("p", "", data.message));
+                return;
+            }
+            // Deleting the last links on the last page leaves it empty
+            if (data.meta.pages && listState.page > data.meta.pages) {
+                listState.page = data.meta.pages;
+                loadLinks();
+                return;
+            }
+            linksDiv.replaceChildren();
+            linksByCode = {};
+            checkboxes = {};
+            selected.clear();
+            showSelection();
+            data.data.forEach((link) => {
+                linksByCode[link.shortcode] = link;
+                linksDiv.appendChild(linkItem(link));
+            });
+            if (!data.data.length) {
+                const filtered =
+                    listState.q ||
+                    listState.group ||
+                    listState.pinnedOnly ||
+                    listState.archived;
+                linksDiv.appendChild(
+                    element(
+                        "p",
+                        "",
+                        filtered ? "No links match" : "No links found",
+                    ),
+                );
+            }
+            showPagination(data.meta);
+        });
+}
+
+function showPagination(meta) {
+    const pages = Math.max(meta.pages, 1);
+    document.getElementById("pageInfo").textContent =
+        "Page " +
+        meta.page +
+        " of " +
+        pages +
+        " (" +
+        meta.total +
+        (meta.total === 1 ? " link)" : " links)");
+    document.getElementById("prevPage").disabled = meta.page <= 1;
+    document.getElementById("nextPage").disabled = meta.page >= pages;
+    document.querySelectorAll(".sort-btn").forEach((b) => {
+        const active = b.dataset.sort === listState.sort;
+        b.classList.toggle("active", active);
+        b.dataset.arrow = active
+            ? listState.order === "asc"
+                ? "\u25B2"
+                : "\u25BC"
+            : "";
+        b.title = active
+            ? "Sorted " +
+              (listState.order === "asc" ? "ascending" : "descending")
+            : "";
+    });
+}
+
+function showSelection() {
+    const count = selected.size;
+    document.getElementById("bulkBar").style.display = count
+        ? "flex"
+        : "none";
+    document.getElementById("selectedCount").textContent =
+        count + (count === 1 ? " link selected" : " links selected");
+    const all = Object.keys(checkboxes).length;
+    document.getElementById("selectAll").checked = all > 0 && count === all;
+}
+
+function selectAll(checked) {
+    Object.entries(checkboxes).forEach(([shortcode, check]) => {
+        check.checked = checked;
+        if (checked) {
+            selected.add(shortcode);
+        } else {
+            selected.delete(shortcode);
+        }
+    });
+    showSelection();
+}
+
+// Deletes every selected link, or none of them if any can't be
+function deleteSelected() {
+    const shortcodes = Array.from(selected);
+    if (!shortcodes.length) return;
+    const listed = shortcodes
+        .slice(0, 10)
+        .map((shortcode) => "/" + shortcode)
+        .join(", ");
+    const more =
+        shortcodes.length > 10
+            ? " and " + (shortcodes.length - 10) + " more"
+            : "";
+    const noun = shortcodes.length === 1 ? " link" : " links";
+    if (
+        !confirm(
+            "Delete " + shortcodes.length + noun + "?\n\n" + listed + more,
+        )
+    ) {
+        return;
+    }
+    fetch(
+        basePath +
+            "/api/v1/links?" +
+            new URLSearchParams({ shortcodes: shortcodes.join(",") }),
+        {
+            method: "DELETE",
+            headers: { "X-CSRF-Token": csrfToken },
+        },
+    )
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.success) {
+                loadLinks();
+            } else {
+                alert("Error: " + data.message);
+            }
+        });
+}
+
+// Picking the sorted column again reverses it
+function sortBy(column) {
+    if (listState.sort === column) {
+        listState.order = listState.order === "asc" ? "desc" : "asc";
+    } else {
+        listState.sort = column;
+        listState.order = defaultOrders[column];
+    }
+    listState.page = 1;
+    loadLinks();
+}
+
+// One row of the trending panel; change is shown for gainers
+function trendItem(link, showChange) {
+    const a = shortLink(link);
+    a.title = link.title || link.url;
+    const name = element("span", "shortcode");
+    name.appendChild(a);
+    const clicks = element("span", "", String(link.clicks));
+    if (showChange) {
+        clicks.appendChild(element("span", "change", "+" + link.change));
+    }
+    const item = element("div", "trend-item");
+    item.appendChild(name);
+    item.appendChild(clicks);
+    return item;
+}
+
+function showTrend(id, links, showChange) {
+    const list = document.getElementById(id);
+    list.replaceChildren();
+    links.forEach((link) => list.appendChild(trendItem(link, showChange)));
+    if (!links.length) {
+        list.appendChild(element("div", "url", "No clicks yet"));
+    }
+}
+
+function loadTrending() {
+    const trendWindow = document.getElementById("trendWindow").value;
+    fetch(basePath + "/api/v1/stats/top?window=" + trendWindow)
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (!data.success) return;
+            showTrend("topLinks", data.data.top, false);
+            showTrend("trendingLinks", data.data.trending, true);
+        });
+}
+
+// The links used most recently. Pinned links are listed first by the
+// API, so they're asked for apart from the rest and merged in by date.
+function loadRecent() {
+    const query = (pinned) =>
+        fetch(
+            basePath +
+                "/api/v1/links?" +
+                new URLSearchParams({
+                    sort: "last_used",
+                    page: 1,
+                    per_page: 10,
+                    pinned,
+                }),
+        )
+            .then(checkAuth)
+            .then((response) => response.json())
+            .then((data) => (data.success ? data.data : []));
+    Promise.all([query(true), query(false)]).then(([pinned, rest]) => {
+        const links = pinned
+            .concat(rest)
+            .filter((link) => link.last_accessed_at)
+            .sort(
+                (a, b) =>
+                    new Date(b.last_accessed_at) -
+                    new Date(a.last_accessed_at),
+            )
+            .slice(0, 10);
+        const list = document.getElementById("recentLinks");
+        list.replaceChildren();
+        links.forEach((link) => {
+            const a = shortLink(link);
+            a.title = link.title || link.url;
+            const name = element("span", "shortcode");
+            name.appendChild(a);
+            const item = element("div", "trend-item");
+            item.appendChild(name);
+            item.appendChild(
+                element(
+                    "span",
+                    "",
+                    new Date(link.last_accessed_at).toLocaleString(),
+                ),
+            );
+            list.appendChild(item);
+        });
+        if (!links.length) {
+            list.appendChild(element("div", "url", "No clicks yet"));
+        }
+    });
+}
+
+function deleteLink(shortcode) {
+    if (confirm("Delete link: " + shortcode + "?")) {
+        fetch(basePath + "/api/v1/links/" + shortcode, {
+            method: "DELETE",
+            headers: { "X-CSRF-Token": csrfToken },
+        })
+            .then(checkAuth)
+            .then((response) => response.json())
+            .then((data) => {
+                if (data.success) {
+                    loadLinks();
+                } else {
+                    alert("Error: " + data.message);
+                }
+            });
+    }
+}
+
+// Archives a link, or restores an archived one
+function archiveLink(shortcode, archive) {
+    fetch(
+        basePath +
+            "/api/v1/links/" +
+            encodeURIComponent(shortcode) +
+            (archive ? "/archive" : "/restore"),
+        {
+            method: "POST",
+            headers: { "X-CSRF-Token": csrfToken },
+        },
+    )
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.success) {
+                loadLinks();
+            } else {
+                alert("Error: " + data.message);
+            }
+        });
+}
+
+function refreshPageInfo(shortcode) {
+    fetch(basePath + "/api/v1/links/" + shortcode + "/page-info", {
+        method: "POST",
+        headers: { "X-CSRF-Token": csrfToken },
+    })
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.success) {
+                loadLinks();
+            } else {
+                alert("Error: " + data.message);
+            }
+        });
+}
+
+let isEditing = false;
+let originalShortcode = null;
+
+function editLink(shortcode) {
+    const link = linksByCode[shortcode];
+    const shortcodeField = document.getElementById("shortcode");
+    const urlField = document.getElementById("url");
+    const redirectTypeField =
+        document.getElementById("redirectType");
+    const saveBtn = document.getElementById("saveBtn");
+    const cancelBtn = document.getElementById("cancelBtn");
+
+    // Populate form with current values
+    shortcodeField.value = link.shortcode;
+    urlField.value = link.url;
+    redirectTypeField.value = String(link.redirect_type || 0);
+    document.getElementById("title").value = link.title || "";
+    document.getElementById("tags").value = (
+        link.tags || []
+    ).join(", ");
+    document.getElementById("group").value = link.group || "";
+    document.getElementById("description").value =
+        link.description || "";
+    document.getElementById("maxClicks").value =
+        link.max_clicks || "";
+    fillUTM(link.utm);
+    document.getElementById("activeFrom").value = toLocalInput(
+        link.active_from,
+    );
+    document.getElementById("activeUntil").value = toLocalInput(
+        link.active_until,
+    );
+    document.getElementById("linkPassword").value = "";
+    document.getElementById("linkPassword").placeholder =
+        link.protected
+            ? "New password (leave blank to keep)"
+            : "Password (optional)";
+
+    // Set editing state
+    isEditing = true;
+    originalShortcode = shortcode;
+
+    // Update UI
+    saveBtn.textContent = "Update Link";
+    cancelBtn.style.display = "inline-block";
+    shortcodeField.focus();
+
+    // Scroll to form
+    document
+        .querySelector(".container")
+        .scrollIntoView({ behavior: "smooth" });
+}
+
+function cancelEdit() {
+    const shortcodeField = document.getElementById("shortcode");
+    const urlField = document.getElementById("url");
+    const saveBtn = document.getElementById("saveBtn");
+    const cancelBtn = document.getElementById("cancelBtn");
+
+    // Clear form
+    shortcodeField.value = "";
+    urlField.value = "";
+    document.getElementById("redirectType").value = "0";
+    document.getElementById("title").value = "";
+    document.getElementById("tags").value = "";
+    document.getElementById("group").value = "";
+    document.getElementById("description").value = "";
+    document.getElementById("maxClicks").value = "";
+    fillUTM(null);
+    document.getElementById("activeFrom").value = "";
+    document.getElementById("activeUntil").value = "";
+    document.getElementById("linkPassword").value = "";
+    document.getElementById("linkPassword").placeholder =
+        "Password (optional)";
+
+    // Reset editing state
+    isEditing = false;
+    originalShortcode = null;
+
+    // Update UI
+    saveBtn.textContent = "Add Link";
+    cancelBtn.style.display = "none";
+}
+
+document
+    .getElementById("addForm")
+    .addEventListener("submit", function (e) {
+        e.preventDefault();
+        const shortcode =
+            document.getElementById("shortcode").value;
+        const url = document.getElementById("url").value;
+        const redirect_type = parseInt(
+            document.getElementById("redirectType").value,
+            10,
+        );
+        const title = document.getElementById("title").value;
+        const description =
+            document.getElementById("description").value;
+        const tags = document
+            .getElementById("tags")
+            .value.split(",")
+            .map((tag) => tag.trim())
+            .filter((tag) => tag);
+        const group = document.getElementById("group").value.trim();
+        const max_clicks =
+            parseInt(
+                document.getElementById("maxClicks").value,
+                10,
+            ) || 0;
+        const password =
+            document.getElementById("linkPassword").value;
+        const active_from = fromLocalInput(
+            document.getElementById("activeFrom").value,
+        );
+        const active_until = fromLocalInput(
+            document.getElementById("activeUntil").value,
+        );
+
+        if (isEditing) {
+            // Update existing link, keeping settings the form doesn't show
+            fetch(basePath + "/api/v1/links", {
+                method: "POST",
+                headers: {
+                    "Content-Type": "application/json",
+                    "X-CSRF-Token": csrfToken,
+                },
+                body: JSON.stringify(
+                    Object.assign(
+                        {},
+                        linksByCode[originalShortcode],
+                        {
+                            shortcode,
+                            url,
+                            redirect_type,
+                            title,
+                            description,
+                            tags,
+                            group,
+                            max_clicks,
+                            one_time: false,
+                            active_from,
+                            active_until,
+                            utm: readUTM(
+                                linksByCode[originalShortcode]
+                                    .utm,
+                            ),
+                            password,
+                        },
+                    ),
+                ),
+            })
+                .then(checkAuth)
+                .then((response) => response.json())
+                .then((data) => {
+                    if (data.success) {
+                        cancelEdit();
+                        loadLinks();
+                    } else {
+                        alert("Error: " + data.message);
+                    }
+                });
+        } else {
+            // Add new link
+            fetch(basePath + "/api/v1/links", {
+                method: "POST",
+                headers: {
+                    "Content-Type": "application/json",
+                    "X-CSRF-Token": csrfToken,
+                },
+                body: JSON.stringify({
+                    shortcode,
+                    url,
+                    redirect_type,
+                    title,
+                    description,
+                    tags,
+                    group,
+                    max_clicks,
+                    active_from,
+                    active_until,
+                    utm: readUTM(null),
+                    password,
+                }),
+            })
+                .then(checkAuth)
+                .then((response) => response.json())
+                .then((data) => {
+                    if (data.success) {
+                        document.getElementById("shortcode").value =
+                            "";
+                        document.getElementById("url").value = "";
+                        document.getElementById(
+                            "redirectType",
+                        ).value = "0";
+                        document.getElementById("title").value =
+                            "";
+                        document.getElementById("tags").value = "";
+                        document.getElementById("group").value = "";
+                        document.getElementById(
+                            "description",
+                        ).value = "";
+                        document.getElementById(
+                            "maxClicks",
+                        ).value = "";
+                        fillUTM(null);
+                        document.getElementById(
+                            "activeFrom",
+                        ).value = "";
+                        document.getElementById(
+                            "activeUntil",
+                        ).value = "";
+                        document.getElementById(
+                            "linkPassword",
+                        ).value = "";
+                        loadLinks();
+                    } else {
+                        alert("Error: " + data.message);
+                    }
+                });
+        }
+    });
+
+// Cancel button event listener
+document
+    .getElementById("cancelBtn")
+    .addEventListener("click", cancelEdit);
+
+// Dark mode functionality
+const darkModeToggle = document.getElementById("darkModeToggle");
+const body = document.body;
+
+// Check for saved dark mode preference
+const isDarkMode = localStorage.getItem("darkMode") === "true";
+if (isDarkMode) {
+    body.classList.add("dark-mode");
+    darkModeToggle.classList.add("active");
+}
+
+// Toggle dark mode
+darkModeToggle.addEventListener("click", function () {
+    body.classList.toggle("dark-mode");
+    darkModeToggle.classList.toggle("active");
+
+    // Save preference
+    localStorage.setItem(
+        "darkMode",
+        body.classList.contains("dark-mode"),
+    );
+});
+
+// Search as the user types, once they pause
+let searchTimer = null;
+document.getElementById("search").addEventListener("input", function () {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(() => {
+        listState.q = this.value.trim();
+        listState.page = 1;
+        loadLinks();
+    }, 300);
+});
+document
+    .getElementById("groupFilter")
+    .addEventListener("change", function () {
+        filterGroup(this.value);
+    });
+document
+    .getElementById("pinnedOnly")
+    .addEventListener("change", function () {
+        listState.pinnedOnly = this.checked;
+        listState.page = 1;
+        loadLinks();
+    });
+document
+    .getElementById("archivedOnly")
+    .addEventListener("change", function () {
+        listState.archived = this.checked;
+        listState.page = 1;
+        loadLinks();
+    });
+const perPageField = document.getElementById("perPage");
+perPageField.value = String(listState.perPage);
+perPageField.addEventListener("change", function () {
+    listState.perPage = parseInt(this.value, 10);
+    listState.page = 1;
+    localStorage.setItem("perPage", listState.perPage);
+    loadLinks();
+});
+document.querySelectorAll(".sort-btn").forEach((b) =>
+    b.addEventListener("click", () => sortBy(b.dataset.sort)),
+);
+document
+    .getElementById("selectAll")
+    .addEventListener("change", function () {
+        selectAll(this.checked);
+    });
+document
+    .getElementById("bulkDelete")
+    .addEventListener("click", deleteSelected);
+document
+    .getElementById("clearSelection")
+    .addEventListener("click", () => selectAll(false));
+document
+    .getElementById("qrClose")
+    .addEventListener("click", () =>
+        document.getElementById("qrDialog").close(),
+    );
+document.getElementById("prevPage").addEventListener("click", () => {
+    listState.page--;
+    loadLinks();
+});
+document.getElementById("nextPage").addEventListener("click", () => {
+    listState.page++;
+    loadLinks();
+});
+
+// Load links on page load
+loadLinks();
+loadTrending();
+loadRecent();
+document
+    .getElementById("trendWindow")
+    .addEventListener("change", loadTrending);
+
+// Initialize form based on template data
+document.addEventListener("DOMContentLoaded", function () {
+    const shortcodeField = document.getElementById("shortcode");
+    const urlField = document.getElementById("url");
+
+    // If shortcode is pre-populated, focus on URL field and clear URL params
+    if (shortcodeField.value.trim()) {
+        urlField.focus();
+        // Clear URL parameters to remove error message after showing it
+        const url = new URL(window.location);
+        url.searchParams.delete("shortcode");
+        url.searchParams.delete("error");
+        window.history.replaceState(
+            {},
+            document.title,
+            url.pathname,
+        );
+    }
+});
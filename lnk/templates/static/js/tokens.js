@@ -0,0 +1,139 @@
+const basePath = document.body.dataset.basePath;
+// Sent with every change, so other sites can't make them
+// through the session cookie
+const csrfToken = document.body.dataset.csrfToken;
+
+if (localStorage.getItem("darkMode") === "true") {
+    document.body.classList.add("dark-mode");
+}
+
+function checkAuth(response) {
+    if (response.status === 401) {
+        window.location =
+            basePath + "/login?next=" + encodeURIComponent("/tokens");
+        throw new Error("Authentication required");
+    }
+    return response;
+}
+
+function formatDate(timestamp) {
+    return new Date(timestamp).toLocaleDateString();
+}
+
+function element(tag, className, text) {
+    const el = document.createElement(tag);
+    if (className) el.className = className;
+    if (text) el.textContent = text;
+    return el;
+}
+
+function loadTokens() {
+    fetch(basePath + "/api/v1/tokens")
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            const list = document.getElementById("tokens");
+            list.replaceChildren();
+            if (!data.success || !data.data.length) {
+                list.appendChild(element("p", "", "No tokens yet"));
+                return;
+            }
+            data.data.forEach((token) => {
+                const info = element("div");
+                info.appendChild(element("div", "token-name", token.name));
+                const scopes = element("div");
+                token.scopes.forEach((scope) =>
+                    scopes.appendChild(element("span", "scope", scope)),
+                );
+                info.appendChild(scopes);
+                info.appendChild(
+                    element(
+                        "div",
+                        "hint",
+                        "Created " +
+                            formatDate(token.created_at) +
+                            " · " +
+                            (token.last_used_at
+                                ? "last used " + formatDate(token.last_used_at)
+                                : "never used") +
+                            (token.expires_at
+                                ? " · expires " + formatDate(token.expires_at)
+                                : ""),
+                    ),
+                );
+
+                const revoke = element("button", "revoke-btn", "Revoke");
+                revoke.addEventListener("click", () => revokeToken(token));
+
+                const item = element("div", "token-item");
+                item.appendChild(info);
+                item.appendChild(revoke);
+                list.appendChild(item);
+            });
+        });
+}
+
+function revokeToken(token) {
+    if (!confirm("Revoke token: " + token.name + "?")) return;
+    fetch(basePath + "/api/v1/tokens/" + token.id, {
+        method: "DELETE",
+        headers: { "X-CSRF-Token": csrfToken },
+    })
+        .then(checkAuth)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.success) {
+                loadTokens();
+            } else {
+                alert("Error: " + data.message);
+            }
+        });
+}
+
+const form = document.getElementById("tokenForm");
+if (form) {
+    form.addEventListener("submit", function (e) {
+        e.preventDefault();
+        const days = parseInt(document.getElementById("expires").value, 10);
+        const request = {
+            name: document.getElementById("name").value,
+            scopes: document.getElementById("scopes").value.split(" "),
+        };
+        if (days) {
+            request.expires_at = new Date(
+                Date.now() + days * 24 * 60 * 60 * 1000,
+            ).toISOString();
+        }
+        fetch(basePath + "/api/v1/tokens", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/json",
+                "X-CSRF-Token": csrfToken,
+            },
+            body: JSON.stringify(request),
+        })
+            .then(checkAuth)
+            .then((response) => response.json())
+            .then((data) => {
+                if (data.success) {
+                    document.getElementById("newTokenValue").textContent =
+                        data.data.token;
+                    document.getElementById("newToken").style.display =
+                        "block";
+                    document.getElementById("name").value = "";
+                    loadTokens();
+                } else {
+                    alert("Error: " + data.message);
+                }
+            });
+    });
+
+    document.getElementById("copyBtn").addEventListener("click", function () {
+        navigator.clipboard.writeText(
+            document.getElementById("newTokenValue").textContent,
+        );
+        this.textContent = "Copied";
+    });
+
+    loadTokens();
+}
@@ -0,0 +1,108 @@
+package lnk
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// overlayFS serves files from top where it has them and from base
+// otherwise. Directory listings combine both.
+type overlayFS struct {
+	top, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.base.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, topErr := fs.ReadDir(o.top, name)
+	base, baseErr := fs.ReadDir(o.base, name)
+	if topErr != nil && baseErr != nil {
+		return nil, baseErr
+	}
+	entries := map[string]fs.DirEntry{}
+	for _, e := range base {
+		entries[e.Name()] = e
+	}
+	for _, e := range top {
+		entries[e.Name()] = e
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+// loadTheme lays THEME_DIR, if set, over the embedded templates. A page
+// template, a partial under partials/ (logo, name, head, footer), or a
+// file under static/ there replaces the embedded one of the same name.
+// Every page is parsed up front, so a broken theme stops the server from
+// starting rather than failing pages later. In development mode the theme
+// is read again on each page and static file served, so edits show up on
+// the next reload.
+func (lf *LinkForwarder) loadTheme() error {
+	if lf.themeDir == "" {
+		lf.themeDir = lf.getenv("THEME_DIR")
+	}
+	lf.pages = pageFiles
+	if lf.themeDir != "" {
+		info, err := os.Stat(lf.themeDir)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid THEME_DIR %q: must be a directory", lf.themeDir)
+		}
+		lf.pages = overlayFS{top: os.DirFS(lf.themeDir), base: pageFiles}
+		lf.reloadTheme = lf.devMode
+	}
+	if err := lf.loadAssets(); err != nil {
+		return fmt.Errorf("invalid THEME_DIR %q: %v", lf.themeDir, err)
+	}
+
+	names, err := fs.Glob(lf.pages, "*.html")
+	if err != nil {
+		return err
+	}
+	lf.templates = map[string]*template.Template{}
+	for _, name := range names {
+		tmpl, err := lf.parseTemplate(name)
+		if err != nil {
+			return fmt.Errorf("invalid THEME_DIR %q: %v", lf.themeDir, err)
+		}
+		lf.templates[name] = tmpl
+	}
+	return nil
+}
+
+// loadAssets reads and fingerprints the static files, the theme's
+// included.
+func (lf *LinkForwarder) loadAssets() error {
+	static, err := fs.Sub(lf.pages, "static")
+	if err != nil {
+		return err
+	}
+	set, err := readAssets(static)
+	if err != nil {
+		return err
+	}
+	lf.assetsMu.Lock()
+	lf.assets = set
+	lf.assetsMu.Unlock()
+	return nil
+}
+
+// currentAssets returns the static files as last loaded.
+func (lf *LinkForwarder) currentAssets() assetSet {
+	lf.assetsMu.RLock()
+	defer lf.assetsMu.RUnlock()
+	return lf.assets
+}
@@ -0,0 +1,39 @@
+package lnk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultRequestTimeout = 30 * time.Second
+
+// loadRequestTimeout reads REQUEST_TIMEOUT, how long a request's database
+// work may run before it is cancelled. Zero turns the limit off.
+func loadRequestTimeout(getenv func(string) string) (time.Duration, error) {
+	v := getenv("REQUEST_TIMEOUT")
+	if v == "" {
+		return defaultRequestTimeout, nil
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid REQUEST_TIMEOUT %q: must be a duration such as 10s, or 0 for no limit", v)
+	}
+	return timeout, nil
+}
+
+// withRequestTimeout puts a deadline on each request's context. Storage
+// calls run with that context, so queries stop when it passes or when the
+// client disconnects. Streams get around it with withoutRequestTimeout.
+func (lf *LinkForwarder) withRequestTimeout(next http.Handler) http.Handler {
+	if lf.requestTimeout == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		untimed := context.WithValue(r.Context(), untimedContextKey, r.Context())
+		ctx, cancel := context.WithTimeout(untimed, lf.requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,322 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Token scopes. A token can only make the API calls its scopes cover, and
+// never more than its account could.
+const (
+	scopeRead   = "read"   // read links, stats, and events
+	scopeCreate = "create" // create links, but not replace existing ones
+	scopeWrite  = "write"  // every other change, including creating links
+	// scopeSession marks calls that need a password or login, such as
+	// managing tokens, so a leaked token can't mint more.
+	scopeSession = "session"
+)
+
+var tokenScopes = []string{scopeRead, scopeCreate, scopeWrite}
+
+// tokenPrefix starts every personal access token, telling them apart from
+// OIDC ID tokens and making them easy to spot in leaked secrets.
+const tokenPrefix = "lnk_"
+
+// maxTokenNameLength bounds the device name given to a token.
+const maxTokenNameLength = 100
+
+var errTokenNotFound = errors.New("token not found")
+
+// APIToken is a personal access token: a long-lived credential for one
+// device or integration, limited to some scopes.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Token      string     `json:"token,omitempty"` // only returned when created
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// allows reports whether the token's scopes cover a call needing scope.
+func (t *APIToken) allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || (s == scopeWrite && scope == scopeCreate) {
+			return true
+		}
+	}
+	return false
+}
+
+const tokenContextKey contextKey = "token"
+
+func withToken(ctx context.Context, token *APIToken) context.Context {
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// currentToken returns the token a request authenticated with, or nil
+// when it used a password, session, or ID token.
+func currentToken(r *http.Request) *APIToken {
+	token, _ := r.Context().Value(tokenContextKey).(*APIToken)
+	return token
+}
+
+// tokenScope returns the token scope an API operation needs: the one it sets,
+// or read for GET and write for everything else.
+func (op apiOperation) tokenScope() string {
+	if op.scope != "" {
+		return op.scope
+	}
+	if op.method == http.MethodGet {
+		return scopeRead
+	}
+	return scopeWrite
+}
+
+// requireScope wraps a handler that tokens may only call with scope. It
+// must run behind requireAuth.
+func (lf *LinkForwarder) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := currentToken(r)
+		if token == nil || token.allows(scope) {
+			next(w, r)
+			return
+		}
+		if scope == scopeSession {
+			writeError(w, http.StatusForbidden, "This can't be done with an API token")
+		} else {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("This token doesn't have the %s scope", scope))
+		}
+	}
+}
+
+// normalizeScopes checks requested scopes and puts them in a fixed order.
+func normalizeScopes(scopes []string) ([]string, error) {
+	requested := map[string]bool{}
+	for _, s := range scopes {
+		requested[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	var out []string
+	for _, s := range tokenScopes {
+		if requested[s] {
+			out = append(out, s)
+			delete(requested, s)
+		}
+	}
+	if len(requested) > 0 || len(out) == 0 {
+		return nil, fmt.Errorf("scopes must be one or more of %s", strings.Join(tokenScopes, ", "))
+	}
+	return out, nil
+}
+
+// createToken issues a token for user. The secret is only ever returned
+// here; the database keeps its hash.
+func (lf *LinkForwarder) createToken(ctx context.Context, user *User, name string, scopes []string, expires *time.Time) (*APIToken, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &APIToken{
+		Name:      name,
+		Scopes:    scopes,
+		Token:     tokenPrefix + secret,
+		ExpiresAt: expires,
+		CreatedAt: lf.now().UTC(),
+	}
+	result, err := lf.db.ExecContext(ctx, `INSERT INTO api_tokens (token_hash, user_id, name, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		hashToken(token.Token), user.ID, name, strings.Join(scopes, " "), expires, token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if token.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// tokenColumns is the column list read by scanToken.
+const tokenColumns = `t.id, t.name, t.scopes, t.expires_at, t.last_used_at, t.created_at`
+
+func scanToken(row rowScanner, extra ...any) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expires, lastUsed sql.NullTime
+	if err := row.Scan(append([]any{&t.ID, &t.Name, &scopes, &expires, &lastUsed, &t.CreatedAt}, extra...)...); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	if expires.Valid {
+		t.ExpiresAt = &expires.Time
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	return &t, nil
+}
+
+// listTokens returns the tokens of a user, newest first.
+func (lf *LinkForwarder) listTokens(ctx context.Context, user *User) ([]APIToken, error) {
+	rows, err := lf.db.QueryContext(ctx, `SELECT `+tokenColumns+` FROM api_tokens t
+		WHERE t.user_id = ? ORDER BY t.id DESC`, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// deleteToken revokes one of a user's tokens.
+func (lf *LinkForwarder) deleteToken(ctx context.Context, user *User, id int64) error {
+	result, err := lf.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, user.ID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errTokenNotFound
+	}
+	return nil
+}
+
+// tokenUser returns the user a token belongs to, and the token, if it
+// exists and hasn't expired.
+func (lf *LinkForwarder) tokenUser(ctx context.Context, secret string) (*User, *APIToken, error) {
+	var u User
+	token, err := scanToken(lf.db.QueryRowContext(ctx, `SELECT `+tokenColumns+`, u.id, u.username, u.role, u.created_at
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, hashToken(secret)),
+		&u.ID, &u.Username, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, errUserNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	now := lf.now().UTC()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, nil, errUserNotFound
+	}
+
+	// Recording every use would turn each read into a write
+	if !lf.readOnly && (token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute) {
+		if _, err := lf.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, token.ID); err != nil {
+			lf.logger.Printf("Failed to record use of token %d: %v", token.ID, err)
+		}
+	}
+	return &u, token, nil
+}
+
+type createTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleTokens lists, creates, and revokes the personal access tokens of
+// the authenticated user.
+func (lf *LinkForwarder) handleTokens(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		writeError(w, http.StatusNotFound, "Authentication is not enabled")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		tokens, err := lf.listTokens(r.Context(), user)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to retrieve tokens")
+			return
+		}
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Tokens retrieved successfully",
+			Data:    tokens,
+		})
+
+	case "POST":
+		var req createTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > maxTokenNameLength {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("name is required and must be at most %d characters", maxTokenNameLength))
+			return
+		}
+		scopes, err := normalizeScopes(req.Scopes)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(lf.now()) {
+			writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+
+		token, err := lf.createToken(r.Context(), user, req.Name, scopes, req.ExpiresAt)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to create token")
+			return
+		}
+		lf.logf(r, "%s created API token %q (%s)", user.Username, token.Name, strings.Join(scopes, ", "))
+		writeJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Message: "Token created successfully; copy it now, it won't be shown again",
+			Data:    token,
+		})
+
+	case "DELETE":
+		id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err := lf.deleteToken(r.Context(), user, id); err != nil {
+			if errors.Is(err, errTokenNotFound) {
+				writeError(w, http.StatusNotFound, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "Failed to revoke token")
+			}
+			return
+		}
+		lf.logf(r, "%s revoked API token %d", user.Username, id)
+		writeJSON(w, http.StatusOK, Response{
+			Success: true,
+			Message: "Token revoked successfully",
+		})
+	}
+}
+
+// handleTokensPage serves the page for managing personal access tokens.
+func (lf *LinkForwarder) handleTokensPage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := lf.loadTemplate("tokens.html")
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		lf.logf(r, "Template error: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := tmpl.Execute(w, TemplateData{User: currentUser(r), CSRFToken: requestCSRFToken(r)}); err != nil {
+		lf.logf(r, "Template execution error: %v", err)
+	}
+}
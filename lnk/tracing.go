@@ -0,0 +1,230 @@
+package lnk
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gorilla/mux"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultServiceName = "lnk"
+
+// loadTracer sets up the OpenTelemetry SDK to send spans to a collector,
+// or anything else that takes OTLP (Jaeger, Grafana Tempo, ...), from the
+// standard OTEL_* variables. It returns nil when neither
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT nor OTEL_EXPORTER_OTLP_ENDPOINT is
+// set, or OTEL_SDK_DISABLED is. anonymize is applied to the client
+// addresses recorded on spans.
+func loadTracer(getenv func(string) string, anonymize func(ip string) string) (*sdktrace.TracerProvider, error) {
+	if disabled, _ := strconv.ParseBool(getenv("OTEL_SDK_DISABLED")); disabled {
+		return nil, nil
+	}
+	protocol := getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = base
+		if protocol != "grpc" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL such as http://localhost:4318", endpoint)
+	}
+
+	headers := map[string]string{}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		for _, pair := range splitList(getenv(name)) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s: %q must be key=value", name, pair)
+			}
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
+			}
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	sampler, err := loadSampler(getenv)
+	if err != nil {
+		return nil, err
+	}
+	service := getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = defaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+
+	// The exporters connect lazily, so a collector that's down doesn't
+	// stop the server starting
+	var exporter sdktrace.SpanExporter
+	switch protocol {
+	case "", "http/protobuf":
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(endpoint), otlptracehttp.WithHeaders(headers))
+	case "grpc":
+		exporter, err = otlptracegrpc.New(context.Background(),
+			otlptracegrpc.WithEndpointURL(endpoint), otlptracegrpc.WithHeaders(headers))
+	default:
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_PROTOCOL %q: must be http/protobuf or grpc", protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the OTLP exporter: %v", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithSpanProcessor(clientAddressProcessor{anonymize}),
+		sdktrace.WithBatcher(exporter),
+	), nil
+}
+
+// loadSampler reads OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG. The
+// default records every trace unless the caller decided not to.
+func loadSampler(getenv func(string) string) (sdktrace.Sampler, error) {
+	ratio := 1.0
+	if v := getenv("OTEL_TRACES_SAMPLER_ARG"); v != "" {
+		var err error
+		if ratio, err = strconv.ParseFloat(v, 64); err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: must be a number from 0 to 1", v)
+		}
+	}
+	switch sampler := getenv("OTEL_TRACES_SAMPLER"); sampler {
+	case "", "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER %q: must be always_on, always_off, traceidratio, or their parentbased_ forms", sampler)
+	}
+}
+
+// clientAddressAttributes are where otelhttp records the client's address,
+// depending on which semantic conventions it follows.
+var clientAddressAttributes = map[attribute.Key]bool{
+	"client.address":       true,
+	"http.client_ip":       true,
+	"net.sock.peer.addr":   true,
+	"network.peer.address": true,
+}
+
+// clientAddressProcessor anonymizes client addresses as spans start, before
+// anything can export them.
+type clientAddressProcessor struct {
+	anonymize func(ip string) string
+}
+
+func (p clientAddressProcessor) OnStart(_ context.Context, s sdktrace.ReadWriteSpan) {
+	for _, attr := range s.Attributes() {
+		if clientAddressAttributes[attr.Key] {
+			s.SetAttributes(attr.Key.String(p.anonymize(attr.Value.Emit())))
+		}
+	}
+}
+
+func (clientAddressProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (clientAddressProcessor) Shutdown(context.Context) error   { return nil }
+func (clientAddressProcessor) ForceFlush(context.Context) error { return nil }
+
+// withTracing records a server span for each request, continuing the
+// caller's trace when it sends a traceparent header.
+func (lf *LinkForwarder) withTracing(next http.Handler) http.Handler {
+	if lf.tracer == nil {
+		return next
+	}
+	annotate := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := trace.SpanFromContext(r.Context())
+		s.SetAttributes(semconv.ClientAddress(lf.privacy.anonymizeIP(clientIP(r))))
+		if id := requestID(r.Context()); id != "" {
+			s.SetAttributes(attribute.String("http.request.header.x-request-id", id))
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(annotate, "",
+		otelhttp.WithTracerProvider(lf.tracer),
+		otelhttp.WithPropagators(propagation.TraceContext{}),
+		// nameSpan adds the route once the router has matched one
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+	)
+}
+
+// nameSpan names the request's span after the route it matched, such as
+// "GET /api/v1/links/{shortcode}", as the router runs it.
+func (lf *LinkForwarder) nameSpan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				s := trace.SpanFromContext(r.Context())
+				s.SetName(r.Method + " " + template)
+				s.SetAttributes(semconv.HTTPRoute(template))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// openTraced opens a database that records each query made for a traced
+// request as a child span. Without a tracer it's sql.Open.
+func openTraced(driverName, dsn string, tracer *sdktrace.TracerProvider) (*sql.DB, error) {
+	if tracer == nil {
+		return sql.Open(driverName, dsn)
+	}
+	return otelsql.Open(driverName, dsn,
+		otelsql.WithTracerProvider(tracer),
+		otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			// Background work such as cleanups would otherwise start a
+			// trace for every query
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}))
+}
+
+// sqliteConn returns the SQLite connection under a driver connection from
+// sql.Conn.Raw, which is otelsql's when queries are traced.
+func sqliteConn(conn any) (*sqlite3.SQLiteConn, bool) {
+	if traced, ok := conn.(interface{ Raw() driver.Conn }); ok {
+		conn = traced.Raw()
+	}
+	c, ok := conn.(*sqlite3.SQLiteConn)
+	return c, ok
+}
@@ -0,0 +1,129 @@
+package lnk
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testCollector takes OTLP/HTTP exports, keeping the spans sent to it.
+type testCollector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req coltracepb.ExportTraceServiceRequest
+	if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, &req) != nil {
+		http.Error(w, "bad export", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+}
+
+func spanAttribute(s *tracepb.Span, key string) string {
+	for _, attr := range s.Attributes {
+		if attr.Key == key {
+			return attr.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+// TestTracing checks that a redirect is exported as a span named after
+// its route, joining the caller's trace, with the client's address
+// anonymized and its query as a child span.
+func TestTracing(t *testing.T) {
+	collector := &testCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+	lf := newTestForwarder(t, map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": srv.URL,
+		"IP_ANONYMIZATION":            "truncate",
+	})
+	if w := serve(lf, "POST", "/api/v1/links", `{"shortcode":"docs","url":"https://dest.example/docs"}`, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating the link: status %d: %s", w.Code, w.Body)
+	}
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	w := serve(lf, "GET", "/docs", "", func(r *http.Request) {
+		r.RemoteAddr = "203.0.113.7:4321"
+		r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	})
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET /docs: status %d", w.Code)
+	}
+	// Close sends the spans still batched
+	lf.Close()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	var redirect *tracepb.Span
+	for _, s := range collector.spans {
+		if s.Name == "GET /{shortcode}" {
+			redirect = s
+		}
+	}
+	if redirect == nil {
+		t.Fatalf("no span for the redirect among %d", len(collector.spans))
+	}
+	if got := hex.EncodeToString(redirect.TraceId); got != traceID {
+		t.Errorf("trace ID %s, want the caller's %s", got, traceID)
+	}
+	if got := spanAttribute(redirect, "lnk.shortcode"); got != "docs" {
+		t.Errorf("lnk.shortcode = %q, want docs", got)
+	}
+	for _, attr := range redirect.Attributes {
+		if strings.Contains(attr.Value.GetStringValue(), "203.0.113.7") {
+			t.Errorf("%s = %q, want the address anonymized", attr.Key, attr.Value.GetStringValue())
+		}
+	}
+	if got := spanAttribute(redirect, "client.address"); got != "203.0.113.0" {
+		t.Errorf("client.address = %q, want 203.0.113.0", got)
+	}
+
+	queries := 0
+	for _, s := range collector.spans {
+		if string(s.ParentSpanId) == string(redirect.SpanId) && spanAttribute(s, "db.statement") != "" {
+			queries++
+		}
+	}
+	if queries == 0 {
+		t.Error("no query spans under the redirect")
+	}
+}
+
+// TestLoadTracer checks the OTEL_* settings the server refuses.
+func TestLoadTracer(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"OTEL_EXPORTER_OTLP_ENDPOINT": "localhost:4318"},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_TRACES_SAMPLER": "sometimes"},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_TRACES_SAMPLER_ARG": "2"},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318", "OTEL_EXPORTER_OTLP_HEADERS": "authorization"},
+	} {
+		if _, err := loadTracer(func(name string) string { return env[name] }, func(ip string) string { return ip }); err == nil {
+			t.Errorf("%v: no error", env)
+		}
+	}
+	tracer, err := loadTracer(func(string) string { return "" }, nil)
+	if tracer != nil || err != nil {
+		t.Errorf("without an endpoint: tracer %v, error %v; want neither", tracer, err)
+	}
+}
@@ -0,0 +1,120 @@
+package lnk
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTrendWindow = "24h"
+	trendingN          = 10 // links in each list
+)
+
+// trendWindows are the periods top links can be counted over, in days.
+// The 24-hour window is counted from raw clicks to the second; the others
+// are whole days in UTC, today included, so they can use click rollups.
+var trendWindows = map[string]int{
+	"24h": 0,
+	"7d":  7,
+	"30d": 30,
+}
+
+// TopLinks lists the most clicked links over a window, and the links whose
+// clicks grew the most since the window before it.
+type TopLinks struct {
+	Window   string         `json:"window"`
+	Since    time.Time      `json:"since"`
+	Top      []TrendingLink `json:"top"`      // most clicks first
+	Trending []TrendingLink `json:"trending"` // biggest increase first
+}
+
+// TrendingLink counts a link's clicks over a window and the window before.
+type TrendingLink struct {
+	Domain         string `json:"domain,omitempty"`
+	Shortcode      string `json:"shortcode"`
+	URL            string `json:"url"`
+	Title          string `json:"title,omitempty"`
+	Clicks         int    `json:"clicks"`
+	PreviousClicks int    `json:"previous_clicks"`
+	Change         int    `json:"change"`
+}
+
+// topLinks counts clicks per link over window, one of trendWindows, and
+// the window before it.
+func (lf *LinkForwarder) topLinks(ctx context.Context, window string) (TopLinks, error) {
+	// window_counts has each link's clicks in the window and the one before
+	var counts string
+	var since, previous any
+	top := TopLinks{Window: window, Top: []TrendingLink{}, Trending: []TrendingLink{}}
+	if days := trendWindows[window]; days == 0 {
+		// clicked_at is stored the way CURRENT_TIMESTAMP writes it
+		const stored = "2006-01-02 15:04:05"
+		now := lf.now().UTC().Truncate(time.Second)
+		top.Since = now.Add(-24 * time.Hour)
+		since, previous = top.Since.Format(stored), now.Add(-48*time.Hour).Format(stored)
+		counts = `window_counts AS (
+			SELECT domain, shortcode, SUM(clicked_at >= ?1) AS clicks, SUM(clicked_at < ?1) AS previous
+			FROM clicks WHERE clicked_at >= ?2 GROUP BY domain, shortcode)`
+	} else {
+		today := lf.now().UTC().Truncate(24 * time.Hour)
+		top.Since = today.AddDate(0, 0, 1-days)
+		since, previous = top.Since.Format("2006-01-02"), top.Since.AddDate(0, 0, -days).Format("2006-01-02")
+		counts = clickCountsSQL + `, window_counts AS (
+			SELECT domain, shortcode, SUM(CASE WHEN day >= ?1 THEN clicks ELSE 0 END) AS clicks,
+				SUM(CASE WHEN day < ?1 THEN clicks ELSE 0 END) AS previous
+			FROM click_counts WHERE day >= ?2 GROUP BY domain, shortcode)`
+	}
+
+	var err error
+	if top.Top, err = lf.queryTrending(ctx, counts, `w.clicks > 0 ORDER BY w.clicks DESC, w.shortcode`, since, previous); err != nil {
+		return top, err
+	}
+	top.Trending, err = lf.queryTrending(ctx, counts, `w.clicks > w.previous ORDER BY w.clicks - w.previous DESC, w.clicks DESC, w.shortcode`, since, previous)
+	return top, err
+}
+
+// queryTrending lists the links in window_counts, as defined by counts,
+// that meet the condition and ordering in where. Deleted links are left
+// out.
+func (lf *LinkForwarder) queryTrending(ctx context.Context, counts, where string, since, previous any) ([]TrendingLink, error) {
+	rows, err := lf.db.QueryContext(ctx, `WITH `+counts+` SELECT w.domain, w.shortcode, l.url, l.title, w.clicks, w.previous
+		FROM window_counts w JOIN links l ON l.domain = w.domain AND l.shortcode = w.shortcode
+		WHERE `+where+` LIMIT ?3`, since, previous, trendingN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := []TrendingLink{}
+	for rows.Next() {
+		var t TrendingLink
+		if err := rows.Scan(&t.Domain, &t.Shortcode, &t.URL, &t.Title, &t.Clicks, &t.PreviousClicks); err != nil {
+			return nil, err
+		}
+		t.Change = t.Clicks - t.PreviousClicks
+		links = append(links, t)
+	}
+	return links, rows.Err()
+}
+
+// handleTopLinks returns the most clicked and trending links.
+func (lf *LinkForwarder) handleTopLinks(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = defaultTrendWindow
+	}
+	if _, ok := trendWindows[window]; !ok {
+		writeError(w, http.StatusBadRequest, "window must be 24h, 7d, or 30d")
+		return
+	}
+	top, err := lf.topLinks(r.Context(), window)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to retrieve top links")
+		return
+	}
+	writeJSON(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Top links retrieved successfully",
+		Data:    top,
+	})
+}
@@ -0,0 +1,78 @@
+package lnk
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches the placeholders a destination URL may use:
+// {path} for everything after the shortcode and {query.NAME} for a query
+// parameter of the short link.
+var placeholderPattern = regexp.MustCompile(`\{(path|query\.[A-Za-z0-9_.-]+)\}`)
+
+// isTemplate reports whether a destination has placeholders to fill in.
+func isTemplate(destination string) bool {
+	return placeholderPattern.MatchString(destination)
+}
+
+// acceptsSuffix reports whether a link can be visited with a path after
+// its shortcode, as in /jira/ABC-123.
+func (l Link) acceptsSuffix() bool {
+	return l.ForwardPath || strings.Contains(l.URL, "{path}")
+}
+
+// expandTemplate fills in a destination's placeholders from the request.
+// Values are escaped for the part of the URL they land in, so visitors
+// can't inject extra query parameters or change the host.
+func expandTemplate(destination string, r *http.Request, suffix string) string {
+	if !isTemplate(destination) {
+		return destination
+	}
+
+	queryStart := strings.Index(destination, "?")
+	query := r.URL.Query()
+	return replaceAllSubmatchIndex(placeholderPattern, destination, func(start int, name string) string {
+		inQuery := queryStart >= 0 && start > queryStart
+
+		var value string
+		if name == "path" {
+			value = suffix
+		} else {
+			value = query.Get(strings.TrimPrefix(name, "query."))
+		}
+
+		if inQuery {
+			return url.QueryEscape(value)
+		}
+		return escapePathSuffix(value)
+	})
+}
+
+// replaceAllSubmatchIndex is like ReplaceAllStringFunc but also passes
+// the match's offset and first capture group to repl.
+func replaceAllSubmatchIndex(re *regexp.Regexp, s string, repl func(start int, group string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		b.WriteString(s[last:m[0]])
+		b.WriteString(repl(m[0], s[m[2]:m[3]]))
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// escapePathSuffix escapes each segment of a path, dropping empty, "." and
+// ".." segments so the result can't climb out of the destination's path.
+func escapePathSuffix(p string) string {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, url.PathEscape(segment))
+	}
+	return strings.Join(segments, "/")
+}
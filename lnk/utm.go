@@ -0,0 +1,95 @@
+package lnk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maxUTMLength limits each UTM parameter value.
+const maxUTMLength = 200
+
+// UTM holds the campaign tracking parameters added to a link's destination
+// at redirect time.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// params returns the UTM query parameters that are set, in the usual order.
+func (u *UTM) params() [][2]string {
+	var params [][2]string
+	for _, p := range [][2]string{
+		{"utm_source", u.Source},
+		{"utm_medium", u.Medium},
+		{"utm_campaign", u.Campaign},
+		{"utm_term", u.Term},
+		{"utm_content", u.Content},
+	} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// normalizeUTM trims a link's UTM parameters and drops the UTM block when
+// it's empty.
+func normalizeUTM(link *Link) error {
+	if link.UTM == nil {
+		return nil
+	}
+	for _, v := range []*string{&link.UTM.Source, &link.UTM.Medium, &link.UTM.Campaign, &link.UTM.Term, &link.UTM.Content} {
+		*v = strings.TrimSpace(*v)
+		if len(*v) > maxUTMLength {
+			return fmt.Errorf("UTM values must be at most %d characters", maxUTMLength)
+		}
+	}
+	if len(link.UTM.params()) == 0 {
+		link.UTM = nil
+	}
+	return nil
+}
+
+// joinUTM encodes UTM parameters for the utm column.
+func joinUTM(utm *UTM) (string, error) {
+	if utm == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(utm)
+	return string(data), err
+}
+
+// splitUTM decodes the utm column.
+func splitUTM(s string) (*UTM, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var utm UTM
+	if err := json.Unmarshal([]byte(s), &utm); err != nil {
+		return nil, err
+	}
+	return &utm, nil
+}
+
+// addUTM sets the link's UTM parameters on a destination, replacing any
+// the destination already has, forwarded ones included.
+func addUTM(destination string, utm *UTM) string {
+	if utm == nil {
+		return destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	q := u.Query()
+	for _, p := range utm.params() {
+		q.Set(p[0], p[1])
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
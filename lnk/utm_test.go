@@ -0,0 +1,34 @@
+package lnk
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestUTMAfterPassThrough checks that the link's own UTM parameters are
+// applied last, so a visitor's forwarded query can't replace them.
+func TestUTMAfterPassThrough(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	link := Link{
+		Shortcode:    "promo",
+		URL:          "https://dest.example/page",
+		ForwardQuery: true,
+		UTM:          &UTM{Source: "news", Medium: "email"},
+	}
+	r := httptest.NewRequest("GET", "/promo?utm_source=evil&ref=x", nil)
+	destination, _ := lf.resolveDestination(httptest.NewRecorder(), r, link, "")
+	u, err := url.Parse(destination)
+	if err != nil {
+		t.Fatalf("resolveDestination returned %q: %v", destination, err)
+	}
+	q := u.Query()
+	if got := q["utm_source"]; len(got) != 1 || got[0] != "news" {
+		t.Errorf("utm_source = %q, want just news", got)
+	}
+	for name, want := range map[string]string{"utm_medium": "email", "ref": "x"} {
+		if got := q.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package lnk
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultShortcodePattern   = `^[A-Za-z0-9][A-Za-z0-9_.-]*$`
+	defaultShortcodeMinLength = 1
+	defaultShortcodeMaxLength = 64
+)
+
+// shortcodeRules controls which shortcodes may be created.
+type shortcodeRules struct {
+	pattern   *regexp.Regexp
+	minLength int
+	maxLength int
+	// lowercase folds shortcodes to lower case on create and lookup, so
+	// /GitHub and /github resolve to the same link.
+	lowercase bool
+}
+
+// loadShortcodeRules reads the shortcode rules from SHORTCODE_PATTERN,
+// SHORTCODE_MIN_LENGTH, SHORTCODE_MAX_LENGTH, and SHORTCODE_CASE.
+func loadShortcodeRules(getenv func(string) string) (shortcodeRules, error) {
+	rules := shortcodeRules{
+		minLength: defaultShortcodeMinLength,
+		maxLength: defaultShortcodeMaxLength,
+	}
+
+	pattern := getenv("SHORTCODE_PATTERN")
+	if pattern == "" {
+		pattern = defaultShortcodePattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return rules, fmt.Errorf("invalid SHORTCODE_PATTERN: %v", err)
+	}
+	rules.pattern = re
+
+	if v := getenv("SHORTCODE_MIN_LENGTH"); v != "" {
+		if rules.minLength, err = strconv.Atoi(v); err != nil || rules.minLength < 1 {
+			return rules, fmt.Errorf("invalid SHORTCODE_MIN_LENGTH %q", v)
+		}
+	}
+	if v := getenv("SHORTCODE_MAX_LENGTH"); v != "" {
+		if rules.maxLength, err = strconv.Atoi(v); err != nil || rules.maxLength < rules.minLength {
+			return rules, fmt.Errorf("invalid SHORTCODE_MAX_LENGTH %q", v)
+		}
+	}
+
+	switch v := strings.ToLower(getenv("SHORTCODE_CASE")); v {
+	case "", "preserve":
+	case "lower":
+		rules.lowercase = true
+	default:
+		return rules, fmt.Errorf("invalid SHORTCODE_CASE %q: must be preserve or lower", v)
+	}
+
+	return rules, nil
+}
+
+// normalize applies the case handling rule to a shortcode.
+func (r shortcodeRules) normalize(shortcode string) string {
+	if r.lowercase {
+		return strings.ToLower(shortcode)
+	}
+	return shortcode
+}
+
+// check reports why a shortcode breaks the rules, if it does.
+func (r shortcodeRules) check(shortcode string) error {
+	n := utf8.RuneCountInString(shortcode)
+	if n < r.minLength || n > r.maxLength {
+		return fmt.Errorf("shortcode must be between %d and %d characters", r.minLength, r.maxLength)
+	}
+	// Slashes and whitespace can never be matched by the /{shortcode} route
+	if strings.ContainsAny(shortcode, "/ \t\r\n") {
+		return fmt.Errorf("shortcode must not contain slashes or whitespace")
+	}
+	if !r.pattern.MatchString(shortcode) {
+		return fmt.Errorf("shortcode must match the pattern %s", r.pattern)
+	}
+	return nil
+}
+
+// defaultReservedShortcodes shadow server routes (or routes we expect to add)
+// and can never be used as shortcodes.
+var defaultReservedShortcodes = []string{
+	"admin",
+	"api",
+	"auth",
+	"debug",
+	"favicon.ico",
+	"feed.json",
+	"feed.xml",
+	"healthz",
+	"login",
+	"logout",
+	"metrics",
+	"robots.txt",
+	"static",
+	"tokens",
+}
+
+// loadReservedShortcodes returns the built-in reserved list plus any extra
+// comma-separated entries from RESERVED_SHORTCODES.
+func loadReservedShortcodes(getenv func(string) string) map[string]bool {
+	reserved := make(map[string]bool)
+	for _, code := range defaultReservedShortcodes {
+		reserved[code] = true
+	}
+	for _, code := range strings.Split(getenv("RESERVED_SHORTCODES"), ",") {
+		if code = strings.ToLower(strings.TrimSpace(code)); code != "" {
+			reserved[code] = true
+		}
+	}
+	return reserved
+}
+
+// validateShortcode checks that a shortcode may be used for a new link.
+func (lf *LinkForwarder) validateShortcode(shortcode string) error {
+	if err := lf.rules.check(shortcode); err != nil {
+		return err
+	}
+	if lf.reserved[strings.ToLower(shortcode)] {
+		return fmt.Errorf("shortcode '%s' is reserved", shortcode)
+	}
+	return nil
+}
+
+// generatedShortcodeLength is how long a generated shortcode is, unless
+// the shortcode rules ask for longer or shorter ones.
+const generatedShortcodeLength = 6
+
+// generateShortcode picks a random unused shortcode in domain for a link
+// created without one.
+func (lf *LinkForwarder) generateShortcode(ctx context.Context, domain string) (string, error) {
+	alphabet := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	if lf.rules.lowercase {
+		alphabet = alphabet[:26] + alphabet[52:]
+	}
+	n := generatedShortcodeLength
+	if n < lf.rules.minLength {
+		n = lf.rules.minLength
+	}
+	if n > lf.rules.maxLength {
+		n = lf.rules.maxLength
+	}
+
+	max := big.NewInt(int64(len(alphabet)))
+	for attempt := 0; attempt < 10; attempt++ {
+		code := make([]byte, n)
+		for i := range code {
+			j, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			code[i] = alphabet[j.Int64()]
+		}
+		shortcode := string(code)
+		if lf.validateShortcode(shortcode) != nil {
+			continue
+		}
+		if _, err := lf.getLink(ctx, domain, shortcode); !errors.Is(err, errLinkNotFound) {
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+		if _, err := lf.resolveAlias(ctx, domain, shortcode); errors.Is(err, errAliasNotFound) {
+			return shortcode, nil
+		}
+	}
+	return "", errors.New("failed to generate a shortcode: set one explicitly")
+}
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxTagLength         = 50
+)
+
+// normalizeTags lowercases and trims tags, splits comma-separated entries,
+// and drops empties and duplicates while keeping the original order.
+func normalizeTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, entry := range tags {
+		for _, tag := range strings.Split(entry, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// joinTags encodes tags for the links.tags column.
+func joinTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "," + strings.Join(tags, ",") + ","
+}
+
+// splitTags decodes the links.tags column.
+func splitTags(s string) []string {
+	return normalizeTags([]string{s})
+}
+
+// validateMetadata checks the optional title, description, and tags.
+func validateMetadata(link Link) error {
+	if utf8.RuneCountInString(link.Title) > maxTitleLength {
+		return fmt.Errorf("title must be at most %d characters", maxTitleLength)
+	}
+	if utf8.RuneCountInString(link.Description) > maxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", maxDescriptionLength)
+	}
+	if len(link.Tags) > maxTags {
+		return fmt.Errorf("a link can have at most %d tags", maxTags)
+	}
+	for _, tag := range link.Tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return fmt.Errorf("tag '%s' must be at most %d characters", tag, maxTagLength)
+		}
+	}
+	return nil
+}
+
+// loadAllowedSchemes reads the destination URL schemes links may use from
+// ALLOWED_SCHEMES, defaulting to http and https.
+func loadAllowedSchemes(getenv func(string) string) map[string]bool {
+	schemes := splitList(getenv("ALLOWED_SCHEMES"))
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	allowed := make(map[string]bool)
+	for _, scheme := range schemes {
+		allowed[strings.ToLower(scheme)] = true
+	}
+	return allowed
+}
+
+// schemePrefix matches a URL scheme such as "https:" or "javascript:".
+var schemePrefix = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+
+// hasScheme reports whether raw starts with a URL scheme rather than a
+// host:port like "localhost:8080/path".
+func hasScheme(raw string) bool {
+	m := schemePrefix.FindString(raw)
+	if m == "" {
+		return false
+	}
+	rest := raw[len(m):]
+	return !(len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9')
+}
+
+// validateURL parses a destination URL, adding https:// when no scheme is
+// given, and checks it is well formed and uses an allowed scheme. It
+// returns the URL to store.
+func (lf *LinkForwarder) validateURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("URL is required")
+	}
+	if strings.ContainsAny(raw, " \t\r\n") {
+		return "", fmt.Errorf("URL must not contain whitespace")
+	}
+	if !hasScheme(raw) {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !lf.allowedSchemes[scheme] {
+		return "", fmt.Errorf("URL scheme '%s' is not allowed", scheme)
+	}
+	if (scheme == "http" || scheme == "https") && u.Hostname() == "" {
+		return "", fmt.Errorf("URL must include a host")
+	}
+
+	return raw, nil
+}
+
+// checkDestination runs every check a destination URL must pass before it
+// can be saved for link, returning the URL to store.
+func (lf *LinkForwarder) checkDestination(ctx context.Context, link Link, raw, requestHost string) (string, error) {
+	destination, err := lf.validateURL(raw)
+	if err != nil {
+		return "", err
+	}
+	if err := lf.checkDomain(destination); err != nil {
+		return "", err
+	}
+	if err := lf.checkDestinationAddress(ctx, destination); err != nil {
+		return "", err
+	}
+	if err := lf.checkRedirectLoop(ctx, link, destination, requestHost); err != nil {
+		return "", err
+	}
+	return destination, nil
+}
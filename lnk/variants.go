@@ -0,0 +1,141 @@
+package lnk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxVariants limits how many destinations one link can split traffic between.
+const maxVariants = 10
+
+// variantCookieAge keeps a visitor on the same variant for 30 days.
+const variantCookieAge = 30 * 24 * 60 * 60
+
+// variantNamePattern keeps variant names safe to store in a cookie.
+var variantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Variant is one of several destinations a link splits its traffic between.
+// Each visit picks a variant with probability proportional to its weight.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// joinVariants encodes variants for the variants column.
+func joinVariants(variants []Variant) (string, error) {
+	if len(variants) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(variants)
+	return string(data), err
+}
+
+// splitVariants decodes the variants column.
+func splitVariants(s string) ([]Variant, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var variants []Variant
+	err := json.Unmarshal([]byte(s), &variants)
+	return variants, err
+}
+
+// normalizeVariants validates a link's variants, naming unnamed ones A, B,
+// C, ... in order.
+func (lf *LinkForwarder) normalizeVariants(ctx context.Context, link *Link, requestHost string) error {
+	if len(link.Variants) > maxVariants {
+		return fmt.Errorf("a link can have at most %d variants", maxVariants)
+	}
+
+	total := 0
+	names := map[string]bool{}
+	for i := range link.Variants {
+		v := &link.Variants[i]
+		v.Name = strings.TrimSpace(v.Name)
+		if v.Name == "" {
+			v.Name = string(rune('A' + i))
+		}
+		if !variantNamePattern.MatchString(v.Name) {
+			return fmt.Errorf("variant name %q may only use letters, digits, - and _", v.Name)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("variant name %q is used more than once", v.Name)
+		}
+		names[v.Name] = true
+
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %s: weight must not be negative", v.Name)
+		}
+		total += v.Weight
+
+		destination, err := lf.checkDestination(ctx, *link, v.URL, requestHost)
+		if err != nil {
+			return fmt.Errorf("variant %s: %v", v.Name, err)
+		}
+		v.URL = destination
+	}
+
+	if len(link.Variants) > 0 && total == 0 {
+		return fmt.Errorf("at least one variant needs a positive weight")
+	}
+	if len(link.Variants) == 0 {
+		link.StickyVariants = false
+	}
+	return nil
+}
+
+// variantCookie is the cookie remembering a visitor's variant for a link.
+func variantCookie(shortcode string) string {
+	return "lnk_variant_" + shortcode
+}
+
+// chooseVariant picks the destination for a visit. Links without variants
+// always go to their URL. With sticky variants, a returning visitor is sent
+// to the same variant as last time.
+func (lf *LinkForwarder) chooseVariant(w http.ResponseWriter, r *http.Request, link Link) (string, string) {
+	if len(link.Variants) == 0 {
+		return link.URL, ""
+	}
+
+	if link.StickyVariants {
+		if cookie, err := r.Cookie(variantCookie(link.Shortcode)); err == nil {
+			for _, v := range link.Variants {
+				if v.Name == cookie.Value && v.Weight > 0 {
+					return v.URL, v.Name
+				}
+			}
+		}
+	}
+
+	total := 0
+	for _, v := range link.Variants {
+		total += v.Weight
+	}
+	pick := rand.Intn(total)
+	chosen := link.Variants[0]
+	for _, v := range link.Variants {
+		if pick < v.Weight {
+			chosen = v
+			break
+		}
+		pick -= v.Weight
+	}
+
+	if link.StickyVariants {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookie(link.Shortcode),
+			Value:    chosen.Name,
+			Path:     lf.appPath("/" + link.Shortcode),
+			MaxAge:   variantCookieAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return chosen.URL, chosen.Name
+}
@@ -0,0 +1,93 @@
+package lnk
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// TestVariants checks that a link's traffic is split between its variants
+// by weight, and that each visit is counted against the variant served.
+func TestVariants(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	body := `{"shortcode":"launch","url":"https://dest.example/","variants":[` +
+		`{"url":"https://dest.example/a","weight":1},` +
+		`{"url":"https://dest.example/b","weight":1},` +
+		`{"name":"off","url":"https://dest.example/off","weight":0}]}`
+	if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating the link: status %d: %s", w.Code, w.Body)
+	}
+
+	const visits = 200
+	served := map[string]int{}
+	for i := 0; i < visits; i++ {
+		w := serve(lf, "GET", "/launch", "", nil)
+		served[w.Header().Get("Location")]++
+		if len(w.Result().Cookies()) != 0 {
+			t.Fatalf("visit set cookies %v, want none without sticky variants", w.Result().Cookies())
+		}
+	}
+	if served["https://dest.example/a"] == 0 || served["https://dest.example/b"] == 0 || len(served) != 2 {
+		t.Errorf("served %v, want only variants A and B", served)
+	}
+
+	stats, err := lf.getStats(context.Background(), "", "launch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Clicks != visits || stats.Variants["A"] != served["https://dest.example/a"] || stats.Variants["B"] != served["https://dest.example/b"] {
+		t.Errorf("stats %+v, want %d clicks split as served %v", stats, visits, served)
+	}
+}
+
+// TestStickyVariants checks that a returning visitor is sent to the variant
+// named by their cookie, unless that variant has since been switched off.
+func TestStickyVariants(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	body := `{"shortcode":"launch","url":"https://dest.example/","sticky_variants":true,"variants":[` +
+		`{"name":"a","url":"https://dest.example/a","weight":1},` +
+		`{"name":"b","url":"https://dest.example/b","weight":1},` +
+		`{"name":"off","url":"https://dest.example/off","weight":0}]}`
+	if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusOK {
+		t.Fatalf("creating the link: status %d: %s", w.Code, w.Body)
+	}
+
+	w := serve(lf, "GET", "/launch", "", nil)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "lnk_variant_launch" || cookies[0].Path != "/launch" {
+		t.Fatalf("cookies %v, want lnk_variant_launch for /launch", cookies)
+	}
+	first := w.Header().Get("Location")
+	if first != "https://dest.example/"+cookies[0].Value {
+		t.Errorf("redirected to %q with variant %q", first, cookies[0].Value)
+	}
+	for i := 0; i < 20; i++ {
+		w := serve(lf, "GET", "/launch", "", func(r *http.Request) { r.AddCookie(cookies[0]) })
+		if got := w.Header().Get("Location"); got != first {
+			t.Fatalf("returning visit redirected to %q, want %q", got, first)
+		}
+	}
+
+	off := &http.Cookie{Name: "lnk_variant_launch", Value: "off"}
+	w = serve(lf, "GET", "/launch", "", func(r *http.Request) { r.AddCookie(off) })
+	if got := w.Header().Get("Location"); got == "https://dest.example/off" {
+		t.Errorf("redirected to the switched-off variant")
+	}
+}
+
+// TestVariantValidation checks the variants the API refuses.
+func TestVariantValidation(t *testing.T) {
+	lf := newTestForwarder(t, nil)
+	for _, variants := range []string{
+		`[{"name":"a","url":"https://dest.example/a","weight":1},{"name":"a","url":"https://dest.example/b","weight":1}]`,
+		`[{"name":"a b","url":"https://dest.example/a","weight":1}]`,
+		`[{"url":"https://dest.example/a","weight":-1},{"url":"https://dest.example/b","weight":2}]`,
+		`[{"url":"https://dest.example/a","weight":0}]`,
+		`[{"url":"javascript:alert(1)","weight":1}]`,
+	} {
+		body := `{"shortcode":"refused","url":"https://dest.example/","variants":` + variants + `}`
+		if w := serve(lf, "POST", "/api/v1/links", body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("variants %s: status %d, want %d", variants, w.Code, http.StatusBadRequest)
+		}
+	}
+}
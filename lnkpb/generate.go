@@ -0,0 +1,14 @@
+// Package lnkpb is the gRPC API of a Link Forwarder server, generated from
+// lnk.proto, for clients in Go:
+//
+//	conn, err := grpc.NewClient("localhost:9090", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	links := lnkpb.NewLinkServiceClient(conn)
+//	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+//	link, err := links.GetLink(ctx, &lnkpb.GetLinkRequest{Shortcode: "docs"})
+//
+// Clients in other languages generate their own code from lnk.proto.
+package lnkpb
+
+// After editing lnk.proto, regenerate the code with protoc, protoc-gen-go,
+// and protoc-gen-go-grpc installed.
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative lnk.proto